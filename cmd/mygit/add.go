@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const addUsage = "mygit add [-n | --dry-run] [-v | --verbose] [-f | --force] [-A | --all | -u | --update] [--] [<pathspec>...]\n   or: mygit add (-p | --patch) [--] [<pathspec>...]"
+
+type addOptions struct {
+	DryRun  bool
+	Verbose bool
+	Force   bool
+	// All stages untracked files and Update does not, both default to the
+	// whole worktree without a pathspec
+	All    bool
+	Update bool
+	// Patch picks the hunks of tracked files to stage
+	Patch bool
+}
+
+// addChange is one update add makes to the index: staging the worktree
+// content of Path, or removing it when the file was deleted.
+type addChange struct {
+	Path   string
+	Remove bool
+}
+
+func (c addChange) String() string {
+	if c.Remove {
+		return fmt.Sprintf("remove '%s'", c.Path)
+	}
+	return fmt.Sprintf("add '%s'", c.Path)
+}
+
+// allWorktreeFiles lists the files below dir, ignored ones included, for
+// add -f. Nested repositories are skipped.
+func allWorktreeFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %s", dir, err.Error())
+	}
+	files := make([]string, 0)
+	for _, e := range entries {
+		if e.Name() == ".git" {
+			continue
+		}
+		path := e.Name()
+		if dir != "." {
+			path = dir + "/" + e.Name()
+		}
+		if !e.IsDir() {
+			files = append(files, worktreeName(path))
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			continue
+		}
+		sub, err := allWorktreeFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sub...)
+	}
+	return files, nil
+}
+
+// addChanges works out what add does for ps: tracked files that changed are
+// staged again and deleted ones removed, then untracked files are added
+// unless opts.Update is set. ignored lists the pathspecs naming only ignored
+// paths, which git refuses to add without -f.
+func addChanges(ps pathspec, opts addOptions) (changes []addChange, ignored []string, err error) {
+	mode := untrackedAll
+	if opts.Update || opts.Force {
+		mode = untrackedNo
+	}
+	status, err := collectStatus(mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make([]string, 0, len(index.Entries))
+	tracked := make(map[string]bool, len(index.Entries))
+	for _, e := range index.Entries {
+		known = append(known, e.Path)
+		tracked[e.Path] = true
+	}
+
+	for _, e := range status.Entries {
+		if e.IndexMode == modeGitlink || !ps.matches(e.Path) {
+			continue
+		}
+		switch {
+		case e.Stages != [3]*IndexEntry{}:
+			_, err := os.Lstat(e.Path)
+			changes = append(changes, addChange{Path: e.Path, Remove: os.IsNotExist(err)})
+		case e.Worktree == 'D':
+			changes = append(changes, addChange{Path: e.Path, Remove: true})
+		case e.Worktree != ' ':
+			changes = append(changes, addChange{Path: e.Path})
+		}
+	}
+
+	if !opts.Update {
+		untracked := status.Untracked
+		if opts.Force {
+			all, err := allWorktreeFiles(".")
+			if err != nil {
+				return nil, nil, err
+			}
+			untracked = untracked[:0]
+			for _, path := range all {
+				if !tracked[path] {
+					untracked = append(untracked, path)
+				}
+			}
+			sort.Strings(untracked)
+		}
+		for _, path := range untracked {
+			// a nested repository would be added as a gitlink
+			if strings.HasSuffix(path, "/") || !ps.matches(path) {
+				continue
+			}
+			changes = append(changes, addChange{Path: path})
+		}
+		known = append(known, untracked...)
+	}
+
+	for _, item := range ps {
+		if item.Exclude || item.Pattern == "" {
+			continue
+		}
+		if _, unmatched := (pathspec{item}).unmatched(known); !unmatched {
+			continue
+		}
+		if _, err := os.Lstat(item.Pattern); err != nil || item.hasWildcard() {
+			return nil, nil, fmt.Errorf("pathspec '%s' did not match any files", item.Original)
+		}
+		if opts.Update {
+			continue
+		}
+		// like git, name the ignored directory rather than the file in it
+		path := item.Pattern
+		for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+			if excluded, err := isIgnored(dir, true); err != nil {
+				return nil, nil, err
+			} else if excluded {
+				path = dir
+			}
+		}
+		ignored = append(ignored, path)
+	}
+	return changes, ignored, nil
+}
+
+func runAdd(args []string) error {
+	var opts addOptions
+	paths := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "-n", "--dry-run":
+			opts.DryRun = true
+		case "-v", "--verbose":
+			opts.Verbose = true
+		case "-f", "--force":
+			opts.Force = true
+		case "-A", "--all":
+			opts.All = true
+		case "-u", "--update":
+			opts.Update = true
+		case "-p", "--patch":
+			opts.Patch = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if opts.All && opts.Update {
+		return fmt.Errorf("options '-A' and '-u' cannot be used together")
+	}
+	if opts.Patch {
+		switch {
+		case opts.DryRun:
+			return fmt.Errorf("options '--patch' and '--dry-run' cannot be used together")
+		case opts.All:
+			return fmt.Errorf("options '--patch' and '--all' cannot be used together")
+		case opts.Update:
+			return fmt.Errorf("options '--patch' and '--update' cannot be used together")
+		}
+		ps, err := parsePathspecs(paths)
+		if err != nil {
+			return err
+		}
+		return runAddPatch(ps)
+	}
+	if len(paths) == 0 && !opts.All && !opts.Update {
+		fmt.Fprint(os.Stderr, "Nothing specified, nothing added.\n")
+		if config, err := getConfig(); err == nil {
+			if enabled, err := config.GetBool("advice.addEmptyPathspec", true); err == nil && enabled {
+				fmt.Fprint(os.Stderr, "hint: Maybe you wanted to say 'git add .'?\n"+
+					"hint: Turn this message off by running\n"+
+					"hint: \"git config advice.addEmptyPathspec false\"\n")
+			}
+		}
+		return nil
+	}
+	ps, err := parsePathspecs(paths)
+	if err != nil {
+		return err
+	}
+
+	changes, ignored, err := addChanges(ps, opts)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	} else {
+		index, lock, err := lockIndex()
+		if err != nil {
+			return err
+		}
+		defer lock.rollback()
+		for _, c := range changes {
+			if c.Remove {
+				index.remove(c.Path)
+			} else {
+				mode := 0
+				if i := index.find(c.Path); i != -1 {
+					mode = index.Entries[i].Mode
+				}
+				if err := stageWorktreeFile(index, c.Path, mode); err != nil {
+					return err
+				}
+			}
+			if opts.Verbose {
+				fmt.Println(c)
+			}
+		}
+		if err := writeIndex(index, lock); err != nil {
+			return err
+		}
+	}
+
+	if len(ignored) > 0 {
+		message := "The following paths are ignored by one of your .gitignore files:\n" + strings.Join(ignored, "\n")
+		if config, err := getConfig(); err == nil {
+			if enabled, err := config.GetBool("advice.addIgnoredFile", true); err == nil && enabled {
+				message += "\nhint: Use -f if you really want to add them.\n" +
+					"hint: Turn this message off by running\n" +
+					"hint: \"git config advice.addIgnoredFile false\""
+			}
+		}
+		return fmt.Errorf("%s", message)
+	}
+	return nil
+}
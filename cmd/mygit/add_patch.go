@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// patchFile is a tracked file whose worktree content differs from the
+// index, as add -p offers it hunk by hunk.
+type patchFile struct {
+	Entry   IndexEntry
+	NewMode int
+	Deleted bool
+	// Ops is the whole edit script from the index to the worktree, which
+	// the hunks are ranges of
+	Ops      []diffOp
+	OldLines []string
+	NewHash  string
+	// oldLine and newLine are the line numbers each op starts at
+	oldLine, newLine []int
+}
+
+// patchHunk is Ops[Start:End] of a file. Split hunks share their context
+// lines but not their changes, so a change is staged when the hunk holding
+// it is. A hunk with Mode set stands for the mode change instead.
+type patchHunk struct {
+	Start, End int
+	Mode       bool
+}
+
+const addPatchHelp = `y - stage this hunk
+n - do not stage this hunk
+q - quit; do not stage this hunk or any of the remaining ones
+a - stage this hunk and all later hunks in the file
+d - do not stage this hunk or any of the later hunks in the file
+`
+
+// newPatchFile diffs the index entry against its worktree file, returning
+// nil when there is nothing add -p can offer: no change, or a binary one.
+func newPatchFile(e IndexEntry) (*patchFile, error) {
+	f := &patchFile{Entry: e, NewMode: e.Mode, NewHash: zeroHash}
+	old, err := readBlobForDiff(e.Hash, e.Mode)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	info, err := os.Lstat(e.Path)
+	switch {
+	case os.IsNotExist(err):
+		f.Deleted = true
+	case err != nil:
+		return nil, err
+	default:
+		f.NewMode = worktreeEntryMode(info, e.Mode)
+		if f.NewMode == modeSymlink {
+			target, err := readLinkTarget(e.Path, info)
+			if err != nil {
+				return nil, err
+			}
+			content = []byte(target)
+		} else {
+			if content, err = os.ReadFile(e.Path); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %s", e.Path, err.Error())
+			}
+			if content, err = applyFilter("clean", e.Path, content); err != nil {
+				return nil, err
+			}
+		}
+		data := append([]byte(fmt.Sprintf("%s %d\u0000", TypeBlob, len(content))), content...)
+		f.NewHash = hex.EncodeToString(calculateObjectBytesHash(data))
+	}
+	if f.NewHash == e.Hash && f.NewMode == e.Mode || old.isBinary() || isBinaryContent(content) {
+		return nil, nil
+	}
+
+	f.OldLines = splitLines(old.Content)
+	f.Ops = looseDiff(f.OldLines, splitLines(content), 0)
+	f.oldLine, f.newLine = make([]int, len(f.Ops)+1), make([]int, len(f.Ops)+1)
+	for i, op := range f.Ops {
+		f.oldLine[i+1], f.newLine[i+1] = f.oldLine[i], f.newLine[i]
+		if op.Kind != '+' {
+			f.oldLine[i+1]++
+		}
+		if op.Kind != '-' {
+			f.newLine[i+1]++
+		}
+	}
+	return f, nil
+}
+
+// hunks groups the changes of the file with the usual context, after a
+// hunk for the mode change if there is one.
+func (f *patchFile) hunks() []patchHunk {
+	hunks := make([]patchHunk, 0)
+	if f.NewMode != f.Entry.Mode && !f.Deleted {
+		hunks = append(hunks, patchHunk{Mode: true})
+	}
+	for _, h := range groupHunks(f.Ops, diffContext, 0) {
+		// no two ops start at the same pair of lines, so the hunk is found
+		// back from where it starts
+		start := 0
+		for f.oldLine[start] != h.OldStart || f.newLine[start] != h.NewStart {
+			start++
+		}
+		hunks = append(hunks, patchHunk{Start: start, End: start + len(h.Ops)})
+	}
+	return hunks
+}
+
+// split cuts a hunk at the context between its changes, each piece keeping
+// all of that context. It returns the hunk alone when there is nothing to
+// cut.
+func (f *patchFile) split(h patchHunk) []patchHunk {
+	// runs holds the start and end of each run of changed lines
+	runs := make([][2]int, 0)
+	for i := h.Start; i < h.End; i++ {
+		if f.Ops[i].Kind == ' ' {
+			continue
+		}
+		if len(runs) > 0 && runs[len(runs)-1][1] == i {
+			runs[len(runs)-1][1] = i + 1
+		} else {
+			runs = append(runs, [2]int{i, i + 1})
+		}
+	}
+	if h.Mode || len(runs) < 2 {
+		return []patchHunk{h}
+	}
+	pieces := make([]patchHunk, len(runs))
+	for i := range runs {
+		piece := patchHunk{Start: h.Start, End: h.End}
+		if i > 0 {
+			piece.Start = runs[i-1][1]
+		}
+		if i+1 < len(runs) {
+			piece.End = runs[i+1][0]
+		}
+		pieces[i] = piece
+	}
+	return pieces
+}
+
+// newContent is the index content with the changes staged applies.
+func (f *patchFile) newContent(staged []bool) []byte {
+	var b strings.Builder
+	for i, op := range f.Ops {
+		if op.Kind == ' ' || op.Kind == '-' && !staged[i] || op.Kind == '+' && staged[i] {
+			b.WriteString(op.Line)
+		}
+	}
+	return []byte(b.String())
+}
+
+// writeHeader writes the lines of the file's diff header after the
+// "diff --git" one.
+func (f *patchFile) writeHeader(w io.Writer) {
+	newName := quotePath("b/"+f.Entry.Path, false)
+	// a mode change is shown as a hunk of its own
+	if f.Deleted {
+		fmt.Fprintf(w, "deleted file mode %06d\n", f.Entry.Mode)
+		newName = "/dev/null"
+	}
+	if f.NewHash == f.Entry.Hash {
+		return
+	}
+	if f.Deleted || f.NewMode != f.Entry.Mode {
+		fmt.Fprintf(w, "index %s..%s\n", abbrevHash(f.Entry.Hash), abbrevHash(f.NewHash))
+	} else {
+		fmt.Fprintf(w, "index %s..%s %06d\n", abbrevHash(f.Entry.Hash), abbrevHash(f.NewHash), f.NewMode)
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", quotePath("a/"+f.Entry.Path, false), newName)
+}
+
+func (f *patchFile) writeHunk(w io.Writer, h patchHunk) {
+	if h.Mode {
+		fmt.Fprintf(w, "old mode %06d\nnew mode %06d\n", f.Entry.Mode, f.NewMode)
+		return
+	}
+	header := diffHunk{
+		OldStart: f.oldLine[h.Start],
+		OldCount: f.oldLine[h.End] - f.oldLine[h.Start],
+		NewStart: f.newLine[h.Start],
+		NewCount: f.newLine[h.End] - f.newLine[h.Start],
+		Ops:      f.Ops[h.Start:h.End],
+	}
+	writeHunks(w, []diffHunk{header}, f.OldLines, nil, 0)
+}
+
+// addPatch asks about each hunk of each file in turn and stages the ones
+// picked. quit is set once the user asked to stop.
+func addPatch(index *Index, f *patchFile) (quit bool, err error) {
+	hunks := f.hunks()
+	// answers holds 'y' or 'n' per hunk, 0 while it was not asked about
+	answers := make([]byte, len(hunks))
+	fmt.Printf("diff --git %s %s\n", quotePath("a/"+f.Entry.Path, false), quotePath("b/"+f.Entry.Path, false))
+	f.writeHeader(os.Stdout)
+	for i, shown := 0, -1; i < len(hunks); {
+		h := hunks[i]
+		// like git, a deletion asked about again is shown with its header
+		if i == shown && f.Deleted {
+			f.writeHeader(os.Stdout)
+		}
+		f.writeHunk(os.Stdout, h)
+		shown = i
+		options, question := "y,n,q,a,d", "Stage this hunk"
+		switch {
+		case h.Mode:
+			question = "Stage mode change"
+		case f.Deleted:
+			question = "Stage deletion"
+		case len(f.split(h)) > 1:
+			options += ",s"
+		}
+		answer, ok := promptLine(fmt.Sprintf("(%d/%d) %s [%s,?]? ", i+1, len(hunks), question, options))
+		if !ok {
+			// like git, the end of the input leaves the rest of the file
+			// undecided and goes on with the next one
+			break
+		}
+		switch answer = strings.TrimSpace(answer); {
+		case answer == "":
+			continue
+		case strings.ContainsRune("yn", rune(answer[0])):
+			answers[i] = answer[0]
+			i++
+		case answer[0] == 'a' || answer[0] == 'd':
+			stage := byte('n')
+			if answer[0] == 'a' {
+				stage = 'y'
+			}
+			for ; i < len(hunks); i++ {
+				answers[i] = stage
+			}
+		case answer[0] == 'q':
+			quit, i = true, len(hunks)
+		case answer[0] == 's' && !strings.Contains(options, "s"):
+			fmt.Println("Sorry, cannot split this hunk")
+		case answer[0] == 's':
+			pieces := f.split(h)
+			fmt.Printf("Split into %d hunks.\n", len(pieces))
+			hunks = append(hunks[:i], append(pieces, hunks[i+1:]...)...)
+			answers = append(answers[:i], append(make([]byte, len(pieces)), answers[i+1:]...)...)
+		default:
+			fmt.Print(addPatchHelp)
+			if strings.Contains(options, "s") {
+				fmt.Println("s - split the current hunk into smaller hunks")
+			}
+			fmt.Println("? - print help")
+		}
+	}
+	fmt.Println()
+
+	staged := make([]bool, len(f.Ops))
+	picked, mode := false, f.Entry.Mode
+	for i, h := range hunks {
+		if answers[i] != 'y' {
+			continue
+		}
+		picked = true
+		if h.Mode {
+			mode = f.NewMode
+		}
+		for j := h.Start; j < h.End; j++ {
+			staged[j] = f.Ops[j].Kind != ' '
+		}
+	}
+	if !picked {
+		return quit, nil
+	}
+	if f.Deleted {
+		index.remove(f.Entry.Path)
+		return quit, nil
+	}
+	// the stat data is left out, so the worktree file is never taken to
+	// match the partly staged content
+	entry := IndexEntry{Path: f.Entry.Path, Mode: mode, Flags: f.Entry.Flags, ExtendedFlags: f.Entry.ExtendedFlags}
+	if entry.Hash, err = writeObject(TypeBlob, f.newContent(staged)); err != nil {
+		return quit, err
+	}
+	index.set(entry)
+	return quit, nil
+}
+
+// runAddPatch is add -p: the changes to tracked files matching ps are shown
+// hunk by hunk, and only the hunks the user picks are staged.
+func runAddPatch(ps pathspec) error {
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	files := make([]*patchFile, 0)
+	unmerged := ""
+	for _, e := range index.Entries {
+		if !ps.matches(e.Path) || e.Mode == modeGitlink || e.assumeUnchanged() || e.skipWorktree() {
+			continue
+		}
+		if e.Stage() != 0 {
+			if e.Path != unmerged {
+				fmt.Fprintf(os.Stderr, "ignoring unmerged: %s\n", e.Path)
+				unmerged = e.Path
+			}
+			continue
+		}
+		if matches, err := worktreeMatchesIndex(index, &e); err != nil {
+			return err
+		} else if matches {
+			continue
+		}
+		f, err := newPatchFile(e)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No changes.")
+		return nil
+	}
+	for _, f := range files {
+		quit, err := addPatch(index, f)
+		if err != nil {
+			return err
+		}
+		if quit {
+			break
+		}
+	}
+	return writeIndex(index, lock)
+}
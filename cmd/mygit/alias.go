@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// aliasExpansion is the result of expanding alias.<name>: either a command
+// with its arguments, or a shell command for aliases starting with '!'.
+type aliasExpansion struct {
+	Name  string
+	Args  []string
+	Shell string
+}
+
+// expandAlias resolves name through alias.* until it names a command, with
+// the alias arguments spliced in before args. Aliases never shadow commands,
+// and an alias expanding back to itself is an error rather than a loop.
+func expandAlias(name string, args []string) (aliasExpansion, error) {
+	expansion := aliasExpansion{Name: name, Args: args}
+	seen := make([]string, 0, 1)
+	for commands[expansion.Name] == nil && expansion.Name != "help" {
+		config, err := getConfig()
+		if err != nil {
+			return expansion, err
+		}
+		value, ok := config.Get("alias." + expansion.Name)
+		if !ok {
+			return expansion, nil
+		}
+		for _, prev := range seen {
+			if prev == expansion.Name {
+				return expansion, fmt.Errorf("alias loop detected: expansion of '%s' does not terminate:\n  %s", seen[0], strings.Join(append(seen, expansion.Name), " ==> "))
+			}
+		}
+		seen = append(seen, expansion.Name)
+
+		if strings.HasPrefix(value, "!") {
+			traceAlias(expansion.Name, value)
+			expansion.Shell = value[1:]
+			return expansion, nil
+		}
+		words, err := splitCommandLine(value)
+		if err != nil {
+			return expansion, fmt.Errorf("bad alias.%s string: %s", expansion.Name, err.Error())
+		}
+		if len(words) == 0 {
+			return expansion, fmt.Errorf("empty alias for %s", expansion.Name)
+		}
+		traceAlias(expansion.Name, quoteTraceArgs(words))
+		expansion.Name = words[0]
+		expansion.Args = append(words[1:], expansion.Args...)
+	}
+	return expansion, nil
+}
+
+// splitCommandLine splits an alias into words like git's split_cmdline:
+// single and double quotes group, and a backslash escapes the next byte.
+func splitCommandLine(line string) ([]string, error) {
+	words := make([]string, 0, 4)
+	var word strings.Builder
+	inWord := false
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && quote != '\'':
+			if i+1 >= len(line) {
+				return nil, fmt.Errorf("cmdline ends with \\")
+			}
+			i++
+			word.WriteByte(line[i])
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == quote:
+			quote = 0
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// runShellAlias runs a '!' alias with sh from the top of the worktree, with
+// the arguments available as "$@" like git passes them. It returns the exit
+// code of the shell.
+func runShellAlias(command string, args []string) int {
+	script := command
+	if len(args) > 0 {
+		script += ` "$@"`
+	}
+	cmd := exec.Command("sh", append([]string{"-c", script, command}, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "GIT_PREFIX=")
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() >= 0 {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "failed to run '%s': %s\n", command, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	attrUnspecified = ""
+	attrSet         = "\x00set"
+	attrUnset       = "\x00unset"
+)
+
+type attrAssignment struct {
+	Name  string
+	Value string
+}
+
+type attrRule struct {
+	// base is the directory of the .gitattributes file, relative to the root
+	Base        string
+	Pattern     string
+	Assignments []attrAssignment
+}
+
+var attrFileCache = map[string][]attrRule{}
+
+func parseAttributes(data []byte, base string) []attrRule {
+	rules := make([]attrRule, 0, 8)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := attrRule{Base: base, Pattern: fields[0]}
+		for _, field := range fields[1:] {
+			switch {
+			case field[0] == '-':
+				rule.Assignments = append(rule.Assignments, attrAssignment{field[1:], attrUnset})
+			case field[0] == '!':
+				rule.Assignments = append(rule.Assignments, attrAssignment{field[1:], attrUnspecified})
+			default:
+				name, value, hasValue := strings.Cut(field, "=")
+				if !hasValue {
+					value = attrSet
+				}
+				rule.Assignments = append(rule.Assignments, attrAssignment{name, value})
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func readAttributesFile(filename string, base string) ([]attrRule, error) {
+	if rules, ok := attrFileCache[filename]; ok {
+		return rules, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read attributes file %s: %s", filename, err.Error())
+	}
+	rules := parseAttributes(data, base)
+	attrFileCache[filename] = rules
+	return rules, nil
+}
+
+// attributeRulesFor returns all rules that may apply to a path, ordered from
+// lowest to highest precedence.
+func attributeRulesFor(relPath string) ([]attrRule, error) {
+	dirs := []string{""}
+	dir := path.Dir(relPath)
+	if dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+
+	allRules := make([]attrRule, 0, 16)
+	for _, d := range dirs {
+		rules, err := readAttributesFile(filepath.Join(filepath.FromSlash(d), ".gitattributes"), d)
+		if err != nil {
+			return nil, err
+		}
+		allRules = append(allRules, rules...)
+	}
+	rules, err := readAttributesFile(filepath.Join(gitDir, "info", "attributes"), "")
+	if err != nil {
+		return nil, err
+	}
+	return append(allRules, rules...), nil
+}
+
+func attrPatternMatches(rule attrRule, relPath string) bool {
+	pattern := rule.Pattern
+	target := relPath
+	if rule.Base != "" {
+		if !strings.HasPrefix(relPath, rule.Base+"/") {
+			return false
+		}
+		target = relPath[len(rule.Base)+1:]
+	}
+	if !strings.Contains(pattern, "/") {
+		return wildmatch(pattern, path.Base(target))
+	}
+	return wildmatch(strings.TrimPrefix(pattern, "/"), target)
+}
+
+// getAttribute returns the value of a single attribute for a worktree path,
+// or attrUnspecified if no rule assigns it.
+func getAttribute(relPath string, name string) (string, error) {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	rules, err := attributeRulesFor(relPath)
+	if err != nil {
+		return "", err
+	}
+	value := attrUnspecified
+	for _, rule := range rules {
+		if !attrPatternMatches(rule, relPath) {
+			continue
+		}
+		for _, a := range rule.Assignments {
+			if a.Name == name {
+				value = a.Value
+			}
+		}
+	}
+	return value, nil
+}
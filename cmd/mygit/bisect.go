@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var bisectStateFiles = []string{"BISECT_START", "BISECT_LOG", "BISECT_NAMES", "BISECT_TERMS", "BISECT_EXPECTED_REV", "BISECT_ANCESTORS_OK", "BISECT_FIRST_PARENT"}
+
+func isBisecting() bool {
+	_, err := os.Stat(filepath.Join(gitDir, "BISECT_START"))
+	return err == nil
+}
+
+func appendBisectLog(text string) error {
+	logPath := filepath.Join(gitDir, "BISECT_LOG")
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", logPath, err.Error())
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		return fmt.Errorf("failed to write %s: %s", logPath, err.Error())
+	}
+	return nil
+}
+
+func cleanBisectState() error {
+	refs, err := listRefs("refs/bisect/")
+	if err != nil {
+		return err
+	}
+	t := beginRefTransaction()
+	for _, r := range refs {
+		if err := t.delete(r.Name, false, ""); err != nil {
+			return err
+		}
+	}
+	if err := t.commit(); err != nil {
+		return err
+	}
+	for _, name := range bisectStateFiles {
+		err := os.Remove(filepath.Join(gitDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+type bisectState struct {
+	Bad  string
+	Good []string
+	Skip map[string]bool
+	// FirstParent follows only the first parent of merges, as start
+	// --first-parent asks
+	FirstParent bool
+}
+
+func readBisectState() (*bisectState, error) {
+	refs, err := listRefs("refs/bisect/")
+	if err != nil {
+		return nil, err
+	}
+	state := &bisectState{Skip: map[string]bool{}}
+	if _, err := os.Stat(filepath.Join(gitDir, "BISECT_FIRST_PARENT")); err == nil {
+		state.FirstParent = true
+	}
+	for _, r := range refs {
+		switch name := strings.TrimPrefix(r.Name, "refs/bisect/"); {
+		case name == "bad":
+			state.Bad = r.Hash
+		case strings.HasPrefix(name, "good-"):
+			state.Good = append(state.Good, r.Hash)
+		case strings.HasPrefix(name, "skip-"):
+			state.Skip[r.Hash] = true
+		}
+	}
+	return state, nil
+}
+
+func (s *bisectState) status() string {
+	switch {
+	case s.Bad == "" && len(s.Good) == 0:
+		return "waiting for both good and bad commits"
+	case s.Bad == "":
+		return fmt.Sprintf("waiting for bad commit, %s known", plural(len(s.Good), "good commit", "good commits"))
+	case len(s.Good) == 0:
+		return "waiting for good commit(s), bad commit known"
+	}
+	return ""
+}
+
+// markBisect records the verdict for a commit as a refs/bisect ref.
+func markBisect(term string, hash string) error {
+	refName := "refs/bisect/" + term
+	if term != "bad" {
+		refName += "-" + hash
+	}
+	if err := writeRefFile(refName, hash); err != nil {
+		return err
+	}
+	commit, err := readCommit(hash)
+	if err != nil {
+		return err
+	}
+	return appendBisectLog(fmt.Sprintf("# %s: [%s] %s\ngit bisect %s %s\n", term, hash, commit.Subject(), term, hash))
+}
+
+// estimateBisectSteps approximates how many more steps are needed when all
+// commits are left to test.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := 0
+	for 1<<(n+1) <= all {
+		n++
+	}
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// bisectCandidates lists the commits reachable from bad but not from any good
+// commit, oldest first, along with how many candidates each one reaches
+// (itself included).
+func bisectCandidates(state *bisectState) ([]*Commit, []int, error) {
+	walk, err := newRevWalk([]string{state.Bad}, state.Good)
+	if err != nil {
+		return nil, nil, err
+	}
+	walk.FirstParent = state.FirstParent
+	candidates := make([]*Commit, 0)
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if commit == nil {
+			break
+		}
+		candidates = append(candidates, commit)
+	}
+	for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+
+	position := make(map[string]int, len(candidates))
+	for i, c := range candidates {
+		position[c.Hash] = i
+	}
+	words := (len(candidates) + 63) / 64
+	reach := make([][]uint64, len(candidates))
+	weights := make([]int, len(candidates))
+	for i, c := range candidates {
+		reach[i] = make([]uint64, words)
+		reach[i][i/64] |= 1 << (i % 64)
+		parents := c.Parents
+		if state.FirstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, p := range parents {
+			if j, ok := position[p]; ok {
+				for k := range reach[i] {
+					reach[i][k] |= reach[j][k]
+				}
+			}
+		}
+		for _, word := range reach[i] {
+			for ; word != 0; word &= word - 1 {
+				weights[i]++
+			}
+		}
+	}
+	return candidates, weights, nil
+}
+
+// bisectNext checks out the commit that best halves the remaining candidates,
+// or reports the first bad commit once it is the only one left.
+func bisectNext() (found bool, err error) {
+	state, err := readBisectState()
+	if err != nil {
+		return false, err
+	}
+	if status := state.status(); status != "" {
+		fmt.Printf("status: %s\n", status)
+		return false, appendBisectLog(fmt.Sprintf("# status: %s\n", status))
+	}
+
+	candidates, weights, err := bisectCandidates(state)
+	if err != nil {
+		return false, err
+	}
+	if len(candidates) == 0 {
+		return false, fmt.Errorf("%s was both good and bad", state.Bad)
+	}
+	// the remaining count is based on the best commit even when it is skipped
+	all := len(candidates)
+	best, bestDistance, reaches, bestAnyDistance := -1, -1, 0, -1
+	for i, c := range candidates {
+		distance := min(weights[i], all-weights[i])
+		if distance > bestAnyDistance {
+			reaches, bestAnyDistance = weights[i], distance
+		}
+		if !state.Skip[c.Hash] && distance > bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	if best == -1 {
+		best = all - 1
+	}
+
+	chosen := candidates[best]
+	if chosen.Hash == state.Bad {
+		return true, reportFirstBad(state, candidates)
+	}
+
+	left := all - reaches - 1
+	fmt.Printf("Bisecting: %s left to test after this (roughly %s)\n",
+		plural(left, "revision", "revisions"), plural(estimateBisectSteps(all), "step", "steps"))
+	if err := bisectCheckout(chosen.Hash); err != nil {
+		return false, err
+	}
+	fmt.Printf("[%s] %s\n", chosen.Hash, chosen.Subject())
+	return false, nil
+}
+
+func reportFirstBad(state *bisectState, candidates []*Commit) error {
+	skipped := make([]string, 0)
+	for _, c := range candidates {
+		if state.Skip[c.Hash] && c.Hash != state.Bad {
+			skipped = append(skipped, c.Hash)
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("There are only 'skip'ped commits left to test.\nThe first bad commit could be any of:\n")
+		for _, hash := range append(skipped, state.Bad) {
+			fmt.Println(hash)
+		}
+		return fmt.Errorf("We cannot bisect more!")
+	}
+
+	commit, err := readCommit(state.Bad)
+	if err != nil {
+		return err
+	}
+	mm, err := readMailmap()
+	if err != nil {
+		return err
+	}
+	parentTree := ""
+	if len(commit.Parents) > 0 {
+		if parentTree, err = commitTreeHash(commit.Parents[0]); err != nil {
+			return err
+		}
+	}
+	changes, err := diffTrees(parentTree, commit.Tree, "")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "%s is the first bad commit\n", commit.Hash)
+	writeCommitHeader(w, commit, "", nil, mm, nil, dateMode{})
+	if len(changes) > 0 {
+		fmt.Fprintln(w)
+		if err := writeDiffStat(w, changes); err != nil {
+			return err
+		}
+		if err := writeDiffSummary(w, changes); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return appendBisectLog(fmt.Sprintf("# first bad commit: [%s] %s\n", commit.Hash, commit.Subject()))
+}
+
+// bisectCheckout detaches HEAD at the commit to test next.
+func bisectCheckout(hash string) error {
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	if err := checkoutCommit(head, hash, checkoutOptions{Quiet: true}); err != nil {
+		return err
+	}
+	from := head
+	if current, onBranch, err := currentBranch(); err != nil {
+		return err
+	} else if onBranch {
+		from = shortenRefName(current)
+	}
+	t := beginRefTransaction()
+	if err := t.update("HEAD", hash, false, "", fmt.Sprintf("checkout: moving from %s to %s", from, hash)); err != nil {
+		return err
+	}
+	if err := t.commit(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(gitDir, "BISECT_EXPECTED_REV"), []byte(hash+"\n"), 0644)
+}
+
+func bisectStart(args []string) error {
+	firstParent := false
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case arg == "--":
+			return fmt.Errorf("limiting bisection to paths is not supported")
+		case arg == "--first-parent":
+			firstParent = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	revs := make([]string, 0, len(names))
+	for _, arg := range names {
+		hash, err := resolveCommitRevision(arg)
+		if err != nil {
+			return fmt.Errorf("'%s' does not appear to be a valid revision", arg)
+		}
+		revs = append(revs, hash)
+	}
+
+	// restarting keeps the position the first start came from
+	start, err := os.ReadFile(filepath.Join(gitDir, "BISECT_START"))
+	if err != nil {
+		current, onBranch, err := currentBranch()
+		if err != nil {
+			return err
+		}
+		if onBranch {
+			start = []byte(shortenRefName(current) + "\n")
+		} else {
+			head, err := resolveHead()
+			if err != nil {
+				return err
+			}
+			start = []byte(head + "\n")
+		}
+	}
+	if err := cleanBisectState(); err != nil {
+		return err
+	}
+	files := map[string]string{"BISECT_START": string(start), "BISECT_TERMS": "bad\ngood\n", "BISECT_NAMES": "\n"}
+	if firstParent {
+		files["BISECT_FIRST_PARENT"] = ""
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(gitDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %s", name, err.Error())
+		}
+	}
+
+	var startLog strings.Builder
+	startLog.WriteString("git bisect start")
+	for _, arg := range args {
+		fmt.Fprintf(&startLog, " '%s'", arg)
+	}
+	if err := appendBisectLog(startLog.String() + "\n"); err != nil {
+		return err
+	}
+	for i, hash := range revs {
+		term := "good"
+		if i == 0 {
+			term = "bad"
+		}
+		if err := markBisect(term, hash); err != nil {
+			return err
+		}
+	}
+	_, err = bisectNext()
+	return err
+}
+
+func bisectMark(term string, args []string) (bool, error) {
+	if !isBisecting() {
+		return false, fmt.Errorf("You need to start by \"git bisect start\"")
+	}
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	if term == "bad" && len(args) > 1 {
+		return false, fmt.Errorf("'git bisect bad' can take only one argument.")
+	}
+	for _, arg := range args {
+		hash, err := resolveCommitRevision(arg)
+		if err != nil {
+			return false, fmt.Errorf("Bad rev input: %s", arg)
+		}
+		if err := markBisect(term, hash); err != nil {
+			return false, err
+		}
+	}
+	return bisectNext()
+}
+
+func bisectReset(args []string) error {
+	if !isBisecting() {
+		fmt.Println("We are not bisecting.")
+		return nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	target := ""
+	if len(args) == 1 {
+		target = args[0]
+	} else {
+		start, err := os.ReadFile(filepath.Join(gitDir, "BISECT_START"))
+		if err != nil {
+			return fmt.Errorf("failed to read BISECT_START: %s", err.Error())
+		}
+		target = strings.TrimSpace(string(start))
+	}
+
+	var err error
+	if _, _, refErr := resolveRef("refs/heads/" + target); refErr == nil {
+		err = switchBranch(target, false, checkoutOptions{})
+	} else {
+		err = detachHead(target, false, checkoutOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not check out original HEAD '%s': %s", target, err.Error())
+	}
+	return cleanBisectState()
+}
+
+// bisectRun marks commits by the exit code of cmd: 0 is good, 125 skips the
+// commit and anything else below 128 is bad.
+func bisectRun(cmd []string) error {
+	if !isBisecting() {
+		return fmt.Errorf("You need to start by \"git bisect start\"")
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("bisect run failed: no command provided.")
+	}
+	command := strings.Join(cmd, " ")
+	for {
+		fmt.Printf("running '%s'\n", command)
+		c := exec.CommandContext(commandContext, "sh", append([]string{"-c", cmd[0] + ` "$@"`}, cmd...)...)
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		traceRunCommand(c)
+		code := 0
+		if err := c.Run(); err != nil {
+			if commandContext.Err() != nil {
+				return errInterrupted
+			}
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return fmt.Errorf("bisect run failed: %s", err.Error())
+			}
+			code = exitErr.ExitCode()
+		}
+
+		term := "bad"
+		switch {
+		case code == 0:
+			term = "good"
+		case code == 125:
+			term = "skip"
+		case code < 0 || code >= 128:
+			return fmt.Errorf("bisect run failed: exit code %d from '%s' is < 0 or >= 128", code, command)
+		}
+		found, err := bisectMark(term, nil)
+		if err != nil {
+			return fmt.Errorf("bisect run cannot continue any more: %s", err.Error())
+		}
+		if found {
+			fmt.Println("bisect found first bad commit")
+			return nil
+		}
+	}
+}
+
+func runBisect(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: mygit bisect (start|bad|good|skip|reset|run|log) [<args>...]")
+	}
+	switch subcommand := args[0]; subcommand {
+	case "start":
+		return bisectStart(args[1:])
+	case "bad", "good", "skip":
+		_, err := bisectMark(subcommand, args[1:])
+		return err
+	case "reset":
+		return bisectReset(args[1:])
+	case "run":
+		return bisectRun(args[1:])
+	case "log":
+		if !isBisecting() {
+			return fmt.Errorf("We are not bisecting.")
+		}
+		content, err := os.ReadFile(filepath.Join(gitDir, "BISECT_LOG"))
+		if err != nil {
+			return fmt.Errorf("failed to read BISECT_LOG: %s", err.Error())
+		}
+		_, err = os.Stdout.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unknown bisect subcommand %s", subcommand)
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type refspec struct {
+	Force bool
+	Src   string
+	Dst   string
+}
+
+func parseRefspec(spec string) refspec {
+	r := refspec{}
+	if strings.HasPrefix(spec, "+") {
+		r.Force = true
+		spec = spec[1:]
+	}
+	r.Src, r.Dst, _ = strings.Cut(spec, ":")
+	return r
+}
+
+// mapRef maps a ref matching the source side of the refspec to its
+// destination, expanding a single '*' wildcard.
+func (r refspec) mapRef(name string) (string, bool) {
+	return mapRefPattern(name, r.Src, r.Dst)
+}
+
+// reverseMapRef maps a ref matching the destination side back to its source.
+func (r refspec) reverseMapRef(name string) (string, bool) {
+	return mapRefPattern(name, r.Dst, r.Src)
+}
+
+func mapRefPattern(name string, from string, to string) (string, bool) {
+	starIdx := strings.IndexByte(from, '*')
+	if starIdx == -1 {
+		return to, name == from
+	}
+	prefix, suffix := from[:starIdx], from[starIdx+1:]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	matched := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(to, "*", matched, 1), true
+}
+
+func remoteFetchRefspecs(config *Config, remote string) []refspec {
+	specs := config.GetAll("remote." + remote + ".fetch")
+	refspecs := make([]refspec, 0, len(specs))
+	for _, s := range specs {
+		refspecs = append(refspecs, parseRefspec(s))
+	}
+	return refspecs
+}
+
+// upstreamOf returns the local ref that tracks the upstream of a branch,
+// e.g. refs/remotes/origin/main for refs/heads/main.
+func upstreamOf(branch string) (string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	short := strings.TrimPrefix(branch, "refs/heads/")
+	remote, hasRemote := config.Get("branch." + short + ".remote")
+	merge, hasMerge := config.Get("branch." + short + ".merge")
+	if !hasRemote || !hasMerge {
+		return "", fmt.Errorf("no upstream configured for branch '%s'", short)
+	}
+	if remote == "." {
+		return merge, nil
+	}
+	for _, spec := range remoteFetchRefspecs(config, remote) {
+		if dst, ok := spec.mapRef(merge); ok {
+			return dst, nil
+		}
+	}
+	return "", fmt.Errorf("upstream branch '%s' not stored as a remote-tracking branch", merge)
+}
+
+// trackingInfo reports where a remote-tracking (or local) ref comes from, so
+// a branch created from it can record branch.<name>.remote and merge.
+func trackingInfo(ref string) (remote string, merge string, ok bool, err error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", "", false, err
+	}
+	for _, e := range config.entries {
+		if e.Section != "remote" || e.Key != "fetch" {
+			continue
+		}
+		if src, matched := parseRefspec(e.Value).reverseMapRef(ref); matched {
+			return e.Subsection, src, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+func setUpstream(branch string, upstreamRef string) error {
+	remote, merge, ok, err := trackingInfo(upstreamRef)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !strings.HasPrefix(upstreamRef, "refs/heads/") {
+			return fmt.Errorf("the requested upstream branch '%s' does not exist", shortenRefName(upstreamRef))
+		}
+		remote, merge = ".", upstreamRef
+	}
+	short := strings.TrimPrefix(branch, "refs/heads/")
+	if err := setConfigValue("branch."+short+".remote", remote); err != nil {
+		return err
+	}
+	if err := setConfigValue("branch."+short+".merge", merge); err != nil {
+		return err
+	}
+	fmt.Printf("branch '%s' set up to track '%s'.\n", short, shortenRefName(upstreamRef))
+	return nil
+}
+
+// resolveBranchArg finds the full ref of a start point or upstream argument.
+func resolveBranchArg(name string) (string, error) {
+	for _, candidate := range dwimRefs(name) {
+		if !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if _, _, err := resolveRef(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("not a valid branch name: '%s'", name)
+}
+
+func formatTrackingInfo(branch string, verbosity int) (string, error) {
+	upstream, err := upstreamOf(branch)
+	if err != nil {
+		return "", nil
+	}
+	name := shortenRefName(upstream)
+	upstreamHash, _, err := resolveRef(upstream)
+	if err == errRefNotFound {
+		if verbosity > 1 {
+			return fmt.Sprintf("[%s: gone] ", name), nil
+		}
+		return "[gone] ", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	localHash, _, err := resolveRef(branch)
+	if err != nil {
+		return "", err
+	}
+	ahead, behind, err := countAheadBehind(localHash, upstreamHash)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, 2)
+	if ahead > 0 {
+		parts = append(parts, fmt.Sprintf("ahead %d", ahead))
+	}
+	if behind > 0 {
+		parts = append(parts, fmt.Sprintf("behind %d", behind))
+	}
+	switch {
+	case verbosity > 1 && len(parts) > 0:
+		return fmt.Sprintf("[%s: %s] ", name, strings.Join(parts, ", ")), nil
+	case verbosity > 1:
+		return fmt.Sprintf("[%s] ", name), nil
+	case len(parts) > 0:
+		return fmt.Sprintf("[%s] ", strings.Join(parts, ", ")), nil
+	}
+	return "", nil
+}
+
+func listBranches(verbosity int, color string, columns columnOptions, filter refFilter) error {
+	columns.finalize()
+	if verbosity > 0 {
+		if columns.Explicit && columns.active() {
+			return fmt.Errorf("options '--column' and '--verbose' cannot be used together")
+		}
+		columns.Enable = "never"
+	}
+
+	filter.Prefix = "refs/heads/"
+	if err := filter.resolveCommits(); err != nil {
+		return err
+	}
+	branches, err := filter.filter()
+	if err != nil {
+		return err
+	}
+	current, onBranch, err := currentBranch()
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+	names := make([]string, 0, len(branches)+1)
+	if !onBranch && err == nil && len(filter.Patterns) == 0 {
+		// a detached HEAD is listed first under a description of where it is
+		head, err := resolveHead()
+		if err != nil {
+			return err
+		}
+		shown, err := filter.acceptsCommit(head)
+		if err != nil {
+			return err
+		}
+		description, err := detachedHeadDescription(head)
+		if err != nil {
+			return err
+		}
+		current = description
+		if shown {
+			branches = append([]Ref{{Name: description, Hash: head}}, branches...)
+		}
+	}
+
+	width := 0
+	for _, b := range branches {
+		names = append(names, shortenRefName(b.Name))
+		width = max(width, len(names[len(names)-1]))
+	}
+
+	colors, err := loadColorPalette("branch", branchColorDefaults, color)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var items []string
+	for i, b := range branches {
+		marker, slot := ' ', "local"
+		if b.Name == current {
+			marker, slot = '*', "current"
+		}
+		name := names[i]
+		if verbosity == 0 {
+			items = append(items, fmt.Sprintf("%c %s", marker, colors.paint(slot, name)))
+			continue
+		}
+		commit, err := readCommit(b.Hash)
+		if err != nil {
+			return err
+		}
+		tracking := ""
+		if strings.HasPrefix(b.Name, "refs/heads/") {
+			tracking, err = formatTrackingInfo(b.Name, verbosity)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%c %s %s %s%s\n", marker, colors.paint(slot, fmt.Sprintf("%-*s", width, name)), abbrevHash(b.Hash), tracking, commit.Subject())
+	}
+	printColumns(w, items, columns, "", "\n", 1)
+	return nil
+}
+
+func createBranch(name string, startPoint string, track bool, force bool) error {
+	refName := "refs/heads/" + name
+	if strings.HasPrefix(name, "-") || name == "HEAD" || !isValidRefName(refName) {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	if _, _, err := resolveRef(refName); err == nil && !force {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	hash, err := resolveCommitRevision(startPoint)
+	if err != nil {
+		if branch, unborn := unbornBranch(); unborn && startPoint == "HEAD" {
+			// git names the branch that has nothing to start from yet
+			startPoint = shortenRefName(branch)
+		}
+		return fmt.Errorf("not a valid object name: '%s'%s", startPoint, refSuggestion(startPoint))
+	}
+	expected := ""
+	if force {
+		// an existing branch is overwritten, but not one updated concurrently
+		expected, _ = readRawRef(refName)
+	}
+	if err := compareAndSwapRef(refName, hash, expected); err != nil {
+		return err
+	}
+	if !track {
+		return nil
+	}
+
+	// like branch.autoSetupMerge=true: track when starting from a remote-tracking branch
+	startRef, err := resolveBranchArg(startPoint)
+	if err != nil || !strings.HasPrefix(startRef, "refs/remotes/") {
+		return nil
+	}
+	if _, _, ok, err := trackingInfo(startRef); err != nil || !ok {
+		return err
+	}
+	return setUpstream(refName, startRef)
+}
+
+// moveBranch renames a branch, or with keep copies it, together with its
+// reflog and its branch.<name> config. HEAD follows a renamed branch it is on.
+func moveBranch(oldName string, newName string, keep bool, force bool) error {
+	oldRef, newRef := "refs/heads/"+oldName, "refs/heads/"+newName
+	if strings.HasPrefix(newName, "-") || newName == "HEAD" || !isValidRefName(newRef) {
+		return fmt.Errorf("'%s' is not a valid branch name", newName)
+	}
+	current, _, err := currentBranch()
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+	hash, _, err := resolveRef(oldRef)
+	if err != nil && (keep || current != oldRef) {
+		return fmt.Errorf("No branch named '%s'.", oldName)
+	}
+	existing, _, _ := resolveRef(newRef)
+	if existing != "" && oldRef != newRef {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", newName)
+		}
+		if current == newRef {
+			return fmt.Errorf("cannot force update the current branch")
+		}
+	}
+
+	verb := "renamed"
+	if keep {
+		verb = "copied"
+	}
+	if hash != "" && oldRef != newRef {
+		reflog, err := os.ReadFile(getReflogPath(oldRef))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read reflog %s: %s", oldRef, err.Error())
+		}
+		t := beginRefTransaction()
+		if err := t.update(newRef, hash, true, existing, ""); err != nil {
+			return err
+		}
+		if !keep {
+			if err := t.delete(oldRef, true, hash); err != nil {
+				return err
+			}
+		}
+		if err := t.commit(); err != nil {
+			return err
+		}
+		logPath := getReflogPath(newRef)
+		if err := os.MkdirAll(filepath.Dir(logPath), mode); err != nil {
+			return fmt.Errorf("failed to create directory for reflog %s: %s", newRef, err.Error())
+		}
+		if err := os.WriteFile(logPath, reflog, 0o644); err != nil {
+			return fmt.Errorf("failed to write reflog %s: %s", newRef, err.Error())
+		}
+		if err := appendReflog(newRef, hash, hash, fmt.Sprintf("Branch: %s %s to %s", verb, oldRef, newRef)); err != nil {
+			return err
+		}
+	}
+	if !keep && current == oldRef {
+		if err := writeSymbolicRef("HEAD", newRef); err != nil {
+			return err
+		}
+		if hash != "" {
+			// like git, HEAD's reflog shows the branch going away and coming back
+			message := fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef)
+			if err := appendReflog("HEAD", hash, zeroHash, message); err != nil {
+				return err
+			}
+			if err := appendReflog("HEAD", "", hash, message); err != nil {
+				return err
+			}
+		}
+	}
+	if oldRef == newRef {
+		return nil
+	}
+	return renameConfigSection(getConfigPath(), "branch", oldName, newName, keep)
+}
+
+func runBranch(args []string) error {
+	verbosity, color := 0, ""
+	upstream, unsetUpstream := "", false
+	track, force := true, false
+	move, list := "", false
+	filter := refFilter{}
+	names := make([]string, 0, 2)
+	columns, err := loadColumnOptions("branch")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-m" || arg == "-M" || arg == "--move" || arg == "-c" || arg == "-C" || arg == "--copy":
+			move = arg
+		case arg == "-l" || arg == "--list":
+			list = true
+		case arg == "--contains" || arg == "--merged" || arg == "--no-merged":
+			// the commit defaults to HEAD when the option comes last
+			commit := "HEAD"
+			if i+1 < len(args) {
+				i++
+				commit = args[i]
+			}
+			switch arg {
+			case "--contains":
+				filter.Contains = append(filter.Contains, commit)
+			case "--merged":
+				filter.Merged = append(filter.Merged, commit)
+			default:
+				filter.NoMerged = append(filter.NoMerged, commit)
+			}
+		case strings.HasPrefix(arg, "--contains="):
+			filter.Contains = append(filter.Contains, strings.TrimPrefix(arg, "--contains="))
+		case strings.HasPrefix(arg, "--merged="):
+			filter.Merged = append(filter.Merged, strings.TrimPrefix(arg, "--merged="))
+		case strings.HasPrefix(arg, "--no-merged="):
+			filter.NoMerged = append(filter.NoMerged, strings.TrimPrefix(arg, "--no-merged="))
+		case arg == "-v" || arg == "--verbose":
+			verbosity++
+		case arg == "-vv":
+			verbosity += 2
+		case arg == "-u":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option -u requires a value")
+			}
+			i++
+			upstream = args[i]
+		case strings.HasPrefix(arg, "--set-upstream-to="):
+			upstream = strings.TrimPrefix(arg, "--set-upstream-to=")
+		case arg == "--unset-upstream":
+			unsetUpstream = true
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "--no-track":
+			track = false
+		case arg == "--track":
+			track = true
+		case arg == "--color" || arg == "--no-color" || strings.HasPrefix(arg, "--color="):
+			when, err := parseColorFlag(arg)
+			if err != nil {
+				return err
+			}
+			color = when
+		case isColumnFlag(arg):
+			if err := columns.parseFlag(arg); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if track {
+		config, err := getConfig()
+		if err != nil {
+			return err
+		}
+		if value, ok := config.Get("branch.autoSetupMerge"); ok && value == "false" {
+			track = false
+		}
+	}
+
+	if upstream != "" || unsetUpstream {
+		branch, _, err := currentBranch()
+		if err != nil {
+			return err
+		}
+		if len(names) > 1 {
+			return fmt.Errorf("too many arguments to set new upstream")
+		}
+		if len(names) == 1 {
+			branch = "refs/heads/" + names[0]
+		}
+		if branch == "" {
+			return fmt.Errorf("HEAD is detached, no branch to set the upstream of")
+		}
+		if _, _, err := resolveRef(branch); err != nil {
+			return fmt.Errorf("branch '%s' does not exist", shortenRefName(branch))
+		}
+		if unsetUpstream {
+			short := strings.TrimPrefix(branch, "refs/heads/")
+			if err := unsetConfigValue("branch." + short + ".merge"); err != nil {
+				return fmt.Errorf("branch '%s' has no upstream information", short)
+			}
+			unsetConfigValue("branch." + short + ".remote")
+			return nil
+		}
+		upstreamRef, err := resolveBranchArg(upstream)
+		if err != nil {
+			return fmt.Errorf("the requested upstream branch '%s' does not exist", upstream)
+		}
+		return setUpstream(branch, upstreamRef)
+	}
+
+	if move != "" {
+		keep := move == "-c" || move == "-C" || move == "--copy"
+		force = force || move == "-M" || move == "-C"
+		switch len(names) {
+		case 1:
+			current, onBranch, err := currentBranch()
+			if err != nil && err != errRefNotFound {
+				return err
+			}
+			if !onBranch {
+				return fmt.Errorf("cannot rename the current branch while not on any")
+			}
+			return moveBranch(strings.TrimPrefix(current, "refs/heads/"), names[0], keep, force)
+		case 2:
+			return moveBranch(names[0], names[1], keep, force)
+		}
+		return fmt.Errorf("too many arguments for a rename operation")
+	}
+	if list || filter.filtersCommits() {
+		filter.Patterns = names
+		return listBranches(verbosity, color, columns, filter)
+	}
+
+	if force && len(names) > 0 {
+		current, _, err := currentBranch()
+		if err != nil && err != errRefNotFound {
+			return err
+		}
+		if current == "refs/heads/"+names[0] {
+			return fmt.Errorf("cannot force update the current branch")
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return listBranches(verbosity, color, columns, filter)
+	case 1:
+		return createBranch(names[0], "HEAD", track, force)
+	case 2:
+		return createBranch(names[0], names[1], track, force)
+	}
+	return fmt.Errorf("too many arguments")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const browseUsage = "mygit browse [--port <port>] [--bind <address>]"
+
+const browsePageSize = 100
+
+var browseTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+table { border-collapse: collapse; }
+td { padding: 0.1em 1em 0.1em 0; vertical-align: top; }
+.hash { font-family: monospace; }
+.add { color: #070; }
+.del { color: #a00; }
+.hunk { color: #07a; }
+</style>
+</head>
+<body>
+<p><a href="/">log</a> | <a href="/refs">refs</a></p>
+<h2>{{.Title}}</h2>
+{{if .Commits}}<table>
+{{range .Commits}}<tr><td class="hash"><a href="/commit/{{.Hash}}">{{.Abbrev}}</a></td><td>{{.Subject}}</td><td>{{.Author}}</td><td>{{.Date}}</td></tr>
+{{end}}</table>
+{{if .More}}<p><a href="/log?rev={{.More}}">older</a></p>{{end}}
+{{end}}{{if .Refs}}<table>
+{{range .Refs}}<tr><td>{{.Name}}</td><td class="hash"><a href="/commit/{{.Hash}}">{{.Abbrev}}</a></td></tr>
+{{end}}</table>
+{{end}}{{with .Commit}}<table>
+<tr><td>author</td><td>{{.Author}}</td><td>{{.AuthorDate}}</td></tr>
+<tr><td>committer</td><td>{{.Committer}}</td><td>{{.CommitterDate}}</td></tr>
+<tr><td>tree</td><td class="hash"><a href="/tree/{{.Tree}}">{{.Tree}}</a></td></tr>
+{{range .Parents}}<tr><td>parent</td><td class="hash"><a href="/commit/{{.}}">{{.}}</a></td></tr>
+{{end}}</table>
+<pre>{{.Message}}</pre>
+{{end}}{{if .Diff}}<pre>{{range .Diff}}<span class="{{.Class}}">{{.Text}}</span>
+{{end}}</pre>
+{{end}}{{if .Entries}}<table>
+{{range .Entries}}<tr><td class="hash">{{.Mode}}</td><td><a href="{{.Link}}">{{.Name}}</a></td></tr>
+{{end}}</table>
+{{end}}{{if .Binary}}<p>Binary file, {{.Binary}} bytes. <a href="{{.Raw}}">raw</a></p>
+{{else if .Raw}}<p><a href="{{.Raw}}">raw</a></p>
+<pre>{{.Content}}</pre>
+{{end}}</body>
+</html>
+`))
+
+type browseCommit struct {
+	Hash    string
+	Abbrev  string
+	Subject string
+	Author  string
+	Date    string
+}
+
+type browseRef struct {
+	Name   string
+	Hash   string
+	Abbrev string
+}
+
+type browseCommitInfo struct {
+	Author        string
+	AuthorDate    string
+	Committer     string
+	CommitterDate string
+	Tree          string
+	Parents       []string
+	Message       string
+}
+
+type browseDiffLine struct {
+	Class string
+	Text  string
+}
+
+type browseEntry struct {
+	Mode string
+	Name string
+	Link string
+}
+
+type browsePage struct {
+	Title   string
+	Commits []browseCommit
+	More    string
+	Refs    []browseRef
+	Commit  *browseCommitInfo
+	Diff    []browseDiffLine
+	Entries []browseEntry
+	Raw     string
+	Content string
+	Binary  int
+}
+
+var errBrowseNotFound = errors.New("not found")
+
+// browseLog lists the commits reachable from rev, one page at a time.
+func browseLog(rev string) (*browsePage, error) {
+	hash, err := resolveCommitRevision(rev)
+	if err != nil {
+		return nil, errBrowseNotFound
+	}
+	walk, err := newRevWalk([]string{hash}, nil)
+	if err != nil {
+		return nil, err
+	}
+	page := &browsePage{Title: "Log of " + rev}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
+			break
+		}
+		if len(page.Commits) == browsePageSize {
+			page.More = commit.Hash
+			break
+		}
+		page.Commits = append(page.Commits, browseCommit{
+			Hash:    commit.Hash,
+			Abbrev:  abbrevHash(commit.Hash),
+			Subject: commit.Subject(),
+			Author:  commit.Author.Name,
+			Date:    formatLogDate(commit.Author),
+		})
+	}
+	return page, nil
+}
+
+func browseRefs() (*browsePage, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	page := &browsePage{Title: "Refs"}
+	for _, r := range refs {
+		hash, err := peelToCommit(r.Hash)
+		if err != nil {
+			continue
+		}
+		page.Refs = append(page.Refs, browseRef{Name: r.Name, Hash: hash, Abbrev: abbrevHash(hash)})
+	}
+	return page, nil
+}
+
+// browseCommitPage shows a commit with its patch against the first parent.
+func browseCommitPage(hash string) (*browsePage, error) {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return nil, errBrowseNotFound
+	}
+	parentTree := ""
+	if len(commit.Parents) > 0 {
+		if parentTree, err = commitTreeHash(commit.Parents[0]); err != nil {
+			return nil, err
+		}
+	}
+	changes, err := diffTrees(parentTree, commit.Tree, "")
+	if err != nil {
+		return nil, err
+	}
+	var patch bytes.Buffer
+	if err := writePatch(&patch, changes, nil, patchOptions{}); err != nil {
+		return nil, err
+	}
+	page := &browsePage{
+		Title: fmt.Sprintf("Commit %s %s", abbrevHash(commit.Hash), commit.Subject()),
+		Commit: &browseCommitInfo{
+			Author:        fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email),
+			AuthorDate:    formatLogDate(commit.Author),
+			Committer:     fmt.Sprintf("%s <%s>", commit.Committer.Name, commit.Committer.Email),
+			CommitterDate: formatLogDate(commit.Committer),
+			Tree:          commit.Tree,
+			Parents:       commit.Parents,
+			Message:       commit.Message,
+		},
+	}
+	for _, line := range strings.SplitAfter(patch.String(), "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "diff "):
+		case strings.HasPrefix(line, "+"):
+			class = "add"
+		case strings.HasPrefix(line, "-"):
+			class = "del"
+		case strings.HasPrefix(line, "@@"):
+			class = "hunk"
+		}
+		if line != "" || class != "" {
+			page.Diff = append(page.Diff, browseDiffLine{Class: class, Text: line})
+		}
+	}
+	return page, nil
+}
+
+// browseTree lists a tree, path being where it sits for the links and the
+// title.
+func browseTree(hash string, path string) (*browsePage, error) {
+	entries, err := readTree(hash)
+	if err != nil {
+		return nil, errBrowseNotFound
+	}
+	page := &browsePage{Title: "Tree /" + path}
+	for _, e := range entries {
+		name, kind := e.Name, "blob"
+		if e.Mode == modeTree {
+			name, kind = e.Name+"/", "tree"
+		} else if e.Mode == modeGitlink {
+			page.Entries = append(page.Entries, browseEntry{Mode: fmt.Sprintf("%06d", e.Mode), Name: name})
+			continue
+		}
+		link := fmt.Sprintf("/%s/%x?path=%s", kind, e.Hash, template.URLQueryEscaper(path+e.Name))
+		page.Entries = append(page.Entries, browseEntry{Mode: fmt.Sprintf("%06d", e.Mode), Name: name, Link: link})
+	}
+	return page, nil
+}
+
+func browseBlob(hash string, path string) (*browsePage, error) {
+	object, err := parseObject(hash)
+	if err != nil || object.Type != TypeBlob {
+		return nil, errBrowseNotFound
+	}
+	page := &browsePage{Title: "Blob /" + path, Raw: "/raw/" + hash}
+	if isBinaryContent(object.Content) {
+		page.Binary = len(object.Content)
+	} else {
+		page.Content = string(object.Content)
+	}
+	return page, nil
+}
+
+func browseRaw(w http.ResponseWriter, hash string) error {
+	object, err := parseObject(hash)
+	if err != nil || object.Type != TypeBlob {
+		return errBrowseNotFound
+	}
+	if isBinaryContent(object.Content) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Content)))
+	_, err = w.Write(object.Content)
+	return err
+}
+
+// browseHandler serves the pages one request at a time, since the object
+// and commit caches are not safe for concurrent use.
+type browseHandler struct {
+	mu sync.Mutex
+}
+
+func (h *browseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "read-only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, arg, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	path := r.URL.Query().Get("path")
+	var page *browsePage
+	var err error
+	switch {
+	case kind == "" || kind == "log":
+		rev := r.URL.Query().Get("rev")
+		if rev == "" {
+			rev = "HEAD"
+		}
+		page, err = browseLog(rev)
+	case kind == "refs":
+		page, err = browseRefs()
+	case !isHexPrefix(arg) || len(arg) != 40:
+		err = errBrowseNotFound
+	case kind == "commit":
+		page, err = browseCommitPage(arg)
+	case kind == "tree":
+		if path != "" {
+			path += "/"
+		}
+		page, err = browseTree(arg, path)
+	case kind == "blob":
+		page, err = browseBlob(arg, path)
+	case kind == "raw":
+		err = browseRaw(w, arg)
+	default:
+		err = errBrowseNotFound
+	}
+	switch {
+	case err == errBrowseNotFound:
+		http.NotFound(w, r)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case page != nil:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := browseTemplate.Execute(w, page); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to render %s: %s\n", r.URL.Path, err.Error())
+		}
+	}
+}
+
+// runBrowse serves a read-only web view of the repository: the log, refs,
+// commits with their patch, trees and blobs. It listens on localhost unless
+// --bind says otherwise, until interrupted.
+func runBrowse(args []string) error {
+	port, bind := "1234", "127.0.0.1"
+	flags := newFlagSet()
+	flags.String(&port, "-p", "--port")
+	flags.String(&bind, "--bind")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: %s", browseUsage)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port '%s'", port)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(bind, port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", net.JoinHostPort(bind, port), err.Error())
+	}
+	server := &http.Server{Handler: &browseHandler{}}
+	go func() {
+		<-commandContext.Done()
+		server.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "Serving the repository at http://%s/\n", listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return checkInterrupted()
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// runCatFile only decompresses as much of the object as it needs: the header
+// for -t and -s, and the content is streamed straight to stdout for -p and
+// "<type> <object>". The latter peels the object to the type first, so
+// "cat-file commit v1.0" shows the commit an annotated tag points at.
+func runCatFile(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--batch") {
+			return runCatFileBatch(args)
+		}
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: mygit cat-file (-t | -s | -p | <type>) <object>")
+	}
+	hash, err := resolveRevision(args[1])
+	if err != nil {
+		return errorWithCode("unknown-revision", "not a valid object name %s", args[1])
+	}
+	switch want := Type(args[0]); want {
+	case "-t", "-s", "-p":
+	case TypeCommit, TypeTree, TypeBlob, TypeTag:
+		if hash, err = peelObject(hash, want); err != nil {
+			return fmt.Errorf("cat-file %s %s: bad file: %s", args[0], args[1], err.Error())
+		}
+	default:
+		if strings.HasPrefix(args[0], "-") {
+			return fmt.Errorf("unknown option %s", args[0])
+		}
+		return fmt.Errorf("invalid object type \"%s\"", args[0])
+	}
+	or, err := openObject(hash)
+	if err != nil {
+		return err
+	}
+	defer or.Close()
+
+	switch args[0] {
+	case "-t":
+		fmt.Print(or.Type)
+	case "-s":
+		fmt.Print(or.Size)
+	default:
+		if _, err := io.Copy(os.Stdout, or); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchOptions are the cat-file --batch and --batch-check settings. Format
+// is the header printed for each object, followed by the content with
+// Contents.
+type batchOptions struct {
+	Format     string
+	Contents   bool
+	AllObjects bool
+	Unordered  bool
+	Buffer     bool
+	// FollowSymlinks resolves symlinks met looking up "<rev>:<path>"
+	FollowSymlinks bool
+}
+
+func parseBatchArgs(args []string) (batchOptions, error) {
+	opts := batchOptions{}
+	mode := ""
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--batch", "--batch-check":
+			if mode != "" {
+				return opts, fmt.Errorf("only one batch option may be specified")
+			}
+			mode, opts.Contents, opts.Format = name, name == "--batch", defaultBatchFormat
+			if hasValue {
+				opts.Format = value
+			}
+			continue
+		}
+		switch {
+		case hasValue:
+			return opts, fmt.Errorf("unknown option %s", arg)
+		case arg == "--batch-all-objects":
+			opts.AllObjects = true
+		case arg == "--unordered":
+			opts.Unordered = true
+		case arg == "--buffer":
+			opts.Buffer = true
+		case arg == "--follow-symlinks":
+			opts.FollowSymlinks = true
+		default:
+			return opts, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	if mode == "" {
+		return opts, fmt.Errorf("'--batch-all-objects' requires a batch mode")
+	}
+	return opts, nil
+}
+
+var batchFormatAtoms = []string{"objectname", "objecttype", "objectsize", "objectsize:disk", "deltabase", "rest"}
+
+// checkBatchFormat rejects unknown atoms before any object is read, like git
+// does even when there is no input.
+func checkBatchFormat(format string) error {
+	for {
+		start := strings.Index(format, "%(")
+		if start == -1 {
+			return nil
+		}
+		end := strings.IndexByte(format[start:], ')')
+		if end == -1 {
+			return nil
+		}
+		if atom := format[start+2 : start+end]; !slices.Contains(batchFormatAtoms, atom) {
+			return fmt.Errorf("unknown format element: %s", atom)
+		}
+		format = format[start+end+1:]
+	}
+}
+
+// expandBatchFormat fills in the %(atom) placeholders of a batch format for
+// one object. rest is what followed the object name on the input line.
+func expandBatchFormat(format string, hash string, or *objectReader, rest string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(format, "%(")
+		if start == -1 {
+			b.WriteString(format)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(format[start:], ')')
+		if end == -1 {
+			b.WriteString(format)
+			return b.String(), nil
+		}
+		b.WriteString(format[:start])
+		atom := format[start+2 : start+end]
+		switch atom {
+		case "objectname":
+			b.WriteString(hash)
+		case "objecttype":
+			b.WriteString(string(or.Type))
+		case "objectsize":
+			fmt.Fprint(&b, or.Size)
+		case "objectsize:disk":
+			info, err := os.Stat(or.path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprint(&b, info.Size())
+		case "deltabase":
+			// loose objects are never deltas
+			b.WriteString(zeroHash)
+		case "rest":
+			b.WriteString(rest)
+		default:
+			return "", fmt.Errorf("unknown format element: %s", atom)
+		}
+		format = format[start+end+1:]
+	}
+}
+
+// jsonBatchObject is what --batch-check prints for an object with --json,
+// whatever its format.
+type jsonBatchObject struct {
+	Oid  string `json:"oid"`
+	Type string `json:"type"`
+	Size int    `json:"size"`
+	Rest string `json:"rest,omitempty"`
+}
+
+type jsonMissingObject struct {
+	Name    string `json:"name"`
+	Missing bool   `json:"missing"`
+}
+
+func writeBatchObject(w io.Writer, opts batchOptions, hash string, rest string) error {
+	or, err := openObject(hash)
+	if err != nil {
+		return err
+	}
+	defer or.Close()
+	if jsonOutput {
+		record := jsonBatchObject{Oid: hash, Type: string(or.Type), Size: or.Size, Rest: rest}
+		return writeJSONLine(w, record)
+	}
+	header, err := expandBatchFormat(opts.Format, hash, or, rest)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, header)
+	if !opts.Contents {
+		return nil
+	}
+	if _, err := io.Copy(w, or); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// maxSymlinkFollows is how many symlinks a path lookup follows before it
+// is taken for a loop.
+const maxSymlinkFollows = 40
+
+var (
+	errDanglingSymlink = errors.New("dangling symlink")
+	errSymlinkLoop     = errors.New("symlink loop")
+	errNotDir          = errors.New("not a directory")
+)
+
+// followTreePath finds path in a tree the way git's --follow-symlinks does:
+// symlinks met on the way, the last component included, are resolved
+// inside the tree. A link leading out of it, being absolute or going above
+// the top with "..", ends the lookup with the rest of the path as outside.
+// A missing entry is errRefNotFound until a symlink was followed, and
+// errDanglingSymlink after.
+func followTreePath(tree string, path string) (hash string, outside string, err error) {
+	type dir struct {
+		hash    string
+		entries []TreeObjectLine
+	}
+	parents := []dir{}
+	missing, follows := errRefNotFound, maxSymlinkFollows
+	current, name, descend := tree, path, true
+	for {
+		if descend {
+			entries, err := readTree(current)
+			if err != nil {
+				return "", "", missing
+			}
+			parents = append(parents, dir{current, entries})
+			if name == "" {
+				return current, "", nil
+			}
+			descend = false
+		}
+		name = strings.TrimLeft(name, "/")
+		first, remainder, hasSlash := strings.Cut(name, "/")
+		if first == ".." {
+			if len(parents) == 1 {
+				return "", name, nil
+			}
+			parents, name = parents[:len(parents)-1], remainder
+			continue
+		}
+		// a link to "dir/.." ends up here
+		if name == "" {
+			return parents[len(parents)-1].hash, "", nil
+		}
+
+		entries := parents[len(parents)-1].entries
+		i := slices.IndexFunc(entries, func(e TreeObjectLine) bool { return e.Name == first })
+		if i == -1 {
+			return "", "", missing
+		}
+		entryHash := hex.EncodeToString(entries[i].Hash)
+		switch entries[i].Mode {
+		case modeTree:
+			if !hasSlash {
+				return entryHash, "", nil
+			}
+			current, name, descend = entryHash, remainder, true
+		case modeSymlink:
+			if follows == 0 {
+				return "", "", errSymlinkLoop
+			}
+			follows--
+			missing = errDanglingSymlink
+			object, err := parseObject(entryHash)
+			if err != nil {
+				return "", "", missing
+			}
+			target := string(object.Content)
+			if strings.HasPrefix(target, "/") {
+				return "", target, nil
+			}
+			// the target is looked up from the directory holding the link
+			name = target
+			if hasSlash {
+				name += "/" + remainder
+			}
+		default:
+			if hasSlash {
+				return "", "", errNotDir
+			}
+			return entryHash, "", nil
+		}
+	}
+}
+
+// resolveBatchName resolves an object name read by a batch. With
+// FollowSymlinks a "<rev>:<path>" goes through followTreePath, and outside
+// is set when it leads out of the tree.
+func resolveBatchName(opts batchOptions, name string) (hash string, outside string, err error) {
+	rev, path, found := strings.Cut(name, ":")
+	if !opts.FollowSymlinks || !found || rev == "" {
+		hash, err = resolveRevision(name)
+		return hash, "", err
+	}
+	tree, err := resolveTreeish(rev)
+	if err != nil {
+		return "", "", errRefNotFound
+	}
+	return followTreePath(tree, revisionPath(path))
+}
+
+// runCatFileBatch prints the objects named on stdin, or every loose object
+// with --batch-all-objects. Those are listed as stored, without replace
+// refs, in hash order, which is also the order they are found in with
+// --unordered.
+func runCatFileBatch(args []string) error {
+	opts, err := parseBatchArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := checkBatchFormat(opts.Format); err != nil {
+		return err
+	}
+	if jsonOutput && opts.Contents {
+		return fmt.Errorf("--json only works with --batch-check")
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if opts.AllObjects {
+		readReplaceRefs = false
+		hashes, err := listLooseObjects()
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := writeBatchObject(w, opts, hash, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	splitRest := strings.Contains(opts.Format, "%(rest)")
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		name, rest := scanner.Text(), ""
+		if splitRest {
+			if i := strings.IndexAny(name, " \t"); i != -1 {
+				name, rest = name[:i], strings.TrimLeft(name[i+1:], " \t")
+			}
+		}
+		hash, outside, err := resolveBatchName(opts, name)
+		if err == nil && outside == "" && !objectExists(hash) {
+			err = errRefNotFound
+		}
+		switch {
+		case outside != "":
+			fmt.Fprintf(w, "symlink %d\n%s\n", len(outside), outside)
+		case err == errDanglingSymlink:
+			fmt.Fprintf(w, "dangling %d\n%s\n", len(name), name)
+		case err == errSymlinkLoop:
+			fmt.Fprintf(w, "loop %d\n%s\n", len(name), name)
+		case err == errNotDir:
+			fmt.Fprintf(w, "notdir %d\n%s\n", len(name), name)
+		case err != nil && jsonOutput:
+			if err := writeJSONLine(w, jsonMissingObject{Name: name, Missing: true}); err != nil {
+				return err
+			}
+		case err != nil:
+			fmt.Fprintf(w, "%s missing\n", name)
+		default:
+			if err := writeBatchObject(w, opts, hash, rest); err != nil {
+				return err
+			}
+		}
+		if !opts.Buffer {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+const checkMailmapUsage = "mygit check-mailmap [--stdin] <contact>..."
+
+// writeMappedContact shows the canonical "Name <email>" for a contact, or
+// "<email>" when it has no name.
+func writeMappedContact(w io.Writer, mm mailmap, contact string) error {
+	name, email, ok := parseContact(contact)
+	if !ok {
+		return fmt.Errorf("unable to parse contact: %s", contact)
+	}
+	name, email = mm.lookup(name, email)
+	if name == "" {
+		_, err := fmt.Fprintf(w, "<%s>\n", email)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s <%s>\n", name, email)
+	return err
+}
+
+// runCheckMailmap maps each contact given, then those read from stdin a
+// line at a time with --stdin, through the mailmap.
+func runCheckMailmap(args []string) error {
+	useStdin := false
+	flags := newFlagSet()
+	flags.Bool(&useStdin, "--stdin")
+	contacts, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(contacts) == 0 && !useStdin {
+		return fmt.Errorf("no contacts specified")
+	}
+	mm, err := readMailmap()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, contact := range contacts {
+		if err := writeMappedContact(w, mm, contact); err != nil {
+			return err
+		}
+	}
+	if !useStdin {
+		return nil
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := writeMappedContact(w, mm, scanner.Text()); err != nil {
+			return err
+		}
+		// answer each line as it comes, for callers holding a pipe open
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
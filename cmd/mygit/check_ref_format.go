@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const checkRefFormatUsage = "mygit check-ref-format [--normalize] [--[no-]allow-onelevel] [--refspec-pattern] <refname>\n   or: mygit check-ref-format --branch <branchname>"
+
+// normalizeRefName drops the leading slashes of a ref name and collapses
+// runs of slashes, as check-ref-format --normalize does.
+func normalizeRefName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' && (b.Len() == 0 || name[i-1] == '/') {
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// runCheckRefFormat tells through its exit code whether a name is valid
+// for a ref, printing it when --normalize or --branch is given.
+func runCheckRefFormat(args []string) error {
+	normalize, onelevel, noOnelevel, pattern, branch := false, false, false, false, false
+	flags := newFlagSet()
+	flags.Bool(&normalize, "--normalize", "--print")
+	flags.Bool(&onelevel, "--allow-onelevel")
+	flags.Bool(&noOnelevel, "--no-allow-onelevel")
+	flags.Bool(&pattern, "--refspec-pattern")
+	flags.Bool(&branch, "--branch")
+	names, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("usage: %s", checkRefFormatUsage)
+	}
+	name := names[0]
+
+	if branch {
+		if strings.HasPrefix(name, "-") || name == "HEAD" || !isValidRefName("refs/heads/"+name) {
+			return fmt.Errorf("'%s' is not a valid branch name", name)
+		}
+		fmt.Println(name)
+		return nil
+	}
+
+	format := refFormat(0)
+	if onelevel && !noOnelevel {
+		format |= refAllowOnelevel
+	}
+	if pattern {
+		format |= refRefspecPattern
+	}
+	if normalize {
+		name = normalizeRefName(name)
+	}
+	if !checkRefFormat(name, format) {
+		return errQuietFailure
+	}
+	if normalize {
+		fmt.Println(name)
+	}
+	return nil
+}
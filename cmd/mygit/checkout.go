@@ -0,0 +1,1197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// resolveHead returns the commit HEAD points to, or "" on an unborn branch.
+func resolveHead() (string, error) {
+	hash, _, err := resolveRef("HEAD")
+	if err == errRefNotFound {
+		return "", nil
+	}
+	return hash, err
+}
+
+// unbornBranch returns the branch HEAD points to when it has no commits
+// yet, as in a new repository or after switch --orphan.
+func unbornBranch() (string, bool) {
+	branch, ok, err := currentBranch()
+	if err != nil || !ok {
+		return "", false
+	}
+	if _, _, err := resolveRef(branch); err != errRefNotFound {
+		return "", false
+	}
+	return branch, true
+}
+
+// errUnbornBranch is what commands defaulting to HEAD report on an unborn
+// branch, instead of failing to resolve HEAD.
+func errUnbornBranch(branch string) error {
+	return fmt.Errorf("your current branch '%s' does not have any commits yet", shortenRefName(branch))
+}
+
+// resolveTreeish resolves a revision naming a commit, tag or tree to a tree.
+func resolveTreeish(spec string) (string, error) {
+	hash, err := resolveRevision(spec)
+	if err != nil {
+		return "", err
+	}
+	tree, err := peelObject(hash, TypeTree)
+	if err != nil {
+		return "", fmt.Errorf("reference is not a tree: %s", spec)
+	}
+	return tree, nil
+}
+
+func indexEntryMatches(index *Index, path string, mode int, hash string) bool {
+	i := index.find(path)
+	if hash == zeroHash {
+		return i == -1
+	}
+	return i != -1 && index.Entries[i].Mode == mode && index.Entries[i].Hash == hash
+}
+
+type checkoutOptions struct {
+	Quiet bool
+	// Force overwrites local changes instead of refusing to
+	Force bool
+	// Merge carries local changes to paths that differ between the commits
+	// over with a three-way merge, leaving conflicts in the index
+	Merge    bool
+	Conflict string
+	// Label names the commit checked out in conflict markers
+	Label string
+}
+
+// checkoutCommit moves the index and worktree from one commit to another.
+// Paths that differ between the two are only touched when they have no local
+// changes; local changes to other paths are carried over.
+func checkoutCommit(oldCommit string, newCommit string, opts checkoutOptions) error {
+	oldTree, err := commitTreeHash(oldCommit)
+	if err != nil {
+		return err
+	}
+	newTree, err := commitTreeHash(newCommit)
+	if err != nil {
+		return err
+	}
+	changes, err := diffTrees(oldTree, newTree, "")
+	if err != nil {
+		return err
+	}
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+
+	if opts.Force {
+		oldIndex := &Index{Entries: append([]IndexEntry(nil), index.Entries...), Timestamp: index.Timestamp}
+		if err := resetIndex(index, newTree); err != nil {
+			return err
+		}
+		if err := resetWorktree(oldIndex, index); err != nil {
+			return err
+		}
+		if err := writeIndex(index, lock); err != nil {
+			return err
+		}
+		return checkInterrupted()
+	}
+	unmerged := make([]string, 0)
+	for _, e := range index.Entries {
+		if e.Stage() != 0 && (len(unmerged) == 0 || unmerged[len(unmerged)-1] != e.Path) {
+			unmerged = append(unmerged, e.Path)
+		}
+	}
+	if len(unmerged) > 0 {
+		return fmt.Errorf("you need to resolve your current index first\n%s: needs merge", strings.Join(unmerged, ": needs merge\n"))
+	}
+
+	updates := make([]fileChange, 0, len(changes))
+	dirty, untracked := make([]string, 0), make([]string, 0)
+	for _, c := range changes {
+		if indexEntryMatches(index, c.Path, c.NewMode, c.NewHash) {
+			// already staged as it is in the new commit
+			continue
+		}
+		if !indexEntryMatches(index, c.Path, c.OldMode, c.OldHash) {
+			dirty = append(dirty, c.Path)
+			continue
+		}
+		if c.Status == 'A' {
+			// with core.ignoreCase a case-only rename finds the old file,
+			// which is removed before the new one is written
+			if _, err := os.Lstat(c.Path); err == nil && !trackedUnderOtherCase(index, c.Path) {
+				untracked = append(untracked, c.Path)
+				continue
+			}
+		} else {
+			clean, err := worktreeContentMatches(index, &index.Entries[index.find(c.Path)])
+			if err != nil {
+				return err
+			}
+			// a file deleted locally is not in the way of its new version
+			if _, statErr := os.Lstat(c.Path); !clean && statErr == nil {
+				dirty = append(dirty, c.Path)
+				continue
+			}
+		}
+		updates = append(updates, c)
+	}
+	if len(untracked) > 0 {
+		return errorWithCode("untracked-files", "the following untracked working tree files would be overwritten by checkout:\n\t%s\nPlease move or remove them before you switch branches.", strings.Join(untracked, "\n\t"))
+	}
+	if len(dirty) > 0 && opts.Merge {
+		if err := mergeLocalChanges(index, oldCommit, oldTree, newTree, opts); err != nil {
+			return err
+		}
+		updates = nil
+	} else if len(dirty) > 0 {
+		return errorWithCode("dirty-worktree", "your local changes to the following files would be overwritten by checkout:\n\t%s\nPlease commit your changes or stash them before you switch branches.", strings.Join(dirty, "\n\t"))
+	}
+
+	progress := startProgress("Updating files", len(updates), opts.Quiet)
+	done := 0
+	// deletions first, a file may be replaced by a directory of the same name
+	for _, c := range updates {
+		if commandContext.Err() != nil {
+			break
+		}
+		if c.Status == 'D' {
+			index.remove(c.Path)
+			if err := removeWorktreeFile(c.Path); err != nil {
+				return err
+			}
+			done++
+			progress.update(done)
+		}
+	}
+	for _, c := range updates {
+		if commandContext.Err() != nil {
+			break
+		}
+		if c.Status != 'D' {
+			entry, err := checkoutFile(c.Path, c.NewMode, c.NewHash)
+			if err != nil {
+				return err
+			}
+			// like git, skip-worktree survives the update, assume-unchanged not
+			if i := index.find(c.Path); i != -1 && index.Entries[i].skipWorktree() {
+				entry.ExtendedFlags = index.Entries[i].ExtendedFlags
+			}
+			index.set(entry)
+			done++
+			progress.update(done)
+		}
+	}
+	progress.stop()
+	// on interrupt the index still records the files updated so far
+	if err := writeIndex(index, lock); err != nil {
+		return err
+	}
+	if err := checkInterrupted(); err != nil {
+		return err
+	}
+	if opts.Quiet {
+		return nil
+	}
+	return showLocalChanges(index, newTree)
+}
+
+// mergeLocalChanges checks out newTree merging in the local changes made on
+// top of oldTree, "local" in conflict markers. The index ends up at newTree
+// but for the stages of conflicted paths.
+func mergeLocalChanges(index *Index, oldCommit string, oldTree string, newTree string, opts checkoutOptions) error {
+	style, err := resolveConflictStyle(opts.Conflict)
+	if err != nil {
+		return err
+	}
+	oldFiles, err := flattenTree(oldTree, "")
+	if err != nil {
+		return err
+	}
+	// like git, only changes not yet staged are carried over
+	staged := make([]string, 0)
+	inOld := make(map[string]bool, len(oldFiles))
+	for _, f := range oldFiles {
+		inOld[f.Path] = true
+		if !indexEntryMatches(index, f.Path, f.Mode, f.Hash) {
+			staged = append(staged, f.Path)
+		}
+	}
+	for _, e := range index.Entries {
+		if !inOld[e.Path] {
+			staged = append(staged, e.Path)
+		}
+	}
+	if len(staged) > 0 {
+		sort.Strings(staged)
+		return fmt.Errorf("cannot continue with staged changes in the following files:\n%s", strings.Join(staged, "\n"))
+	}
+
+	work := &Index{Entries: append([]IndexEntry(nil), index.Entries...), Timestamp: index.Timestamp}
+	if err := stageTrackedChanges(work); err != nil {
+		return err
+	}
+	workTree, err := writeIndexTree(work.Entries, "")
+	if err != nil {
+		return err
+	}
+	baseLabel := abbrevHash(oldCommit)
+	if current, onBranch, err := currentBranch(); err == nil && onBranch {
+		baseLabel = strings.TrimPrefix(current, "refs/heads/")
+	}
+	m := &treeMerge{OursLabel: opts.Label, BaseLabel: baseLabel, TheirsLabel: "local", Style: style, MarkerSize: conflictMarkerSize}
+	mergedTree, err := m.mergeTrees(oldTree, newTree, workTree, "")
+	if err != nil {
+		return err
+	}
+
+	workFiles, err := flattenTree(workTree, "")
+	if err != nil {
+		return err
+	}
+	mergedFiles, err := flattenTree(mergedTree, "")
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]treeFile, len(mergedFiles))
+	for _, f := range mergedFiles {
+		merged[f.Path] = f
+	}
+	worktree := make(map[string]treeFile, len(workFiles))
+	for _, f := range workFiles {
+		worktree[f.Path] = f
+		if _, ok := merged[f.Path]; !ok {
+			if err := removeWorktreeFile(f.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := resetIndex(index, newTree); err != nil {
+		return err
+	}
+	for _, f := range mergedFiles {
+		if w, ok := worktree[f.Path]; ok && w.Mode == f.Mode && w.Hash == f.Hash {
+			continue
+		}
+		entry, err := checkoutFile(f.Path, f.Mode, f.Hash)
+		if err != nil {
+			return err
+		}
+		if indexEntryMatches(index, f.Path, f.Mode, f.Hash) {
+			index.set(entry)
+		}
+	}
+	for _, s := range m.Stages {
+		if index.find(s.Path) != -1 {
+			index.remove(s.Path)
+		}
+	}
+	for _, s := range m.Stages {
+		index.Entries = append(index.Entries, IndexEntry{Mode: s.Mode, Hash: s.Hash, Flags: uint16(s.Stage) << 12, Path: s.Path})
+	}
+	return nil
+}
+
+// showLocalChanges lists the paths that differ from tree in the index or
+// worktree after a checkout, like "diff-index --name-status" does.
+func showLocalChanges(index *Index, tree string) error {
+	files, err := flattenTree(tree, "")
+	if err != nil {
+		return err
+	}
+	inTree := make(map[string]treeFile, len(files))
+	for _, f := range files {
+		inTree[f.Path] = f
+	}
+	changes := make([]fileChange, 0)
+	for i := 0; i < len(index.Entries); i++ {
+		e := &index.Entries[i]
+		f, ok := inTree[e.Path]
+		delete(inTree, e.Path)
+		if e.Stage() != 0 {
+			i = stagesEnd(index.Entries, i) - 1
+			changes = append(changes, fileChange{Status: 'M', Path: e.Path})
+			continue
+		}
+		status, _, err := worktreeStatus(index, e)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !ok && status == 'D':
+			continue
+		case !ok:
+			status = 'A'
+		case status == ' ':
+			status = changeLetter(f.Mode, f.Hash, e.Mode, e.Hash)
+		}
+		if status != ' ' {
+			changes = append(changes, fileChange{Status: status, Path: e.Path})
+		}
+	}
+	for _, f := range files {
+		if _, ok := inTree[f.Path]; ok {
+			changes = append(changes, fileChange{Status: 'D', Path: f.Path})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, c := range changes {
+		fmt.Fprintf(w, "%c\t%s\n", c.Status, c.Path)
+	}
+	return nil
+}
+
+// guessRemoteBranch finds the single remote-tracking branch named
+// <remote>/<name>, for "switch <name>" to create a tracking branch from.
+func guessRemoteBranch(name string) (string, error) {
+	refs, err := listRefs("refs/remotes/")
+	if err != nil {
+		return "", err
+	}
+	found := ""
+	for _, r := range refs {
+		_, branch, ok := strings.Cut(strings.TrimPrefix(r.Name, "refs/remotes/"), "/")
+		if ok && branch == name {
+			if found != "" {
+				return "", nil
+			}
+			found = r.Name
+		}
+	}
+	return found, nil
+}
+
+// warnLeavingCommits tells the user about commits made on a detached HEAD
+// that no ref can reach anymore once HEAD moves to newHead.
+func warnLeavingCommits(oldHead string, newHead string) error {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+	exclude := []string{newHead}
+	for _, r := range refs {
+		if hash, err := peelToCommit(r.Hash); err == nil {
+			exclude = append(exclude, hash)
+		}
+	}
+	walk, err := newRevWalk([]string{oldHead}, exclude)
+	if err != nil {
+		return err
+	}
+	lost := make([]*Commit, 0)
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		lost = append(lost, commit)
+	}
+
+	if len(lost) == 0 {
+		commit, err := readCommit(oldHead)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Previous HEAD position was %s %s\n", abbrevHash(oldHead), commit.Subject())
+		return nil
+	}
+
+	const orphanCutoff = 4
+	var b strings.Builder
+	for i, c := range lost {
+		if i < orphanCutoff || (i == orphanCutoff && len(lost) == orphanCutoff+1) {
+			fmt.Fprintf(&b, "  %s %s\n", abbrevHash(c.Hash), c.Subject())
+		}
+	}
+	if len(lost) > orphanCutoff+1 {
+		fmt.Fprintf(&b, " ... and %d more.\n", len(lost)-orphanCutoff)
+	}
+	noun, pronoun := "commit", "it"
+	if len(lost) > 1 {
+		noun, pronoun = "commits", "them"
+	}
+	fmt.Fprintf(os.Stderr, "Warning: you are leaving %d %s behind, not connected to\nany of your branches:\n\n%s\n", len(lost), noun, b.String())
+	fmt.Fprintf(os.Stderr, "If you want to keep %s by creating a new branch, this may be a good time\nto do so with:\n\n git branch <new-branch-name> %s\n\n", pronoun, abbrevHash(oldHead))
+	return nil
+}
+
+// updateHead points HEAD at refName, or detaches it at target when refName
+// is "", and records the move in the HEAD reflog. The worktree must already
+// be checked out.
+func updateHead(oldHead string, target string, refName string, reflogTo string, quiet bool) error {
+	current, onBranch, err := currentBranch()
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+	if !quiet && !onBranch && oldHead != "" && oldHead != target {
+		if err := warnLeavingCommits(oldHead, target); err != nil {
+			return err
+		}
+	}
+
+	from := oldHead
+	if onBranch {
+		from = strings.TrimPrefix(current, "refs/heads/")
+	}
+	value := target
+	if refName != "" {
+		value = "ref: " + refName
+	}
+	t := beginRefTransaction()
+	if err := t.update("HEAD", value, false, "", fmt.Sprintf("checkout: moving from %s to %s", from, reflogTo)); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// previousCheckout resolves "-" to the branch or commit checked out before
+// the last checkout.
+func previousCheckout() (string, error) {
+	_, from, _, found, err := lastCheckout()
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("no previous branch to switch to")
+	}
+	return from, nil
+}
+
+const detachedHeadAdvice = `Note: switching to '%s'.
+
+You are in 'detached HEAD' state. You can look around, make experimental
+changes and commit them, and you can discard any commits you make in this
+state without impacting any branches by switching back to a branch.
+
+If you want to create a new branch to retain commits you create, you may
+do so (now or later) by using -c with the switch command. Example:
+
+  git switch -c <new-branch-name>
+
+Or undo this operation with:
+
+  git switch -
+
+Turn off this advice by setting config variable advice.detachedHead to false
+
+`
+
+// detachHead checks out a commit without a branch. The long advice is only
+// given when leaving a branch without asking for --detach explicitly.
+func detachHead(spec string, advise bool, opts checkoutOptions) error {
+	opts.Label = spec
+	target, err := resolveCommitRevision(spec)
+	if err != nil {
+		return fmt.Errorf("invalid reference: %s%s", spec, refSuggestion(spec))
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	_, onBranch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if err := checkoutCommit(head, target, opts); err != nil {
+		return err
+	}
+	if err := updateHead(head, target, "", spec, opts.Quiet); err != nil {
+		return err
+	}
+	if opts.Quiet {
+		return nil
+	}
+
+	if advise && onBranch {
+		config, err := getConfig()
+		if err != nil {
+			return err
+		}
+		if enabled, err := config.GetBool("advice.detachedHead", true); err == nil && enabled {
+			fmt.Fprintf(os.Stderr, detachedHeadAdvice, spec)
+		}
+	}
+	commit, err := readCommit(target)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "HEAD is now at %s %s\n", abbrevHash(target), commit.Subject())
+	return nil
+}
+
+// detachedHeadDescription describes a detached HEAD like git does, based on
+// the last checkout recorded in the HEAD reflog.
+func detachedHeadDescription(head string) (string, error) {
+	entry, _, to, found, err := lastCheckout()
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "(no branch)", nil
+	}
+	from := abbrevHash(entry.New)
+	for _, candidate := range dwimRefs(to) {
+		hash, _, err := resolveRef(candidate)
+		if err != nil || !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if peeled, err := peelToCommit(hash); err == nil && peeled == entry.New {
+			from = strings.TrimPrefix(strings.TrimPrefix(candidate, "refs/tags/"), "refs/remotes/")
+		}
+		break
+	}
+	if head == entry.New {
+		return fmt.Sprintf("(HEAD detached at %s)", from), nil
+	}
+	return fmt.Sprintf("(HEAD detached from %s)", from), nil
+}
+
+func switchBranch(name string, guess bool, opts checkoutOptions) error {
+	opts.Label = name
+	refName := "refs/heads/" + name
+	current, _, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+
+	target, _, err := resolveRef(refName)
+	if err == errRefNotFound && guess {
+		remoteRef, err := guessRemoteBranch(name)
+		if err != nil {
+			return err
+		}
+		if remoteRef != "" {
+			remoteHash, _, err := resolveRef(remoteRef)
+			if err != nil {
+				return err
+			}
+			if err := checkoutCommit(head, remoteHash, opts); err != nil {
+				return err
+			}
+			if err := createBranch(name, shortenRefName(remoteRef), true, false); err != nil {
+				return err
+			}
+			if err := updateHead(head, remoteHash, refName, name, opts.Quiet); err != nil {
+				return err
+			}
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", name)
+			}
+			return nil
+		}
+	}
+	if err == errRefNotFound {
+		if _, err := resolveCommitRevision(name); err == nil {
+			kind := "commit"
+			if _, _, err := resolveRef("refs/tags/" + name); err == nil {
+				kind = "tag"
+			} else if _, _, err := resolveRef("refs/remotes/" + name); err == nil {
+				kind = "remote branch"
+			}
+			return fmt.Errorf("a branch is expected, got %s '%s'\nhint: If you want to detach HEAD at the commit, try again with the --detach option.", kind, name)
+		}
+		return fmt.Errorf("invalid reference: %s%s", name, refSuggestion(name))
+	}
+	if err != nil {
+		return err
+	}
+
+	if current == refName {
+		// still resets with --force and lists what is changed locally
+		if err := checkoutCommit(head, head, opts); err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Already on '%s'\n", name)
+		}
+		return nil
+	}
+	if err := checkoutCommit(head, target, opts); err != nil {
+		return err
+	}
+	if err := updateHead(head, target, refName, name, opts.Quiet); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", name)
+	}
+	return nil
+}
+
+func switchNewBranch(name string, startPoint string, force bool, opts checkoutOptions) error {
+	opts.Label = name
+	refName := "refs/heads/" + name
+	if _, _, err := resolveRef(refName); err == nil && !force {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	if head == "" && startPoint == "" {
+		// nothing to check out on an unborn branch
+		if err := writeSymbolicRef("HEAD", refName); err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", name)
+		}
+		return nil
+	}
+	if startPoint == "" {
+		startPoint = "HEAD"
+	}
+
+	target, err := resolveCommitRevision(startPoint)
+	if err != nil {
+		return fmt.Errorf("invalid reference: %s%s", startPoint, refSuggestion(startPoint))
+	}
+	if err := checkoutCommit(head, target, opts); err != nil {
+		return err
+	}
+	if err := createBranch(name, startPoint, true, force); err != nil {
+		return err
+	}
+	if err := updateHead(head, target, refName, name, opts.Quiet); err != nil {
+		return err
+	}
+	switch {
+	case opts.Quiet:
+	case force:
+		fmt.Fprintf(os.Stderr, "Switched to and reset branch '%s'\n", name)
+	default:
+		fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", name)
+	}
+	return nil
+}
+
+// switchOrphanBranch points HEAD at a new unborn branch, so the next commit
+// starts a history of its own. switch --orphan empties the index and the
+// worktree, checkout --orphan keeps them as of startPoint (HEAD by default)
+// for that first commit.
+func switchOrphanBranch(name string, startPoint string, keep bool, opts checkoutOptions) error {
+	opts.Label = name
+	refName := "refs/heads/" + name
+	if !isValidRefName(refName) {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	if _, _, err := resolveRef(refName); err == nil {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	target := ""
+	if keep {
+		target = head
+		if startPoint != "" {
+			if target, err = resolveCommitRevision(startPoint); err != nil {
+				return fmt.Errorf("invalid reference: %s%s", startPoint, refSuggestion(startPoint))
+			}
+		}
+	}
+	if target != head {
+		if err := checkoutCommit(head, target, opts); err != nil {
+			return err
+		}
+	}
+	if err := writeSymbolicRef("HEAD", refName); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", name)
+	}
+	return nil
+}
+
+func runSwitch(args []string) error {
+	newBranch, orphan, force, guess, detach := "", "", false, true, false
+	opts := checkoutOptions{}
+	names := make([]string, 0, 2)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-c" || arg == "--create" || arg == "-C" || arg == "--force-create":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			force = arg == "-C" || arg == "--force-create"
+			i++
+			newBranch = args[i]
+		case arg == "--orphan":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			orphan = args[i]
+		case arg == "-d" || arg == "--detach":
+			detach = true
+		case arg == "--no-guess":
+			guess = false
+		case arg == "--guess":
+			guess = true
+		case arg == "-q" || arg == "--quiet":
+			opts.Quiet = true
+		case arg == "-f" || arg == "--force" || arg == "--discard-changes":
+			opts.Force = true
+		case arg == "-m" || arg == "--merge":
+			opts.Merge = true
+		case strings.HasPrefix(arg, "--conflict="):
+			opts.Merge, opts.Conflict = true, strings.TrimPrefix(arg, "--conflict=")
+		case arg == "-":
+			names = append(names, arg)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) > 0 && names[0] == "-" {
+		previous, err := previousCheckout()
+		if err != nil {
+			return err
+		}
+		names[0] = previous
+	}
+
+	if orphan != "" {
+		if newBranch != "" || detach || len(names) > 0 {
+			return fmt.Errorf("usage: switch --orphan <new-branch>")
+		}
+		return switchOrphanBranch(orphan, "", false, opts)
+	}
+	if newBranch != "" {
+		if detach {
+			return fmt.Errorf("options -c and --detach cannot be used together")
+		}
+		if len(names) > 1 {
+			return fmt.Errorf("usage: switch -c <new-branch> [<start-point>]")
+		}
+		startPoint := ""
+		if len(names) == 1 {
+			startPoint = names[0]
+		}
+		return switchNewBranch(newBranch, startPoint, force, opts)
+	}
+	if detach {
+		if len(names) > 1 {
+			return fmt.Errorf("usage: switch --detach [<commit>]")
+		}
+		if len(names) == 0 {
+			names = append(names, "HEAD")
+		}
+		return detachHead(names[0], false, opts)
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("usage: switch <branch>")
+	}
+	return switchBranch(names[0], guess, opts)
+}
+
+// runCheckout switches branches, detaches HEAD at a commit, or restores
+// paths, depending on its arguments like the old porcelain does.
+func runCheckout(args []string) error {
+	newBranch, orphan, force, detach, dashDash := "", "", false, false, false
+	opts := checkoutOptions{}
+	names, pathspecs := make([]string, 0, 1), []string(nil)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			pathspecs = append(make([]string, 0), args[i+1:]...)
+			i = len(args)
+			dashDash = true
+		case arg == "-b" || arg == "-B":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			force = arg == "-B"
+			i++
+			newBranch = args[i]
+		case arg == "--orphan":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			orphan = args[i]
+		case arg == "--detach":
+			detach = true
+		case arg == "-q" || arg == "--quiet":
+			opts.Quiet = true
+		case arg == "-f" || arg == "--force":
+			opts.Force = true
+		case arg == "-m" || arg == "--merge":
+			opts.Merge = true
+		case strings.HasPrefix(arg, "--conflict="):
+			opts.Merge, opts.Conflict = true, strings.TrimPrefix(arg, "--conflict=")
+		case arg == "-":
+			names = append(names, arg)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) > 0 && names[0] == "-" {
+		previous, err := previousCheckout()
+		if err != nil {
+			return err
+		}
+		names[0] = previous
+	}
+
+	// without "--", the first argument is a revision if it resolves to one
+	if pathspecs == nil && len(names) > 0 && newBranch == "" && orphan == "" {
+		if _, err := resolveTreeish(names[0]); err != nil || len(names) > 1 {
+			if err == nil {
+				pathspecs = names[1:]
+				names = names[:1]
+			} else {
+				pathspecs, names = names, nil
+			}
+		}
+	}
+	if pathspecs != nil {
+		if len(names) > 1 || newBranch != "" || orphan != "" || detach {
+			return fmt.Errorf("cannot switch branches while updating paths")
+		}
+		if len(names) == 1 {
+			return restorePaths(restoreOptions{Source: names[0], Staged: true, Worktree: true, Overlay: true, Pathspecs: pathspecs})
+		}
+		return restorePaths(restoreOptions{Worktree: true, Pathspecs: pathspecs, Merge: opts.Merge, Conflict: opts.Conflict, ReportRecreated: !dashDash && !opts.Quiet})
+	}
+
+	if len(names) > 1 {
+		return fmt.Errorf("usage: checkout [<branch>|<commit>]")
+	}
+	if orphan != "" {
+		if newBranch != "" || detach {
+			return fmt.Errorf("options --orphan, -b and --detach cannot be used together")
+		}
+		startPoint := ""
+		if len(names) == 1 {
+			startPoint = names[0]
+		}
+		return switchOrphanBranch(orphan, startPoint, true, opts)
+	}
+	if newBranch != "" {
+		startPoint := ""
+		if len(names) == 1 {
+			startPoint = names[0]
+		}
+		return switchNewBranch(newBranch, startPoint, force, opts)
+	}
+	if len(names) == 0 && !detach {
+		// stays where HEAD is, only listing the local changes
+		head, err := resolveHead()
+		if err != nil {
+			return err
+		}
+		return checkoutCommit(head, head, opts)
+	}
+	if len(names) == 0 {
+		names = append(names, "HEAD")
+	}
+	if !detach {
+		if _, _, err := resolveRef("refs/heads/" + names[0]); err == nil {
+			return switchBranch(names[0], false, opts)
+		}
+		if remoteRef, err := guessRemoteBranch(names[0]); err == nil && remoteRef != "" {
+			if _, err := resolveCommitRevision(names[0]); err != nil {
+				return switchBranch(names[0], true, opts)
+			}
+		}
+	}
+	return detachHead(names[0], !detach, opts)
+}
+
+type restoreOptions struct {
+	Source    string
+	Staged    bool
+	Worktree  bool
+	Overlay   bool
+	Pathspecs []string
+	// IgnoreUnmatched skips the check that every pathspec names a file
+	IgnoreUnmatched bool
+	// Merge recreates the conflicted merge of unmerged paths from their
+	// stages, shown in Conflict style or else merge.conflictStyle
+	Merge    bool
+	Conflict string
+	// ReportRecreated counts the recreated conflicts on stderr, as checkout
+	// does for paths not given after "--"
+	ReportRecreated bool
+}
+
+// stagesEnd is where the stages of the unmerged path at i end in entries.
+func stagesEnd(entries []IndexEntry, i int) int {
+	end := i + 1
+	for end < len(entries) && entries[end].Path == entries[i].Path {
+		end++
+	}
+	return end
+}
+
+// checkUnmerged tells why the stages of an unmerged path cannot be checked
+// out: only their merge can, which needs ours and theirs.
+func checkUnmerged(stages []IndexEntry, merge bool) error {
+	if !merge {
+		return fmt.Errorf("path '%s' is unmerged", stages[0].Path)
+	}
+	found := 0
+	for _, e := range stages {
+		found |= 1 << e.Stage()
+	}
+	if found&(1<<2) == 0 || found&(1<<3) == 0 {
+		return fmt.Errorf("path '%s' does not have all necessary versions", stages[0].Path)
+	}
+	return nil
+}
+
+// resolveConflictStyle is the style given by --conflict, falling back to
+// merge.conflictStyle.
+func resolveConflictStyle(name string) (conflictStyle, error) {
+	if name == "" {
+		return configConflictStyle()
+	}
+	style, ok := parseConflictStyle(name)
+	if !ok {
+		return conflictStyleMerge, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'", name)
+	}
+	return style, nil
+}
+
+// checkoutMerged writes the merge of an unmerged path's stages to the
+// worktree, conflicts and all, leaving the stages in the index.
+func checkoutMerged(stages []IndexEntry, style conflictStyle) error {
+	path := stages[0].Path
+	versions := make([]*IndexEntry, 3)
+	for i := range stages {
+		versions[stages[i].Stage()-1] = &stages[i]
+	}
+	contents := make([][]byte, 3)
+	for i, e := range versions {
+		if e == nil {
+			continue
+		}
+		object, err := parseObject(e.Hash)
+		if err != nil {
+			return err
+		}
+		contents[i] = object.Content
+	}
+	merged := contents[1]
+	if isBinaryContent(contents[0]) || isBinaryContent(contents[1]) || isBinaryContent(contents[2]) {
+		fmt.Fprintf(os.Stderr, "warning: Cannot merge binary files: %s (ours vs. theirs)\n", path)
+	} else {
+		opts := mergeOptions{OursLabel: "ours", BaseLabel: "base", TheirsLabel: "theirs", Style: style}
+		merged, _ = mergeContent(contents[0], contents[1], contents[2], opts)
+	}
+	hash, err := writeObject(TypeBlob, merged)
+	if err != nil {
+		return err
+	}
+	_, err = checkoutFile(path, versions[1].Mode, hash)
+	return err
+}
+
+func runRestore(args []string) error {
+	staged, worktree, overlay, merge := false, false, false, false
+	source, conflict := "", ""
+	pathspecs := make([]string, 0, 1)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			pathspecs = append(pathspecs, args[i+1:]...)
+			i = len(args)
+		case arg == "-S" || arg == "--staged":
+			staged = true
+		case arg == "-W" || arg == "--worktree":
+			worktree = true
+		case arg == "-s" || arg == "--source":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			source = args[i]
+		case strings.HasPrefix(arg, "--source="):
+			source = strings.TrimPrefix(arg, "--source=")
+		case arg == "--overlay":
+			overlay = true
+		case arg == "--no-overlay":
+			overlay = false
+		case arg == "-m" || arg == "--merge":
+			merge = true
+		case strings.HasPrefix(arg, "--conflict="):
+			merge, conflict = true, strings.TrimPrefix(arg, "--conflict=")
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			pathspecs = append(pathspecs, arg)
+		}
+	}
+	if len(pathspecs) == 0 {
+		return fmt.Errorf("you must specify path(s) to restore")
+	}
+	return restorePaths(restoreOptions{Source: source, Staged: staged, Worktree: worktree, Overlay: overlay, Pathspecs: pathspecs, Merge: merge, Conflict: conflict})
+}
+
+// restorePaths restores files from the index, or from a tree when a source
+// is given, into the worktree and/or the index.
+func restorePaths(opts restoreOptions) error {
+	staged, worktree, overlay, source := opts.Staged, opts.Worktree, opts.Overlay, opts.Source
+	pathspecs, err := parsePathspecs(opts.Pathspecs)
+	if err != nil {
+		return err
+	}
+	if !staged && !worktree {
+		worktree = true
+	}
+	if opts.Merge && staged {
+		return fmt.Errorf("'--merge' or '--conflict' cannot be used with --staged")
+	}
+	if source == "" && staged {
+		source = "HEAD"
+	}
+	style := conflictStyleMerge
+	if opts.Merge {
+		if style, err = resolveConflictStyle(opts.Conflict); err != nil {
+			return err
+		}
+	}
+
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	var sourceFiles []treeFile
+	if _, unborn := unbornBranch(); source == "HEAD" && unborn {
+		// an unborn HEAD has the empty tree
+	} else if source != "" {
+		tree, err := resolveTreeish(source)
+		if err != nil {
+			return err
+		}
+		sourceFiles, err = flattenTree(tree, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.IgnoreUnmatched {
+		known := make([]string, 0, len(index.Entries)+len(sourceFiles))
+		for _, e := range index.Entries {
+			known = append(known, e.Path)
+		}
+		for _, f := range sourceFiles {
+			known = append(known, f.Path)
+		}
+		if item, ok := pathspecs.unmatched(known); ok {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git", item.Original)
+		}
+	}
+
+	if source == "" {
+		// unmerged paths are all checked before any file is touched
+		failed := false
+		for i, e := range index.Entries {
+			if e.Stage() == 0 || !pathspecs.matches(e.Path) || (i > 0 && index.Entries[i-1].Path == e.Path) {
+				continue
+			}
+			if err := checkUnmerged(index.Entries[i:stagesEnd(index.Entries, i)], opts.Merge); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+				failed = true
+			}
+		}
+		if failed {
+			return errQuietFailure
+		}
+
+		recreated := 0
+		for i := 0; i < len(index.Entries); i++ {
+			e := index.Entries[i]
+			if !pathspecs.matches(e.Path) {
+				continue
+			}
+			if e.Stage() != 0 {
+				end := stagesEnd(index.Entries, i)
+				if err := checkoutMerged(index.Entries[i:end], style); err != nil {
+					return err
+				}
+				recreated++
+				i = end - 1
+				continue
+			}
+			entry, err := checkoutFile(e.Path, e.Mode, e.Hash)
+			if err != nil {
+				return err
+			}
+			entry.Flags, entry.ExtendedFlags = e.Flags, e.ExtendedFlags
+			index.Entries[i] = entry
+		}
+		if recreated > 0 && opts.ReportRecreated {
+			fmt.Fprintf(os.Stderr, "Recreated %s\n", plural(recreated, "merge conflict", "merge conflicts"))
+		}
+		return writeIndex(index, lock)
+	}
+
+	inSource := map[string]bool{}
+	for _, f := range sourceFiles {
+		if !pathspecs.matches(f.Path) {
+			continue
+		}
+		inSource[f.Path] = true
+		entry := IndexEntry{Path: f.Path, Mode: f.Mode, Hash: f.Hash}
+		if worktree {
+			entry, err = checkoutFile(f.Path, f.Mode, f.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		if staged {
+			index.set(entry)
+		}
+	}
+	// paths missing from the source are removed unless overlaying
+	removed := make([]string, 0)
+	for _, e := range index.Entries {
+		if !overlay && pathspecs.matches(e.Path) && !inSource[e.Path] {
+			removed = append(removed, e.Path)
+		}
+	}
+	for _, path := range removed {
+		if staged {
+			index.remove(path)
+		}
+		if worktree {
+			if err := removeWorktreeFile(path); err != nil {
+				return err
+			}
+		}
+	}
+	if !staged {
+		return nil
+	}
+	return writeIndex(index, lock)
+}
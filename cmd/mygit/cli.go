@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const globalUsage = "usage: mygit [-h | --help] [-C <path>] [-p | --paginate | -P | --no-pager]\n" +
+	"             [--git-dir=<path>] [--no-replace-objects] [--in-memory] [--json] [--porcelain-errors]\n" +
+	"             <command> [<args>...]"
+
+type command struct {
+	Usage string
+	// Action describes the command in "Error on <action> ..." messages
+	Action string
+	Run    func(args []string) error
+}
+
+var commands = map[string]*command{
+	"init":               {Usage: "mygit init [--separate-git-dir <git-dir>]", Action: "init", Run: runInit},
+	"cat-file":           {Usage: "mygit cat-file (-t | -s | -p | <type>) <object>\n   or: mygit cat-file (--batch | --batch-check)[=<format>] [--batch-all-objects [--unordered]] [--buffer] [--follow-symlinks]", Action: "reading object", Run: runCatFile},
+	"hash-object":        {Usage: "mygit hash-object [-t <type>] [-w] [--literally] [--no-filters] [--path=<file>] (--stdin | <file>...)", Action: "hashing object", Run: runHashObject},
+	"ls-files":           {Usage: lsFilesUsage, Action: "listing files", Run: runLsFiles},
+	"index-dump":         {Usage: "mygit index-dump", Action: "reading index", Run: runIndexDump},
+	"unpack-file":        {Usage: "mygit unpack-file <blob>", Action: "unpacking file", Run: runUnpackFile},
+	"ls-tree":            {Usage: "mygit ls-tree [--name-only | -l] [-z] <tree-ish>", Action: "reading object", Run: runLsTree},
+	"update-index":       {Usage: updateIndexUsage, Action: "update-index", Run: runUpdateIndex},
+	"write-tree":         {Usage: "mygit write-tree [-q | --quiet] [--prefix=<prefix>/]", Action: "writing tree", Run: runWriteTree},
+	"mktree":             {Usage: "mygit mktree [-z] [--missing] [--batch]", Action: "writing tree", Run: runMktree},
+	"mktag":              {Usage: "mygit mktag [--[no-]strict]", Action: "writing tag", Run: runMktag},
+	"commit-tree":        {Usage: "mygit commit-tree <tree> [-p <parent>] [-m <message>]", Action: "writing tree", Run: runCommitTree},
+	"log":                {Usage: "mygit log [-n <count>] [--oneline] [--since=<date>] [--until=<date>] [--date=<format>] [-p | -s] [--check] [-w | -b] [--ignore-blank-lines] [--word-diff[=<mode>]] [--word-diff-regex=<regex>] [--[no-]textconv] [-m | -c | --cc] [--raw] [-M | --no-renames] [--first-parent] [--topo-order | --date-order] [--reverse] [--follow] [--[no-]mailmap] [--decorate[=short|full|auto|no] | --no-decorate] [--color[=<when>]] [<revision>...] [-- <path>...]", Action: "reading log", Run: runLog},
+	"show":               {Usage: "mygit show [-s] [--check] [-w | -b] [--ignore-blank-lines] [--word-diff[=<mode>]] [--word-diff-regex=<regex>] [--[no-]textconv] [--date=<format>] [-m | -c | --cc] [--raw] [-M | --no-renames] [--[no-]mailmap] [--decorate[=short|full|auto|no] | --no-decorate] [--color[=<when>]] [<object>...]", Action: "showing objects", Run: runShow},
+	"whatchanged":        {Usage: "mygit whatchanged [-n <count>] [--oneline] [--since=<date>] [--until=<date>] [--date=<format>] [-p] [--check] [-w | -b] [--ignore-blank-lines] [--word-diff[=<mode>]] [--word-diff-regex=<regex>] [--[no-]textconv] [-m | -c | --cc] [--first-parent] [--reverse] [<revision>...] [-- <path>...]", Action: "reading log", Run: runWhatchanged},
+	"merge-file":         {Usage: mergeFileUsage, Action: "merging files", Run: runMergeFile},
+	"merge-tree":         {Usage: mergeTreeUsage, Action: "merging trees", Run: runMergeTree},
+	"difftool":           {Usage: difftoolUsage, Action: "difftool", Run: runDifftool},
+	"mergetool":          {Usage: mergetoolUsage, Action: "mergetool", Run: runMergetool},
+	"browse":             {Usage: browseUsage, Action: "browse", Run: runBrowse},
+	"graph-export":       {Usage: graphExportUsage, Action: "exporting graph", Run: runGraphExport},
+	"show-branch":        {Usage: showBranchUsage, Action: "show-branch", Run: runShowBranch},
+	"repo-size":          {Usage: "mygit repo-size [--top <n>]", Action: "measuring repository", Run: runRepoSize},
+	"stats":              {Usage: "mygit stats [--top <n>]", Action: "gathering statistics", Run: runStats},
+	"show-index":         {Usage: "mygit show-index < <pack-idx-file>", Action: "reading pack index", Run: runShowIndex},
+	"pack-info":          {Usage: "mygit pack-info <pack>", Action: "inspecting pack", Run: runPackInfo},
+	"subtree":            {Usage: subtreeUsage, Action: "subtree", Run: runSubtree},
+	"replay":             {Usage: replayUsage, Action: "replaying commits", Run: runReplay},
+	"rewrite-history":    {Usage: "mygit rewrite-history [-n | --dry-run] [--path <path>... [--invert-paths]] [--subdirectory-filter <dir>] [--strip-blobs-bigger-than <size>]", Action: "rewriting history", Run: runRewriteHistory},
+	"rev-list":           {Usage: "mygit rev-list [-n <count>] [--since=<date>] [--until=<date>] [--count] [--left-right] [--first-parent] [--topo-order | --date-order] [--reverse] <revision>... [-- <path>...]", Action: "listing revisions", Run: runRevList},
+	"credential":         {Usage: "mygit credential (fill | approve | reject)", Action: "credential", Run: runCredential},
+	"ls-remote":          {Usage: "mygit ls-remote --get-url [<remote>]", Action: "listing remote", Run: runLsRemote},
+	"remote":             {Usage: "mygit remote get-url [--push] [--all] <name>", Action: "remote", Run: runRemote},
+	"branch":             {Usage: "mygit branch [-v | -vv] [--color[=<when>]] [--[no-]column[=<options>]] [-l] [--contains [<commit>]] [--[no-]merged [<commit>]] [<pattern>...]\n   or: mygit branch [--[no-]track] [-f] <branch-name> [<start-point>]\n   or: mygit branch (-m | -M | -c | -C) [<old-branch>] <new-branch>\n   or: mygit branch (-u <upstream> | --set-upstream-to=<upstream> | --unset-upstream) [<branch-name>]", Action: "branch", Run: runBranch},
+	"tag":                {Usage: tagUsage, Action: "tag", Run: runTag},
+	"switch":             {Usage: "mygit switch [-q] [-f | -m | --conflict=<style>] [--[no-]guess] <branch>\n   or: mygit switch [-q] [-f | -m] (-c | -C) <new-branch> [<start-point>]\n   or: mygit switch [-q] [-f | -m] --detach [<commit>]\n   or: mygit switch [-q] [-f | -m] --orphan <new-branch>", Action: "switch", Run: runSwitch},
+	"checkout":           {Usage: "mygit checkout [-q] [-f | -m | --conflict=<style>] [<branch> | <commit>]\n   or: mygit checkout [-q] [-f | -m] (-b | -B) <new-branch> [<start-point>]\n   or: mygit checkout [-q] [-f | -m] --orphan <new-branch> [<start-point>]\n   or: mygit checkout [-m | --conflict=<style>] [<tree-ish>] -- <path>...", Action: "checkout", Run: runCheckout},
+	"add":                {Usage: addUsage, Action: "adding files", Run: runAdd},
+	"commit":             {Usage: "mygit commit [-q] [-a] [-s] [-n | --no-verify] [-e | --no-edit] [--allow-empty] [--allow-empty-message] [--cleanup=<mode>] [-t <file>] [--fixup=<commit>] [-m <message> | -F <file>]", Action: "commit", Run: runCommit},
+	"bisect":             {Usage: "mygit bisect start [--first-parent] [<bad> [<good>...]]\n   or: mygit bisect (bad | good | skip | reset | run | log) [<args>...]", Action: "bisect", Run: runBisect},
+	"config":             {Usage: configUsage, Action: "config", Run: runConfig},
+	"var":                {Usage: "mygit var (-l | <variable>)", Action: "var", Run: runVar},
+	"name-rev":           {Usage: "mygit name-rev [--tags] [--name-only] (--all | --annotate-stdin | <commit>...)", Action: "name-rev", Run: runNameRev},
+	"shortlog":           {Usage: "mygit shortlog [-n] [-s] [-e] [<revision>...]", Action: "shortlog", Run: runShortlog},
+	"stripspace":         {Usage: "mygit stripspace [-s | --strip-comments | -c | --comment-lines]", Action: "stripspace", Run: runStripspace},
+	"interpret-trailers": {Usage: "mygit interpret-trailers [--in-place] [--trim-empty] [--where <place>] [--if-exists <action>] [--if-missing <action>] [--trailer <token>[(=|:)<value>]]... [--parse] [<file>...]", Action: "interpreting trailers", Run: runInterpretTrailers},
+	"status":             {Usage: "mygit status [-s | --porcelain[=<version>]] [-b] [-z] [-u<mode>] [--[no-]column[=<options>]] [--] [<pathspec>...]", Action: "status", Run: runStatus},
+	"replace":            {Usage: "mygit replace [-f] <object> <replacement>\n   or: mygit replace -d <object>...\n   or: mygit replace [--format=(short | medium | long)] [-l [<pattern>]]", Action: "replace", Run: runReplace},
+	"reset":              {Usage: "mygit reset [-q] [--soft | --mixed | --hard] [<commit>]\n   or: mygit reset [-q] [<tree-ish>] [--] <pathspec>...", Action: "reset", Run: runReset},
+	"rev-parse":          {Usage: "mygit rev-parse [--verify] [-q] [--short[=<length>]] [--abbrev-ref] [--symbolic-full-name] [--is-shallow-repository] <args>...", Action: "rev-parse", Run: runRevParse},
+	"restore":            {Usage: "mygit restore [-s <tree-ish>] [-S] [-W] [--[no-]overlay] [-m | --conflict=<style>] [--] <pathspec>...", Action: "restore", Run: runRestore},
+	"reflog":             {Usage: reflogUsage, Action: "reflog", Run: runReflog},
+	"prune":              {Usage: "mygit prune [-n] [-v] [--expire <time>]", Action: "pruning objects", Run: runPrune},
+	"maintenance":        {Usage: "mygit maintenance run [--auto] [--quiet] [--task=<task>]", Action: "maintenance", Run: runMaintenance},
+	"update-ref":         {Usage: "mygit update-ref [-m <reason>] [--no-deref] (-d <ref> [<old-oid>] | <ref> <new-oid> [<old-oid>])\n   or: mygit update-ref [-m <reason>] [--no-deref] [-z] --stdin", Action: "update-ref", Run: runUpdateRef},
+	"check-ref-format":   {Usage: checkRefFormatUsage, Action: "check-ref-format", Run: runCheckRefFormat},
+	"check-mailmap":      {Usage: checkMailmapUsage, Action: "check-mailmap", Run: runCheckMailmap},
+	"symbolic-ref":       {Usage: "mygit symbolic-ref [-q] [--short] [-d] <name> [<ref>]", Action: "symbolic ref", Run: runSymbolicRef},
+}
+
+// completion is registered apart, as its scripts are written from the
+// other commands.
+func init() {
+	commands["completion"] = &command{Usage: completionUsage, Action: "completion", Run: runCompletion}
+}
+
+func printCommandList(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%s\n\nAvailable commands:\n", globalUsage)
+	for _, name := range names {
+		fmt.Fprintf(w, "   %s\n", name)
+	}
+	fmt.Fprintf(w, "\nSee 'mygit help <command>' or 'mygit <command> -h' for its options.\n")
+}
+
+// runHelp prints the usage of a command, or the list of commands.
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		printCommandList(os.Stdout)
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if config, err := getConfig(); err == nil {
+			if value, ok := config.Get("alias." + args[0]); ok {
+				fmt.Printf("'%s' is aliased to '%s'\n", args[0], value)
+				return nil
+			}
+		}
+		return fmt.Errorf("no such command '%s'", args[0])
+	}
+	fmt.Printf("usage: %s\n", cmd.Usage)
+	return nil
+}
+
+func isHelpRequest(args []string) bool {
+	return len(args) == 1 && (args[0] == "-h" || args[0] == "--help")
+}
+
+type cliFlag struct {
+	boolean *bool
+	value   *string
+}
+
+// flagSet parses the options of a command, which may be mixed with its
+// positional arguments. "--" ends the options.
+type flagSet struct {
+	flags map[string]cliFlag
+	seen  map[string]bool
+}
+
+func newFlagSet() *flagSet {
+	return &flagSet{flags: map[string]cliFlag{}, seen: map[string]bool{}}
+}
+
+// Changed reports whether the flag registered as name was given.
+func (f *flagSet) Changed(name string) bool {
+	return f.seen[name]
+}
+
+// Bool registers a flag without a value under all of the given names.
+func (f *flagSet) Bool(p *bool, names ...string) {
+	for _, name := range names {
+		f.flags[name] = cliFlag{boolean: p}
+	}
+}
+
+// String registers a flag taking a value, given as "--name=value" or as the
+// next argument.
+func (f *flagSet) String(p *string, names ...string) {
+	for _, name := range names {
+		f.flags[name] = cliFlag{value: p}
+	}
+}
+
+// Parse sets the registered flags found in args and returns the remaining
+// positional arguments.
+func (f *flagSet) Parse(args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(positional, args[i+1:]...), nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue = strings.Cut(arg, "=")
+		}
+		flag, ok := f.flags[name]
+		f.seen[name] = ok
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("unknown option %s", arg)
+		case flag.boolean != nil && hasValue:
+			return nil, fmt.Errorf("option %s takes no value", name)
+		case flag.boolean != nil:
+			*flag.boolean = true
+		case hasValue:
+			*flag.value = value
+		case i+1 >= len(args):
+			return nil, fmt.Errorf("option %s requires a value", name)
+		default:
+			i++
+			*flag.value = args[i]
+		}
+	}
+	return positional, nil
+}
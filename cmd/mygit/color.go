@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const colorReset = "\x1b[m"
+
+// colorPalette maps the slots of color.<command>.<slot> to escape sequences.
+// A nil palette disables color.
+type colorPalette map[string]string
+
+var diffColorDefaults = map[string]string{
+	"context":    "",
+	"meta":       "bold",
+	"frag":       "cyan",
+	"func":       "",
+	"old":        "red",
+	"new":        "green",
+	"commit":     "yellow",
+	"whitespace": "normal red",
+}
+
+var branchColorDefaults = map[string]string{
+	"current": "green",
+	"local":   "",
+}
+
+var statusColorDefaults = map[string]string{
+	"header":       "",
+	"added":        "green",
+	"changed":      "red",
+	"untracked":    "red",
+	"unmerged":     "red",
+	"branch":       "",
+	"nobranch":     "red",
+	"localbranch":  "green",
+	"remotebranch": "red",
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var colorAttributes = map[string]int{
+	"bold":    1,
+	"dim":     2,
+	"italic":  3,
+	"ul":      4,
+	"blink":   5,
+	"reverse": 7,
+	"strike":  9,
+}
+
+// parseColor turns a config color like "bold red blue" into an escape
+// sequence. The first color is the foreground, the second the background.
+func parseColor(value string) (string, error) {
+	attrs := make([]string, 0, 2)
+	colors := make([]string, 0, 2)
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		if word == "reset" {
+			if len(attrs) > 0 || len(colors) > 0 {
+				return "", fmt.Errorf("invalid color value: %s", value)
+			}
+			attrs = append(attrs, "")
+			continue
+		}
+		if code, ok := colorAttributes[strings.TrimPrefix(strings.TrimPrefix(word, "no-"), "no")]; ok {
+			if strings.HasPrefix(word, "no") {
+				if code == 1 {
+					code = 2
+				}
+				code += 20
+			}
+			attrs = append(attrs, strconv.Itoa(code))
+			continue
+		}
+		if len(colors) == 2 {
+			return "", fmt.Errorf("invalid color value: %s", value)
+		}
+		base := 30
+		if len(colors) == 1 {
+			base = 40
+		}
+		code, err := parseColorName(word, base)
+		if err != nil {
+			return "", fmt.Errorf("invalid color value: %s", value)
+		}
+		colors = append(colors, code)
+	}
+	codes := make([]string, 0, len(attrs)+len(colors))
+	codes = append(codes, attrs...)
+	for _, c := range colors {
+		if c != "" {
+			codes = append(codes, c)
+		}
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// parseColorName returns the SGR code for one color word, "" for "normal".
+func parseColorName(word string, base int) (string, error) {
+	if word == "normal" || word == "default" {
+		if word == "default" {
+			return strconv.Itoa(base + 9), nil
+		}
+		return "", nil
+	}
+	name, bright := strings.CutPrefix(word, "bright")
+	for i, n := range colorNames {
+		if n == name {
+			if bright {
+				return strconv.Itoa(base + 60 + i), nil
+			}
+			return strconv.Itoa(base + i), nil
+		}
+	}
+	if hex, ok := strings.CutPrefix(word, "#"); ok && len(hex) == 6 {
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, rgb>>16, rgb>>8&0xff, rgb&0xff), nil
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n < -1 || n > 255 {
+		return "", fmt.Errorf("unknown color %s", word)
+	}
+	if n == -1 {
+		return "", nil
+	}
+	if n < 8 {
+		return strconv.Itoa(base + n), nil
+	}
+	return fmt.Sprintf("%d;5;%d", base+8, n), nil
+}
+
+// parseColorFlag returns the <when> of --color[=<when>] or --no-color.
+func parseColorFlag(arg string) (string, error) {
+	switch arg {
+	case "--color":
+		return "always", nil
+	case "--no-color":
+		return "never", nil
+	}
+	when := strings.TrimPrefix(arg, "--color=")
+	if _, _, err := colorWhen(when); err != nil {
+		return "", err
+	}
+	return when, nil
+}
+
+// colorWhen parses a color.ui style value into (decided, on).
+func colorWhen(value string) (bool, bool, error) {
+	switch strings.ToLower(value) {
+	case "always":
+		return true, true, nil
+	case "never":
+		return true, false, nil
+	case "auto":
+		return false, false, nil
+	}
+	on, err := parseConfigBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid color value: %s", value)
+	}
+	// like git, "true" only colors terminals
+	return !on, false, nil
+}
+
+// useColor decides whether command output should be colored: --color
+// wins, then color.<command>, then color.ui, which defaults to auto.
+func useColor(config *Config, command string, when string) (bool, error) {
+	if when == "" {
+		when = "auto"
+		if value, ok := config.Get("color.ui"); ok {
+			when = value
+		}
+		if value, ok := config.Get("color." + command); ok {
+			when = value
+		}
+	}
+	decided, on, err := colorWhen(when)
+	if err != nil || decided {
+		return on, err
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false, nil
+	}
+	if runningPager != nil {
+		return config.GetBool("color.pager", true)
+	}
+	return isTerminal(os.Stdout), nil
+}
+
+// loadColorPalette returns the palette for command, or nil when output is
+// not colored.
+func loadColorPalette(command string, defaults map[string]string, when string) (colorPalette, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	on, err := useColor(config, command, when)
+	if err != nil || !on {
+		return nil, err
+	}
+	palette := colorPalette{}
+	for slot, value := range defaults {
+		if v, ok := config.Get("color." + command + "." + slot); ok {
+			value = v
+		} else if slot == "context" {
+			// "plain" is the old name of the context slot
+			if v, ok := config.Get("color." + command + ".plain"); ok {
+				value = v
+			}
+		} else if slot == "added" {
+			// and "updated" that of the status slot for staged changes
+			if v, ok := config.Get("color." + command + ".updated"); ok {
+				value = v
+			}
+		}
+		code, err := parseColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid color for color.%s.%s: %s", command, slot, err.Error())
+		}
+		palette[slot] = code
+	}
+	return palette, nil
+}
+
+// wrap is paint for text git writes with color_fprintf, which leaves it
+// without escapes when the slot's color is "normal".
+func (p colorPalette) wrap(slot string, text string) string {
+	if p[slot] == "" {
+		return text
+	}
+	return p[slot] + text + colorReset
+}
+
+// paint wraps text in the slot's color and a reset, or returns it unchanged
+// when color is off.
+func (p colorPalette) paint(slot string, text string) string {
+	if p == nil {
+		return text
+	}
+	return p[slot] + text + colorReset
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// columnOptions says whether and how a listing is laid out in columns, as
+// set by column.ui, column.<command> and --column. Items fill the columns
+// top to bottom with the "column" layout and the rows left to right with
+// "row"; "plain" prints one item per line. Dense columns are each only as
+// wide as their widest item.
+type columnOptions struct {
+	Enable string
+	Layout string
+	Dense  bool
+	// Explicit is set when --column or --no-column was given
+	Explicit bool
+}
+
+// parse applies a space or comma separated list of column options. Setting
+// a layout without saying when implies "always".
+func (c *columnOptions) parse(value string) error {
+	enableSet, layoutSet := false, false
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' }) {
+		switch word {
+		case "always", "never", "auto":
+			c.Enable, enableSet = word, true
+		case "plain", "column", "row":
+			c.Layout, layoutSet = word, true
+		case "dense":
+			c.Dense = true
+		case "nodense":
+			c.Dense = false
+		default:
+			return fmt.Errorf("unsupported option '%s'", word)
+		}
+	}
+	if layoutSet && !enableSet {
+		c.Enable = "always"
+	}
+	return nil
+}
+
+// loadColumnOptions reads column.ui and column.<command>, in the order they
+// appear in the config.
+func loadColumnOptions(command string) (columnOptions, error) {
+	opts := columnOptions{Enable: "never", Layout: "column"}
+	config, err := getConfig()
+	if err != nil {
+		return opts, err
+	}
+	for _, e := range config.entries {
+		if e.Section != "column" || e.Subsection != "" || (e.Key != "ui" && e.Key != command) {
+			continue
+		}
+		if e.NoValue {
+			return opts, fmt.Errorf("missing value for 'column.%s'", e.Key)
+		}
+		if err := opts.parse(e.Value); err != nil {
+			return opts, fmt.Errorf("invalid column.%s mode %s: %s", e.Key, e.Value, err.Error())
+		}
+	}
+	return opts, nil
+}
+
+// isColumnFlag reports whether arg is --column[=<options>] or --no-column.
+func isColumnFlag(arg string) bool {
+	return arg == "--column" || arg == "--no-column" || strings.HasPrefix(arg, "--column=")
+}
+
+// parseFlag applies --column[=<options>] or --no-column. --column means
+// "always" unless its options say otherwise.
+func (c *columnOptions) parseFlag(arg string) error {
+	c.Explicit = true
+	if arg == "--no-column" {
+		c.Enable = "never"
+		return nil
+	}
+	c.Enable = "always"
+	if value, ok := strings.CutPrefix(arg, "--column="); ok {
+		return c.parse(value)
+	}
+	return nil
+}
+
+// finalize decides "auto": columns are used when the output goes to a
+// terminal or the pager.
+func (c *columnOptions) finalize() {
+	if c.Enable == "auto" {
+		c.Enable = "never"
+		if isTerminal(os.Stdout) || runningPager != nil {
+			c.Enable = "always"
+		}
+	}
+}
+
+func (c columnOptions) active() bool {
+	return c.Enable == "always"
+}
+
+// termColumns returns the width of the terminal: $COLUMNS when set, else
+// what the terminal reports, else 80.
+func termColumns() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	out := os.Stdout
+	if runningPager != nil {
+		out = runningPager.stdout
+	}
+	if n, ok := terminalWidth(out); ok && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// itemWidth is the width an item takes on screen, not counting the color
+// escapes in it.
+func itemWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] == ';' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			if j < len(s) && s[j] == 'm' {
+				i = j + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		width++
+	}
+	return width
+}
+
+// columnTable is a listing being laid out: rows by cols cells, and for each
+// column of a dense table the index of its widest item.
+type columnTable struct {
+	items      []string
+	lens       []int
+	row        bool
+	rows, cols int
+	widest     []int
+}
+
+// index maps a cell to the item shown in it.
+func (t *columnTable) index(x int, y int) int {
+	if t.row {
+		return y*t.cols + x
+	}
+	return x*t.rows + y
+}
+
+func (t *columnTable) computeWidest() {
+	t.widest = make([]int, t.cols)
+	for x := 0; x < t.cols; x++ {
+		t.widest[x] = t.index(x, 0)
+		for y := 0; y < t.rows; y++ {
+			if i := t.index(x, y); i < len(t.items) && t.lens[t.widest[x]] < t.lens[i] {
+				t.widest[x] = i
+			}
+		}
+	}
+}
+
+// shrink takes away rows as long as the columns, each as wide as its own
+// widest item, still fit in width.
+func (t *columnTable) shrink(width int, indent string, padding int) {
+	for t.rows > 1 {
+		rows, cols := t.rows, t.cols
+		t.rows--
+		t.cols = (len(t.items) + t.rows - 1) / t.rows
+		t.computeWidest()
+		total := itemWidth(indent)
+		for x := 0; x < t.cols; x++ {
+			total += t.lens[t.widest[x]] + padding
+		}
+		if total > width {
+			t.rows, t.cols = rows, cols
+			break
+		}
+	}
+	t.computeWidest()
+}
+
+// printColumns writes items laid out as opts says, each row starting with
+// indent and ending with nl, and the items at least padding apart, in the
+// width of the terminal.
+func printColumns(w io.Writer, items []string, opts columnOptions, indent string, nl string, padding int) {
+	if len(items) == 0 {
+		return
+	}
+	if !opts.active() || opts.Layout == "plain" {
+		for _, item := range items {
+			fmt.Fprintf(w, "%s%s%s", indent, item, nl)
+		}
+		return
+	}
+
+	width := termColumns() - 1
+	t := &columnTable{items: items, lens: make([]int, len(items)), row: opts.Layout == "row"}
+	cell := 0
+	for i, item := range items {
+		t.lens[i] = itemWidth(item)
+		cell = max(cell, t.lens[i])
+	}
+	cell += padding
+	t.cols = max((width-itemWidth(indent))/cell, 1)
+	t.rows = (len(items) + t.cols - 1) / t.cols
+	if opts.Dense {
+		t.shrink(width, indent, padding)
+	}
+
+	for y := 0; y < t.rows; y++ {
+		for x := 0; x < t.cols; x++ {
+			i := t.index(x, y)
+			if i >= len(items) {
+				break
+			}
+			length := t.lens[i]
+			if t.widest != nil && t.lens[t.widest[x]] < cell {
+				// a dense column is narrower than the cell
+				length += cell - t.lens[t.widest[x]] - padding
+			}
+			newline := i+t.rows >= len(items)
+			if t.row {
+				newline = x == t.cols-1 || i == len(items)-1
+			}
+			if x == 0 {
+				io.WriteString(w, indent)
+			}
+			io.WriteString(w, items[i])
+			if newline {
+				io.WriteString(w, nl)
+			} else {
+				io.WriteString(w, strings.Repeat(" ", max(cell-length, 0)))
+			}
+		}
+	}
+}
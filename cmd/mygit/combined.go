@@ -0,0 +1,552 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// combinedPath is a file of a merge that differs from every parent, with
+// what each parent had there (mode 0 when it did not have the file).
+type combinedPath struct {
+	Path         string
+	Mode         int
+	Hash         string
+	ParentModes  []int
+	ParentHashes []string
+	// ParentStatus is the change from each parent, as diff-tree shows it
+	ParentStatus []byte
+	// Added is set when no parent had the file
+	Added bool
+}
+
+// combinedPaths returns the files of a merge that differ from all of its
+// parents, the only ones a combined diff shows.
+func combinedPaths(tree string, parentTrees []string, paths pathspec) ([]combinedPath, error) {
+	perParent := make([]map[string]fileChange, len(parentTrees))
+	var order []fileChange
+	for n, parentTree := range parentTrees {
+		changes, err := diffTrees(parentTree, tree, "")
+		if err != nil {
+			return nil, err
+		}
+		perParent[n] = map[string]fileChange{}
+		for _, c := range filterChanges(changes, paths) {
+			perParent[n][c.Path] = c
+		}
+		if n == 0 {
+			order = filterChanges(changes, paths)
+		}
+	}
+
+	combined := make([]combinedPath, 0)
+	for _, first := range order {
+		p := combinedPath{Path: first.Path, Mode: first.NewMode, Hash: first.NewHash, Added: true}
+		for n := range parentTrees {
+			c, ok := perParent[n][first.Path]
+			if !ok {
+				break
+			}
+			p.ParentModes = append(p.ParentModes, c.OldMode)
+			p.ParentHashes = append(p.ParentHashes, c.OldHash)
+			p.ParentStatus = append(p.ParentStatus, c.Status)
+			p.Added = p.Added && c.Status == 'A'
+		}
+		if len(p.ParentModes) == len(parentTrees) {
+			combined = append(combined, p)
+		}
+	}
+	return combined, nil
+}
+
+// lostLine is a parent line missing from the merge result; parents has a
+// bit set for each parent the line was removed from.
+type lostLine struct {
+	Line    string
+	parents uint
+}
+
+// combinedLine is a line of the merge result. Bit n of flag is set when the
+// line is not in parent n; the two bits above those mark the lines shown and
+// the context lines whose lost lines are not. lost are the parent lines
+// removed before it, and parentLine where each parent is at the line.
+type combinedLine struct {
+	Line       string
+	flag       uint
+	lost       []lostLine
+	parentLine []int
+}
+
+// coalesceLost merges the lines lost from parent n into those lost from the
+// earlier parents, sharing the lines they have in common.
+func coalesceLost(base []lostLine, lost []lostLine, n int) []lostLine {
+	if len(base) == 0 {
+		return lost
+	}
+	const (
+		fromBase = iota
+		fromNew
+		match
+	)
+	lcs := make([][]int, len(base)+1)
+	direction := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lost)+1)
+		direction[i] = make([]int, len(lost)+1)
+	}
+	for j := 1; j <= len(lost); j++ {
+		direction[0][j] = fromNew
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(lost); j++ {
+			switch {
+			case base[i-1].Line == lost[j-1].Line:
+				lcs[i][j], direction[i][j] = lcs[i-1][j-1]+1, match
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j], direction[i][j] = lcs[i][j-1], fromNew
+			default:
+				lcs[i][j], direction[i][j] = lcs[i-1][j], fromBase
+			}
+		}
+	}
+	reversed := make([]lostLine, 0, len(base)+len(lost))
+	for i, j := len(base), len(lost); i > 0 || j > 0; {
+		switch direction[i][j] {
+		case match:
+			line := base[i-1]
+			line.parents |= 1 << n
+			reversed = append(reversed, line)
+			i, j = i-1, j-1
+		case fromNew:
+			reversed = append(reversed, lost[j-1])
+			j--
+		default:
+			reversed = append(reversed, base[i-1])
+			i--
+		}
+	}
+	merged := make([]lostLine, len(reversed))
+	for i, line := range reversed {
+		merged[len(reversed)-1-i] = line
+	}
+	return merged
+}
+
+// combineParent records how parent n differs from the result lines.
+// Removed lines hang before the result line following them, like git's
+// combine-diff does.
+func combineParent(lines []combinedLine, parentLines []string, resultLines []string, n int) {
+	cnt := len(resultLines)
+	bit := uint(1) << n
+	lost := make([][]lostLine, cnt+1)
+	ops := compactedDiff(parentLines, resultLines)
+	for i, r := 0, 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i, r = i+1, r+1
+			continue
+		}
+		bucket := r
+		for ; i < len(ops) && ops[i].Kind != ' '; i++ {
+			if ops[i].Kind == '-' {
+				lost[bucket] = append(lost[bucket], lostLine{Line: ops[i].Line, parents: bit})
+			} else {
+				lines[r].flag |= bit
+				r++
+			}
+		}
+	}
+
+	parentLine := 1
+	for r := 0; r <= cnt; r++ {
+		lines[r].parentLine[n] = parentLine
+		lines[r].lost = coalesceLost(lines[r].lost, lost[r], n)
+		for _, l := range lines[r].lost {
+			if l.parents&bit != 0 {
+				parentLine++
+			}
+		}
+		if r < cnt && lines[r].flag&bit == 0 {
+			parentLine++
+		}
+	}
+	lines[cnt+1].parentLine[n] = parentLine
+}
+
+func isInterestingLine(l combinedLine, allMask uint) bool {
+	return l.flag&allMask != 0 || len(l.lost) > 0
+}
+
+// findNextLine returns the first line from i on that is marked, or with
+// unmarked that is not, cnt+1 when there is none.
+func findNextLine(lines []combinedLine, mark uint, i int, cnt int, unmarked bool) int {
+	for ; i <= cnt; i++ {
+		if (lines[i].flag&mark == 0) == unmarked {
+			return i
+		}
+	}
+	return i
+}
+
+// adjustHunkTail steps back over a last hunk line that is only there to
+// show the lines lost before it, since it already gives a line of context.
+func adjustHunkTail(lines []combinedLine, allMask uint, hunkBegin int, i int) int {
+	if hunkBegin+1 <= i && lines[i-1].flag&allMask == 0 {
+		i--
+	}
+	return i
+}
+
+// giveContext marks the context lines around the interesting ones, joining
+// groups of lines close enough to share it.
+func giveContext(lines []combinedLine, cnt int, numParents int) bool {
+	allMask := uint(1)<<numParents - 1
+	mark := uint(1) << numParents
+	noPreDelete := uint(2) << numParents
+
+	i := findNextLine(lines, mark, 0, cnt, false)
+	if cnt < i {
+		return false
+	}
+	for i <= cnt {
+		for j := max(i-diffContext, 0); j < i; j++ {
+			if lines[j].flag&mark == 0 {
+				lines[j].flag |= noPreDelete
+			}
+			lines[j].flag |= mark
+		}
+		for {
+			j := findNextLine(lines, mark, i, cnt, true)
+			if cnt < j {
+				return true
+			}
+			k := findNextLine(lines, mark, j, cnt, false)
+			j = adjustHunkTail(lines, allMask, i, j)
+			if k < j+diffContext {
+				for ; j < k; j++ {
+					lines[j].flag |= mark
+				}
+				i = k
+				continue
+			}
+			i = k
+			for end := min(j+diffContext, cnt+1); j < end; j++ {
+				lines[j].flag |= mark
+			}
+			break
+		}
+	}
+	return true
+}
+
+// makeCombinedHunks marks the lines to show. Dense, like --cc, leaves out
+// the hunks where the result only takes one parent's side over the others.
+func makeCombinedHunks(lines []combinedLine, cnt int, numParents int, dense bool) bool {
+	allMask := uint(1)<<numParents - 1
+	mark := uint(1) << numParents
+	for i := 0; i <= cnt; i++ {
+		if isInterestingLine(lines[i], allMask) {
+			lines[i].flag |= mark
+		} else {
+			lines[i].flag &^= mark
+		}
+	}
+	if !dense {
+		return giveContext(lines, cnt, numParents)
+	}
+
+	for i := 0; i <= cnt; {
+		for i <= cnt && lines[i].flag&mark == 0 {
+			i++
+		}
+		if cnt < i {
+			break
+		}
+		hunkBegin := i
+		j := i + 1
+		for ; j <= cnt; j++ {
+			if lines[j].flag&mark != 0 {
+				continue
+			}
+			// look past the end for an interesting line within context
+			la := adjustHunkTail(lines, allMask, hunkBegin, j)
+			la = min(la+diffContext, cnt+1)
+			contin := false
+			for la > 0 {
+				la--
+				if la < j {
+					break
+				}
+				if lines[la].flag&mark != 0 {
+					contin = true
+					break
+				}
+			}
+			if !contin {
+				break
+			}
+			j = la
+		}
+		hunkEnd := j
+
+		// the hunk is dull when there are only two versions, one of them
+		// taken by the result
+		sameDiff, interesting := uint(0), false
+		for j := i; j < hunkEnd && !interesting; j++ {
+			if thisDiff := lines[j].flag & allMask; thisDiff != 0 {
+				if sameDiff == 0 {
+					sameDiff = thisDiff
+				} else if sameDiff != thisDiff {
+					interesting = true
+				}
+			}
+			for _, l := range lines[j].lost {
+				if interesting {
+					break
+				}
+				thisDiff := l.parents
+				if sameDiff == 0 {
+					sameDiff = thisDiff
+				} else if sameDiff != thisDiff {
+					interesting = true
+				}
+			}
+		}
+		if !interesting && sameDiff != allMask {
+			for j := hunkBegin; j < hunkEnd; j++ {
+				lines[j].flag &^= mark
+			}
+		}
+		i = hunkEnd
+	}
+	return giveContext(lines, cnt, numParents)
+}
+
+// isHunkCommentLine is git's default rule for the line named in a hunk
+// header, as combined diffs use it.
+func isHunkCommentLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	c := line[0]
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_' || c == '$'
+}
+
+func writeCombinedHunks(w io.Writer, lines []combinedLine, cnt int, numParents int, colors colorPalette) {
+	mark := uint(1) << numParents
+	noPreDelete := uint(2) << numParents
+	markers := strings.Repeat("@", numParents+1)
+	for lno := 0; ; {
+		comment := ""
+		for lno <= cnt && lines[lno].flag&mark == 0 {
+			if isHunkCommentLine(lines[lno].Line) {
+				comment = lines[lno].Line
+			}
+			lno++
+		}
+		if cnt < lno {
+			return
+		}
+		hunkEnd := lno + 1
+		for hunkEnd <= cnt && lines[hunkEnd].flag&mark != 0 {
+			hunkEnd++
+		}
+		resultLines := hunkEnd - lno
+		if cnt < hunkEnd {
+			resultLines--
+		}
+
+		var header strings.Builder
+		header.WriteString(markers)
+		for n := 0; n < numParents; n++ {
+			start := lines[lno].parentLine[n]
+			fmt.Fprintf(&header, " -%d,%d", start, lines[hunkEnd].parentLine[n]-start)
+		}
+		fmt.Fprintf(&header, " +%d,%d %s", lno+1, resultLines, markers)
+		line := colors.paint("frag", header.String())
+		// like git, the name stops short of its last non-blank character
+		end := 0
+		for i := 0; i < min(len(comment), 40) && comment[i] != '\n'; i++ {
+			if comment[i] != ' ' && comment[i] != '\t' && comment[i] != '\r' {
+				end = i
+			}
+		}
+		if end > 0 {
+			line += colors.paint("context", " ") + colors.paint("func", comment[:end])
+		}
+		fmt.Fprintln(w, line)
+
+		for lno < hunkEnd {
+			l := lines[lno]
+			lno++
+			if l.flag&noPreDelete == 0 {
+				for _, lost := range l.lost {
+					var b strings.Builder
+					for n := 0; n < numParents; n++ {
+						if lost.parents&(1<<n) != 0 {
+							b.WriteByte('-')
+						} else {
+							b.WriteByte(' ')
+						}
+					}
+					fmt.Fprintln(w, colors.paint("old", b.String()+strings.TrimSuffix(lost.Line, "\n")))
+				}
+			}
+			if cnt < lno {
+				break
+			}
+			var b strings.Builder
+			for n := 0; n < numParents; n++ {
+				if l.flag&(1<<n) != 0 {
+					b.WriteByte('+')
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+			slot := "new"
+			if l.flag&(mark-1) == 0 {
+				slot = "context"
+			}
+			fmt.Fprintln(w, colors.paint(slot, b.String()+strings.TrimSuffix(l.Line, "\n")))
+		}
+	}
+}
+
+// writeCombinedPath shows one file of a combined diff: nothing when dense
+// finds no hunk worth showing and the modes agree.
+func writeCombinedPath(w io.Writer, p combinedPath, dense bool, colors colorPalette) error {
+	numParents := len(p.ParentHashes)
+	result, err := readBlobForDiff(p.Hash, p.Mode)
+	if err != nil {
+		return err
+	}
+	binary := result.isBinary()
+	parents := make([]diffBlob, numParents)
+	for n, hash := range p.ParentHashes {
+		if parents[n], err = readBlobForDiff(hash, p.ParentModes[n]); err != nil {
+			return err
+		}
+		binary = binary || parents[n].isBinary()
+	}
+	modeDiffers := false
+	for _, mode := range p.ParentModes {
+		modeDiffers = modeDiffers || mode != p.Mode
+	}
+	deleted := p.Mode == 0
+
+	writeHeader := func(fileHeader bool) {
+		kind := "combined"
+		if dense {
+			kind = "cc"
+		}
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("diff --%s %s", kind, quotePath(p.Path, false))))
+		abbrevs := make([]string, numParents)
+		for n, hash := range p.ParentHashes {
+			abbrevs[n] = abbrevHash(hash)
+		}
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("index %s..%s", strings.Join(abbrevs, ","), abbrevHash(p.Hash))))
+		if modeDiffers {
+			if p.Added {
+				fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("new file mode %06d", p.Mode)))
+			} else {
+				modes := make([]string, numParents)
+				for n, mode := range p.ParentModes {
+					modes[n] = fmt.Sprintf("%06d", mode)
+				}
+				line := "mode " + strings.Join(modes, ",")
+				if deleted {
+					line = "deleted file " + line
+				} else {
+					line += fmt.Sprintf("..%06d", p.Mode)
+				}
+				fmt.Fprintln(w, colors.paint("meta", line))
+			}
+		}
+		if !fileHeader {
+			return
+		}
+		oldName, newName := quotePath("a/"+p.Path, false), quotePath("b/"+p.Path, false)
+		if p.Added {
+			oldName = "/dev/null"
+		}
+		if deleted {
+			newName = "/dev/null"
+		}
+		fmt.Fprintln(w, colors.paint("meta", "--- "+oldName))
+		fmt.Fprintln(w, colors.paint("meta", "+++ "+newName))
+	}
+	if binary {
+		writeHeader(false)
+		fmt.Fprintln(w, "Binary files differ")
+		return nil
+	}
+
+	resultLines := splitLines(result.Content)
+	cnt := len(resultLines)
+	lines := make([]combinedLine, cnt+2)
+	for i := range lines {
+		lines[i].parentLine = make([]int, numParents)
+		if i < cnt {
+			lines[i].Line = resultLines[i]
+		}
+	}
+	for n, parent := range parents {
+		combineParent(lines, splitLines(parent.Content), resultLines, n)
+	}
+	if !makeCombinedHunks(lines, cnt, numParents, dense) && !modeDiffers {
+		return nil
+	}
+	writeHeader(true)
+	if !deleted {
+		writeCombinedHunks(w, lines, cnt, numParents, colors)
+	}
+	return nil
+}
+
+// writeCombinedRaw shows the combined form of --raw: the modes and blobs of
+// all parents and then the result's, followed by the change from each parent.
+func writeCombinedRaw(w io.Writer, combined []combinedPath) {
+	for _, p := range combined {
+		var b strings.Builder
+		b.WriteString(strings.Repeat(":", len(p.ParentModes)))
+		for _, mode := range p.ParentModes {
+			fmt.Fprintf(&b, "%06d ", mode)
+		}
+		fmt.Fprintf(&b, "%06d ", p.Mode)
+		for _, hash := range p.ParentHashes {
+			b.WriteString(abbrevHash(hash) + " ")
+		}
+		fmt.Fprintf(&b, "%s %s\t%s", abbrevHash(p.Hash), p.ParentStatus, quotePath(p.Path, false))
+		fmt.Fprintln(w, b.String())
+	}
+}
+
+// writeCombinedDiff shows a merge against all of its parents at once, like
+// git's -c and, with dense, --cc. As for other diffs, a blank line separates
+// it from the commit message when there is anything to show.
+func writeCombinedDiff(w io.Writer, opts *logOptions, tree string, parentTrees []string) error {
+	combined, err := combinedPaths(tree, parentTrees, opts.Pathspecs)
+	if err != nil {
+		return err
+	}
+	// like git, the line ahead of the diff is there even when no path is
+	// shown
+	fmt.Fprintln(w)
+	if len(combined) == 0 {
+		return nil
+	}
+	if opts.Raw {
+		writeCombinedRaw(w, combined)
+	}
+	if opts.Raw && opts.Patch {
+		fmt.Fprintln(w)
+	}
+	if !opts.Patch {
+		return nil
+	}
+	for _, p := range combined {
+		if err := writeCombinedPath(w, p, opts.Dense, opts.Colors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
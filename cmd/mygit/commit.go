@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Commit struct {
+	Hash      string
+	Tree      string
+	Parents   []string
+	Author    Identity
+	Committer Identity
+	// Headers holds any extra headers (encoding, gpgsig, ...) in order
+	Headers []commitHeader
+	Message string
+}
+
+type commitHeader struct {
+	Key   string
+	Value string
+}
+
+var commitCache = map[string]*Commit{}
+
+func parseCommitContent(hash string, content []byte) (*Commit, error) {
+	commit := &Commit{Hash: hash}
+	headerBlock, message, found := bytes.Cut(content, []byte("\n\n"))
+	if !found {
+		headerBlock = bytes.TrimSuffix(content, []byte("\n"))
+	}
+	commit.Message = string(message)
+
+	lines := strings.Split(string(headerBlock), "\n")
+	for i := 0; i < len(lines); i++ {
+		key, value, _ := strings.Cut(lines[i], " ")
+		// continuation lines of multi-line headers start with a space
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], " ") {
+			i++
+			value += "\n" + lines[i][1:]
+		}
+
+		var err error
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author, err = parseIdentity(value)
+		case "committer":
+			commit.Committer, err = parseIdentity(value)
+		default:
+			commit.Headers = append(commit.Headers, commitHeader{key, value})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse commit %s: %s", hash, err.Error())
+		}
+	}
+	if commit.Tree == "" {
+		return nil, fmt.Errorf("failed to parse commit %s: missing tree", hash)
+	}
+	return commit, nil
+}
+
+func readCommit(hash string) (*Commit, error) {
+	if commit, ok := commitCache[hash]; ok {
+		return commit, nil
+	}
+	object, err := parseObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if object.Type != TypeCommit {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, object.Type)
+	}
+	commit, err := parseCommitContent(hash, object.Content)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyGraft(commit); err != nil {
+		return nil, err
+	}
+	commitCache[hash] = commit
+	return commit, nil
+}
+
+func (c *Commit) Header(key string) (string, bool) {
+	for _, h := range c.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+func (c *Commit) Subject() string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(c.Message, "\n"), "\n\n")
+	lines := strings.Split(strings.TrimRight(subject, "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, " ")
+}
+
+// cleanup modes of commit --cleanup and commit.cleanup. The default is
+// strip when the message is edited and whitespace otherwise.
+const (
+	cleanupStrip      = "strip"
+	cleanupWhitespace = "whitespace"
+	cleanupVerbatim   = "verbatim"
+	cleanupDefault    = "default"
+)
+
+// commentChar is core.commentChar, the character starting the lines strip
+// removes from commit messages.
+func commentChar(config *Config) string {
+	if value, ok := config.Get("core.commentChar"); ok && value != "" && value != "auto" {
+		return value[:1]
+	}
+	return "#"
+}
+
+// applyCleanup cleans up an edited or given commit message for mode.
+func applyCleanup(message string, mode string, comment string) string {
+	switch mode {
+	case cleanupVerbatim:
+		return message
+	case cleanupStrip:
+		return stripSpace(message, comment)
+	}
+	return stripSpace(message, "")
+}
+
+// commitMessageHint is the comment added below a message being edited,
+// telling what the cleanup mode does with comments.
+func commitMessageHint(mode string, comment string) string {
+	if mode == cleanupStrip {
+		return fmt.Sprintf("\n%s Please enter the commit message for your changes. Lines starting\n"+
+			"%s with '%s' will be ignored, and an empty message aborts the commit.\n%s\n", comment, comment, comment, comment)
+	}
+	return fmt.Sprintf("\n%s Please enter the commit message for your changes. Lines starting\n"+
+		"%s with '%s' will be kept; you may remove them yourself if you want to.\n"+
+		"%s An empty message aborts the commit.\n%s\n", comment, comment, comment, comment, comment)
+}
+
+// writeCommitObject stores a commit and returns its hash.
+func writeCommitObject(tree string, parents []string, author Identity, committer Identity, message string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n", author, committer)
+	// the message is taken to be in i18n.commitEncoding already
+	if encoding := commitEncoding(); encoding != "" {
+		fmt.Fprintf(&b, "encoding %s\n", encoding)
+	}
+	fmt.Fprintf(&b, "\n%s", message)
+	return writeObject(TypeCommit, []byte(b.String()))
+}
+
+func mergeHeads() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "MERGE_HEAD"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MERGE_HEAD: %s", err.Error())
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// stageTrackedChanges updates the index with the worktree content of every
+// tracked file, dropping the ones that were deleted, as commit -a does.
+func stageTrackedChanges(index *Index) error {
+	kept := index.Entries[:0]
+	for _, e := range index.Entries {
+		if e.Stage() != 0 || e.Mode == modeGitlink || e.assumeUnchanged() || e.skipWorktree() {
+			kept = append(kept, e)
+			continue
+		}
+		info, err := os.Lstat(e.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if matches, err := worktreeMatchesIndex(index, &e); err != nil {
+			return err
+		} else if !matches {
+			e.Mode = worktreeEntryMode(info, e.Mode)
+			if e.Mode == modeSymlink {
+				target, err := readLinkTarget(e.Path, info)
+				if err != nil {
+					return err
+				}
+				if e.Hash, err = writeObject(TypeBlob, []byte(target)); err != nil {
+					return err
+				}
+			} else {
+				hash, err := writeBlobObject(e.Path)
+				if err != nil {
+					return err
+				}
+				e.Hash = hex.EncodeToString(hash)
+			}
+		}
+		e.setStatData(info)
+		kept = append(kept, e)
+	}
+	index.Entries = kept
+	return nil
+}
+
+// fixupTarget reads the commit --fixup names.
+func fixupTarget(spec string) (*Commit, error) {
+	hash, err := resolveRevision(spec)
+	if err == nil {
+		hash, err = peelToCommit(hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup commit %s", spec)
+	}
+	return readCommit(hash)
+}
+
+func runCommit(args []string) error {
+	var messages []string
+	messageFile, templateFile, cleanup, fixup := "", "", "", ""
+	signOff, allowEmpty, allowEmptyMessage, all, quiet, noVerify := false, false, false, false, false, false
+	// edit is nil unless -e or --no-edit is given
+	var edit *bool
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-m" || arg == "--message" || arg == "-F" || arg == "--file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			if arg == "-F" || arg == "--file" {
+				messageFile = args[i]
+			} else {
+				messages = append(messages, args[i])
+			}
+		case strings.HasPrefix(arg, "--message="):
+			messages = append(messages, strings.TrimPrefix(arg, "--message="))
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			messages = append(messages, arg[2:])
+		case strings.HasPrefix(arg, "--file="):
+			messageFile = strings.TrimPrefix(arg, "--file=")
+		case arg == "-t" || arg == "--template":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			templateFile = args[i]
+		case strings.HasPrefix(arg, "--template="):
+			templateFile = strings.TrimPrefix(arg, "--template=")
+		case arg == "-e" || arg == "--edit" || arg == "--no-edit":
+			edit = new(bool)
+			*edit = arg != "--no-edit"
+		case arg == "--fixup":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			fixup = args[i]
+		case strings.HasPrefix(arg, "--fixup="):
+			fixup = strings.TrimPrefix(arg, "--fixup=")
+		case strings.HasPrefix(arg, "--cleanup="):
+			cleanup = strings.TrimPrefix(arg, "--cleanup=")
+		case arg == "-s" || arg == "--signoff":
+			signOff = true
+		case arg == "--no-signoff":
+			signOff = false
+		case arg == "--allow-empty":
+			allowEmpty = true
+		case arg == "--allow-empty-message":
+			allowEmptyMessage = true
+		case arg == "-a" || arg == "--all":
+			all = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "-n" || arg == "--no-verify":
+			noVerify = true
+		case arg == "--verify":
+			noVerify = false
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	if messageFile != "" && messageFile != "-" {
+		messageFile = prefixFilename(messageFile)
+	}
+	if templateFile != "" {
+		templateFile = prefixFilename(templateFile)
+	}
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if templateFile == "" {
+		templateFile, _ = config.Get("commit.template")
+	}
+	// source is what prepare-commit-msg is told the message came from
+	var message, source, template string
+	switch {
+	case len(messages) > 0 && messageFile != "":
+		return fmt.Errorf("options -m and -F cannot be used together")
+	case fixup != "" && messageFile != "":
+		return fmt.Errorf("options '-F' and '--fixup' cannot be used together")
+	case fixup != "":
+		// rebase --autosquash looks for the subject after "fixup! "
+		target, err := fixupTarget(fixup)
+		if err != nil {
+			return err
+		}
+		message, source = "fixup! "+target.Subject()+"\n\n"+strings.Join(messages, "\n\n"), "message"
+	case len(messages) > 0:
+		message, source = strings.Join(messages, "\n\n"), "message"
+	case messageFile == "-":
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read message from stdin: %s", err.Error())
+		}
+		message, source = string(content), "message"
+	case messageFile != "":
+		content, err := os.ReadFile(messageFile)
+		if err != nil {
+			return fmt.Errorf("failed to read message file %s: %s", messageFile, err.Error())
+		}
+		message, source = string(content), "message"
+	}
+	editing := source == ""
+	if edit != nil {
+		editing = *edit
+	}
+	if source == "" {
+		if content, err := os.ReadFile(filepath.Join(gitDir, "MERGE_MSG")); err == nil {
+			message, source = string(content), "merge"
+		} else if templateFile != "" {
+			content, err := os.ReadFile(expandHomePath(templateFile))
+			if err != nil {
+				return fmt.Errorf("could not read '%s': %s", templateFile, err.Error())
+			}
+			message, source, template = string(content), "template", string(content)
+		}
+	}
+
+	if cleanup == "" {
+		cleanup, _ = config.Get("commit.cleanup")
+	}
+	switch cleanup {
+	case "", cleanupDefault:
+		cleanup = cleanupWhitespace
+		if editing {
+			cleanup = cleanupStrip
+		}
+	case cleanupStrip, cleanupWhitespace, cleanupVerbatim:
+	default:
+		return fmt.Errorf("Invalid cleanup mode %s", cleanup)
+	}
+	comment := commentChar(config)
+
+	// hooks are pointed at the index the commit is made from. Like git, -a
+	// stages into index.lock and shows the hooks that, while a plain commit
+	// leaves the index unlocked for them.
+	hookEnv := []string{"GIT_INDEX_FILE=" + getIndexPath()}
+	var index *Index
+	var lock *lockFile
+	if all {
+		if index, lock, err = lockIndex(); err != nil {
+			return err
+		}
+		defer lock.rollback()
+		if err := stageTrackedChanges(index); err != nil {
+			return err
+		}
+		if err := writeLockedIndex(index, lock); err != nil {
+			return err
+		}
+		if inMemory == nil {
+			hookEnv[0] = "GIT_INDEX_FILE=" + lockFilePath(lock.Path)
+		}
+	}
+	if !noVerify {
+		if err := runHook("pre-commit", hookEnv); err != nil {
+			return err
+		}
+	}
+	// the index is read after pre-commit, which may have changed it
+	if all && inMemory == nil {
+		if index, err = readIndexFile(lockFilePath(lock.Path)); err != nil {
+			return err
+		}
+	} else if !all {
+		if index, lock, err = lockIndex(); err != nil {
+			return err
+		}
+		defer lock.rollback()
+	}
+	for _, e := range index.Entries {
+		if e.Stage() != 0 {
+			return fmt.Errorf("committing is not possible because you have unmerged files")
+		}
+	}
+	tree, err := writeIndexTree(index.Entries, "")
+	if err != nil {
+		return err
+	}
+
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	merged, err := mergeHeads()
+	if err != nil {
+		return err
+	}
+	parents := make([]string, 0, 1+len(merged))
+	if head != "" {
+		parents = append(parents, head)
+	}
+	parents = append(parents, merged...)
+	parentTree, err := commitTreeHash(head)
+	if err != nil {
+		return err
+	}
+	if len(merged) == 0 && !allowEmpty && (tree == parentTree || head == "" && len(index.Entries) == 0) {
+		return fmt.Errorf("nothing to commit")
+	}
+
+	author, err := currentIdentity("AUTHOR")
+	if err != nil {
+		return err
+	}
+	committer, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return err
+	}
+
+	editMsgPath := filepath.Join(gitDir, "COMMIT_EDITMSG")
+	buffer := message
+	if buffer != "" && !strings.HasSuffix(buffer, "\n") {
+		buffer += "\n"
+	}
+	if editing {
+		buffer += commitMessageHint(cleanup, comment)
+	}
+	if err := os.WriteFile(editMsgPath, []byte(buffer), 0644); err != nil {
+		return fmt.Errorf("failed to write COMMIT_EDITMSG: %s", err.Error())
+	}
+	hookArgs := []string{editMsgPath}
+	if source != "" {
+		hookArgs = append(hookArgs, source)
+	}
+	if !editing {
+		hookEnv = append(hookEnv, "GIT_EDITOR=:")
+	}
+	if err := runHook("prepare-commit-msg", hookEnv, hookArgs...); err != nil {
+		return err
+	}
+	if editing {
+		if err := launchEditor(editMsgPath); err != nil {
+			return err
+		}
+	}
+	if !noVerify {
+		if err := runHook("commit-msg", hookEnv, editMsgPath); err != nil {
+			return err
+		}
+	}
+	content, err := os.ReadFile(editMsgPath)
+	if err != nil {
+		return fmt.Errorf("failed to read COMMIT_EDITMSG: %s", err.Error())
+	}
+	message = applyCleanup(string(content), cleanup, comment)
+	if template != "" && message == applyCleanup(template, cleanup, comment) {
+		return fmt.Errorf("Aborting commit; you did not edit the message.")
+	}
+	if signOff {
+		tc, err := loadTrailerConfig()
+		if err != nil {
+			return err
+		}
+		trailer, err := signOffTrailer(tc)
+		if err != nil {
+			return err
+		}
+		message = addTrailers(message, []trailerArg{trailer}, tc, trailerOptions{})
+	}
+	if strings.TrimSpace(message) == "" && !allowEmptyMessage {
+		return fmt.Errorf("Aborting commit due to empty commit message.")
+	}
+	// the policy is checked along with the commit-msg hook
+	if !noVerify {
+		policy, err := loadCommitPolicy()
+		if err != nil {
+			return err
+		}
+		broken, err := policy.violations(message)
+		if err != nil {
+			return err
+		}
+		for _, reason := range broken {
+			fmt.Fprintf(os.Stderr, "error: %s\n", reason)
+		}
+		if len(broken) > 0 {
+			return fmt.Errorf("commit message does not follow the policy")
+		}
+	}
+
+	hash, err := writeCommitObject(tree, parents, author, committer, message)
+	if err != nil {
+		return err
+	}
+
+	commit := Commit{Message: message}
+	reflogMessage := "commit: "
+	switch {
+	case head == "":
+		reflogMessage = "commit (initial): "
+	case len(merged) > 0:
+		reflogMessage = "commit (merge): "
+	}
+	// the branch must still be at the parent the commit was made on
+	if err := updateHeadRef(hash, head, reflogMessage+commit.Subject()); err != nil {
+		return err
+	}
+	if all {
+		if err := writeIndex(index, lock); err != nil {
+			return err
+		}
+	}
+	if err := removeBranchState(); err != nil {
+		return err
+	}
+	// the commit is made, so a failing post-commit hook changes nothing; it
+	// sees the index the commit left
+	runHook("post-commit", []string{"GIT_INDEX_FILE=" + getIndexPath()})
+	if !quiet {
+		// the summary keeps the indentation of the subject line
+		indent := message[:len(message)-len(strings.TrimLeft(message, " \t"))]
+		if err := printCommitSummary(hash, head, parentTree, tree, author, committer, indent+commit.Subject()); err != nil {
+			return err
+		}
+	}
+	startAutoMaintenance(quiet)
+	return nil
+}
+
+// printCommitSummary prints the "[main 1234567] subject" block shown after a
+// commit.
+func printCommitSummary(hash string, parent string, parentTree string, tree string, author Identity, committer Identity, subject string) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	label := "detached HEAD"
+	if branch, ok, err := currentBranch(); err != nil {
+		return err
+	} else if ok {
+		label = shortenRefName(branch)
+	}
+	if parent == "" {
+		label += " (root-commit)"
+	}
+	fmt.Fprintf(w, "[%s %s] %s\n", label, abbrevHash(hash), subject)
+	if author.Name != committer.Name || author.Email != committer.Email {
+		fmt.Fprintf(w, " Author: %s <%s>\n", author.Name, author.Email)
+	}
+	changes, err := diffTrees(parentTree, tree, "")
+	if err != nil {
+		return err
+	}
+	return writeDiffSummary(w, changes)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// commitPolicy is the [policy] config section, rules a commit message has
+// to follow:
+//
+//	[policy]
+//		maxSubjectLength = 72
+//		requireTrailer = Signed-off-by
+//	[policy "ticket"]
+//		pattern = ^[A-Z]+-[0-9]+:
+//		message = the subject must start with a ticket
+//
+// A named rule's pattern must match the message and its reject pattern must
+// not, both with ^ and $ matching at each line.
+type commitPolicy struct {
+	MaxSubjectLength int
+	Trailers         []string
+	Rules            []policyRule
+}
+
+type policyRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Reject  *regexp.Regexp
+	Message string
+}
+
+func loadCommitPolicy() (*commitPolicy, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	policy := &commitPolicy{Trailers: config.GetAll("policy.requireTrailer")}
+	if policy.MaxSubjectLength, err = config.GetInt("policy.maxSubjectLength", 0); err != nil {
+		return nil, err
+	}
+
+	byName := map[string]int{}
+	for _, e := range config.entries {
+		if e.Section != "policy" || e.Subsection == "" {
+			continue
+		}
+		i, ok := byName[e.Subsection]
+		if !ok {
+			i = len(policy.Rules)
+			byName[e.Subsection] = i
+			policy.Rules = append(policy.Rules, policyRule{Name: e.Subsection})
+		}
+		rule := &policy.Rules[i]
+		switch e.Key {
+		case "pattern", "reject":
+			re, err := regexp.Compile("(?m)" + e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("bad policy.%s.%s regular expression: %s", e.Subsection, e.Key, e.Value)
+			}
+			if e.Key == "pattern" {
+				rule.Pattern = re
+			} else {
+				rule.Reject = re
+			}
+		case "message":
+			rule.Message = e.Value
+		}
+	}
+	return policy, nil
+}
+
+// violations checks a commit message against the policy, describing each
+// rule it breaks.
+func (p *commitPolicy) violations(message string) ([]string, error) {
+	var broken []string
+	subject := (&Commit{Message: message}).Subject()
+	if length := utf8.RuneCountInString(subject); p.MaxSubjectLength > 0 && length > p.MaxSubjectLength {
+		broken = append(broken, fmt.Sprintf("the subject is %d characters long, over policy.maxSubjectLength of %d", length, p.MaxSubjectLength))
+	}
+
+	if len(p.Trailers) > 0 {
+		tc, err := loadTrailerConfig()
+		if err != nil {
+			return nil, err
+		}
+		info := parseTrailerInfo(message, tc, trailerOptions{})
+		for _, token := range p.Trailers {
+			found := false
+			for _, item := range info.Items {
+				if strings.EqualFold(item.Token, token) && item.Value != "" {
+					found = true
+				}
+			}
+			if !found {
+				broken = append(broken, fmt.Sprintf("the message has no %s trailer, which policy.requireTrailer asks for", token))
+			}
+		}
+	}
+
+	for _, rule := range p.Rules {
+		matches := rule.Pattern == nil || rule.Pattern.MatchString(message)
+		rejected := rule.Reject != nil && rule.Reject.MatchString(message)
+		if matches && !rejected {
+			continue
+		}
+		reason := rule.Message
+		if reason == "" {
+			reason = "the message does not follow it"
+		}
+		broken = append(broken, fmt.Sprintf("policy %s: %s", rule.Name, reason))
+	}
+	return broken, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommitAllPreCommitSeesStagedIndex(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"a.txt": "a\n"})
+	// the hook records what it sees staged, and stages a file of its own
+	hook := fmt.Sprintf("#!/bin/sh\n%[1]q status --porcelain >.git/seen\necho new >new.txt\n%[1]q add new.txt\n", os.Args[0])
+	if err := os.MkdirAll(filepath.Join(dir, ".git", "hooks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".git", "hooks", "pre-commit"), []byte(hook), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mustRunMygit(t, dir, "commit", "-q", "-a", "-m", "change a")
+
+	seen, err := os.ReadFile(filepath.Join(dir, ".git", "seen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "M  a.txt\n"; string(seen) != want {
+		t.Errorf("pre-commit saw status %q, want %q", seen, want)
+	}
+	if out, want := mustRunMygit(t, dir, "ls-tree", "--name-only", "HEAD"), "a.txt\nnew.txt\n"; out != want {
+		t.Errorf("committed %q, want %q", out, want)
+	}
+	if out := mustRunMygit(t, dir, "status", "--porcelain"); out != "" {
+		t.Errorf("status after the commit %q, want it clean", out)
+	}
+}
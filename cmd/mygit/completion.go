@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const completionUsage = "mygit completion (bash | zsh | fish)\n   or: mygit completion (--branches | --tags | --refs | --remotes)"
+
+// completionArguments says what the arguments of a command are, for the
+// completion scripts to offer: "refs" are branches and tags. Other commands
+// complete file names.
+var completionArguments = map[string]string{
+	"bisect": "refs", "branch": "refs", "cat-file": "refs", "checkout": "refs",
+	"log": "refs", "ls-tree": "refs", "merge-tree": "refs", "name-rev": "refs",
+	"replay": "refs", "reset": "refs", "rev-list": "refs", "rev-parse": "refs",
+	"shortlog": "refs", "show": "refs", "show-branch": "refs", "whatchanged": "refs",
+	"switch": "branches", "tag": "tags", "remote": "remotes", "ls-remote": "remotes",
+	"help": "commands",
+}
+
+var (
+	negatableOption = regexp.MustCompile(`--\[no-\]([A-Za-z0-9][A-Za-z0-9-]*)`)
+	usageOption     = regexp.MustCompile(`(?:^|[\s\[(|])(--?[A-Za-z0-9][A-Za-z0-9-]*)`)
+)
+
+// commandOptions reads the options of a command off its usage, "--[no-]x"
+// giving both "--x" and "--no-x".
+func commandOptions(usage string) []string {
+	usage = negatableOption.ReplaceAllString(usage, "--$1 --no-$1")
+	seen := map[string]bool{}
+	var options []string
+	for _, m := range usageOption.FindAllStringSubmatch(usage, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			options = append(options, m[1])
+		}
+	}
+	sort.Strings(options)
+	return options
+}
+
+// completionCommands returns the command names, with help, in order.
+func completionCommands() []string {
+	names := []string{"help"}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandsWithArguments groups the commands completing arguments of kind.
+func commandsWithArguments(kind string) []string {
+	var names []string
+	for name, k := range completionArguments {
+		if k == kind {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completionKinds are the dynamic argument kinds with the helper option
+// that lists them.
+var completionKinds = []struct{ Kind, Flag string }{
+	{"refs", "--refs"}, {"branches", "--branches"}, {"tags", "--tags"}, {"remotes", "--remotes"},
+}
+
+func writeBashCompletion(w io.Writer) {
+	names := completionCommands()
+	fmt.Fprintf(w, "# bash completion for mygit, written by \"mygit completion bash\"\n")
+	fmt.Fprintf(w, "_mygit() {\n\tlocal cur=${COMP_WORDS[COMP_CWORD]} words\n")
+	fmt.Fprintf(w, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n\t\twords=\"%s\"\n\telse\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\t\tcase \"${COMP_WORDS[1]}:$cur\" in\n")
+	for _, name := range names {
+		if cmd := commands[name]; cmd != nil {
+			if options := commandOptions(cmd.Usage); len(options) > 0 {
+				fmt.Fprintf(w, "\t\t%s:-*) words=\"%s\" ;;\n", name, strings.Join(options, " "))
+			}
+		}
+	}
+	fmt.Fprintf(w, "\t\t*:-*) return ;;\n")
+	fmt.Fprintf(w, "\t\t%s:*) words=\"%s\" ;;\n", strings.Join(commandsWithArguments("commands"), ":*|"), strings.Join(names, " "))
+	for _, k := range completionKinds {
+		if with := commandsWithArguments(k.Kind); len(with) > 0 {
+			fmt.Fprintf(w, "\t\t%s:*) words=$(mygit completion %s 2>/dev/null) ;;\n", strings.Join(with, ":*|"), k.Flag)
+		}
+	}
+	fmt.Fprintf(w, "\t\t*) return ;;\n\t\tesac\n\tfi\n")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n}\n")
+	fmt.Fprintf(w, "complete -o default -F _mygit mygit\n")
+}
+
+func writeZshCompletion(w io.Writer) {
+	names := completionCommands()
+	fmt.Fprintf(w, "#compdef mygit\n# zsh completion for mygit, written by \"mygit completion zsh\"\n")
+	fmt.Fprintf(w, "_mygit() {\n\tlocal -a candidates\n")
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )); then\n\t\tcandidates=(%s)\n\telse\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\t\tcase \"${words[2]}:${words[CURRENT]}\" in\n")
+	for _, name := range names {
+		if cmd := commands[name]; cmd != nil {
+			if options := commandOptions(cmd.Usage); len(options) > 0 {
+				fmt.Fprintf(w, "\t\t(%s:-*) candidates=(%s) ;;\n", name, strings.Join(options, " "))
+			}
+		}
+	}
+	fmt.Fprintf(w, "\t\t(*:-*) return 1 ;;\n")
+	fmt.Fprintf(w, "\t\t(%s:*) candidates=(%s) ;;\n", strings.Join(commandsWithArguments("commands"), ":*|"), strings.Join(names, " "))
+	for _, k := range completionKinds {
+		if with := commandsWithArguments(k.Kind); len(with) > 0 {
+			fmt.Fprintf(w, "\t\t(%s:*) candidates=(${(f)\"$(mygit completion %s 2>/dev/null)\"}) ;;\n", strings.Join(with, ":*|"), k.Flag)
+		}
+	}
+	fmt.Fprintf(w, "\t\t(*) _files; return ;;\n\t\tesac\n\tfi\n")
+	fmt.Fprintf(w, "\tcompadd -a candidates\n}\n")
+	fmt.Fprintf(w, "compdef _mygit mygit\n")
+}
+
+func writeFishCompletion(w io.Writer) {
+	names := completionCommands()
+	fmt.Fprintf(w, "# fish completion for mygit, written by \"mygit completion fish\"\n")
+	fmt.Fprintf(w, "complete -c mygit -f -n __fish_use_subcommand -a '%s'\n", strings.Join(names, " "))
+	for _, name := range names {
+		cmd := commands[name]
+		if cmd == nil {
+			continue
+		}
+		for _, option := range commandOptions(cmd.Usage) {
+			flag := "-l " + strings.TrimPrefix(option, "--")
+			if !strings.HasPrefix(option, "--") {
+				flag = "-o " + strings.TrimPrefix(option, "-")
+				if len(option) == 2 {
+					flag = "-s " + option[1:]
+				}
+			}
+			fmt.Fprintf(w, "complete -c mygit -n '__fish_seen_subcommand_from %s' %s\n", name, flag)
+		}
+	}
+	fmt.Fprintf(w, "complete -c mygit -f -n '__fish_seen_subcommand_from %s' -a '%s'\n", strings.Join(commandsWithArguments("commands"), " "), strings.Join(names, " "))
+	for _, k := range completionKinds {
+		if with := commandsWithArguments(k.Kind); len(with) > 0 {
+			fmt.Fprintf(w, "complete -c mygit -f -n '__fish_seen_subcommand_from %s' -a '(mygit completion %s 2>/dev/null)'\n", strings.Join(with, " "), k.Flag)
+		}
+	}
+}
+
+// completionRefs lists the short names of the refs under prefixes.
+func completionRefs(w io.Writer, prefixes ...string) error {
+	for _, prefix := range prefixes {
+		refs, err := listRefs(prefix)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			fmt.Fprintln(w, shortenRefName(ref.Name))
+		}
+	}
+	return nil
+}
+
+// completionRemotes lists the configured remotes in config order.
+func completionRemotes(w io.Writer) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, e := range config.entries {
+		if e.Section == "remote" && e.Subsection != "" && !seen[e.Subsection] {
+			seen[e.Subsection] = true
+			fmt.Fprintln(w, e.Subsection)
+		}
+	}
+	return nil
+}
+
+// runCompletion writes a completion script for a shell, built from the
+// commands and the options in their usage. The scripts call back into
+// mygit with --branches, --tags, --refs and --remotes to list those names
+// as they are when completing.
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", completionUsage)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(w)
+	case "zsh":
+		writeZshCompletion(w)
+	case "fish":
+		writeFishCompletion(w)
+	case "--branches":
+		return completionRefs(w, "refs/heads/")
+	case "--tags":
+		return completionRefs(w, "refs/tags/")
+	case "--refs":
+		return completionRefs(w, "refs/heads/", "refs/tags/", "refs/remotes/")
+	case "--remotes":
+		return completionRemotes(w)
+	default:
+		return fmt.Errorf("unknown shell '%s'", args[0])
+	}
+	return nil
+}
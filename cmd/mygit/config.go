@@ -0,0 +1,820 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type configEntry struct {
+	Section    string
+	Subsection string
+	Key        string
+	Value      string
+	NoValue    bool
+}
+
+type Config struct {
+	entries []configEntry
+}
+
+var loadedConfig *Config
+
+// configScope is where a config file applies, from the lowest precedence to
+// the highest: settings in later scopes override earlier ones.
+type configScope int
+
+const (
+	scopeSystem configScope = iota
+	scopeGlobal
+	scopeLocal
+	scopeWorktree
+)
+
+// maxIncludeDepth bounds include.path chains, which may form a cycle.
+const maxIncludeDepth = 10
+
+func getConfigPath() string {
+	return filepath.Join(gitDir, "config")
+}
+
+// xdgConfigPath is $XDG_CONFIG_HOME/git/<name>, or ~/.config/git/<name>.
+func xdgConfigPath(name string) string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", name)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "git", name)
+	}
+	return ""
+}
+
+// configFiles returns the files read for a scope, in the order they are
+// read. The worktree config is only read with extensions.worktreeConfig.
+func configFiles(scope configScope) []string {
+	switch scope {
+	case scopeSystem:
+		if noSystem, err := parseConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); err == nil && noSystem {
+			return nil
+		}
+		if path, ok := os.LookupEnv("GIT_CONFIG_SYSTEM"); ok {
+			return []string{path}
+		}
+		return []string{"/etc/gitconfig"}
+	case scopeGlobal:
+		if path, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+			return []string{path}
+		}
+		files := make([]string, 0, 2)
+		if xdg := xdgConfigPath("config"); xdg != "" {
+			files = append(files, xdg)
+		}
+		if home := os.Getenv("HOME"); home != "" {
+			files = append(files, filepath.Join(home, ".gitconfig"))
+		}
+		return files
+	case scopeLocal:
+		return []string{getConfigPath()}
+	default:
+		return []string{filepath.Join(gitDir, "config.worktree")}
+	}
+}
+
+// configWritePath is the file written for a scope: ~/.gitconfig for the
+// global scope unless only the XDG file exists, and the repository config
+// for the worktree scope unless extensions.worktreeConfig is set.
+func configWritePath(scope configScope) (string, error) {
+	switch scope {
+	case scopeSystem:
+		if path, ok := os.LookupEnv("GIT_CONFIG_SYSTEM"); ok {
+			return path, nil
+		}
+		return "/etc/gitconfig", nil
+	case scopeGlobal:
+		if path, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+			return path, nil
+		}
+		home := os.Getenv("HOME")
+		if home == "" {
+			return "", fmt.Errorf("$HOME not set")
+		}
+		path := filepath.Join(home, ".gitconfig")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if xdg := xdgConfigPath("config"); xdg != "" {
+				if _, err := os.Stat(xdg); err == nil {
+					return xdg, nil
+				}
+			}
+		}
+		return path, nil
+	case scopeWorktree:
+		enabled, err := worktreeConfigEnabled()
+		if err != nil || !enabled {
+			return getConfigPath(), err
+		}
+		return configFiles(scopeWorktree)[0], nil
+	}
+	return getConfigPath(), nil
+}
+
+func worktreeConfigEnabled() (bool, error) {
+	entries, err := readConfigFile(getConfigPath())
+	if err != nil {
+		return false, err
+	}
+	local := &Config{entries: entries}
+	return local.GetBool("extensions.worktreeConfig", false)
+}
+
+// readConfigFile parses one config file without following its includes. A
+// missing file has no entries.
+func readConfigFile(path string) ([]configEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %s", path, err.Error())
+	}
+	entries, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad config file %s: %s", path, err.Error())
+	}
+	return entries, nil
+}
+
+// readConfigWithIncludes parses a config file and splices the files named by
+// its include.path and matching includeIf.<condition>.path entries in right
+// after them, so their settings take the place of the include.
+func readConfigWithIncludes(path string, depth int) ([]configEntry, error) {
+	entries, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	expanded := make([]configEntry, 0, len(entries))
+	for _, e := range entries {
+		expanded = append(expanded, e)
+		if e.Key != "path" || e.NoValue || !(e.Section == "include" && e.Subsection == "" ||
+			e.Section == "includeif" && includeConditionMatches(e.Subsection, path)) {
+			continue
+		}
+		includePath := expandHomePath(e.Value)
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		if depth >= maxIncludeDepth {
+			return nil, fmt.Errorf("exceeded maximum include depth (%d) while including %s from %s", maxIncludeDepth, e.Value, path)
+		}
+		included, err := readConfigWithIncludes(includePath, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, included...)
+	}
+	return expanded, nil
+}
+
+func expandHomePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// includeConditionMatches evaluates the gitdir: and gitdir/i: conditions of
+// includeIf against the repository directory. Like git, a relative pattern
+// matches at any depth, "./" is relative to the including file and a
+// trailing "/" matches everything below.
+func includeConditionMatches(condition string, configPath string) bool {
+	pattern, fold := "", false
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		pattern = strings.TrimPrefix(condition, "gitdir:")
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		pattern, fold = strings.TrimPrefix(condition, "gitdir/i:"), true
+	default:
+		return false
+	}
+	pattern = expandHomePath(pattern)
+	if strings.HasPrefix(pattern, "./") {
+		if base, err := filepath.Abs(filepath.Dir(configPath)); err == nil {
+			pattern = base + pattern[1:]
+		}
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	dir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return false
+	}
+	candidates := []string{dir}
+	if real, err := filepath.EvalSymlinks(dir); err == nil && real != dir {
+		candidates = append(candidates, real)
+	}
+	for _, candidate := range candidates {
+		if wildmatchFrom(filepath.ToSlash(pattern), filepath.ToSlash(candidate), fold) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads the config files of all scopes with their includes.
+func loadConfig() (*Config, error) {
+	config := &Config{}
+	for _, scope := range []configScope{scopeSystem, scopeGlobal, scopeLocal, scopeWorktree} {
+		if scope == scopeWorktree {
+			if enabled, err := config.GetBool("extensions.worktreeConfig", false); err != nil || !enabled {
+				continue
+			}
+		}
+		for _, path := range configFiles(scope) {
+			entries, err := readConfigWithIncludes(path, 0)
+			if err != nil {
+				return nil, err
+			}
+			config.entries = append(config.entries, entries...)
+		}
+	}
+	return config, nil
+}
+
+// getConfig loads the repository config once per process.
+func getConfig() (*Config, error) {
+	if loadedConfig != nil {
+		return loadedConfig, nil
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	loadedConfig = config
+	return config, nil
+}
+
+func parseConfig(data []byte) ([]configEntry, error) {
+	entries := make([]configEntry, 0, 16)
+	section, subsection := "", ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		// values may continue on the next line after a trailing backslash
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && scanner.Scan() {
+			lineNo++
+			line = line[:len(line)-1] + scanner.Text()
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			var rest string
+			var err error
+			section, subsection, rest, err = parseSectionHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err.Error())
+			}
+			line = strings.TrimSpace(rest)
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("line %d: key outside of section", lineNo)
+		}
+		key, value, noValue, err := parseConfigLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err.Error())
+		}
+		entries = append(entries, configEntry{
+			Section:    section,
+			Subsection: subsection,
+			Key:        key,
+			Value:      value,
+			NoValue:    noValue,
+		})
+	}
+	return entries, scanner.Err()
+}
+
+func parseSectionHeader(line string) (section string, subsection string, rest string, err error) {
+	endIdx := strings.IndexByte(line, ']')
+	if endIdx == -1 {
+		return "", "", "", fmt.Errorf("unterminated section header")
+	}
+	header := line[1:endIdx]
+	rest = line[endIdx+1:]
+
+	quoteIdx := strings.IndexByte(header, '"')
+	if quoteIdx == -1 {
+		// deprecated [section.subsection] syntax
+		section, subsection, _ = strings.Cut(header, ".")
+		return strings.ToLower(strings.TrimSpace(section)), subsection, rest, nil
+	}
+
+	section = strings.ToLower(strings.TrimSpace(header[:quoteIdx]))
+	quoted := header[quoteIdx:]
+	if len(quoted) < 2 || quoted[len(quoted)-1] != '"' {
+		return "", "", "", fmt.Errorf("bad subsection in section header")
+	}
+	subsection = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(quoted[1 : len(quoted)-1])
+	return section, subsection, rest, nil
+}
+
+func parseConfigLine(line string) (key string, value string, noValue bool, err error) {
+	eqIdx := strings.IndexByte(line, '=')
+	if eqIdx == -1 {
+		key = strings.TrimSpace(line)
+		if commentIdx := strings.IndexAny(key, "#;"); commentIdx != -1 {
+			key = strings.TrimSpace(key[:commentIdx])
+		}
+		noValue = true
+	} else {
+		key = strings.TrimSpace(line[:eqIdx])
+		value, err = parseConfigValue(line[eqIdx+1:])
+		if err != nil {
+			return "", "", false, err
+		}
+	}
+	if key == "" {
+		return "", "", false, fmt.Errorf("empty key")
+	}
+	return strings.ToLower(key), value, noValue, nil
+}
+
+func parseConfigValue(raw string) (string, error) {
+	var b strings.Builder
+	inQuotes := false
+	pendingSpace := ""
+	raw = strings.TrimLeft(raw, " \t")
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '\\':
+			if i+1 >= len(raw) {
+				return "", fmt.Errorf("bad escape at end of value")
+			}
+			i++
+			b.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch raw[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case '\\', '"':
+				b.WriteByte(raw[i])
+			default:
+				return "", fmt.Errorf("bad escape sequence \\%c", raw[i])
+			}
+			continue
+		case !inQuotes && (c == '#' || c == ';'):
+			return b.String(), nil
+		case !inQuotes && (c == ' ' || c == '\t'):
+			// trailing whitespace is dropped, inner whitespace is kept
+			pendingSpace += string(c)
+			continue
+		default:
+			b.WriteString(pendingSpace)
+			pendingSpace = ""
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString(pendingSpace)
+		pendingSpace = ""
+	}
+	if inQuotes {
+		return "", fmt.Errorf("unterminated quote in value")
+	}
+	return b.String(), nil
+}
+
+// splitConfigName splits "section.sub.section.key" into its parts; only the
+// section and key are case-insensitive.
+func splitConfigName(name string) (section string, subsection string, key string) {
+	firstDot := strings.IndexByte(name, '.')
+	lastDot := strings.LastIndexByte(name, '.')
+	if firstDot == -1 {
+		return strings.ToLower(name), "", ""
+	}
+	section = strings.ToLower(name[:firstDot])
+	key = strings.ToLower(name[lastDot+1:])
+	if firstDot != lastDot {
+		subsection = name[firstDot+1 : lastDot]
+	}
+	return
+}
+
+func (c *Config) lookup(name string) []configEntry {
+	section, subsection, key := splitConfigName(name)
+	found := make([]configEntry, 0, 1)
+	for _, e := range c.entries {
+		if e.Section == section && e.Subsection == subsection && e.Key == key {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (c *Config) Get(name string) (string, bool) {
+	found := c.lookup(name)
+	if len(found) == 0 {
+		return "", false
+	}
+	return found[len(found)-1].Value, true
+}
+
+func (c *Config) GetAll(name string) []string {
+	found := c.lookup(name)
+	values := make([]string, 0, len(found))
+	for _, e := range found {
+		values = append(values, e.Value)
+	}
+	return values
+}
+
+func (c *Config) GetBool(name string, def bool) (bool, error) {
+	found := c.lookup(name)
+	if len(found) == 0 {
+		return def, nil
+	}
+	last := found[len(found)-1]
+	if last.NoValue {
+		return true, nil
+	}
+	return parseConfigBool(last.Value)
+}
+
+func (c *Config) GetInt(name string, def int) (int, error) {
+	value, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	return parseConfigInt(value)
+}
+
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%s'", value)
+}
+
+func parseConfigInt(value string) (int, error) {
+	multiplier := 1
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s'", value)
+	}
+	return n * multiplier, nil
+}
+
+func quoteConfigValue(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if needsQuotes {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+func formatSectionHeader(section string, subsection string) string {
+	if subsection == "" {
+		return "[" + section + "]"
+	}
+	return fmt.Sprintf("[%s \"%s\"]", section, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection))
+}
+
+// formatConfigEntry prints an entry the way config --list does.
+func formatConfigEntry(e configEntry) string {
+	name := e.Section
+	if e.Subsection != "" {
+		name += "." + e.Subsection
+	}
+	if e.NoValue {
+		return name + "." + e.Key
+	}
+	return name + "." + e.Key + "=" + e.Value
+}
+
+// editConfigFile rewrites the entries of one key in a config file: when value
+// is nil the key is removed, otherwise its last occurrence is replaced (or a
+// new one is added to the section).
+func editConfigFile(configPath string, name string, value *string) error {
+	section, subsection, key := splitConfigName(name)
+	if section == "" || key == "" {
+		return fmt.Errorf("key does not contain a section: %s", name)
+	}
+	if inMemory != nil {
+		return errInMemoryConfig
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config %s: %s", configPath, err.Error())
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	keyLines := make([]int, 0, 1)
+	sectionEnd := -1
+	curSection, curSubsection := "", ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			curSection, curSubsection, _, err = parseSectionHeader(trimmed)
+			if err != nil {
+				return fmt.Errorf("bad config file %s: %s", configPath, err.Error())
+			}
+		}
+		if curSection != section || curSubsection != subsection {
+			continue
+		}
+		sectionEnd = i
+		if trimmed == "" || trimmed[0] == '[' || trimmed[0] == '#' || trimmed[0] == ';' {
+			continue
+		}
+		lineKey, _, _, err := parseConfigLine(trimmed)
+		if err == nil && lineKey == key {
+			keyLines = append(keyLines, i)
+		}
+	}
+
+	rawKey := name[strings.LastIndexByte(name, '.')+1:]
+	if value == nil {
+		if len(keyLines) == 0 {
+			return errConfigKeyNotFound
+		}
+		for i := len(keyLines) - 1; i >= 0; i-- {
+			lines = append(lines[:keyLines[i]], lines[keyLines[i]+1:]...)
+		}
+	} else {
+		entry := fmt.Sprintf("\t%s = %s\n", rawKey, quoteConfigValue(*value))
+		switch {
+		case len(keyLines) > 0:
+			lines[keyLines[len(keyLines)-1]] = entry
+		case sectionEnd >= 0:
+			lines = append(lines[:sectionEnd+1], append([]string{entry}, lines[sectionEnd+1:]...)...)
+		default:
+			if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+				lines[len(lines)-1] += "\n"
+			}
+			lines = append(lines, formatSectionHeader(section, subsection)+"\n", entry)
+		}
+	}
+
+	if err := writeFileAtomic(configPath, []byte(strings.Join(lines, ""))); err != nil {
+		return fmt.Errorf("failed to write config %s: %s", configPath, err.Error())
+	}
+	loadedConfig = nil
+	return nil
+}
+
+var errConfigKeyNotFound = fmt.Errorf("config key not found")
+
+// errInMemoryConfig is returned by config writes under --in-memory, which
+// keeps no config of its own.
+var errInMemoryConfig = fmt.Errorf("cannot write the config of an in-memory repository")
+
+// renameConfigSection renames the [section "from"] blocks of a config file to
+// [section "to"], or with keep appends a copy of them under the new name.
+func renameConfigSection(configPath string, section string, from string, to string, keep bool) error {
+	if inMemory != nil {
+		return errInMemoryConfig
+	}
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config %s: %s", configPath, err.Error())
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	header := formatSectionHeader(section, to) + "\n"
+	copied := make([]string, 0)
+	found, inSection := false, false
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") {
+			curSection, curSubsection, _, err := parseSectionHeader(trimmed)
+			if err != nil {
+				return fmt.Errorf("bad config file %s: %s", configPath, err.Error())
+			}
+			inSection = curSection == section && curSubsection == from
+			if inSection {
+				found = true
+				if !keep {
+					lines[i] = header
+				}
+				copied = append(copied, header)
+				continue
+			}
+		}
+		if inSection && keep {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			copied = append(copied, line)
+		}
+	}
+	if !found {
+		return nil
+	}
+	if keep {
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			lines[len(lines)-1] += "\n"
+		}
+		lines = append(lines, copied...)
+	}
+	if err := writeFileAtomic(configPath, []byte(strings.Join(lines, ""))); err != nil {
+		return fmt.Errorf("failed to write config %s: %s", configPath, err.Error())
+	}
+	loadedConfig = nil
+	return nil
+}
+
+func setConfigValue(name string, value string) error {
+	return editConfigFile(getConfigPath(), name, &value)
+}
+
+func unsetConfigValue(name string) error {
+	return editConfigFile(getConfigPath(), name, nil)
+}
+
+const configUsage = "mygit config [--system | --global | --local | --worktree | -f <file>] [--get | --get-all | --unset | --unset-all | -l] [<name> [<value>]]"
+
+func runConfig(args []string) error {
+	system, global, local, worktree := false, false, false, false
+	get, getAll, list, unset, unsetAll := false, false, false, false, false
+	file := ""
+	flags := newFlagSet()
+	flags.Bool(&system, "--system")
+	flags.Bool(&global, "--global")
+	flags.Bool(&local, "--local")
+	flags.Bool(&worktree, "--worktree")
+	flags.String(&file, "-f", "--file")
+	flags.Bool(&get, "--get")
+	flags.Bool(&getAll, "--get-all")
+	flags.Bool(&list, "-l", "--list")
+	flags.Bool(&unset, "--unset")
+	flags.Bool(&unsetAll, "--unset-all")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	scopes := 0
+	scope := scopeLocal
+	for _, s := range []struct {
+		set   bool
+		scope configScope
+	}{{system, scopeSystem}, {global, scopeGlobal}, {local, scopeLocal}, {worktree, scopeWorktree}} {
+		if s.set {
+			scopes++
+			scope = s.scope
+		}
+	}
+	if file != "" {
+		file = prefixFilename(file)
+		scopes++
+	}
+	if scopes > 1 {
+		return fmt.Errorf("only one config file at a time")
+	}
+	actions := 0
+	for _, set := range []bool{get, getAll, list, unset, unsetAll} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return fmt.Errorf("only one action at a time")
+	}
+
+	writePath := file
+	if writePath == "" {
+		if writePath, err = configWritePath(scope); err != nil {
+			return err
+		}
+	}
+	// reading without a scope sees every file with its includes; a single
+	// scope is read as it is, like git without --includes
+	readEntries := func() ([]configEntry, error) {
+		if scopes == 0 {
+			config, err := getConfig()
+			if err != nil {
+				return nil, err
+			}
+			return config.entries, nil
+		}
+		files := []string{writePath}
+		if file == "" && scope != scopeWorktree {
+			files = configFiles(scope)
+		}
+		entries := make([]configEntry, 0)
+		for _, path := range files {
+			fileEntries, err := readConfigFile(path)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, fileEntries...)
+		}
+		return entries, nil
+	}
+
+	switch {
+	case list:
+		if len(args) != 0 {
+			return fmt.Errorf("usage: %s", configUsage)
+		}
+		entries, err := readEntries()
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		for _, e := range entries {
+			fmt.Fprintln(w, formatConfigEntry(e))
+		}
+		return nil
+	case unset || unsetAll:
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", configUsage)
+		}
+		if unset {
+			entries, err := readConfigFile(writePath)
+			if err != nil {
+				return err
+			}
+			if found := (&Config{entries: entries}).lookup(args[0]); len(found) > 1 {
+				return fmt.Errorf("%s has multiple values", args[0])
+			}
+		}
+		err := editConfigFile(writePath, args[0], nil)
+		if err == errConfigKeyNotFound {
+			return errQuietFailure
+		}
+		return err
+	case len(args) == 2 && !get && !getAll:
+		return editConfigFile(writePath, args[0], &args[1])
+	case len(args) != 1:
+		return fmt.Errorf("usage: %s", configUsage)
+	}
+
+	if section, _, key := splitConfigName(args[0]); section == "" || key == "" {
+		return fmt.Errorf("key does not contain a section: %s", args[0])
+	}
+	entries, err := readEntries()
+	if err != nil {
+		return err
+	}
+	values := (&Config{entries: entries}).GetAll(args[0])
+	if len(values) == 0 {
+		return errQuietFailure
+	}
+	if !getAll {
+		values = values[len(values)-1:]
+	}
+	for _, value := range values {
+		fmt.Println(value)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+	Quit     bool
+}
+
+func parseCredential(r io.Reader) (*Credential, error) {
+	cred := &Credential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid credential line: %s", line)
+		}
+		switch key {
+		case "protocol":
+			cred.Protocol = value
+		case "host":
+			cred.Host = value
+		case "path":
+			cred.Path = value
+		case "username":
+			cred.Username = value
+		case "password":
+			cred.Password = value
+		case "url":
+			if err := cred.setURL(value); err != nil {
+				return nil, err
+			}
+		case "quit":
+			cred.Quit, _ = parseConfigBool(value)
+		}
+	}
+	return cred, scanner.Err()
+}
+
+func (c *Credential) setURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("invalid credential url: %s", rawURL)
+	}
+	c.Protocol = u.Scheme
+	c.Host = u.Host
+	c.Path = strings.TrimPrefix(u.Path, "/")
+	if u.User != nil {
+		c.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			c.Password = password
+		}
+	}
+	return nil
+}
+
+func (c *Credential) write(w io.Writer) {
+	fields := []struct{ key, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Fprintf(w, "%s=%s\n", f.key, f.value)
+		}
+	}
+}
+
+func (c *Credential) description() string {
+	desc := c.Protocol + "://"
+	if c.Username != "" {
+		desc += url.PathEscape(c.Username) + "@"
+	}
+	desc += c.Host
+	if c.Path != "" {
+		desc += "/" + c.Path
+	}
+	return desc
+}
+
+// credentialURLMatches reports whether a credential.<url>.* config section
+// applies to the credential.
+func credentialURLMatches(pattern string, c *Credential) bool {
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+	if u.Scheme != c.Protocol || (u.Host != "" && !strings.EqualFold(u.Host, c.Host)) {
+		return false
+	}
+	if u.User != nil && u.User.Username() != c.Username && c.Username != "" {
+		return false
+	}
+	path := strings.Trim(u.Path, "/")
+	return path == "" || path == c.Path || strings.HasPrefix(c.Path, path+"/")
+}
+
+// credentialConfig returns the values of credential.<key> and all matching
+// credential.<url>.<key> entries in config order.
+func credentialConfig(config *Config, c *Credential, key string) []string {
+	values := make([]string, 0, 2)
+	for _, e := range config.entries {
+		if e.Section != "credential" || e.Key != key {
+			continue
+		}
+		if e.Subsection == "" || credentialURLMatches(e.Subsection, c) {
+			values = append(values, e.Value)
+		}
+	}
+	return values
+}
+
+func credentialHelpers(config *Config, c *Credential) []string {
+	helpers := make([]string, 0, 2)
+	for _, h := range credentialConfig(config, c, "helper") {
+		// an empty helper resets the list collected so far
+		if h == "" {
+			helpers = helpers[:0]
+			continue
+		}
+		helpers = append(helpers, h)
+	}
+	return helpers
+}
+
+func credentialHelperCommand(helper string, action string) string {
+	if strings.HasPrefix(helper, "!") {
+		return helper[1:] + " " + action
+	}
+	if filepath.IsAbs(helper) {
+		return helper + " " + action
+	}
+	name, args, _ := strings.Cut(helper, " ")
+	if path, err := exec.LookPath("git-credential-" + name); err == nil {
+		return strings.TrimSpace(path+" "+args) + " " + action
+	}
+	return "git credential-" + helper + " " + action
+}
+
+func runCredentialHelper(helper string, action string, c *Credential) (*Credential, error) {
+	var input bytes.Buffer
+	c.write(&input)
+	cmd := exec.CommandContext(commandContext, "sh", "-c", credentialHelperCommand(helper, action))
+	cmd.Stdin = &input
+	cmd.Stderr = os.Stderr
+	traceRunCommand(cmd)
+	if action != "get" {
+		cmd.Stdout = io.Discard
+		return nil, cmd.Run()
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper '%s' failed: %s", helper, err.Error())
+	}
+	return parseCredential(bytes.NewReader(output))
+}
+
+func credentialPrompt(prompt string, hidden bool) (string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	askpass := os.Getenv("GIT_ASKPASS")
+	if askpass == "" {
+		askpass, _ = config.Get("core.askPass")
+	}
+	if askpass == "" {
+		askpass = os.Getenv("SSH_ASKPASS")
+	}
+	if askpass != "" {
+		cmd := exec.Command(askpass, prompt)
+		traceRunCommand(cmd)
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run askpass %s: %s", askpass, err.Error())
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	}
+
+	if os.Getenv("GIT_TERMINAL_PROMPT") == "0" {
+		return "", fmt.Errorf("terminal prompts disabled")
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: no terminal", strings.TrimSuffix(prompt, ": "))
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	if hidden {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = tty
+		if err := stty.Run(); err == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = tty
+				restore.Run()
+				fmt.Fprintln(tty)
+			}()
+		}
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read %s", strings.TrimSuffix(prompt, ": "))
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// fillCredential asks each configured helper for the missing pieces and
+// falls back to prompting the user.
+func fillCredential(c *Credential) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if c.Username == "" {
+		if usernames := credentialConfig(config, c, "username"); len(usernames) > 0 {
+			c.Username = usernames[len(usernames)-1]
+		}
+	}
+
+	for _, helper := range credentialHelpers(config, c) {
+		if c.Username != "" && c.Password != "" {
+			return nil
+		}
+		result, err := runCredentialHelper(helper, "get", c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+			continue
+		}
+		if c.Username == "" {
+			c.Username = result.Username
+		}
+		if c.Password == "" && (result.Username == "" || result.Username == c.Username) {
+			c.Password = result.Password
+		}
+		if result.Quit {
+			return fmt.Errorf("credential helper '%s' told us to quit", helper)
+		}
+	}
+
+	if c.Username == "" {
+		c.Username, err = credentialPrompt(fmt.Sprintf("Username for '%s://%s': ", c.Protocol, c.Host), false)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Password == "" {
+		c.Password, err = credentialPrompt(fmt.Sprintf("Password for '%s': ", c.description()), true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeCredential passes an approved or rejected credential on to every
+// helper so they can cache or forget it.
+func storeCredential(c *Credential, action string) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if action == "store" && (c.Username == "" || c.Password == "") {
+		return nil
+	}
+	for _, helper := range credentialHelpers(config, c) {
+		if _, err := runCredentialHelper(helper, action, c); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: credential helper '%s' failed to %s\n", helper, action)
+		}
+	}
+	return nil
+}
+
+// applyCredentialConfig drops the path of http(s) credentials unless
+// credential.useHttpPath asks for per-repository credentials.
+func applyCredentialConfig(c *Credential) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	useHTTPPath := false
+	for _, value := range credentialConfig(config, c, "usehttppath") {
+		useHTTPPath, err = parseConfigBool(value)
+		if err != nil {
+			return err
+		}
+	}
+	if !useHTTPPath && (c.Protocol == "http" || c.Protocol == "https") {
+		c.Path = ""
+	}
+	return nil
+}
+
+func runCredential(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: credential (fill|approve|reject)")
+	}
+	cred, err := parseCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if err := applyCredentialConfig(cred); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "fill":
+		if err := fillCredential(cred); err != nil {
+			return err
+		}
+		cred.write(os.Stdout)
+		return nil
+	case "approve":
+		return storeCredential(cred, "store")
+	case "reject":
+		return storeCredential(cred, "erase")
+	}
+	return fmt.Errorf("unknown credential action %s", args[0])
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dateLayouts are the fixed formats parseDate tries, after git's own
+// "<timestamp> <tz>": RFC 2822, the format log shows dates in, ISO 8601 and
+// its looser variants, and US month/day/year. Dates without a zone are in
+// the local one.
+var dateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"Mon Jan 2 15:04:05 2006",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006.01.02 15:04:05",
+	"01/02/2006 15:04:05",
+}
+
+// dateOnlyLayouts name a day alone; its time of day is taken from now.
+var dateOnlyLayouts = []string{"2006-01-02", "2006.01.02", "01/02/2006", "2 Jan 2006", "Jan 2 2006", "Jan 2, 2006"}
+
+// parseDate reads a date the way git reads GIT_AUTHOR_DATE and
+// GIT_COMMITTER_DATE: "<timestamp> <tz>", "@<timestamp>", a bare timestamp,
+// RFC 2822 or ISO 8601.
+func parseDate(value string, now time.Time) (time.Time, error) {
+	value = strings.Join(strings.Fields(value), " ")
+	stamp, zone, _ := strings.Cut(strings.TrimPrefix(value, "@"), " ")
+	if seconds, err := strconv.ParseInt(stamp, 10, 64); err == nil && (len(stamp) >= 9 || zone != "" || strings.HasPrefix(value, "@")) {
+		when := time.Unix(seconds, 0).UTC()
+		if zone == "" {
+			return when, nil
+		}
+		if offset, err := parseTimezoneOffset(zone); err == nil {
+			return when.In(time.FixedZone("", offset)), nil
+		}
+	}
+	for _, layout := range dateLayouts {
+		if when, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return when, nil
+		}
+	}
+	for _, layout := range dateOnlyLayouts {
+		if day, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+}
+
+var dateUnits = map[string]func(t time.Time, n int) time.Time{
+	"second":    func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Second) },
+	"minute":    func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Minute) },
+	"hour":      func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Hour) },
+	"day":       func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -n) },
+	"week":      func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -7*n) },
+	"fortnight": func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -14*n) },
+	"month":     func(t time.Time, n int) time.Time { return t.AddDate(0, -n, 0) },
+	"year":      func(t time.Time, n int) time.Time { return t.AddDate(-n, 0, 0) },
+}
+
+// dateTimes are the times of day approxidate knows by name, meaning the
+// last one before now.
+var dateTimes = map[string]int{"midnight": 0, "noon": 12, "tea": 17}
+
+// lookupName finds a weekday or month by its name or the first three
+// letters of it.
+func lookupName(word string, names func(i int) string, count int) (int, bool) {
+	for i := 0; i < count; i++ {
+		name := strings.ToLower(names(i))
+		if len(word) >= 3 && strings.HasPrefix(name, word) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// approxidate reads a date like git's approxidate: any date parseDate takes,
+// or a description relative to now such as "yesterday", "2 weeks ago",
+// "2.weeks.ago", "last tuesday", "noon yesterday", "3pm" or "march 5".
+// Unlike git, words it does not know are an error rather than ignored.
+func approxidate(value string, now time.Time) (time.Time, error) {
+	if when, err := parseDate(value, now); err == nil {
+		return when, nil
+	}
+	words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	// split "3pm" into "3" and "pm"
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		digits := strings.IndexFunc(word, func(r rune) bool { return !unicode.IsDigit(r) })
+		if digits > 0 {
+			tokens = append(tokens, word[:digits], word[digits:])
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	if len(tokens) == 0 {
+		return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+	}
+
+	t := now
+	n := -1
+	// monthNamed is set after a month without a day, which may follow it
+	monthNamed := false
+	setTime := func(hour int, minute int) {
+		// a time later today means the day before, unless another day is named
+		day := t
+		sameDay := t.Year() == now.Year() && t.YearDay() == now.YearDay()
+		if sameDay && (hour > t.Hour() || hour == t.Hour() && minute > t.Minute()) {
+			day = day.AddDate(0, 0, -1)
+		}
+		t = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, t.Location())
+	}
+	for _, token := range tokens {
+		if number, err := strconv.Atoi(token); err == nil {
+			switch {
+			case number >= 1000:
+				t = time.Date(number, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+				continue
+			case monthNamed && number <= 31:
+				t = time.Date(t.Year(), t.Month(), number, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+				monthNamed = false
+				continue
+			}
+			n = number
+			continue
+		}
+		monthNamed = false
+		count := max(n, 1)
+		unit := strings.TrimSuffix(token, "s")
+		if hour, ok := dateTimes[token]; ok {
+			setTime(hour, 0)
+		} else if apply, ok := dateUnits[unit]; ok {
+			t = apply(t, count)
+		} else if token == "am" || token == "pm" {
+			if n < 0 || n > 12 {
+				return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+			}
+			hour := n % 12
+			if token == "pm" {
+				hour += 12
+			}
+			setTime(hour, 0)
+		} else if weekday, ok := lookupName(token, func(i int) string { return time.Weekday(i).String() }, 7); ok {
+			// the last such day before today, n-1 more weeks back for "<n> <day>"
+			back := (int(t.Weekday())-weekday+6)%7 + 1
+			t = t.AddDate(0, 0, -back-7*(count-1))
+		} else if month, ok := lookupName(token, func(i int) string { return time.Month(i + 1).String() }, 12); ok {
+			day := t.Day()
+			if n > 0 && n <= 31 {
+				day = n
+			}
+			year := t.Year()
+			if time.Month(month+1) > t.Month() {
+				year--
+			}
+			t = time.Date(year, time.Month(month+1), day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+			monthNamed = n <= 0
+		} else {
+			switch token {
+			case "now", "today", "ago", "at", "the", "and":
+			case "yesterday":
+				t = t.AddDate(0, 0, -1)
+			case "last", "a", "an":
+				n = 1
+				continue
+			default:
+				return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+			}
+		}
+		n = -1
+	}
+	if n >= 0 {
+		return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+	}
+	return t, nil
+}
+
+// dateMode is how dates are shown, set by --date or log.date: one of
+// "default", "relative", "local", "iso", "iso-strict", "rfc", "short", "raw",
+// "unix" or "format" with a strftime Format. Local shows them in the local
+// zone rather than the one they were recorded in, as the "-local" modes do.
+type dateMode struct {
+	Kind   string
+	Format string
+	Local  bool
+}
+
+var dateModeNames = map[string]string{
+	"default":        "default",
+	"relative":       "relative",
+	"local":          "local",
+	"iso":            "iso",
+	"iso8601":        "iso",
+	"iso-strict":     "iso-strict",
+	"iso8601-strict": "iso-strict",
+	"rfc":            "rfc",
+	"rfc2822":        "rfc",
+	"short":          "short",
+	"raw":            "raw",
+	"unix":           "unix",
+}
+
+// parseDateMode reads a --date value.
+func parseDateMode(value string) (dateMode, error) {
+	if format, ok := strings.CutPrefix(value, "format:"); ok {
+		return dateMode{Kind: "format", Format: format}, nil
+	}
+	if format, ok := strings.CutPrefix(value, "format-local:"); ok {
+		return dateMode{Kind: "format", Format: format, Local: true}, nil
+	}
+	name, local := strings.CutSuffix(value, "-local")
+	kind, ok := dateModeNames[name]
+	if !ok || local && (kind == "relative" || kind == "local" || kind == "raw" || kind == "unix") {
+		return dateMode{}, fmt.Errorf("unknown date format %s", value)
+	}
+	if kind == "local" {
+		kind, local = "default", true
+	}
+	return dateMode{Kind: kind, Local: local}, nil
+}
+
+// formatDate shows when a commit or tag was made as mode says.
+func formatDate(when time.Time, mode dateMode) string {
+	if mode.Local {
+		when = when.Local()
+	}
+	switch mode.Kind {
+	case "relative":
+		return relativeDate(when, time.Now())
+	case "iso":
+		return when.Format("2006-01-02 15:04:05 -0700")
+	case "iso-strict":
+		return when.Format("2006-01-02T15:04:05-07:00")
+	case "rfc":
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case "short":
+		return when.Format("2006-01-02")
+	case "raw":
+		return fmt.Sprintf("%d %s", when.Unix(), when.Format("-0700"))
+	case "unix":
+		return strconv.FormatInt(when.Unix(), 10)
+	case "format":
+		return strftime(mode.Format, when)
+	}
+	if mode.Local {
+		return when.Format("Mon Jan 2 15:04:05 2006")
+	}
+	return when.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+// relativeDate describes how long before now a date is, rounding like git:
+// seconds up to 90 of them, then minutes, hours, days, weeks, months, and
+// years with months up to five years.
+func relativeDate(when time.Time, now time.Time) string {
+	diff := int64(now.Sub(when) / time.Second)
+	if diff < 0 {
+		return "in the future"
+	}
+	ago := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s ago", n, unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	if diff < 90 {
+		return ago(diff, "second")
+	}
+	if diff = (diff + 30) / 60; diff < 90 {
+		return ago(diff, "minute")
+	}
+	if diff = (diff + 30) / 60; diff < 36 {
+		return ago(diff, "hour")
+	}
+	days := (diff + 12) / 24
+	switch {
+	case days < 14:
+		return ago(days, "day")
+	case days < 70:
+		return ago((days+3)/7, "week")
+	case days < 365:
+		return ago((days+15)/30, "month")
+	case days < 1825:
+		months := (days*12*2 + 365) / (365 * 2)
+		years := plural(int(months/12), "year", "years")
+		if months%12 == 0 {
+			return years + " ago"
+		}
+		return years + ", " + ago(months%12, "month")
+	}
+	return ago((days+183)/365, "year")
+}
+
+// strftime formats a date with the strftime conversions --date=format:
+// understands. Unknown ones are kept as they are.
+func strftime(format string, when time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			b.WriteString(when.Format("Mon"))
+		case 'A':
+			b.WriteString(when.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(when.Format("Jan"))
+		case 'B':
+			b.WriteString(when.Format("January"))
+		case 'c':
+			b.WriteString(when.Format("Mon Jan _2 15:04:05 2006"))
+		case 'd':
+			b.WriteString(when.Format("02"))
+		case 'e':
+			b.WriteString(when.Format("_2"))
+		case 'D':
+			b.WriteString(when.Format("01/02/06"))
+		case 'F':
+			b.WriteString(when.Format("2006-01-02"))
+		case 'H':
+			b.WriteString(when.Format("15"))
+		case 'I':
+			b.WriteString(when.Format("03"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", when.YearDay())
+		case 'm':
+			b.WriteString(when.Format("01"))
+		case 'M':
+			b.WriteString(when.Format("04"))
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(when.Format("PM"))
+		case 'R':
+			b.WriteString(when.Format("15:04"))
+		case 's':
+			fmt.Fprintf(&b, "%d", when.Unix())
+		case 'S':
+			b.WriteString(when.Format("05"))
+		case 't':
+			b.WriteByte('\t')
+		case 'T':
+			b.WriteString(when.Format("15:04:05"))
+		case 'u':
+			fmt.Fprintf(&b, "%d", (int(when.Weekday())+6)%7+1)
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(when.Weekday()))
+		case 'y':
+			b.WriteString(when.Format("06"))
+		case 'Y':
+			b.WriteString(when.Format("2006"))
+		case 'z':
+			b.WriteString(when.Format("-0700"))
+		case 'Z':
+			b.WriteString(when.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
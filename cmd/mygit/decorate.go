@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	decorateNo    = "no"
+	decorateShort = "short"
+	decorateFull  = "full"
+	decorateAuto  = "auto"
+)
+
+var decorateColorDefaults = map[string]string{
+	"branch":       "bold green",
+	"remoteBranch": "bold red",
+	"tag":          "bold yellow",
+	"stash":        "bold magenta",
+	"HEAD":         "bold cyan",
+}
+
+// decoration is one ref naming a commit, with Slot its color.decorate slot.
+type decoration struct {
+	Ref  string
+	Name string
+	Slot string
+}
+
+// decorations maps commit hashes to the refs pointing at them, in the order
+// log shows them.
+type decorations struct {
+	refs map[string][]decoration
+	// headBranch is the branch HEAD is on, "" when detached
+	headBranch string
+	colors     colorPalette
+}
+
+// parseDecorateMode accepts the values of --decorate= and log.decorate.
+func parseDecorateMode(value string) (string, error) {
+	switch value {
+	case decorateNo, decorateShort, decorateFull, decorateAuto:
+		return value, nil
+	}
+	on, err := parseConfigBool(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid --decorate option: %s", value)
+	}
+	if on {
+		return decorateShort, nil
+	}
+	return decorateNo, nil
+}
+
+// decorateMode decides how log decorates commits: --decorate wins, then
+// log.decorate, which defaults to auto, short names when output is a
+// terminal.
+func decorateMode(config *Config, flag string) (string, error) {
+	mode := flag
+	if mode == "" {
+		mode = decorateAuto
+		if value, ok := config.Get("log.decorate"); ok {
+			var err error
+			if mode, err = parseDecorateMode(value); err != nil {
+				return "", err
+			}
+		}
+	}
+	if mode == decorateAuto {
+		if runningPager != nil || isTerminal(os.Stdout) {
+			return decorateShort, nil
+		}
+		return decorateNo, nil
+	}
+	return mode, nil
+}
+
+// decorationSlot returns the color slot of a decorated ref, or "" for refs
+// log does not show, like notes and replace refs.
+func decorationSlot(ref string) string {
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		return "branch"
+	case strings.HasPrefix(ref, "refs/remotes/"):
+		return "remoteBranch"
+	case strings.HasPrefix(ref, "refs/tags/"):
+		return "tag"
+	case ref == "refs/stash":
+		return "stash"
+	}
+	return ""
+}
+
+// loadDecorations maps the commits of all branches, remote-tracking
+// branches, tags and the stash to their refs, annotated tags being peeled to
+// the commit they tag. Like git, refs of a commit are listed in reverse
+// name order, after HEAD.
+func loadDecorations(mode string, colors colorPalette) (*decorations, error) {
+	d := &decorations{refs: map[string][]decoration{}}
+	if colors != nil {
+		var err error
+		if d.colors, err = loadColorPalette("decorate", decorateColorDefaults, "always"); err != nil {
+			return nil, err
+		}
+		d.colors["commit"] = colors["commit"]
+	}
+
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for i := len(refs) - 1; i >= 0; i-- {
+		r := refs[i]
+		slot := decorationSlot(r.Name)
+		if slot == "" {
+			continue
+		}
+		commit, err := peelToCommit(r.Hash)
+		if err != nil {
+			continue
+		}
+		name := r.Name
+		if mode == decorateShort {
+			name = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(name, "refs/heads/"), "refs/remotes/"), "refs/tags/")
+		}
+		if slot == "tag" {
+			name = "tag: " + name
+		}
+		d.refs[commit] = append(d.refs[commit], decoration{Ref: r.Name, Name: name, Slot: slot})
+	}
+
+	head, target, err := resolveRef("HEAD")
+	if err == errRefNotFound {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target != "HEAD" {
+		d.headBranch = target
+	}
+	d.refs[head] = append([]decoration{{Ref: "HEAD", Name: "HEAD", Slot: "HEAD"}}, d.refs[head]...)
+	return d, nil
+}
+
+// format returns the " (HEAD -> main, tag: v1.0)" suffix of the commit line,
+// or "" for a commit no ref points at. When HEAD is on a branch naming the
+// same commit, the two are shown together.
+func (d *decorations) format(hash string) string {
+	if d == nil || len(d.refs[hash]) == 0 {
+		return ""
+	}
+	refs := d.refs[hash]
+	onBranch := false
+	for _, r := range refs {
+		onBranch = onBranch || r.Ref == d.headBranch
+	}
+
+	var b strings.Builder
+	b.WriteString(d.colors.paint("commit", " ("))
+	for i, r := range refs {
+		if onBranch && r.Ref == d.headBranch {
+			continue
+		}
+		if i > 0 {
+			b.WriteString(d.colors.paint("commit", ", "))
+		}
+		if r.Ref == "HEAD" && onBranch {
+			b.WriteString(d.colors.paint("HEAD", "HEAD -> "))
+			for _, branch := range refs {
+				if branch.Ref == d.headBranch {
+					b.WriteString(d.colors.paint(branch.Slot, branch.Name))
+				}
+			}
+			continue
+		}
+		b.WriteString(d.colors.paint(r.Slot, r.Name))
+	}
+	b.WriteString(d.colors.paint("commit", ")"))
+	return b.String()
+}
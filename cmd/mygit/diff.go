@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	modeTree    = 40000
+	modeGitlink = 160000
+	modeSymlink = 120000
+	zeroHash    = "0000000000000000000000000000000000000000"
+	diffContext = 3
+
+	emptyBlobHash = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	// emptyTreeHash is the tree without entries, which git knows about
+	// whether or not it is stored
+	emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+)
+
+type fileChange struct {
+	Path    string
+	Status  byte
+	OldMode int
+	NewMode int
+	OldHash string
+	NewHash string
+	// OldPath and Score are set for renames, whose Status is 'R'
+	OldPath string
+	Score   int
+}
+
+// oldPath is where the file was before the change.
+func (c fileChange) oldPath() string {
+	if c.Status == 'R' {
+		return c.OldPath
+	}
+	return c.Path
+}
+
+// renameDisplayName shows a rename like git's pprint_rename, with the
+// common leading and trailing directories outside braces:
+// "dir/{old => new}".
+func renameDisplayName(oldPath string, newPath string) string {
+	prefix := 0
+	for i := 0; i < min(len(oldPath), len(newPath)) && oldPath[i] == newPath[i]; i++ {
+		if oldPath[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	suffix := 0
+	for i := 1; i <= min(len(oldPath), len(newPath))-prefix && oldPath[len(oldPath)-i] == newPath[len(newPath)-i]; i++ {
+		if oldPath[len(oldPath)-i] == '/' {
+			suffix = i
+		}
+	}
+	if prefix+suffix == 0 {
+		return oldPath + " => " + newPath
+	}
+	return oldPath[:prefix] + "{" + oldPath[prefix:len(oldPath)-suffix] + " => " + newPath[prefix:len(newPath)-suffix] + "}" + oldPath[len(oldPath)-suffix:]
+}
+
+func readTree(hash string) ([]TreeObjectLine, error) {
+	object, err := parseObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if object.Type != TypeTree {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, object.Type)
+	}
+	return parseTreeEntries(object.Content)
+}
+
+// treeEntryKey sorts like git: directories compare as if they had a trailing slash.
+func treeEntryKey(e TreeObjectLine) string {
+	if e.Mode == modeTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+func readTreeOrEmpty(hash string) ([]TreeObjectLine, error) {
+	if hash == "" || hash == emptyTreeHash {
+		return nil, nil
+	}
+	return readTree(hash)
+}
+
+// diffTrees compares two trees recursively, either of which may be "" for
+// the empty tree.
+func diffTrees(oldTree string, newTree string, prefix string) ([]fileChange, error) {
+	oldEntries, err := readTreeOrEmpty(oldTree)
+	if err != nil {
+		return nil, err
+	}
+	newEntries, err := readTreeOrEmpty(newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]fileChange, 0)
+	i, j := 0, 0
+	for i < len(oldEntries) || j < len(newEntries) {
+		var oldEntry, newEntry *TreeObjectLine
+		switch {
+		case i >= len(oldEntries):
+			newEntry = &newEntries[j]
+			j++
+		case j >= len(newEntries):
+			oldEntry = &oldEntries[i]
+			i++
+		case oldEntries[i].Name == newEntries[j].Name:
+			oldEntry, newEntry = &oldEntries[i], &newEntries[j]
+			i++
+			j++
+		case treeEntryKey(oldEntries[i]) < treeEntryKey(newEntries[j]):
+			oldEntry = &oldEntries[i]
+			i++
+		default:
+			newEntry = &newEntries[j]
+			j++
+		}
+
+		entryChanges, err := diffTreeEntries(oldEntry, newEntry, prefix)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, entryChanges...)
+	}
+	return changes, nil
+}
+
+func diffTreeEntries(oldEntry *TreeObjectLine, newEntry *TreeObjectLine, prefix string) ([]fileChange, error) {
+	if oldEntry != nil && newEntry != nil {
+		oldHash, newHash := hex.EncodeToString(oldEntry.Hash), hex.EncodeToString(newEntry.Hash)
+		if oldEntry.Mode == newEntry.Mode && oldHash == newHash {
+			return nil, nil
+		}
+		oldIsTree, newIsTree := oldEntry.Mode == modeTree, newEntry.Mode == modeTree
+		if oldIsTree && newIsTree {
+			return diffTrees(oldHash, newHash, prefix+oldEntry.Name+"/")
+		}
+		if oldIsTree == newIsTree {
+			return []fileChange{{
+				Path:    prefix + newEntry.Name,
+				Status:  'M',
+				OldMode: oldEntry.Mode,
+				NewMode: newEntry.Mode,
+				OldHash: oldHash,
+				NewHash: newHash,
+			}}, nil
+		}
+		// a blob replaced by a tree or vice versa is a deletion plus an addition
+		deleted, err := diffTreeEntries(oldEntry, nil, prefix)
+		if err != nil {
+			return nil, err
+		}
+		added, err := diffTreeEntries(nil, newEntry, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if oldIsTree {
+			return append(added, deleted...), nil
+		}
+		return append(deleted, added...), nil
+	}
+
+	if oldEntry != nil {
+		hash := hex.EncodeToString(oldEntry.Hash)
+		if oldEntry.Mode == modeTree {
+			return diffTrees(hash, "", prefix+oldEntry.Name+"/")
+		}
+		return []fileChange{{Path: prefix + oldEntry.Name, Status: 'D', OldMode: oldEntry.Mode, OldHash: hash, NewHash: zeroHash}}, nil
+	}
+	hash := hex.EncodeToString(newEntry.Hash)
+	if newEntry.Mode == modeTree {
+		return diffTrees("", hash, prefix+newEntry.Name+"/")
+	}
+	return []fileChange{{Path: prefix + newEntry.Name, Status: 'A', NewMode: newEntry.Mode, OldHash: zeroHash, NewHash: hash}}, nil
+}
+
+func writeRawDiff(w io.Writer, changes []fileChange) {
+	for _, c := range changes {
+		if c.Status == 'R' {
+			fmt.Fprintf(w, ":%06d %06d %s %s R%03d\t%s\t%s\n", c.OldMode, c.NewMode, abbrevHash(c.OldHash), abbrevHash(c.NewHash), c.Score, quotePath(c.OldPath, false), quotePath(c.Path, false))
+			continue
+		}
+		fmt.Fprintf(w, ":%06d %06d %s %s %c\t%s\n", c.OldMode, c.NewMode, abbrevHash(c.OldHash), abbrevHash(c.NewHash), c.Status, quotePath(c.Path, false))
+	}
+}
+
+// diffBlob is one side of a file change. Blobs over core.bigFileThreshold are
+// not read, only their size is known and they count as binary.
+type diffBlob struct {
+	Content []byte
+	Size    int
+	Big     bool
+}
+
+func (b diffBlob) isBinary() bool {
+	return b.Big || isBinaryContent(b.Content)
+}
+
+func readBlobForDiff(hash string, mode int) (diffBlob, error) {
+	if hash == zeroHash {
+		return diffBlob{}, nil
+	}
+	if mode == modeGitlink {
+		content := []byte(fmt.Sprintf("Subproject commit %s\n", hash))
+		return diffBlob{Content: content, Size: len(content)}, nil
+	}
+	or, err := openObject(hash)
+	if err != nil {
+		return diffBlob{}, err
+	}
+	defer or.Close()
+	if int64(or.Size) > bigFileThreshold() {
+		return diffBlob{Size: or.Size, Big: true}, nil
+	}
+	content, err := io.ReadAll(or)
+	if err != nil {
+		return diffBlob{}, err
+	}
+	return diffBlob{Content: content, Size: len(content)}, nil
+}
+
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) != -1
+}
+
+// patchOptions change how the hunks of a patch are made and shown.
+type patchOptions struct {
+	Flags diffFlags
+	// Words shows changed lines word by word when set
+	Words *wordDiff
+	// Textconv converts files with a textconv diff driver to text first
+	Textconv bool
+}
+
+func writePatch(w io.Writer, changes []fileChange, colors colorPalette, opts patchOptions) error {
+	for _, c := range changes {
+		if err := writeFilePatch(w, c, colors, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFilePatch(w io.Writer, c fileChange, colors colorPalette, opts patchOptions) error {
+	oldName, newName := quotePath("a/"+c.oldPath(), false), quotePath("b/"+c.Path, false)
+	fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("diff --git %s %s", oldName, newName)))
+	switch {
+	case c.Status == 'A':
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("new file mode %06d", c.NewMode)))
+	case c.Status == 'D':
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("deleted file mode %06d", c.OldMode)))
+	case c.OldMode != c.NewMode:
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("old mode %06d", c.OldMode)))
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("new mode %06d", c.NewMode)))
+	}
+	if c.Status == 'R' {
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("similarity index %d%%", c.Score)))
+		fmt.Fprintln(w, colors.paint("meta", "rename from "+quotePath(c.OldPath, false)))
+		fmt.Fprintln(w, colors.paint("meta", "rename to "+quotePath(c.Path, false)))
+	}
+	if c.OldHash == c.NewHash {
+		return nil
+	}
+	if (c.Status == 'M' || c.Status == 'R') && c.OldMode == c.NewMode {
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("index %s..%s %06d", abbrevHash(c.OldHash), abbrevHash(c.NewHash), c.NewMode)))
+	} else {
+		fmt.Fprintln(w, colors.paint("meta", fmt.Sprintf("index %s..%s", abbrevHash(c.OldHash), abbrevHash(c.NewHash))))
+	}
+
+	oldBlob, err := readBlobForDiff(c.OldHash, c.OldMode)
+	if err != nil {
+		return err
+	}
+	newBlob, err := readBlobForDiff(c.NewHash, c.NewMode)
+	if err != nil {
+		return err
+	}
+
+	if c.Status == 'A' {
+		oldName = "/dev/null"
+	}
+	if c.Status == 'D' {
+		newName = "/dev/null"
+	}
+	// converted files are text whatever they hold
+	oldText, newText := false, false
+	if opts.Textconv {
+		if oldBlob, oldText, err = textconvBlob(c.oldPath(), c.OldHash, c.OldMode, oldBlob); err != nil {
+			return err
+		}
+		if newBlob, newText, err = textconvBlob(c.Path, c.NewHash, c.NewMode, newBlob); err != nil {
+			return err
+		}
+	}
+	if (!oldText && oldBlob.isBinary()) || (!newText && newBlob.isBinary()) {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return nil
+	}
+	oldContent, newContent := oldBlob.Content, newBlob.Content
+
+	hunks := diffLines(splitLines(oldContent), splitLines(newContent), diffContext, opts.Flags)
+	if len(hunks) == 0 {
+		return nil
+	}
+	// like GNU diff, a name with a space is ended by a tab so it can be told
+	// apart from a timestamp
+	if strings.IndexByte(c.Path, ' ') != -1 {
+		if c.Status != 'A' {
+			oldName += "\t"
+		}
+		if c.Status != 'D' {
+			newName += "\t"
+		}
+	}
+	fmt.Fprintln(w, colors.paint("meta", "--- "+oldName))
+	fmt.Fprintln(w, colors.paint("meta", "+++ "+newName))
+	if opts.Words != nil {
+		writeWordDiffHunks(w, hunks, splitLines(oldContent), colors, opts.Words)
+		return nil
+	}
+	ws := wsRule(0)
+	if colors != nil {
+		ws = whitespaceRuleFor(c.Path)
+	}
+	writeHunks(w, hunks, splitLines(oldContent), colors, ws)
+	return nil
+}
+
+// countLineChanges returns the number of added and removed lines of a file
+// change; binary files count as none.
+func countLineChanges(c fileChange) (insertions int, deletions int, err error) {
+	oldBlob, err := readBlobForDiff(c.OldHash, c.OldMode)
+	if err != nil {
+		return 0, 0, err
+	}
+	newBlob, err := readBlobForDiff(c.NewHash, c.NewMode)
+	if err != nil {
+		return 0, 0, err
+	}
+	if oldBlob.isBinary() || newBlob.isBinary() {
+		return 0, 0, nil
+	}
+	for _, op := range compactedDiff(splitLines(oldBlob.Content), splitLines(newBlob.Content)) {
+		switch op.Kind {
+		case '+':
+			insertions++
+		case '-':
+			deletions++
+		}
+	}
+	return insertions, deletions, nil
+}
+
+func plural(n int, singular string, pluralForm string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, pluralForm)
+}
+
+// writeDiffStat prints a " path | 3 ++-" line per changed file, scaling the
+// graph to fit 80 columns.
+func writeDiffStat(w io.Writer, changes []fileChange) error {
+	type statLine struct {
+		insertions, deletions int
+		binary                bool
+		oldSize, newSize      int
+	}
+	stats := make([]statLine, 0, len(changes))
+	nameWidth, numWidth, maxChange := 0, 1, 0
+	for _, c := range changes {
+		oldBlob, err := readBlobForDiff(c.OldHash, c.OldMode)
+		if err != nil {
+			return err
+		}
+		newBlob, err := readBlobForDiff(c.NewHash, c.NewMode)
+		if err != nil {
+			return err
+		}
+		line := statLine{oldSize: oldBlob.Size, newSize: newBlob.Size}
+		if oldBlob.isBinary() || newBlob.isBinary() {
+			line.binary = true
+			numWidth = max(numWidth, len("Bin"))
+		} else if line.insertions, line.deletions, err = countLineChanges(c); err != nil {
+			return err
+		}
+		stats = append(stats, line)
+		nameWidth = max(nameWidth, len(statName(c)))
+		maxChange = max(maxChange, line.insertions+line.deletions)
+	}
+	numWidth = max(numWidth, len(fmt.Sprint(maxChange)))
+	graphWidth := max(80-nameWidth-numWidth-5, 6)
+	scale := func(n int) int {
+		if maxChange <= graphWidth || n == 0 {
+			return n
+		}
+		return 1 + n*(graphWidth-1)/maxChange
+	}
+
+	for i, c := range changes {
+		line := stats[i]
+		if line.binary {
+			fmt.Fprintf(w, " %-*s | Bin %d -> %d bytes\n", nameWidth, statName(c), line.oldSize, line.newSize)
+			continue
+		}
+		graph := strings.Repeat("+", scale(line.insertions)) + strings.Repeat("-", scale(line.deletions))
+		if graph != "" {
+			graph = " " + graph
+		}
+		fmt.Fprintf(w, " %-*s | %*d%s\n", nameWidth, statName(c), numWidth, line.insertions+line.deletions, graph)
+	}
+	return nil
+}
+
+// statName is the name a change is listed under by --stat.
+func statName(c fileChange) string {
+	if c.Status == 'R' {
+		return renameDisplayName(quotePath(c.OldPath, false), quotePath(c.Path, false))
+	}
+	return quotePath(c.Path, false)
+}
+
+// writeDiffSummary prints the "N files changed" line followed by the created,
+// deleted and mode changed files, like commit and merge do.
+func writeDiffSummary(w io.Writer, changes []fileChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	insertions, deletions := 0, 0
+	for _, c := range changes {
+		ins, del, err := countLineChanges(c)
+		if err != nil {
+			return err
+		}
+		insertions += ins
+		deletions += del
+	}
+	summary := " " + plural(len(changes), "file changed", "files changed")
+	if insertions > 0 || deletions == 0 {
+		summary += ", " + plural(insertions, "insertion(+)", "insertions(+)")
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += ", " + plural(deletions, "deletion(-)", "deletions(-)")
+	}
+	fmt.Fprintln(w, summary)
+
+	for _, c := range changes {
+		switch {
+		case c.Status == 'A':
+			fmt.Fprintf(w, " create mode %06d %s\n", c.NewMode, quotePath(c.Path, false))
+		case c.Status == 'D':
+			fmt.Fprintf(w, " delete mode %06d %s\n", c.OldMode, quotePath(c.Path, false))
+		case c.Status == 'R':
+			fmt.Fprintf(w, " rename %s (%d%%)\n", renameDisplayName(quotePath(c.OldPath, false), quotePath(c.Path, false)), c.Score)
+			if c.OldMode != c.NewMode {
+				fmt.Fprintf(w, " mode change %06d => %06d\n", c.OldMode, c.NewMode)
+			}
+		case c.OldMode != c.NewMode:
+			fmt.Fprintf(w, " mode change %06d => %06d %s\n", c.OldMode, c.NewMode, quotePath(c.Path, false))
+		}
+	}
+	return nil
+}
+
+// splitLines splits content keeping the line terminators, so a missing
+// newline at the end of file stays visible.
+func splitLines(content []byte) []string {
+	lines := make([]string, 0, bytes.Count(content, []byte("\n"))+1)
+	for len(content) > 0 {
+		idx := bytes.IndexByte(content, '\n')
+		if idx == -1 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:idx+1]))
+		content = content[idx+1:]
+	}
+	return lines
+}
+
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+}
+
+type diffHunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	Ops                []diffOp
+}
+
+// myersDiff computes a shortest edit script between a and b.
+func myersDiff(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+2)
+	trace := make([][]int, 0, 16)
+
+	var d int
+found:
+	for d = 0; d <= maxD; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break found
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[offset+k-1] < prev[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{' ', a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// changeGroup is a run of changed lines [Start, End) on one side of a diff.
+type changeGroup struct {
+	Start, End int
+}
+
+// changeFlags marks changed lines, with a false sentinel on both ends so
+// flags[i+1] describes line i.
+type changeFlags []bool
+
+func (f changeFlags) changed(i int) bool { return f[i+1] }
+func (f changeFlags) set(i int, v bool)  { f[i+1] = v }
+
+func (f changeFlags) firstGroup() changeGroup {
+	g := changeGroup{}
+	for f.changed(g.End) {
+		g.End++
+	}
+	return g
+}
+
+func (f changeFlags) nextGroup(g *changeGroup) bool {
+	if g.End == len(f)-2 {
+		return false
+	}
+	g.Start = g.End + 1
+	g.End = g.Start
+	for f.changed(g.End) {
+		g.End++
+	}
+	return true
+}
+
+func (f changeFlags) previousGroup(g *changeGroup) bool {
+	if g.Start == 0 {
+		return false
+	}
+	g.End = g.Start - 1
+	for g.Start = g.End; f.changed(g.Start - 1); g.Start-- {
+	}
+	return true
+}
+
+func (f changeFlags) slideDown(lines []string, g *changeGroup) bool {
+	if g.End < len(lines) && lines[g.Start] == lines[g.End] {
+		f.set(g.Start, false)
+		f.set(g.End, true)
+		g.Start++
+		g.End++
+		for f.changed(g.End) {
+			g.End++
+		}
+		return true
+	}
+	return false
+}
+
+func (f changeFlags) slideUp(lines []string, g *changeGroup) bool {
+	if g.Start > 0 && lines[g.Start-1] == lines[g.End-1] {
+		g.Start--
+		g.End--
+		f.set(g.Start, true)
+		f.set(g.End, false)
+		for f.changed(g.Start - 1) {
+			g.Start--
+		}
+		return true
+	}
+	return false
+}
+
+// compactChanges slides ambiguous groups of changes as far down as they can
+// go, merging adjacent groups and lining them up with changes on the other
+// side, the same way xdiff does before emitting hunks.
+func compactChanges(lines []string, flags changeFlags, otherFlags changeFlags) {
+	g := flags.firstGroup()
+	og := otherFlags.firstGroup()
+	for {
+		if g.End != g.Start {
+			var earliestEnd, endMatchingOther, groupSize int
+			for {
+				groupSize = g.End - g.Start
+				endMatchingOther = -1
+				for flags.slideUp(lines, &g) {
+					otherFlags.previousGroup(&og)
+				}
+				earliestEnd = g.End
+				if og.End > og.Start {
+					endMatchingOther = g.End
+				}
+				for flags.slideDown(lines, &g) {
+					otherFlags.nextGroup(&og)
+					if og.End > og.Start {
+						endMatchingOther = g.End
+					}
+				}
+				if groupSize == g.End-g.Start {
+					break
+				}
+			}
+			if g.End != earliestEnd && endMatchingOther != -1 {
+				for og.End == og.Start {
+					flags.slideUp(lines, &g)
+					otherFlags.previousGroup(&og)
+				}
+			}
+		}
+		if !flags.nextGroup(&g) {
+			break
+		}
+		otherFlags.nextGroup(&og)
+	}
+}
+
+func compactedDiff(a []string, b []string) []diffOp {
+	flagsA := make(changeFlags, len(a)+2)
+	flagsB := make(changeFlags, len(b)+2)
+	x, y := 0, 0
+	for _, op := range myersDiff(a, b) {
+		switch op.Kind {
+		case '-':
+			flagsA.set(x, true)
+			x++
+		case '+':
+			flagsB.set(y, true)
+			y++
+		default:
+			x++
+			y++
+		}
+	}
+	compactChanges(a, flagsA, flagsB)
+	compactChanges(b, flagsB, flagsA)
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	x, y = 0, 0
+	for x < len(a) || y < len(b) {
+		switch {
+		case x < len(a) && flagsA.changed(x):
+			ops = append(ops, diffOp{'-', a[x]})
+			x++
+		case y < len(b) && flagsB.changed(y):
+			ops = append(ops, diffOp{'+', b[y]})
+			y++
+		default:
+			ops = append(ops, diffOp{' ', a[x]})
+			x++
+			y++
+		}
+	}
+	return ops
+}
+
+// diffFlags loosen how lines are compared, as -w, -b and
+// --ignore-blank-lines do.
+type diffFlags int
+
+const (
+	// diffIgnoreAllSpace ignores whitespace altogether
+	diffIgnoreAllSpace diffFlags = 1 << iota
+	// diffIgnoreSpaceChange ignores whitespace at the end of lines and
+	// treats all other runs of whitespace as equal
+	diffIgnoreSpaceChange
+	// diffIgnoreBlankLines leaves out the hunks only adding or removing
+	// blank lines
+	diffIgnoreBlankLines
+
+	diffWhitespaceFlags = diffIgnoreAllSpace | diffIgnoreSpaceChange
+)
+
+func isDiffSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// normalizeLine reduces a line to what is compared under the whitespace
+// flags.
+func normalizeLine(line string, flags diffFlags) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if !isDiffSpace(line[i]) {
+			b.WriteByte(line[i])
+			continue
+		}
+		j := i
+		for j < len(line) && isDiffSpace(line[j]) {
+			j++
+		}
+		if flags&diffIgnoreAllSpace == 0 && j < len(line) {
+			b.WriteByte(' ')
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+// isBlankDiffLine is true for an empty line, or with the whitespace flags
+// for one with nothing but whitespace.
+func isBlankDiffLine(line string, flags diffFlags) bool {
+	if flags&diffWhitespaceFlags == 0 {
+		return line == "\n"
+	}
+	return strings.TrimLeft(line, " \t\n\v\f\r") == ""
+}
+
+// looseDiff is compactedDiff with lines compared under flags. The lines
+// shown are the originals, the new ones for unchanged lines.
+func looseDiff(a []string, b []string, flags diffFlags) []diffOp {
+	if flags&diffWhitespaceFlags == 0 {
+		return compactedDiff(a, b)
+	}
+	keysA, keysB := make([]string, len(a)), make([]string, len(b))
+	for i, line := range a {
+		keysA[i] = normalizeLine(line, flags)
+	}
+	for i, line := range b {
+		keysB[i] = normalizeLine(line, flags)
+	}
+	ops := compactedDiff(keysA, keysB)
+	x, y := 0, 0
+	for i := range ops {
+		switch ops[i].Kind {
+		case '-':
+			ops[i].Line = a[x]
+			x++
+		case '+':
+			ops[i].Line = b[y]
+			y++
+		default:
+			ops[i].Line = b[y]
+			x++
+			y++
+		}
+	}
+	return ops
+}
+
+// diffChange is a run of changed lines, ops[Start:End], which removes the
+// lines of a from Old up to OldEnd and adds Added lines of b from New.
+type diffChange struct {
+	Start, End  int
+	Old, OldEnd int
+	New, Added  int
+	// Ignore is set for a change of blank lines only under
+	// --ignore-blank-lines
+	Ignore bool
+}
+
+func diffChanges(ops []diffOp, flags diffFlags) []diffChange {
+	changes := make([]diffChange, 0)
+	old, new := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i++
+			old++
+			new++
+			continue
+		}
+		c := diffChange{Start: i, Old: old, New: new, Ignore: flags&diffIgnoreBlankLines != 0}
+		for ; i < len(ops) && ops[i].Kind != ' '; i++ {
+			if ops[i].Kind == '-' {
+				old++
+			} else {
+				c.Added++
+				new++
+			}
+			c.Ignore = c.Ignore && isBlankDiffLine(ops[i].Line, flags)
+		}
+		c.End, c.OldEnd = i, old
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// nextHunk returns the changes, from the first, that go into the next hunk,
+// the way xdiff's xdl_get_hunk groups them: changes closer than twice the
+// context share a hunk, and ignored changes only join one when they are
+// within the context of a change that is shown.
+func nextHunk(changes []diffChange, context int) []diffChange {
+	maxCommon, maxIgnorable := 2*context, context
+	// leading ignored changes are dropped up to the last one far enough
+	// from the change after it
+	first := 0
+	for i := 0; i < len(changes) && changes[i].Ignore; i++ {
+		if i+1 == len(changes) || changes[i+1].Old-changes[i].OldEnd >= maxIgnorable {
+			first = i + 1
+		}
+	}
+	changes = changes[first:]
+	if len(changes) == 0 {
+		return nil
+	}
+	last, ignored := 0, 0
+	for i := 1; i < len(changes); i++ {
+		c, prev := changes[i], changes[i-1]
+		distance := c.Old - prev.OldEnd
+		switch {
+		case distance > maxCommon:
+			return changes[:last+1]
+		case distance < maxIgnorable && (!c.Ignore || last == i-1):
+			last, ignored = i, 0
+		case distance < maxIgnorable:
+			ignored += c.Added
+		case last != i-1 && c.Old+ignored-changes[last].OldEnd > maxCommon:
+			return changes[:last+1]
+		case !c.Ignore:
+			last, ignored = i, 0
+		default:
+			ignored += c.Added
+		}
+	}
+	return changes[:last+1]
+}
+
+// diffLines groups the edit script into hunks with the given amount of
+// context, comparing lines under flags.
+func diffLines(a []string, b []string, context int, flags diffFlags) []diffHunk {
+	return groupHunks(looseDiff(a, b, flags), context, flags)
+}
+
+// groupHunks groups an edit script into hunks, whose Ops are slices of ops.
+func groupHunks(ops []diffOp, context int, flags diffFlags) []diffHunk {
+	hunks := make([]diffHunk, 0)
+
+	// oldLine and newLine are the line numbers ops start at
+	oldLine, newLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.Kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.Kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	changes := diffChanges(ops, flags)
+	for len(changes) > 0 {
+		group := nextHunk(changes, context)
+		if group == nil {
+			break
+		}
+		first, last := group[0], group[len(group)-1]
+		start, end := first.Start, last.End
+		for start > 0 && first.Start-start < context && ops[start-1].Kind == ' ' {
+			start--
+		}
+		for end < len(ops) && end-last.End < context && ops[end].Kind == ' ' {
+			end++
+		}
+		hunk := diffHunk{
+			OldStart: oldLine[start],
+			OldCount: oldLine[end] - oldLine[start],
+			NewStart: newLine[start],
+			NewCount: newLine[end] - newLine[start],
+			Ops:      ops[start:end],
+		}
+		hunks = append(hunks, hunk)
+		// group is a prefix of what is left once ignored changes before it
+		// are dropped
+		for changes[0].Start != last.Start {
+			changes = changes[1:]
+		}
+		changes = changes[1:]
+	}
+	return hunks
+}
+
+// dropIgnoredChanges leaves out the modified files whose patch would be
+// empty once lines are compared under flags.
+func dropIgnoredChanges(changes []fileChange, flags diffFlags) ([]fileChange, error) {
+	kept := make([]fileChange, 0, len(changes))
+	for _, c := range changes {
+		if c.Status != 'M' || c.OldMode != c.NewMode {
+			kept = append(kept, c)
+			continue
+		}
+		oldBlob, err := readBlobForDiff(c.OldHash, c.OldMode)
+		if err != nil {
+			return nil, err
+		}
+		newBlob, err := readBlobForDiff(c.NewHash, c.NewMode)
+		if err != nil {
+			return nil, err
+		}
+		if oldBlob.isBinary() || newBlob.isBinary() || len(diffLines(splitLines(oldBlob.Content), splitLines(newBlob.Content), diffContext, flags)) > 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept, nil
+}
+
+func formatHunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// hunkFuncName finds the closest line above the hunk that looks like the
+// start of a function, using git's default rule.
+func hunkFuncName(oldLines []string, hunkStart int) string {
+	for i := hunkStart - 1; i >= 0; i-- {
+		line := oldLines[i]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_' || c == '$' {
+			line = strings.TrimRight(line, " \t\r\n")
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+func writeHunkHeader(w io.Writer, h diffHunk, oldLines []string, colors colorPalette) {
+	header := colors.paint("frag", fmt.Sprintf("@@ -%s +%s @@", formatHunkRange(h.OldStart, h.OldCount), formatHunkRange(h.NewStart, h.NewCount)))
+	if funcName := hunkFuncName(oldLines, h.OldStart); funcName != "" {
+		header += colors.paint("context", " ") + colors.paint("func", funcName)
+	}
+	fmt.Fprintln(w, header)
+}
+
+// writeHunks writes the hunks of a patch, highlighting the whitespace
+// errors ws checks for in the added lines when colored.
+func writeHunks(w io.Writer, hunks []diffHunk, oldLines []string, colors colorPalette, ws wsRule) {
+	for _, h := range hunks {
+		writeHunkHeader(w, h, oldLines, colors)
+		for _, op := range h.Ops {
+			line := strings.TrimSuffix(op.Line, "\n")
+			switch op.Kind {
+			case '+':
+				// like git, the marker is painted apart from the line so
+				// whitespace errors can be highlighted in between
+				_, painted := checkWhitespace(op.Line, ws, colors)
+				fmt.Fprintln(w, colors.paint("new", "+")+painted)
+			case '-':
+				fmt.Fprintln(w, colors.paint("old", "-"+line))
+			default:
+				fmt.Fprintln(w, colors.paint("context", string(op.Kind)+line))
+			}
+			if !strings.HasSuffix(op.Line, "\n") {
+				fmt.Fprintln(w, colors.paint("context", "\\ No newline at end of file"))
+			}
+		}
+	}
+}
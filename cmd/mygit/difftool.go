@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const difftoolUsage = "mygit difftool [-y | --[no-]prompt] [-t <tool> | -x <command>] [--[no-]trust-exit-code] [--cached] [<commit> [<commit>]] [--] [<path>...]"
+
+// builtinTool is how a well-known tool is run when no <tool>.cmd is
+// configured for it.
+type builtinTool struct {
+	Diff          string
+	Merge         string
+	TrustExitCode bool
+}
+
+var builtinTools = map[string]builtinTool{
+	"vimdiff": {
+		Diff:  `vim -R -f -d "$LOCAL" "$REMOTE"`,
+		Merge: `vim -f -d -c '4wincmd w | wincmd J' "$LOCAL" "$BASE" "$REMOTE" "$MERGED"`,
+	},
+	"nvimdiff": {
+		Diff:  `nvim -R -f -d "$LOCAL" "$REMOTE"`,
+		Merge: `nvim -f -d -c '4wincmd w | wincmd J' "$LOCAL" "$BASE" "$REMOTE" "$MERGED"`,
+	},
+	"meld": {
+		Diff:  `meld "$LOCAL" "$REMOTE"`,
+		Merge: `meld "$LOCAL" "$MERGED" "$REMOTE" --output "$MERGED"`,
+	},
+	"kdiff3": {
+		Diff:          `kdiff3 --L1 "$MERGED (A)" --L2 "$MERGED (B)" "$LOCAL" "$REMOTE"`,
+		Merge:         `kdiff3 --auto --L1 "$MERGED (Base)" --L2 "$MERGED (Local)" --L3 "$MERGED (Remote)" -o "$MERGED" "$BASE" "$LOCAL" "$REMOTE"`,
+		TrustExitCode: true,
+	},
+	"opendiff": {
+		Diff:  `opendiff "$LOCAL" "$REMOTE"`,
+		Merge: `opendiff "$LOCAL" "$REMOTE" -ancestor "$BASE" -merge "$MERGED"`,
+	},
+}
+
+// toolCommand is the shell command running a diff or merge tool: the
+// difftool.<tool>.cmd (for diffs) or mergetool.<tool>.cmd setting, else the
+// builtin tool of that name. trust tells whether a builtin's exit code
+// reports success.
+func toolCommand(config *Config, tool string, merge bool) (command string, trust bool, err error) {
+	if !merge {
+		if command, ok := config.Get("difftool." + tool + ".cmd"); ok {
+			return command, false, nil
+		}
+	}
+	if command, ok := config.Get("mergetool." + tool + ".cmd"); ok {
+		return command, false, nil
+	}
+	builtin, ok := builtinTools[tool]
+	if !ok {
+		return "", false, fmt.Errorf("unknown tool '%s', set mergetool.%s.cmd", tool, tool)
+	}
+	if merge {
+		return builtin.Merge, builtin.TrustExitCode, nil
+	}
+	return builtin.Diff, builtin.TrustExitCode, nil
+}
+
+// runTool runs a tool command through the shell on the terminal, with the
+// file versions in $LOCAL, $REMOTE, $BASE and $MERGED, and returns its exit
+// code.
+func runTool(command string, env []string) (int, error) {
+	cmd := exec.CommandContext(commandContext, "sh", "-c", command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		if err := checkInterrupted(); err != nil {
+			return 0, err
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, fmt.Errorf("failed to run '%s': %s", command, err.Error())
+	}
+	return 0, nil
+}
+
+// promptLine asks a question on stdout and reads the answer from stdin a
+// byte at a time, so nothing meant for the tool is read ahead. ok is false
+// at the end of the input.
+func promptLine(question string) (answer string, ok bool) {
+	fmt.Print(question)
+	line := make([]byte, 0, 8)
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 && b[0] == '\n' {
+			return strings.TrimRight(string(line), "\r"), true
+		}
+		if n == 1 {
+			line = append(line, b[0])
+			continue
+		}
+		if err != nil {
+			return string(line), len(line) > 0
+		}
+	}
+}
+
+// writeToolFile writes a version of path for a tool to look at into dir,
+// named after the file so tools can tell its type. The blob goes through the
+// smudge filter of path, and a missing version is /dev/null.
+func writeToolFile(dir string, path string, mode int, hash string) (string, error) {
+	if hash == zeroHash {
+		return os.DevNull, nil
+	}
+	pattern := "*_" + filepath.Base(path)
+	switch mode {
+	case modeGitlink:
+		return writeTempFile(dir, pattern, []byte(fmt.Sprintf("Subproject commit %s\n", hash)))
+	case modeSymlink:
+		return unpackBlob(dir, pattern, hash, "")
+	}
+	return unpackBlob(dir, pattern, hash, path)
+}
+
+// difftoolChanges lists the files that differ the way diff would compare
+// them: the index with the worktree, a commit with the index when cached, a
+// commit with the worktree, or two commits. A new side that is the worktree
+// file has NewHash "", so the tool edits the file itself.
+func difftoolChanges(revisions []string, cached bool) ([]fileChange, error) {
+	if len(revisions) == 1 {
+		if left, right, ok := parseSymmetricRange(revisions[0]); ok {
+			leftHash, err := resolveCommitRevision(left)
+			if err != nil {
+				return nil, err
+			}
+			rightHash, err := resolveCommitRevision(right)
+			if err != nil {
+				return nil, err
+			}
+			bases, err := mergeBases(leftHash, rightHash)
+			if err != nil {
+				return nil, err
+			}
+			if len(bases) == 0 {
+				return nil, fmt.Errorf("%s: no merge base", revisions[0])
+			}
+			revisions = []string{bases[0], rightHash}
+		} else if from, to, ok := strings.Cut(revisions[0], ".."); ok {
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			revisions = []string{from, to}
+		}
+	}
+	if len(revisions) > 2 || len(revisions) == 2 && cached {
+		return nil, fmt.Errorf("usage: %s", difftoolUsage)
+	}
+	if len(revisions) == 2 {
+		oldTree, err := resolveTreeish(revisions[0])
+		if err != nil {
+			return nil, err
+		}
+		newTree, err := resolveTreeish(revisions[1])
+		if err != nil {
+			return nil, err
+		}
+		return diffTrees(oldTree, newTree, "")
+	}
+
+	index, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer writeRefreshedIndex(index)
+	if len(revisions) == 0 && !cached {
+		changes := make([]fileChange, 0)
+		for i := range index.Entries {
+			e := &index.Entries[i]
+			if e.Stage() != 0 {
+				continue
+			}
+			letter, _, err := worktreeStatus(index, e)
+			if err != nil {
+				return nil, err
+			}
+			switch letter {
+			case ' ':
+			case 'D':
+				changes = append(changes, fileChange{Path: e.Path, Status: 'D', OldMode: e.Mode, OldHash: e.Hash, NewHash: zeroHash})
+			default:
+				c := fileChange{Path: e.Path, Status: 'M', OldMode: e.Mode, OldHash: e.Hash, NewMode: e.Mode}
+				if c.NewHash, err = worktreeHash(e, e.Mode); err != nil {
+					return nil, err
+				}
+				changes = append(changes, c)
+			}
+		}
+		return changes, nil
+	}
+
+	tree := ""
+	if len(revisions) == 1 {
+		if tree, err = resolveTreeish(revisions[0]); err != nil {
+			return nil, err
+		}
+	} else if _, unborn := unbornBranch(); !unborn {
+		if tree, err = resolveTreeish("HEAD"); err != nil {
+			return nil, err
+		}
+	}
+	files, err := flattenTree(tree, "")
+	if err != nil {
+		return nil, err
+	}
+	old := make(map[string]treeFile, len(files))
+	for _, f := range files {
+		old[f.Path] = f
+	}
+	changes := make([]fileChange, 0)
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		f, inTree := old[e.Path]
+		delete(old, e.Path)
+		if e.Stage() != 0 {
+			continue
+		}
+		c := fileChange{Path: e.Path, Status: 'M', OldMode: f.Mode, OldHash: f.Hash, NewMode: e.Mode, NewHash: e.Hash}
+		if !inTree {
+			c.Status, c.OldHash = 'A', zeroHash
+		}
+		if !cached {
+			letter, mode, err := worktreeStatus(index, e)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case letter == 'D':
+				c.NewMode, c.NewHash = 0, zeroHash
+			case letter != ' ' || c.OldHash != e.Hash || c.OldMode != e.Mode:
+				c.NewMode = mode
+				if c.NewHash, err = worktreeHash(e, mode); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if c.OldHash == c.NewHash && c.OldMode == c.NewMode {
+			continue
+		}
+		if c.NewHash == zeroHash {
+			if !inTree {
+				continue
+			}
+			c.Status = 'D'
+		}
+		changes = append(changes, c)
+	}
+	for _, f := range old {
+		changes = append(changes, fileChange{Path: f.Path, Status: 'D', OldMode: f.Mode, OldHash: f.Hash, NewHash: zeroHash})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// worktreeHash is the worktree side of a change to an index entry: "" for
+// a file, which the tool reads from the worktree, or the commit checked out
+// in a submodule.
+func worktreeHash(e *IndexEntry, mode int) (string, error) {
+	if mode != modeGitlink {
+		return "", nil
+	}
+	head, _, err := submoduleHead(e.Path)
+	if head == "" && e.Mode == modeGitlink {
+		head = e.Hash
+	}
+	return head, err
+}
+
+// runDifftool shows each changed file in an external diff tool, one after
+// the other, asking first unless told not to.
+func runDifftool(args []string) error {
+	tool, extcmd := "", ""
+	noPrompt, prompt, cached, trust, noTrust := false, false, false, false, false
+	var paths []string
+	if i := slices.Index(args, "--"); i != -1 {
+		args, paths = args[:i], args[i+1:]
+	}
+	flags := newFlagSet()
+	flags.String(&tool, "-t", "--tool")
+	flags.String(&extcmd, "-x", "--extcmd")
+	flags.Bool(&noPrompt, "-y", "--no-prompt")
+	flags.Bool(&prompt, "--prompt")
+	flags.Bool(&cached, "--cached", "--staged")
+	flags.Bool(&trust, "--trust-exit-code")
+	flags.Bool(&noTrust, "--no-trust-exit-code")
+	revisions, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+
+	command := extcmd + ` "$LOCAL" "$REMOTE"`
+	if extcmd == "" {
+		if tool == "" {
+			tool, _ = config.Get("diff.tool")
+		}
+		if tool == "" {
+			tool, _ = config.Get("merge.tool")
+		}
+		if tool == "" {
+			return fmt.Errorf("no diff tool configured, set diff.tool or use --tool")
+		}
+		if command, _, err = toolCommand(config, tool, false); err != nil {
+			return err
+		}
+	} else {
+		tool = extcmd
+	}
+	if prompt || noPrompt {
+		prompt = !noPrompt
+	} else if prompt, err = config.GetBool("difftool.prompt", true); err != nil {
+		return err
+	}
+	if !trust && !noTrust {
+		if trust, err = config.GetBool("difftool.trustExitCode", false); err != nil {
+			return err
+		}
+	}
+	ps, err := parsePathspecs(paths)
+	if err != nil {
+		return err
+	}
+
+	all, err := difftoolChanges(revisions, cached)
+	if err != nil {
+		return err
+	}
+	changes := make([]fileChange, 0, len(all))
+	for _, c := range all {
+		if ps.matches(c.Path) {
+			changes = append(changes, c)
+		}
+	}
+	dir, err := os.MkdirTemp("", "mygit-difftool.")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for n, c := range changes {
+		if prompt {
+			fmt.Printf("\nViewing (%d/%d): '%s'\n", n+1, len(changes), c.Path)
+			answer, ok := promptLine(fmt.Sprintf("Launch '%s' [Y/n]? ", tool))
+			if !ok {
+				fmt.Println()
+				return nil
+			}
+			if answer == "n" {
+				continue
+			}
+		}
+		local, err := writeToolFile(dir, c.Path, c.OldMode, c.OldHash)
+		if err != nil {
+			return err
+		}
+		remote := c.Path
+		if c.NewHash != "" {
+			if remote, err = writeToolFile(dir, c.Path, c.NewMode, c.NewHash); err != nil {
+				return err
+			}
+		}
+		env := []string{"LOCAL=" + local, "REMOTE=" + remote, "MERGED=" + c.Path, "BASE=" + c.Path}
+		code, err := runTool(command, env)
+		if err != nil {
+			return err
+		}
+		if code != 0 && trust {
+			fmt.Fprintf(os.Stderr, "external diff died, stopping at %s\n", c.Path)
+			return exitStatus(code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// editorCommand is the editor to run, from $GIT_EDITOR, core.editor,
+// $VISUAL and $EDITOR, with vi as the last resort.
+func editorCommand(config *Config) string {
+	return firstSetting(config, "GIT_EDITOR", "core.editor", "VISUAL", "EDITOR", "vi")
+}
+
+// launchEditor lets the user edit path with their editor, run by the shell
+// so core.editor may carry arguments. The ":" editor leaves the file as is.
+func launchEditor(path string) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	editor := editorCommand(config)
+	if editor == ":" {
+		return nil
+	}
+	if editor == "vi" && os.Getenv("TERM") == "dumb" && os.Getenv("VISUAL") == "" && os.Getenv("EDITOR") == "" {
+		return fmt.Errorf("Terminal is dumb, but EDITOR unset")
+	}
+	cmd := exec.CommandContext(commandContext, "sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		return fmt.Errorf("There was a problem with the editor '%s'.", editor)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// mygit has no iconv, so it converts between UTF-8 and the single byte
+// encodings most often found in old histories. Text in any other encoding
+// is shown as it is, which is also what git does when iconv fails.
+
+// cp1252Extras are the characters windows-1252 puts in 0x80-0x9f, where
+// ISO-8859-1 has control characters. Zero marks the unused positions.
+var cp1252Extras = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+// latin9Changes are the positions where ISO-8859-15 differs from
+// ISO-8859-1.
+var latin9Changes = map[byte]rune{
+	0xa4: 0x20ac, 0xa6: 0x0160, 0xa8: 0x0161, 0xb4: 0x017d,
+	0xb8: 0x017e, 0xbc: 0x0152, 0xbd: 0x0153, 0xbe: 0x0178,
+}
+
+// normalizeEncoding folds the spellings of an encoding name, so "UTF-8" and
+// "utf8" or "ISO-8859-1" and "latin1" compare equal. "" is UTF-8.
+func normalizeEncoding(name string) string {
+	name = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	switch name {
+	case "", "utf8":
+		return "utf8"
+	case "latin1", "l1", "iso88591":
+		return "iso88591"
+	case "latin9", "l9", "iso885915":
+		return "iso885915"
+	case "windows1252", "cp1252":
+		return "cp1252"
+	case "ascii", "usascii":
+		return "ascii"
+	}
+	return name
+}
+
+// commitEncoding is i18n.commitEncoding, the encoding recorded in new
+// commits; "" means UTF-8, which is never recorded.
+func commitEncoding() string {
+	config, err := getConfig()
+	if err != nil {
+		return ""
+	}
+	if value, ok := config.Get("i18n.commitEncoding"); ok && normalizeEncoding(value) != "utf8" {
+		return value
+	}
+	return ""
+}
+
+// logOutputEncoding is the encoding log prints messages in:
+// i18n.logOutputEncoding, falling back to i18n.commitEncoding and UTF-8.
+func logOutputEncoding() string {
+	config, err := getConfig()
+	if err != nil {
+		return "UTF-8"
+	}
+	if value, ok := config.Get("i18n.logOutputEncoding"); ok {
+		return value
+	}
+	if value, ok := config.Get("i18n.commitEncoding"); ok {
+		return value
+	}
+	return "UTF-8"
+}
+
+func decodeText(text string, encoding string) ([]rune, bool) {
+	if encoding == "utf8" {
+		if !utf8.ValidString(text) {
+			return nil, false
+		}
+		return []rune(text), true
+	}
+	runes := make([]rune, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c < 0x80:
+			runes = append(runes, rune(c))
+		case encoding == "iso88591":
+			runes = append(runes, rune(c))
+		case encoding == "iso885915":
+			if r, ok := latin9Changes[c]; ok {
+				runes = append(runes, r)
+			} else {
+				runes = append(runes, rune(c))
+			}
+		case encoding == "cp1252" && c < 0xa0:
+			if cp1252Extras[c-0x80] == 0 {
+				return nil, false
+			}
+			runes = append(runes, cp1252Extras[c-0x80])
+		case encoding == "cp1252":
+			runes = append(runes, rune(c))
+		default:
+			return nil, false
+		}
+	}
+	return runes, true
+}
+
+func encodeRune(r rune, encoding string) (byte, bool) {
+	if r < 0x80 {
+		return byte(r), true
+	}
+	switch encoding {
+	case "iso88591":
+		return byte(r), r <= 0xff
+	case "iso885915":
+		for c, changed := range latin9Changes {
+			if changed == r {
+				return c, true
+			}
+		}
+		if _, replaced := latin9Changes[byte(r)]; replaced {
+			return 0, false
+		}
+		return byte(r), r <= 0xff
+	case "cp1252":
+		for i, extra := range cp1252Extras {
+			if extra == r && extra != 0 {
+				return byte(0x80 + i), true
+			}
+		}
+		return byte(r), r >= 0xa0 && r <= 0xff
+	}
+	return 0, false
+}
+
+// reencode converts text from one encoding to another. Text that cannot be
+// converted, or is in an encoding mygit does not know, is returned as it is.
+func reencode(text string, from string, to string) string {
+	from, to = normalizeEncoding(from), normalizeEncoding(to)
+	if from == to {
+		return text
+	}
+	runes, ok := decodeText(text, from)
+	if !ok {
+		return text
+	}
+	if to == "utf8" {
+		return string(runes)
+	}
+	out := make([]byte, 0, len(runes))
+	for _, r := range runes {
+		c, ok := encodeRune(r, to)
+		if !ok {
+			return text
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// reencodeCommit returns a copy of commit with the message and identities
+// converted from its encoding header to the encoding log output uses.
+func reencodeCommit(commit *Commit, to string) *Commit {
+	from, _ := commit.Header("encoding")
+	if normalizeEncoding(from) == normalizeEncoding(to) {
+		return commit
+	}
+	converted := *commit
+	converted.Message = reencode(commit.Message, from, to)
+	converted.Author.Name = reencode(commit.Author.Name, from, to)
+	converted.Committer.Name = reencode(commit.Committer.Name, from, to)
+	return &converted
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// porcelainErrors is set by the global --porcelain-errors option. Errors
+// are then reported with a stable code ahead of their text, so wrappers can
+// tell failures apart without matching prose that may change.
+var porcelainErrors bool
+
+// errorCodes is the catalog of the codes --porcelain-errors reports. Codes
+// are never renamed or reused; errors without one are reported as "failed".
+var errorCodes = map[string]string{
+	"failed":           "the command failed for a reason without its own code",
+	"interrupted":      "the command was interrupted by a signal",
+	"missing-object":   "an object the command needed is not in the repository",
+	"unknown-revision": "a revision could not be resolved to an object",
+	"stale-ref":        "a ref was not at the value the update expected",
+	"dirty-worktree":   "local changes to tracked files would be overwritten",
+	"untracked-files":  "untracked files would be overwritten",
+	"repository":       "the repository cannot be used: not found, or of an unsupported format",
+}
+
+// codedError is an error carrying one of the errorCodes.
+type codedError struct {
+	Code string
+	Err  error
+}
+
+func (e *codedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.Err
+}
+
+// errorWithCode formats an error tagged with code.
+func errorWithCode(code string, format string, args ...any) error {
+	return &codedError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// errorCode returns the code of err, "failed" when it has none.
+func errorCode(err error) string {
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.Is(err, errInterrupted):
+		return "interrupted"
+	}
+	return "failed"
+}
+
+// reportError writes an error to stderr after prefix, with the refs close
+// to a revision that was not found, or as "error <code> <quoted text>"
+// with --porcelain-errors.
+func reportError(prefix string, err error) {
+	if porcelainErrors {
+		fmt.Fprintf(os.Stderr, "error %s %s\n", errorCode(err), strconv.Quote(err.Error()))
+		return
+	}
+	hint := ""
+	var unknown *unknownRevisionError
+	if errors.As(err, &unknown) {
+		hint = refSuggestion(unknown.Name)
+	}
+	fmt.Fprintf(os.Stderr, "%s%s%s\n", prefix, err.Error(), hint)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// filesystemOptions holds what the worktree filesystem can represent,
+// loaded on first use: core.fileMode (the executable bit), core.symlinks,
+// core.ignoreCase and core.precomposeUnicode.
+var filesystemOptions *struct {
+	fileMode          bool
+	symlinks          bool
+	ignoreCase        bool
+	precomposeUnicode bool
+}
+
+func loadFilesystemOptions() {
+	filesystemOptions = &struct {
+		fileMode          bool
+		symlinks          bool
+		ignoreCase        bool
+		precomposeUnicode bool
+	}{fileMode: true, symlinks: true}
+	config, err := getConfig()
+	if err != nil {
+		return
+	}
+	if value, err := config.GetBool("core.fileMode", true); err == nil {
+		filesystemOptions.fileMode = value
+	}
+	if value, err := config.GetBool("core.symlinks", true); err == nil {
+		filesystemOptions.symlinks = value
+	}
+	if value, err := config.GetBool("core.ignoreCase", false); err == nil {
+		filesystemOptions.ignoreCase = value
+	}
+	if value, err := config.GetBool("core.precomposeUnicode", false); err == nil {
+		filesystemOptions.precomposeUnicode = value
+	}
+}
+
+func trustExecutableBit() bool {
+	if filesystemOptions == nil {
+		loadFilesystemOptions()
+	}
+	return filesystemOptions.fileMode
+}
+
+func hasSymlinks() bool {
+	if filesystemOptions == nil {
+		loadFilesystemOptions()
+	}
+	return filesystemOptions.symlinks
+}
+
+func ignoreCase() bool {
+	if filesystemOptions == nil {
+		loadFilesystemOptions()
+	}
+	return filesystemOptions.ignoreCase
+}
+
+// worktreeName is the name a path read from the worktree is tracked under.
+// With core.precomposeUnicode the decomposed names some filesystems return
+// are composed, so they compare equal to the names in the index and trees.
+func worktreeName(path string) string {
+	if filesystemOptions == nil {
+		loadFilesystemOptions()
+	}
+	if filesystemOptions.precomposeUnicode {
+		return precompose(path)
+	}
+	return path
+}
+
+// worktreeEntryMode is the mode a worktree file has for an index entry of
+// indexMode, like git's ce_mode_from_stat: regular files keep the recorded
+// executable bit when core.fileMode is false, and stand for symlinks when
+// core.symlinks is false.
+func worktreeEntryMode(info os.FileInfo, indexMode int) int {
+	worktreeMode := worktreeFileMode(info)
+	if !info.Mode().IsRegular() {
+		return worktreeMode
+	}
+	if indexMode == modeSymlink && !hasSymlinks() {
+		return modeSymlink
+	}
+	if (indexMode == 100644 || indexMode == 100755) && !trustExecutableBit() {
+		return indexMode
+	}
+	return worktreeMode
+}
+
+// readLinkTarget reads the target of a symlink, or the content of the plain
+// file standing in for it when core.symlinks is false.
+func readLinkTarget(path string, info os.FileInfo) (string, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read link %s: %s", path, err.Error())
+		}
+		return target, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", path, err.Error())
+	}
+	return string(content), nil
+}
+
+// probeFilesystem records the capabilities of the filesystem holding the
+// repository in its config, as git init does: core.fileMode always, and
+// core.symlinks and core.ignoreCase only when they differ from the default.
+func probeFilesystem() error {
+	probe := filepath.Join(gitDir, "config")
+	if _, err := os.Stat(probe); os.IsNotExist(err) {
+		if err := os.WriteFile(probe, nil, 0644); err != nil {
+			return fmt.Errorf("failed to write config: %s", err.Error())
+		}
+	}
+
+	fileMode := false
+	if info, err := os.Stat(probe); err == nil {
+		if err := os.Chmod(probe, info.Mode()^0o100); err == nil {
+			if changed, err := os.Stat(probe); err == nil && changed.Mode() != info.Mode() {
+				fileMode = true
+			}
+			os.Chmod(probe, info.Mode())
+		}
+	}
+	if err := setConfigValue("core.filemode", fmt.Sprint(fileMode)); err != nil {
+		return err
+	}
+
+	link := filepath.Join(gitDir, "tXXXXXX")
+	if err := os.Symlink("testing", link); err != nil {
+		if err := setConfigValue("core.symlinks", "false"); err != nil {
+			return err
+		}
+	} else {
+		os.Remove(link)
+	}
+
+	// a case-insensitive filesystem finds the config under another case
+	if _, err := os.Stat(filepath.Join(gitDir, "CoNfIg")); err == nil {
+		if err := setConfigValue("core.ignorecase", "true"); err != nil {
+			return err
+		}
+	}
+
+	// and one that normalizes Unicode finds a composed name decomposed
+	composed := filepath.Join(gitDir, "\u00c4")
+	if f, err := os.Create(composed); err == nil {
+		f.Close()
+		if _, err := os.Lstat(filepath.Join(gitDir, "A\u0308")); err == nil {
+			if err := setConfigValue("core.precomposeunicode", "true"); err != nil {
+				os.Remove(composed)
+				return err
+			}
+		}
+		os.Remove(composed)
+	}
+	filesystemOptions = nil
+	return nil
+}
+
+// foldPath is the key that paths differing only in case share when
+// core.ignoreCase is set.
+func foldPath(path string) string {
+	if ignoreCase() {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// trackedUnderOtherCase reports whether the index tracks path under a name
+// differing only in case, when core.ignoreCase is set.
+func trackedUnderOtherCase(index *Index, path string) bool {
+	if !ignoreCase() {
+		return false
+	}
+	for _, e := range index.Entries {
+		if e.Path != path && strings.EqualFold(e.Path, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type filterProcess struct {
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	stdout       *bufio.Reader
+	capabilities map[string]bool
+	aborted      bool
+}
+
+var filterProcesses = map[string]*filterProcess{}
+
+func startFilterProcess(command string) (*filterProcess, error) {
+	cmd := exec.CommandContext(commandContext, "sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	traceRunCommand(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start filter process '%s': %s", command, err.Error())
+	}
+	p := &filterProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), capabilities: map[string]bool{}}
+	if err := p.handshake(); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("handshake with filter process '%s' failed: %s", command, err.Error())
+	}
+	return p, nil
+}
+
+func (p *filterProcess) handshake() error {
+	for _, s := range []string{"git-filter-client", "version=2"} {
+		if err := writePktString(p.stdin, s); err != nil {
+			return err
+		}
+	}
+	if err := writePktFlush(p.stdin); err != nil {
+		return err
+	}
+	welcome, err := readPktStrings(p.stdout)
+	if err != nil {
+		return err
+	}
+	if len(welcome) < 2 || welcome[0] != "git-filter-server" || welcome[1] != "version=2" {
+		return fmt.Errorf("unexpected welcome %q", welcome)
+	}
+
+	for _, s := range []string{"capability=clean", "capability=smudge"} {
+		if err := writePktString(p.stdin, s); err != nil {
+			return err
+		}
+	}
+	if err := writePktFlush(p.stdin); err != nil {
+		return err
+	}
+	capabilities, err := readPktStrings(p.stdout)
+	if err != nil {
+		return err
+	}
+	for _, c := range capabilities {
+		if name, ok := strings.CutPrefix(c, "capability="); ok {
+			p.capabilities[name] = true
+		}
+	}
+	return nil
+}
+
+func readFilterStatus(r io.Reader, current string) (string, error) {
+	lines, err := readPktStrings(r)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range lines {
+		if status, ok := strings.CutPrefix(l, "status="); ok {
+			current = status
+		}
+	}
+	return current, nil
+}
+
+// run sends the content read from in through the filter, writing its output
+// to out as it arrives.
+func (p *filterProcess) run(command string, pathname string, in io.Reader, out io.Writer) error {
+	header := []string{"command=" + command, "pathname=" + pathname}
+	for _, s := range header {
+		if err := writePktString(p.stdin, s); err != nil {
+			return err
+		}
+	}
+	if err := writePktFlush(p.stdin); err != nil {
+		return err
+	}
+	if err := writePktStream(p.stdin, in); err != nil {
+		return err
+	}
+
+	status, err := readFilterStatus(p.stdout, "")
+	if err != nil {
+		return err
+	}
+	if status != "success" {
+		if status == "abort" {
+			p.aborted = true
+		}
+		return fmt.Errorf("filter process returned status %s", status)
+	}
+	if err := copyPktData(out, p.stdout); err != nil {
+		return err
+	}
+	// the filter may change its mind after sending the content
+	status, err = readFilterStatus(p.stdout, status)
+	if err != nil {
+		return err
+	}
+	if status != "success" {
+		return fmt.Errorf("filter process returned status %s", status)
+	}
+	return nil
+}
+
+func (p *filterProcess) stop() {
+	p.stdin.Close()
+	p.cmd.Wait()
+}
+
+func stopFilterProcesses() {
+	for name, p := range filterProcesses {
+		p.stop()
+		delete(filterProcesses, name)
+	}
+}
+
+func runFilterCommand(command string, pathname string, in io.Reader, out io.Writer) error {
+	quoted := "'" + strings.ReplaceAll(pathname, "'", `'\''`) + "'"
+	cmd := exec.CommandContext(commandContext, "sh", "-c", strings.ReplaceAll(command, "%f", quoted))
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("external filter '%s' failed: %s", command, err.Error())
+	}
+	return nil
+}
+
+// filterDriver returns the filter driver selected by the path's filter
+// attribute, "" when there is none.
+func filterDriver(pathname string) (string, error) {
+	driver, err := getAttribute(pathname, "filter")
+	if err != nil {
+		return "", err
+	}
+	if driver == attrUnspecified || driver == attrSet || driver == attrUnset {
+		return "", nil
+	}
+	return driver, nil
+}
+
+// runFilter streams the content read from in through the clean or smudge side
+// of driver into out. passthrough tells the caller to use the content
+// unchanged instead, discarding anything written to out: the driver has no
+// command for this direction, or it failed and is not required.
+func runFilter(driver string, direction string, pathname string, in io.Reader, out io.Writer) (passthrough bool, err error) {
+	config, err := getConfig()
+	if err != nil {
+		return false, err
+	}
+	required, err := config.GetBool("filter."+driver+".required", false)
+	if err != nil {
+		return false, err
+	}
+
+	ran, err := runFilterDriver(config, driver, direction, pathname, in, out)
+	if err != nil {
+		if required {
+			return false, fmt.Errorf("%s: %s filter '%s' failed: %s", pathname, direction, driver, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "error: %s: %s filter '%s' failed: %s\n", pathname, direction, driver, err.Error())
+		return true, nil
+	}
+	if !ran {
+		if required {
+			return false, fmt.Errorf("%s: %s filter '%s' is required but not configured", pathname, direction, driver)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// applyFilter runs the clean or smudge side of the filter driver selected by
+// the path's filter attribute. Content passes through unchanged when no driver
+// is configured, or when a non-required driver fails.
+func applyFilter(direction string, pathname string, content []byte) ([]byte, error) {
+	driver, err := filterDriver(pathname)
+	if err != nil || driver == "" {
+		return content, err
+	}
+	var out bytes.Buffer
+	passthrough, err := runFilter(driver, direction, pathname, bytes.NewReader(content), &out)
+	if err != nil {
+		return nil, err
+	}
+	if passthrough {
+		return content, nil
+	}
+	return out.Bytes(), nil
+}
+
+func runFilterDriver(config *Config, driver string, direction string, pathname string, in io.Reader, out io.Writer) (bool, error) {
+	if processCmd, ok := config.Get("filter." + driver + ".process"); ok {
+		p, ok := filterProcesses[driver]
+		if !ok {
+			var err error
+			p, err = startFilterProcess(processCmd)
+			if err != nil {
+				return false, err
+			}
+			filterProcesses[driver] = p
+		}
+		if !p.aborted && p.capabilities[direction] {
+			return true, p.run(direction, pathname, in, out)
+		}
+	}
+	if command, ok := config.Get("filter." + driver + "." + direction); ok && command != "" {
+		return true, runFilterCommand(command, pathname, in, out)
+	}
+	return false, nil
+}
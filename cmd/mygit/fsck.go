@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type fsckSeverity int
+
+const (
+	fsckIgnore fsckSeverity = iota
+	fsckInfo
+	fsckWarn
+	fsckError
+)
+
+// fsckSeverities holds git's default severity of each fsck message id.
+var fsckSeverities = map[string]fsckSeverity{
+	"badDate":                 fsckError,
+	"badDateOverflow":         fsckError,
+	"badEmail":                fsckError,
+	"badName":                 fsckError,
+	"badObjectSha1":           fsckError,
+	"badParentSha1":           fsckError,
+	"badTimezone":             fsckError,
+	"badTree":                 fsckError,
+	"badTreeSha1":             fsckError,
+	"badType":                 fsckError,
+	"duplicateEntries":        fsckError,
+	"missingAuthor":           fsckError,
+	"missingCommitter":        fsckError,
+	"missingEmail":            fsckError,
+	"missingNameBeforeEmail":  fsckError,
+	"missingObject":           fsckError,
+	"missingSpaceBeforeDate":  fsckError,
+	"missingSpaceBeforeEmail": fsckError,
+	"missingTag":              fsckError,
+	"missingTagEntry":         fsckError,
+	"missingTree":             fsckError,
+	"missingType":             fsckError,
+	"missingTypeEntry":        fsckError,
+	"multipleAuthors":         fsckError,
+	"nulInHeader":             fsckError,
+	"treeNotSorted":           fsckError,
+	"unterminatedHeader":      fsckError,
+	"zeroPaddedDate":          fsckError,
+	"emptyName":               fsckWarn,
+	"fullPathname":            fsckWarn,
+	"hasDot":                  fsckWarn,
+	"hasDotdot":               fsckWarn,
+	"hasDotgit":               fsckWarn,
+	"nullSha1":                fsckWarn,
+	"zeroPaddedFilemode":      fsckWarn,
+	"badFilemode":             fsckWarn,
+	"badTagName":              fsckInfo,
+	"missingTaggerEntry":      fsckInfo,
+	"extraHeaderEntry":        fsckIgnore,
+}
+
+// fsckChecker validates objects, reporting problems at the severity set by
+// fsck.<msg-id> or git's default. In strict mode warnings are errors, and
+// with WarningsFatal so are infos. Context prefixes every message.
+type fsckChecker struct {
+	Strict        bool
+	WarningsFatal bool
+	Context       string
+	config        *Config
+}
+
+func newFsckChecker(strict bool, context string) (*fsckChecker, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &fsckChecker{Strict: strict, Context: context, config: config}, nil
+}
+
+func (c *fsckChecker) severity(id string) fsckSeverity {
+	severity := fsckSeverities[id]
+	if value, ok := c.config.Get("fsck." + id); ok {
+		switch strings.ToLower(value) {
+		case "error":
+			severity = fsckError
+		case "warn":
+			severity = fsckWarn
+		case "ignore":
+			severity = fsckIgnore
+		}
+	}
+	if c.Strict && severity == fsckWarn {
+		severity = fsckError
+	}
+	// infos are reported like warnings
+	if severity == fsckInfo {
+		severity = fsckWarn
+	}
+	if c.WarningsFatal && severity == fsckWarn {
+		severity = fsckError
+	}
+	return severity
+}
+
+// report returns an error for problems severe enough to reject the object and
+// prints a warning for the others.
+func (c *fsckChecker) report(id string, format string, args ...interface{}) error {
+	message := fmt.Sprintf("%s: %s: %s", c.Context, id, fmt.Sprintf(format, args...))
+	switch c.severity(id) {
+	case fsckError:
+		return fmt.Errorf("%s", message)
+	case fsckWarn:
+		fmt.Fprintf(os.Stderr, "warning: %s\n", message)
+	}
+	return nil
+}
+
+// check validates content as an object of the given type.
+func (c *fsckChecker) check(objectType Type, content []byte) error {
+	switch objectType {
+	case TypeBlob:
+		return nil
+	case TypeTree:
+		return c.checkTree(content)
+	case TypeCommit:
+		return c.checkCommit(content)
+	case TypeTag:
+		_, _, err := c.checkTag(content)
+		return err
+	}
+	return fmt.Errorf("invalid object type \"%s\"", objectType)
+}
+
+// validateObject checks objects given to hash-object; only errors reject them.
+func validateObject(objectType Type, content []byte) error {
+	c, err := newFsckChecker(false, "object fails fsck")
+	if err != nil {
+		return err
+	}
+	return c.check(objectType, content)
+}
+
+// checkHeaders makes sure the headers are terminated and free of NUL bytes.
+func (c *fsckChecker) checkHeaders(content []byte) error {
+	for i, b := range content {
+		if b == 0 {
+			return c.report("nulInHeader", "unterminated header: NUL at offset %d", i)
+		}
+		if b == '\n' && i+1 < len(content) && content[i+1] == '\n' {
+			return nil
+		}
+	}
+	if len(content) > 0 && content[len(content)-1] == '\n' {
+		return nil
+	}
+	return c.report("unterminatedHeader", "unterminated header")
+}
+
+// headerValue returns the value of the header line at the start of buffer
+// and what follows it.
+func headerValue(buffer string, key string) (value string, rest string, ok bool) {
+	if !strings.HasPrefix(buffer, key+" ") {
+		return "", buffer, false
+	}
+	value, rest, found := strings.Cut(buffer[len(key)+1:], "\n")
+	if !found {
+		return value, "", true
+	}
+	return value, rest, true
+}
+
+// checkIdent reports an identity line checkIdentityLine finds wrong.
+func (c *fsckChecker) checkIdent(ident string) error {
+	if id, message := checkIdentityLine(ident); id != "" {
+		return c.report(id, message)
+	}
+	return nil
+}
+
+func (c *fsckChecker) checkCommit(content []byte) error {
+	if err := c.checkHeaders(content); err != nil {
+		return err
+	}
+	buffer := string(content)
+	tree, buffer, ok := headerValue(buffer, "tree")
+	if !ok {
+		return c.report("missingTree", "invalid format - expected 'tree' line")
+	}
+	if !isHexHash(tree) {
+		if err := c.report("badTreeSha1", "invalid 'tree' line format - bad sha1"); err != nil {
+			return err
+		}
+	}
+	for {
+		parent, rest, ok := headerValue(buffer, "parent")
+		if !ok {
+			break
+		}
+		if !isHexHash(parent) {
+			if err := c.report("badParentSha1", "invalid 'parent' line format - bad sha1"); err != nil {
+				return err
+			}
+		}
+		buffer = rest
+	}
+	authors := 0
+	for {
+		author, rest, ok := headerValue(buffer, "author")
+		if !ok {
+			break
+		}
+		authors++
+		if err := c.checkIdent(author); err != nil {
+			return err
+		}
+		buffer = rest
+	}
+	if authors == 0 {
+		return c.report("missingAuthor", "invalid format - expected 'author' line")
+	}
+	if authors > 1 {
+		if err := c.report("multipleAuthors", "invalid format - multiple 'author' lines"); err != nil {
+			return err
+		}
+	}
+	committer, _, ok := headerValue(buffer, "committer")
+	if !ok {
+		return c.report("missingCommitter", "invalid format - expected 'committer' line")
+	}
+	return c.checkIdent(committer)
+}
+
+// checkTag validates a tag and returns the object it points at.
+func (c *fsckChecker) checkTag(content []byte) (target string, targetType Type, err error) {
+	if err := c.checkHeaders(content); err != nil {
+		return "", "", err
+	}
+	buffer := string(content)
+	target, buffer, ok := headerValue(buffer, "object")
+	if !ok {
+		return "", "", c.report("missingObject", "invalid format - expected 'object' line")
+	}
+	if !isHexHash(target) {
+		if err := c.report("badObjectSha1", "invalid 'object' line format - bad sha1"); err != nil {
+			return "", "", err
+		}
+	}
+	if !strings.HasPrefix(buffer, "type ") {
+		return "", "", c.report("missingTypeEntry", "invalid format - expected 'type' line")
+	}
+	value, buffer, _ := headerValue(buffer, "type")
+	if buffer == "" {
+		return "", "", c.report("missingType", "invalid format - unexpected end after 'type' line")
+	}
+	targetType = Type(value)
+	switch targetType {
+	case TypeBlob, TypeTree, TypeCommit, TypeTag:
+	default:
+		if err := c.report("badType", "invalid 'type' value"); err != nil {
+			return "", "", err
+		}
+	}
+	if !strings.HasPrefix(buffer, "tag ") {
+		return "", "", c.report("missingTagEntry", "invalid format - expected 'tag' line")
+	}
+	name, buffer, _ := headerValue(buffer, "tag")
+	if buffer == "" {
+		return "", "", c.report("missingTag", "invalid format - unexpected end after 'type' line")
+	}
+	if !isValidRefName("refs/tags/" + name) {
+		if err := c.report("badTagName", "invalid 'tag' name: %s", name); err != nil {
+			return "", "", err
+		}
+	}
+	if tagger, rest, ok := headerValue(buffer, "tagger"); ok {
+		if err := c.checkIdent(tagger); err != nil {
+			return "", "", err
+		}
+		buffer = rest
+	} else if err := c.report("missingTaggerEntry", "invalid format - expected 'tagger' line"); err != nil {
+		return "", "", err
+	}
+	if buffer != "" && !strings.HasPrefix(buffer, "\n") {
+		if err := c.report("extraHeaderEntry", "invalid format - extra header(s) after 'tagger'"); err != nil {
+			return "", "", err
+		}
+	}
+	return target, targetType, nil
+}
+
+func (c *fsckChecker) checkTree(content []byte) error {
+	var hasNullSha1, hasFullPath, hasEmptyName, hasDot, hasDotdot, hasDotgit, hasZeroPad, hasBadModes, hasDups, notSorted bool
+	seen := map[string]bool{}
+	prevName, prevMode := "", -1
+	for len(content) > 0 {
+		nul := bytes.IndexByte(content, 0)
+		if nul == -1 || nul+21 > len(content) {
+			return c.report("badTree", "cannot be parsed as a tree")
+		}
+		modeText, name, found := strings.Cut(string(content[:nul]), " ")
+		if !found || modeText == "" || strings.Trim(modeText, "01234567") != "" {
+			return c.report("badTree", "cannot be parsed as a tree")
+		}
+		hash := hex.EncodeToString(content[nul+1 : nul+21])
+		content = content[nul+21:]
+
+		m, _ := strconv.Atoi(modeText)
+		hasNullSha1 = hasNullSha1 || hash == zeroHash
+		hasFullPath = hasFullPath || strings.Contains(name, "/")
+		hasEmptyName = hasEmptyName || name == ""
+		hasDot = hasDot || name == "."
+		hasDotdot = hasDotdot || name == ".."
+		hasDotgit = hasDotgit || strings.EqualFold(name, ".git")
+		hasZeroPad = hasZeroPad || modeText[0] == '0'
+		switch m {
+		case 100644, 100755, modeSymlink, modeTree, modeGitlink:
+		case 100664:
+			hasBadModes = hasBadModes || c.Strict
+		default:
+			hasBadModes = true
+		}
+
+		if prevMode != -1 {
+			prev := TreeObjectLine{Mode: prevMode, Name: prevName}
+			if treeEntryKey(prev) > treeEntryKey(TreeObjectLine{Mode: m, Name: name}) {
+				notSorted = true
+			}
+		}
+		// a file and a directory of the same name are duplicates too
+		hasDups = hasDups || seen[name]
+		seen[name] = true
+		prevName, prevMode = name, m
+	}
+
+	for _, problem := range []struct {
+		found   bool
+		id, msg string
+	}{
+		{hasNullSha1, "nullSha1", "contains entries pointing to null sha1"},
+		{hasFullPath, "fullPathname", "contains full pathnames"},
+		{hasEmptyName, "emptyName", "contains empty pathname"},
+		{hasDot, "hasDot", "contains '.'"},
+		{hasDotdot, "hasDotdot", "contains '..'"},
+		{hasDotgit, "hasDotgit", "contains '.git'"},
+		{hasZeroPad, "zeroPaddedFilemode", "contains zero-padded file modes"},
+		{hasBadModes, "badFilemode", "contains bad file modes"},
+		{hasDups, "duplicateEntries", "contains duplicate file entries"},
+		{notSorted, "treeNotSorted", "not properly sorted"},
+	} {
+		if problem.found {
+			if err := c.report(problem.id, "%s", problem.msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// startWriteout syncs the file outright where the data cannot be handed to
+// the disk alone.
+func startWriteout(f *os.File) error {
+	return f.Sync()
+}
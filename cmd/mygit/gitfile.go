@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// readGitFile follows a .git file of the form "gitdir: <path>", as written
+// by init --separate-git-dir and for submodules, to the repository it points
+// at. A relative path is relative to the directory holding the file.
+func readGitFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", path, err.Error())
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok || target == "" {
+		return "", fmt.Errorf("invalid gitfile format: %s", path)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("not a git repository: %s", target)
+	}
+	// the .git file of a linked worktree points at a directory sharing
+	// objects and refs with the main repository through commondir
+	if _, err := os.Stat(filepath.Join(target, "commondir")); err == nil {
+		return "", fmt.Errorf("%s is a linked worktree, which is not supported", target)
+	}
+	return target, nil
+}
+
+// ceilingDirectories are the absolute paths of GIT_CEILING_DIRECTORIES,
+// which discovery does not search up into. Other entries are ignored.
+func ceilingDirectories() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs
+}
+
+// absoluteEnvPath makes a path given in the environment absolute, so it
+// still names the same file once discovery changes directory.
+func absoluteEnvPath(name string) {
+	if path := os.Getenv(name); path != "" && !filepath.IsAbs(path) {
+		if abs, err := filepath.Abs(path); err == nil {
+			os.Setenv(name, abs)
+		}
+	}
+}
+
+// worktreePrefix is the directory the command was started in, relative to
+// the top of the worktree and ending in '/', or "" at the top: what git
+// calls the prefix. Commands run at the top, so file names and pathspecs
+// given to them are prefixed with it, and the paths they show are made
+// relative to it again.
+var worktreePrefix string
+
+// prefixFilename names a file given on the command line from the top of
+// the worktree, like git's prefix_filename.
+func prefixFilename(name string) string {
+	if worktreePrefix == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(worktreePrefix, name)
+}
+
+// relativePath shows a path from the top of the worktree relative to the
+// directory the command was started in, keeping a trailing '/'.
+func relativePath(path string) string {
+	if worktreePrefix == "" {
+		return path
+	}
+	rel, err := filepath.Rel(worktreePrefix, path)
+	if err != nil {
+		return path
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(path, "/") {
+		rel += "/"
+	}
+	return rel
+}
+
+// discoverGitDir finds the repository unless it was given by --git-dir or
+// GIT_DIR: the nearest directory holding .git, starting at the current one
+// and going up until a ceiling directory. Commands run at the top of the
+// worktree, so discovery changes to it and records the way back in
+// worktreePrefix. A .git file is resolved to the repository it points at.
+// With upward set to false, as for init, only the current directory is
+// looked at and its owner is not checked.
+func discoverGitDir(upward bool) error {
+	absoluteEnvPath("GIT_INDEX_FILE")
+	absoluteEnvPath("GIT_OBJECT_DIRECTORY")
+	if os.Getenv("GIT_DIR") != "" {
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = resolved
+	}
+	ceilings := ceilingDirectories()
+	top := cwd
+	for {
+		if _, err := os.Stat(filepath.Join(top, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(top)
+		if !upward || parent == top || slices.Contains(ceilings, parent) {
+			return nil
+		}
+		top = parent
+	}
+	if top != cwd {
+		if err := os.Chdir(top); err != nil {
+			return fmt.Errorf("cannot change to '%s': %s", top, err.Error())
+		}
+		if rel, err := filepath.Rel(top, cwd); err == nil {
+			worktreePrefix = filepath.ToSlash(rel) + "/"
+		}
+	}
+	info, err := os.Stat(".git")
+	if err != nil {
+		return nil
+	}
+	gitFile := ""
+	if !info.IsDir() {
+		dir, err := readGitFile(".git")
+		if err != nil {
+			return err
+		}
+		gitDir, gitFile = dir, filepath.Join(top, ".git")
+	}
+	if !upward {
+		return nil
+	}
+	return checkOwnership(top, gitFile)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const pgpSignatureStart = "-----BEGIN PGP SIGNATURE-----"
+
+// splitSignature splits a signed tag into the payload and the signature
+// appended to it, from the last line starting one. The signature is nil for
+// an unsigned tag.
+func splitSignature(content []byte) (payload []byte, signature []byte) {
+	start := -1
+	for i := 0; i < len(content); {
+		if bytes.HasPrefix(content[i:], []byte(pgpSignatureStart)) {
+			start = i
+		}
+		next := bytes.IndexByte(content[i:], '\n')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	if start == -1 {
+		return content, nil
+	}
+	return content[:start], content[start:]
+}
+
+// gpgProgram is gpg.openpgp.program or gpg.program, defaulting to gpg.
+func gpgProgram() (string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	for _, key := range []string{"gpg.openpgp.program", "gpg.program"} {
+		if value, ok := config.Get(key); ok && value != "" {
+			return value, nil
+		}
+	}
+	return "gpg", nil
+}
+
+// verifySignature checks signature against payload with gpg. The signature
+// is good when gpg succeeds and reports GOODSIG on its status output. What
+// gpg says about the signature is returned either way, for the caller to
+// show.
+func verifySignature(payload []byte, signature []byte) (good bool, output []byte, err error) {
+	program, err := gpgProgram()
+	if err != nil {
+		return false, nil, err
+	}
+	f, err := createTempFile(os.TempDir(), ".git_vtag_tmp")
+	if err != nil {
+		return false, nil, fmt.Errorf("could not create temporary file: %s", err.Error())
+	}
+	defer func() {
+		os.Remove(f.Name())
+		forgetTempFile(f.Name())
+	}()
+	if _, err := f.Write(signature); err != nil {
+		f.Close()
+		return false, nil, fmt.Errorf("failed writing detached signature to '%s': %s", f.Name(), err.Error())
+	}
+	f.Close()
+
+	var status, stderr bytes.Buffer
+	cmd := exec.CommandContext(commandContext, program, "--status-fd=1", "--verify", f.Name(), "-")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(payload), &status, &stderr
+	traceRunCommand(cmd)
+	runErr := cmd.Run()
+	if err := checkInterrupted(); err != nil {
+		return false, nil, err
+	}
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return false, nil, fmt.Errorf("cannot run %s: %s", program, runErr.Error())
+	}
+	good = runErr == nil && strings.Contains("\n"+status.String(), "\n[GNUPG:] GOODSIG ")
+	return good, stderr.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// commitGraft overrides the parents recorded in a commit. Shallow commits are
+// cut off from their parents, which a shallow clone does not have.
+type commitGraft struct {
+	Parents []string
+	Shallow bool
+}
+
+// grafts holds the entries of info/grafts and shallow, loaded on first use.
+var grafts map[string]commitGraft
+
+func graftFilePath() string {
+	if path := os.Getenv("GIT_GRAFT_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(gitDir, "info", "grafts")
+}
+
+// readHashLines reads a file of lines starting with a hash, skipping blank
+// lines and comments. A missing file has no lines.
+func readHashLines(path string) ([][]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err.Error())
+	}
+	lines := make([][]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		for _, hash := range fields {
+			if !isHexHash(hash) {
+				return nil, fmt.Errorf("bad graft data in %s: %s", path, line)
+			}
+		}
+		lines = append(lines, fields)
+	}
+	return lines, nil
+}
+
+func loadGrafts() error {
+	grafts = map[string]commitGraft{}
+	graftLines, err := readHashLines(graftFilePath())
+	if err != nil {
+		return err
+	}
+	if len(graftLines) > 0 {
+		warnGraftFileDeprecated()
+	}
+	for _, fields := range graftLines {
+		grafts[fields[0]] = commitGraft{Parents: fields[1:]}
+	}
+	shallowLines, err := readHashLines(filepath.Join(gitDir, "shallow"))
+	if err != nil {
+		return err
+	}
+	for _, fields := range shallowLines {
+		grafts[fields[0]] = commitGraft{Shallow: true}
+	}
+	return nil
+}
+
+func warnGraftFileDeprecated() {
+	if config, err := getConfig(); err == nil {
+		if enabled, err := config.GetBool("advice.graftFileDeprecated", true); err == nil && !enabled {
+			return
+		}
+	}
+	fmt.Fprint(os.Stderr, "hint: Support for <GIT_DIR>/info/grafts is deprecated\n"+
+		"hint: and will be removed in a future Git version.\n"+
+		"hint: \n"+
+		"hint: Please use \"git replace --convert-graft-file\"\n"+
+		"hint: to convert the grafts into replace refs.\n"+
+		"hint: \n"+
+		"hint: Turn this message off by running\n"+
+		"hint: \"git config advice.graftFileDeprecated false\"\n")
+}
+
+// applyGraft replaces the parents of a commit that is grafted or shallow,
+// so history walks stop at the boundary instead of looking for objects
+// that are not there.
+func applyGraft(commit *Commit) error {
+	if grafts == nil {
+		if err := loadGrafts(); err != nil {
+			return err
+		}
+	}
+	graft, ok := grafts[commit.Hash]
+	if !ok {
+		return nil
+	}
+	commit.Parents = graft.Parents
+	return nil
+}
+
+// isShallowRepository reports whether the repository has a shallow file.
+func isShallowRepository() (bool, error) {
+	if grafts == nil {
+		if err := loadGrafts(); err != nil {
+			return false, err
+		}
+	}
+	for _, graft := range grafts {
+		if graft.Shallow {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const graphExportUsage = "mygit graph-export [--format=dot|json] (--all | [<revision>...])"
+
+// graphCommit is a commit of the exported graph in JSON, one per line.
+type graphCommit struct {
+	Oid     string   `json:"oid"`
+	Parents []string `json:"parents"`
+	Author  string   `json:"author"`
+	Date    string   `json:"date"`
+	Subject string   `json:"subject"`
+	Refs    []string `json:"refs,omitempty"`
+}
+
+// runGraphExport writes the commits of a range, parents after children,
+// with the refs pointing at them, for Graphviz or other tools to draw.
+func runGraphExport(args []string) error {
+	format, all := "", false
+	flags := newFlagSet()
+	flags.String(&format, "--format")
+	flags.Bool(&all, "--all")
+	revisions, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "":
+		format = "dot"
+		if jsonOutput {
+			format = "json"
+		}
+	case "dot", "json":
+	default:
+		return fmt.Errorf("unknown format '%s', expected dot or json", format)
+	}
+	if all && len(revisions) > 0 {
+		return fmt.Errorf("usage: %s", graphExportUsage)
+	}
+	if len(revisions) == 0 && !all {
+		if branch, unborn := unbornBranch(); unborn {
+			return errUnbornBranch(branch)
+		}
+		revisions = []string{"HEAD"}
+	}
+	include, exclude, err := parseRevisionArgs(revisions)
+	if err != nil {
+		return err
+	}
+	if all {
+		if _, include, err = historyRefs(); err != nil {
+			return err
+		}
+	}
+	walk, err := newRevWalk(include, exclude)
+	if err != nil {
+		return err
+	}
+	walk.Sort = sortTopo
+	commits := make([]*Commit, 0)
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		commits = append(commits, commit)
+	}
+	decorated, err := loadDecorations(decorateFull, nil)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if format == "json" {
+		for _, c := range commits {
+			record := graphCommit{
+				Oid:     c.Hash,
+				Parents: append([]string{}, c.Parents...),
+				Author:  fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email),
+				Date:    c.Author.When.Format(time.RFC3339),
+				Subject: c.Subject(),
+			}
+			for _, d := range decorated.refs[c.Hash] {
+				record.Refs = append(record.Refs, d.Ref)
+			}
+			if err := writeJSONLine(w, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeGraphDot(w, commits, decorated)
+}
+
+// writeGraphDot writes the commits as a Graphviz digraph: a box per commit,
+// an edge to each parent in the range and a note per ref. Parents outside
+// the range are left out, like the boundary of a log.
+func writeGraphDot(w io.Writer, commits []*Commit, decorated *decorations) error {
+	inRange := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		inRange[c.Hash] = true
+	}
+	fmt.Fprintf(w, "digraph history {\n\trankdir=BT;\n\tnode [shape=box, fontname=monospace];\n")
+	for _, c := range commits {
+		label := abbrevHash(c.Hash) + "\n" + c.Subject()
+		fmt.Fprintf(w, "\t%s [label=%s, tooltip=%s];\n", dotQuote(c.Hash), dotQuote(label), dotQuote(c.Author.Name+", "+formatLogDate(c.Author)))
+		for _, parent := range c.Parents {
+			if inRange[parent] {
+				fmt.Fprintf(w, "\t%s -> %s;\n", dotQuote(parent), dotQuote(c.Hash))
+			}
+		}
+		for _, d := range decorated.refs[c.Hash] {
+			fmt.Fprintf(w, "\t%s [label=%s, shape=note];\n", dotQuote(d.Ref), dotQuote(shortenRefName(d.Ref)))
+			fmt.Fprintf(w, "\t%s -> %s [style=dashed, arrowhead=none];\n", dotQuote(c.Hash), dotQuote(d.Ref))
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// dotQuote makes s a DOT string, its newlines breaking label lines.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
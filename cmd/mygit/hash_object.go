@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type hashObjectOptions struct {
+	Type      Type
+	Write     bool
+	Literally bool
+	NoFilters bool
+	Path      string
+}
+
+// hashObject hashes content as an object of the given type, writing it when
+// asked. Blobs read from a path go through the clean filter.
+func hashObject(opts hashObjectOptions, content []byte, path string) (string, error) {
+	if opts.Type == TypeBlob && path != "" && !opts.NoFilters {
+		var err error
+		if content, err = applyFilter("clean", path, content); err != nil {
+			return "", err
+		}
+	}
+	if !opts.Literally {
+		if err := validateObject(opts.Type, content); err != nil {
+			return "", err
+		}
+	}
+	if opts.Write {
+		return writeObject(opts.Type, content)
+	}
+	data := append([]byte(fmt.Sprintf("%s %d\u0000", opts.Type, len(content))), content...)
+	return hex.EncodeToString(calculateObjectBytesHash(data)), nil
+}
+
+func runHashObject(args []string) error {
+	opts := hashObjectOptions{Type: TypeBlob}
+	fromStdin := false
+	files := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-w":
+			opts.Write = true
+		case arg == "-t":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option -t requires a value")
+			}
+			i++
+			opts.Type = Type(args[i])
+		case arg == "--literally":
+			opts.Literally = true
+		case arg == "--stdin":
+			fromStdin = true
+		case arg == "--no-filters":
+			opts.NoFilters = true
+		case strings.HasPrefix(arg, "--path="):
+			opts.Path = strings.TrimPrefix(arg, "--path=")
+		case arg == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if opts.Path != "" && opts.NoFilters {
+		return fmt.Errorf("options --path and --no-filters cannot be used together")
+	}
+	if opts.Path != "" {
+		opts.Path = prefixFilename(opts.Path)
+	}
+
+	if fromStdin {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %s", err.Error())
+		}
+		hash, err := hashObject(opts, content, opts.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+	}
+	for _, file := range files {
+		file = prefixFilename(file)
+		path := file
+		if opts.Path != "" {
+			path = opts.Path
+		}
+		if opts.Type == TypeBlob {
+			filterPath := path
+			if opts.NoFilters {
+				filterPath = ""
+			}
+			big, size, err := openBigFile(file, filterPath)
+			if err != nil {
+				return err
+			}
+			if big != nil {
+				hash, err := streamObject(TypeBlob, size, big, opts.Write)
+				big.Close()
+				if err != nil {
+					return fmt.Errorf("%s: %s", file, err.Error())
+				}
+				fmt.Println(hash)
+				continue
+			}
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", file, err.Error())
+		}
+		hash, err := hashObject(opts, content, path)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err.Error())
+		}
+		fmt.Println(hash)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// hookPath is where the hook called name lives: under core.hooksPath when
+// set, otherwise in the hooks directory of the repository. A relative
+// core.hooksPath is taken from the top of the worktree, where hooks run, so
+// "core.hooksPath = .githooks" works for hooks kept in the project itself;
+// pointing it at /dev/null disables all hooks.
+func hookPath(name string) string {
+	if config, err := getConfig(); err == nil {
+		if dir, ok := config.Get("core.hooksPath"); ok && dir != "" {
+			dir = expandHomePath(dir)
+			if abs, err := filepath.Abs(dir); err == nil {
+				dir = abs
+			}
+			return filepath.Join(dir, name)
+		}
+	}
+	return filepath.Join(gitDir, "hooks", name)
+}
+
+// runHook runs a hook with args if it exists, with nothing on its stdin.
+func runHook(name string, env []string, args ...string) error {
+	return runHookInput(name, env, nil, args...)
+}
+
+// runHookInput runs a hook with args and input on its stdin if it exists.
+// Its output goes to stderr, and a hook that is not executable is skipped
+// with a hint like git gives. A hook exiting with an error fails with
+// errQuietFailure, since the hook reports its own reasons.
+func runHookInput(name string, env []string, input []byte, args ...string) error {
+	path := hookPath(name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if info.Mode()&0o111 == 0 {
+		if config, err := getConfig(); err == nil {
+			if enabled, err := config.GetBool("advice.ignoredHook", true); err == nil && !enabled {
+				return nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n"+
+			"hint: You can disable this warning with `git config advice.ignoredHook false`.\n", path)
+		return nil
+	}
+	cmd := exec.CommandContext(commandContext, path, args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return errQuietFailure
+		}
+		return fmt.Errorf("failed to run hook %s: %s", name, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identity is the author, committer or tagger of an object, written in it
+// as "<name> <<email>> <timestamp> <tz>".
+type Identity struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// parseIdentity reads an identity line leniently, as objects git wrote
+// before fsck's rules may carry odd names; checkIdentityLine is the strict
+// check.
+func parseIdentity(value string) (Identity, error) {
+	emailEnd := strings.LastIndexByte(value, '>')
+	name, email, ok := parseContact(value[:emailEnd+1])
+	if !ok {
+		return Identity{}, fmt.Errorf("bad identity line %q", value)
+	}
+	ident := Identity{Name: name, Email: email}
+
+	dateParts := strings.Fields(value[emailEnd+1:])
+	if len(dateParts) != 2 {
+		return Identity{}, fmt.Errorf("bad date in identity line %q", value)
+	}
+	timestamp, err := strconv.ParseInt(dateParts[0], 10, 64)
+	if err != nil {
+		return Identity{}, fmt.Errorf("bad timestamp in identity line %q", value)
+	}
+	offset, err := parseTimezoneOffset(dateParts[1])
+	if err != nil {
+		return Identity{}, fmt.Errorf("bad timezone in identity line %q", value)
+	}
+	ident.When = time.Unix(timestamp, 0).In(time.FixedZone("", offset))
+	return ident, nil
+}
+
+func parseTimezoneOffset(tz string) (int, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return 0, fmt.Errorf("bad timezone %q", tz)
+	}
+	hours, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return 0, err
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+func (ident Identity) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", ident.Name, ident.Email, ident.When.Unix(), ident.When.Format("-0700"))
+}
+
+// checkIdentityField rejects a name or email that would break the
+// identity line it is written into: one with "<", ">" or a newline.
+func checkIdentityField(what string, value string) error {
+	if strings.ContainsAny(value, "<>\n") {
+		return fmt.Errorf("invalid %s '%s': it may not contain '<', '>' or a newline", what, value)
+	}
+	return nil
+}
+
+// checkIdentityLine validates "<name> <<email>> <timestamp> <tz>" the way
+// git's fsck does, returning the fsck message id and message of the first
+// problem, or "" when there is none.
+func checkIdentityLine(ident string) (id string, message string) {
+	p := ident + "\n"
+	if strings.HasPrefix(p, "<") {
+		return "missingNameBeforeEmail", "invalid author/committer line - missing space before email"
+	}
+	i := strings.IndexAny(p, "<>\n")
+	if p[i] == '>' {
+		return "badName", "invalid author/committer line - bad name"
+	}
+	if p[i] != '<' {
+		return "missingEmail", "invalid author/committer line - missing email"
+	}
+	if p[i-1] != ' ' {
+		return "missingSpaceBeforeEmail", "invalid author/committer line - missing space before email"
+	}
+	i++
+	i += strings.IndexAny(p[i:], "<>\n")
+	if p[i] != '>' {
+		return "badEmail", "invalid author/committer line - bad email"
+	}
+	i++
+	if p[i] != ' ' {
+		return "missingSpaceBeforeDate", "invalid author/committer line - missing space before date"
+	}
+	i++
+	if p[i] == '0' && p[i+1] != ' ' {
+		return "zeroPaddedDate", "invalid author/committer line - zero-padded date"
+	}
+	end := i
+	for end < len(p) && '0' <= p[end] && p[end] <= '9' {
+		end++
+	}
+	if end > i {
+		if _, err := strconv.ParseInt(p[i:end], 10, 64); err != nil {
+			return "badDateOverflow", "invalid author/committer line - date causes integer overflow"
+		}
+	}
+	if end == i || p[end] != ' ' {
+		return "badDate", "invalid author/committer line - bad date"
+	}
+	tz := p[end+1:]
+	if len(tz) < 6 || (tz[0] != '+' && tz[0] != '-') || strings.Trim(tz[1:5], "0123456789") != "" || tz[5] != '\n' {
+		return "badTimezone", "invalid author/committer line - bad time zone"
+	}
+	return "", ""
+}
+
+// parseContact reads "Name <email>" or "<email>", as check-mailmap takes.
+func parseContact(contact string) (name string, email string, ok bool) {
+	start := strings.IndexByte(contact, '<')
+	end := strings.LastIndexByte(contact, '>')
+	if start == -1 || end < start {
+		return "", "", false
+	}
+	return strings.TrimSpace(contact[:start]), contact[start+1 : end], true
+}
+
+// identityUnknown is the error for an identity that could not be made up,
+// with git's advice on configuring one.
+func identityUnknown(kind string, reason string) error {
+	return fmt.Errorf("%s identity unknown\n\n*** Please tell me who you are.\n\nRun\n\n"+
+		"  git config --global user.email \"you@example.com\"\n  git config --global user.name \"Your Name\"\n\n"+
+		"to set your account's default identity.\nOmit --global to set the identity only in this repository.\n\n%s",
+		strings.ToLower(kind), reason)
+}
+
+// currentIdentity returns the identity for kind "AUTHOR" or "COMMITTER" from
+// GIT_<kind>_{NAME,EMAIL,DATE}, then author.* or committer.*, then user.*.
+// A name or email that would make an invalid identity line is rejected, as
+// is an empty name or an email that could not be made up from $USER and the
+// host name.
+func currentIdentity(kind string) (Identity, error) {
+	return lookupIdentity(kind, true)
+}
+
+// reflogIdentity is the committer recorded in reflogs, which like git's
+// does not have to be complete.
+func reflogIdentity() (Identity, error) {
+	return lookupIdentity("COMMITTER", false)
+}
+
+func lookupIdentity(kind string, strict bool) (Identity, error) {
+	config, err := getConfig()
+	if err != nil {
+		return Identity{}, err
+	}
+	section := strings.ToLower(kind)
+	lookup := func(envName string, key string) string {
+		if value := os.Getenv(envName); value != "" {
+			return value
+		}
+		if value, ok := config.Get(section + "." + key); ok {
+			return value
+		}
+		value, _ := config.Get("user." + key)
+		return value
+	}
+
+	ident := Identity{
+		Name:  lookup("GIT_"+kind+"_NAME", "name"),
+		Email: lookup("GIT_"+kind+"_EMAIL", "email"),
+		When:  time.Now(),
+	}
+	if ident.Email == "" {
+		ident.Email = os.Getenv("EMAIL")
+	}
+	if ident.Name == "" || ident.Email == "" {
+		user := os.Getenv("USER")
+		host, _ := os.Hostname()
+		if ident.Name == "" {
+			ident.Name = user
+		}
+		if ident.Email == "" {
+			ident.Email = user + "@" + host
+			if strict && (user == "" || host == "") {
+				return Identity{}, identityUnknown(kind, fmt.Sprintf("unable to auto-detect email address (got '%s')", ident.Email))
+			}
+		}
+	}
+	if strict && ident.Name == "" {
+		return Identity{}, identityUnknown(kind, fmt.Sprintf("empty ident name (for <%s>) not allowed", ident.Email))
+	}
+
+	if err := checkIdentityField("name", ident.Name); err != nil {
+		return Identity{}, err
+	}
+	if err := checkIdentityField("email", ident.Email); err != nil {
+		return Identity{}, err
+	}
+
+	if date := os.Getenv("GIT_" + kind + "_DATE"); date != "" {
+		when, err := parseDate(date, ident.When)
+		if err != nil {
+			return Identity{}, err
+		}
+		ident.When = when
+	}
+	return ident, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ignoreRule struct {
+	// Base is the directory of the .gitignore file, relative to the root
+	Base    string
+	Pattern string
+	Negated bool
+	DirOnly bool
+	// Anchored patterns have a '/' before their end and match the path
+	// below Base instead of the name at any depth
+	Anchored bool
+}
+
+var ignoreFileCache = map[string][]ignoreRule{}
+
+// trimIgnoreLine drops trailing spaces unless they are escaped with a
+// backslash, as git does.
+func trimIgnoreLine(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end > 1 && line[end-2] == '\\' {
+			break
+		}
+		end--
+	}
+	return line[:end]
+}
+
+func parseIgnoreRules(data []byte, base string) []ignoreRule {
+	rules := make([]ignoreRule, 0, 8)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := trimIgnoreLine(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{Base: base}
+		switch {
+		case line[0] == '!':
+			rule.Negated = true
+			line = line[1:]
+		case strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#"):
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.DirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.Anchored = strings.Contains(line, "/")
+		rule.Pattern = strings.TrimPrefix(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func readIgnoreFile(filename string, base string) ([]ignoreRule, error) {
+	if rules, ok := ignoreFileCache[filename]; ok {
+		return rules, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) && !os.IsPermission(err) {
+		return nil, fmt.Errorf("failed to read ignore file %s: %s", filename, err.Error())
+	}
+	rules := parseIgnoreRules(data, base)
+	ignoreFileCache[filename] = rules
+	return rules, nil
+}
+
+// excludesFile is core.excludesFile, or $XDG_CONFIG_HOME/git/ignore when it
+// is not set.
+func excludesFile() (string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	if file, ok := config.Get("core.excludesFile"); ok {
+		return expandHomePath(file), nil
+	}
+	return xdgConfigPath("ignore"), nil
+}
+
+// ignoreRulesFor returns the rules that may apply to a path in dir, ordered
+// from lowest to highest precedence: the global excludes file, info/exclude
+// and then the .gitignore files from the root down.
+func ignoreRulesFor(dir string) ([]ignoreRule, error) {
+	allRules := make([]ignoreRule, 0, 16)
+	global, err := excludesFile()
+	if err != nil {
+		return nil, err
+	}
+	if global != "" {
+		rules, err := readIgnoreFile(global, "")
+		if err != nil {
+			return nil, err
+		}
+		allRules = append(allRules, rules...)
+	}
+	rules, err := readIgnoreFile(filepath.Join(gitDir, "info", "exclude"), "")
+	if err != nil {
+		return nil, err
+	}
+	allRules = append(allRules, rules...)
+
+	dirs := []string{""}
+	if dir != "." && dir != "" {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, d := range dirs {
+		rules, err := readIgnoreFile(filepath.Join(filepath.FromSlash(d), ".gitignore"), d)
+		if err != nil {
+			return nil, err
+		}
+		allRules = append(allRules, rules...)
+	}
+	return allRules, nil
+}
+
+func ignorePatternMatches(rule ignoreRule, relPath string, isDir bool) bool {
+	if rule.DirOnly && !isDir {
+		return false
+	}
+	target := relPath
+	if rule.Base != "" {
+		if !strings.HasPrefix(relPath, rule.Base+"/") {
+			return false
+		}
+		target = relPath[len(rule.Base)+1:]
+	}
+	if !rule.Anchored {
+		target = path.Base(target)
+	}
+	if ignoreCase() {
+		return wildmatchFold(rule.Pattern, target)
+	}
+	return wildmatch(rule.Pattern, target)
+}
+
+// isIgnored reports whether a worktree path is excluded by the ignore
+// rules. The last matching rule decides, and like git a path inside an
+// excluded directory cannot be included again.
+func isIgnored(relPath string, isDir bool) (bool, error) {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	if dir := path.Dir(relPath); dir != "." {
+		if ignored, err := isIgnored(dir, true); err != nil || ignored {
+			return ignored, err
+		}
+	}
+	rules, err := ignoreRulesFor(path.Dir(relPath))
+	if err != nil {
+		return false, err
+	}
+	ignored := false
+	for _, rule := range rules {
+		if ignorePatternMatches(rule, relPath, isDir) {
+			ignored = !rule.Negated
+		}
+	}
+	return ignored, nil
+}
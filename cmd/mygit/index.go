@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const indexEntryFixedSize = 62
+
+type IndexEntry struct {
+	CTimeSec      uint32
+	CTimeNsec     uint32
+	MTimeSec      uint32
+	MTimeNsec     uint32
+	Dev           uint32
+	Ino           uint32
+	Mode          int
+	UID           uint32
+	GID           uint32
+	Size          uint32
+	Hash          string
+	Flags         uint16
+	ExtendedFlags uint16
+	Path          string
+}
+
+func (e *IndexEntry) Stage() int {
+	return int(e.Flags>>12) & 3
+}
+
+const (
+	// indexFlagAssumeValid is set by "update-index --assume-unchanged"
+	indexFlagAssumeValid = 0x8000
+	// indexFlagSkipWorktree is one of the extended flags, only stored by
+	// index versions 3 and up
+	indexFlagSkipWorktree = 0x4000
+)
+
+func (e *IndexEntry) assumeUnchanged() bool {
+	return e.Flags&indexFlagAssumeValid != 0
+}
+
+func (e *IndexEntry) skipWorktree() bool {
+	return e.ExtendedFlags&indexFlagSkipWorktree != 0
+}
+
+type indexExtension struct {
+	Signature string
+	Data      []byte
+}
+
+type Index struct {
+	// Version is the format read, 0 for a new index
+	Version    uint32
+	Entries    []IndexEntry
+	Extensions []indexExtension
+	// Timestamp is the mtime of the index file as read, zero without one.
+	// Entries modified at or after it are racily clean: their stat data
+	// cannot tell changes made in the same instant apart.
+	Timestamp time.Time
+
+	// refreshed is set when the stat data of clean entries was updated
+	refreshed bool
+}
+
+// getIndexPath is .git/index unless GIT_INDEX_FILE names another file, as
+// scripts do to build a tree without touching the real index.
+func getIndexPath() string {
+	if path := os.Getenv("GIT_INDEX_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(gitDir, "index")
+}
+
+// indexModeToTreeMode converts the octal mode stored in the index to the
+// digits-as-int form used for tree entries, e.g. 0o100644 to 100644.
+func indexModeToTreeMode(m uint32) int {
+	treeMode, _ := strconv.Atoi(strconv.FormatUint(uint64(m), 8))
+	return treeMode
+}
+
+func treeModeToIndexMode(m int) uint32 {
+	indexMode, _ := strconv.ParseUint(strconv.Itoa(m), 8, 32)
+	return uint32(indexMode)
+}
+
+// readIndex parses the index written by git; a missing index is empty.
+func readIndex() (*Index, error) {
+	if inMemory != nil {
+		if index := inMemory.readIndex(); index != nil {
+			return index, nil
+		}
+	}
+	return readIndexFile(getIndexPath())
+}
+
+// readIndexFile parses the index in indexPath, empty when it is missing.
+func readIndexFile(indexPath string) (*Index, error) {
+	info, err := os.Stat(indexPath)
+	if os.IsNotExist(err) {
+		return &Index{}, nil
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(indexPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index %s: %s", indexPath, err.Error())
+	}
+	if len(data) < 12+20 || !bytes.Equal(data[:4], []byte("DIRC")) {
+		return nil, fmt.Errorf("index %s has a bad signature", indexPath)
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("index %s has unsupported version %d", indexPath, version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	index := &Index{Version: version, Entries: make([]IndexEntry, 0, count), Timestamp: info.ModTime()}
+	offset := 12
+	previousPath := ""
+	for i := uint32(0); i < count; i++ {
+		if offset+indexEntryFixedSize > len(data) {
+			return nil, fmt.Errorf("index %s is truncated", indexPath)
+		}
+		entry := data[offset:]
+		e := IndexEntry{
+			CTimeSec:  binary.BigEndian.Uint32(entry[0:4]),
+			CTimeNsec: binary.BigEndian.Uint32(entry[4:8]),
+			MTimeSec:  binary.BigEndian.Uint32(entry[8:12]),
+			MTimeNsec: binary.BigEndian.Uint32(entry[12:16]),
+			Dev:       binary.BigEndian.Uint32(entry[16:20]),
+			Ino:       binary.BigEndian.Uint32(entry[20:24]),
+			Mode:      indexModeToTreeMode(binary.BigEndian.Uint32(entry[24:28])),
+			UID:       binary.BigEndian.Uint32(entry[28:32]),
+			GID:       binary.BigEndian.Uint32(entry[32:36]),
+			Size:      binary.BigEndian.Uint32(entry[36:40]),
+			Hash:      hex.EncodeToString(entry[40:60]),
+			Flags:     binary.BigEndian.Uint16(entry[60:62]),
+		}
+		headerSize := indexEntryFixedSize
+		if e.Flags&0x4000 != 0 {
+			if offset+headerSize+2 > len(data) {
+				return nil, fmt.Errorf("index %s is truncated", indexPath)
+			}
+			e.ExtendedFlags = binary.BigEndian.Uint16(entry[62:64])
+			headerSize += 2
+		}
+		if version == 4 {
+			// the path drops the given number of bytes from the end of the
+			// previous one and appends the rest, without padding
+			strip, n := decodeIndexVarint(data[offset+headerSize:])
+			if n == 0 || strip > uint64(len(previousPath)) {
+				return nil, fmt.Errorf("index %s has a bad path in entry %d", indexPath, i)
+			}
+			nameStart := offset + headerSize + n
+			nameEnd := bytes.IndexByte(data[nameStart:], 0)
+			if nameEnd == -1 {
+				return nil, fmt.Errorf("index %s is truncated", indexPath)
+			}
+			e.Path = previousPath[:len(previousPath)-int(strip)] + string(data[nameStart:nameStart+nameEnd])
+			previousPath = e.Path
+			index.Entries = append(index.Entries, e)
+			offset = nameStart + nameEnd + 1
+			continue
+		}
+		nameEnd := bytes.IndexByte(data[offset+headerSize:], 0)
+		if nameEnd == -1 {
+			return nil, fmt.Errorf("index %s is truncated", indexPath)
+		}
+		e.Path = string(data[offset+headerSize : offset+headerSize+nameEnd])
+		index.Entries = append(index.Entries, e)
+		// entries are NUL padded to a multiple of 8 bytes
+		entrySize := (headerSize + nameEnd + 8) &^ 7
+		offset += entrySize
+	}
+
+	for offset+8 <= len(data)-20 {
+		signature := string(data[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		offset += 8
+		if offset+size > len(data)-20 {
+			return nil, fmt.Errorf("index %s has a truncated %s extension", indexPath, signature)
+		}
+		// extensions starting with an uppercase letter are optional
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, fmt.Errorf("index %s uses unsupported extension %s", indexPath, signature)
+		}
+		index.Extensions = append(index.Extensions, indexExtension{Signature: signature, Data: data[offset : offset+size]})
+		offset += size
+	}
+	return index, nil
+}
+
+// lockIndex takes index.lock and then reads the index, so no other process
+// can change it before writeIndex commits the lock or it is rolled back.
+func lockIndex() (*Index, *lockFile, error) {
+	if inMemory != nil {
+		index, err := readIndex()
+		return index, &lockFile{Path: getIndexPath()}, err
+	}
+	lock, err := acquireLock(getIndexPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := readIndex()
+	if err != nil {
+		lock.rollback()
+		return nil, nil, err
+	}
+	return index, lock, nil
+}
+
+// decodeIndexVarint reads the big-endian base-128 number of index version
+// 4 paths, where each continuation adds one so no two encodings are equal.
+// n is 0 when data ends first.
+func decodeIndexVarint(data []byte) (value uint64, n int) {
+	for i, c := range data {
+		if i > 0 {
+			value++
+		}
+		value = value<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func encodeIndexVarint(value uint64) []byte {
+	buf := []byte{byte(value & 0x7f)}
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		buf = append([]byte{0x80 | byte(value&0x7f)}, buf...)
+	}
+	return buf
+}
+
+// defaultIndexVersion is the format of a new index: GIT_INDEX_VERSION, else
+// index.version, else 4 with feature.manyFiles and 2 otherwise.
+func defaultIndexVersion() uint32 {
+	if value, ok := os.LookupEnv("GIT_INDEX_VERSION"); ok {
+		if version, err := strconv.Atoi(value); err == nil && version >= 2 && version <= 4 {
+			return uint32(version)
+		}
+		fmt.Fprintf(os.Stderr, "warning: GIT_INDEX_VERSION set, but the value is invalid.\nUsing version 2\n")
+		return 2
+	}
+	config, err := getConfig()
+	if err != nil {
+		return 2
+	}
+	def := 2
+	if manyFiles, err := config.GetBool("feature.manyFiles", false); err == nil && manyFiles {
+		def = 4
+	}
+	version, err := config.GetInt("index.version", def)
+	if err != nil || version < 2 || version > 4 {
+		fmt.Fprintf(os.Stderr, "warning: index.version set, but the value is invalid.\nUsing version 2\n")
+		return 2
+	}
+	return uint32(version)
+}
+
+// writeIndex writes the entries sorted by path and stage through the lock
+// taken by lockIndex, in the format the index was read in. Extensions are
+// dropped since the cache-tree and others would be stale.
+func writeIndex(index *Index, lock *lockFile) error {
+	if err := writeLockedIndex(index, lock); err != nil {
+		return err
+	}
+	if inMemory != nil {
+		return nil
+	}
+	if err := lock.commit(); err != nil {
+		return fmt.Errorf("failed to write index: %s", err.Error())
+	}
+	if info, err := os.Stat(getIndexPath()); err == nil {
+		index.Timestamp = info.ModTime()
+	}
+	return nil
+}
+
+// writeLockedIndex is writeIndex leaving the lock held, so the new index can
+// be read from the lock file before it is committed, as hooks do during a
+// commit. Each call replaces what the previous one wrote.
+func writeLockedIndex(index *Index, lock *lockFile) error {
+	sort.SliceStable(index.Entries, func(i, j int) bool {
+		a, b := &index.Entries[i], &index.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Stage() < b.Stage()
+	})
+
+	version := index.Version
+	if version == 0 {
+		version = defaultIndexVersion()
+	}
+	if version == 2 || version == 3 {
+		// like git, version 3 is only used when extended flags need it
+		version = 2
+		for _, e := range index.Entries {
+			if e.ExtendedFlags != 0 {
+				version = 3
+			}
+		}
+	}
+
+	for i := range index.Entries {
+		index.smudgeRacyEntry(&index.Entries[i])
+	}
+
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, version)
+	binary.Write(&b, binary.BigEndian, uint32(len(index.Entries)))
+	previousPath := ""
+	for _, e := range index.Entries {
+		hash, err := hex.DecodeString(e.Hash)
+		if err != nil || len(hash) != 20 {
+			return fmt.Errorf("index entry %s has invalid hash %s", e.Path, e.Hash)
+		}
+		flags := e.Flags &^ (0x4000 | 0xfff)
+		flags |= uint16(min(len(e.Path), 0xfff))
+		if e.ExtendedFlags != 0 {
+			flags |= 0x4000
+		}
+		start := b.Len()
+		for _, v := range []uint32{e.CTimeSec, e.CTimeNsec, e.MTimeSec, e.MTimeNsec, e.Dev, e.Ino, treeModeToIndexMode(e.Mode), e.UID, e.GID, e.Size} {
+			binary.Write(&b, binary.BigEndian, v)
+		}
+		b.Write(hash)
+		binary.Write(&b, binary.BigEndian, flags)
+		if e.ExtendedFlags != 0 {
+			binary.Write(&b, binary.BigEndian, e.ExtendedFlags)
+		}
+		if version == 4 {
+			common := 0
+			for common < len(previousPath) && common < len(e.Path) && previousPath[common] == e.Path[common] {
+				common++
+			}
+			b.Write(encodeIndexVarint(uint64(len(previousPath) - common)))
+			b.WriteString(e.Path[common:])
+			b.WriteByte(0)
+			previousPath = e.Path
+			continue
+		}
+		b.WriteString(e.Path)
+		padding := 8 - (b.Len()-start)%8
+		b.Write(make([]byte, padding))
+	}
+	b.Write(calculateObjectBytesHash(b.Bytes()))
+
+	if inMemory != nil {
+		lock.rollback()
+		index.Version, index.refreshed = version, false
+		stored := *index
+		stored.Entries = slices.Clone(index.Entries)
+		inMemory.index = &stored
+		return nil
+	}
+	if err := lock.replace(b.Bytes()); err != nil {
+		lock.rollback()
+		return fmt.Errorf("failed to write index: %s", err.Error())
+	}
+	index.Version, index.refreshed = version, false
+	return nil
+}
+
+// writeRefreshedIndex saves the stat data refreshed while comparing the
+// worktree, so the next command does not rehash the same files. Nothing is
+// written when another process holds the lock or changed the index since it
+// was read.
+func writeRefreshedIndex(index *Index) {
+	if !index.refreshed || index.Timestamp.IsZero() || inMemory != nil {
+		return
+	}
+	lock, err := acquireLock(getIndexPath())
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(getIndexPath()); err != nil || !info.ModTime().Equal(index.Timestamp) {
+		lock.rollback()
+		return
+	}
+	writeIndex(index, lock)
+}
+
+// smudgeRacyEntry clears the size of a racily clean entry whose file did
+// change, as git does, so it no longer matches the stat data once the new
+// index is newer than the file.
+func (index *Index) smudgeRacyEntry(e *IndexEntry) {
+	if e.Stage() != 0 || e.Mode == modeGitlink || !index.isRacy(e) {
+		return
+	}
+	if hash, err := hashWorktreeFile(e.Path); err != nil || hash != e.Hash {
+		e.Size = 0
+	}
+}
+
+// setStatData records the stat information of the worktree file so later
+// checks can skip rehashing unchanged files.
+func (e *IndexEntry) setStatData(info os.FileInfo) {
+	e.MTimeSec, e.MTimeNsec = uint32(info.ModTime().Unix()), uint32(info.ModTime().Nanosecond())
+	e.CTimeSec, e.CTimeNsec = e.MTimeSec, e.MTimeNsec
+	e.Size = uint32(info.Size())
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		e.CTimeSec, e.CTimeNsec = uint32(st.Ctim.Sec), uint32(st.Ctim.Nsec)
+		e.Dev, e.Ino = uint32(st.Dev), uint32(st.Ino)
+		e.UID, e.GID = st.Uid, st.Gid
+	}
+}
+
+// statOptions holds core.trustctime and core.checkStat, loaded on first use.
+var statOptions *struct {
+	trustCtime bool
+	minimal    bool
+}
+
+func loadStatOptions() {
+	statOptions = &struct {
+		trustCtime bool
+		minimal    bool
+	}{trustCtime: true}
+	config, err := getConfig()
+	if err != nil {
+		return
+	}
+	if trust, err := config.GetBool("core.trustctime", true); err == nil {
+		statOptions.trustCtime = trust
+	}
+	if checkStat, ok := config.Get("core.checkStat"); ok {
+		statOptions.minimal = checkStat == "minimal"
+	}
+}
+
+// statMatches compares the recorded stat data with the worktree file, the
+// way git's ie_match_stat does. With core.checkStat=minimal only the size
+// and the whole seconds of the times are compared, and core.trustctime=false
+// ignores the ctime that tools like backup programs change.
+func (e *IndexEntry) statMatches(info os.FileInfo) bool {
+	if statOptions == nil {
+		loadStatOptions()
+	}
+	if e.Size != uint32(info.Size()) || e.MTimeSec != uint32(info.ModTime().Unix()) {
+		return false
+	}
+	if !statOptions.minimal && e.MTimeNsec != uint32(info.ModTime().Nanosecond()) {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	if statOptions.trustCtime {
+		if e.CTimeSec != uint32(st.Ctim.Sec) || !statOptions.minimal && e.CTimeNsec != uint32(st.Ctim.Nsec) {
+			return false
+		}
+	}
+	if !statOptions.minimal && (e.Ino != uint32(st.Ino) || e.UID != st.Uid || e.GID != st.Gid) {
+		return false
+	}
+	return true
+}
+
+// isRacy reports whether the entry was modified no earlier than the index
+// was written, so a change made right after could have the same stat data.
+func (index *Index) isRacy(e *IndexEntry) bool {
+	if index.Timestamp.IsZero() {
+		return false
+	}
+	mtime := time.Unix(int64(e.MTimeSec), int64(e.MTimeNsec))
+	return !mtime.Before(index.Timestamp)
+}
+
+func (index *Index) find(path string) int {
+	for i := range index.Entries {
+		if index.Entries[i].Path == path && index.Entries[i].Stage() == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// set replaces all stages of path with a single entry.
+func (index *Index) set(entry IndexEntry) {
+	index.remove(entry.Path)
+	index.Entries = append(index.Entries, entry)
+}
+
+func (index *Index) remove(path string) {
+	kept := index.Entries[:0]
+	for _, e := range index.Entries {
+		if e.Path != path {
+			kept = append(kept, e)
+		}
+	}
+	index.Entries = kept
+}
+
+// writeIndexTree writes the tree objects for sorted stage 0 entries below
+// prefix and returns the hash of the top one.
+func writeIndexTree(entries []IndexEntry, prefix string) (string, error) {
+	var content bytes.Buffer
+	for i := 0; i < len(entries); {
+		name := entries[i].Path[len(prefix):]
+		if slash := strings.IndexByte(name, '/'); slash != -1 {
+			dir := prefix + name[:slash+1]
+			end := i
+			for end < len(entries) && strings.HasPrefix(entries[end].Path, dir) {
+				end++
+			}
+			hash, err := writeIndexTree(entries[i:end], dir)
+			if err != nil {
+				return "", err
+			}
+			rawHash, _ := hex.DecodeString(hash)
+			fmt.Fprintf(&content, "%d %s\u0000", modeTree, name[:slash])
+			content.Write(rawHash)
+			i = end
+			continue
+		}
+		rawHash, err := hex.DecodeString(entries[i].Hash)
+		if err != nil {
+			return "", fmt.Errorf("index entry %s has invalid hash %s", entries[i].Path, entries[i].Hash)
+		}
+		fmt.Fprintf(&content, "%d %s\u0000", entries[i].Mode, name)
+		content.Write(rawHash)
+		i++
+	}
+	return writeObject(TypeTree, content.Bytes())
+}
+
+// readIndexHashes returns the object hashes referenced by the entries of the
+// index written by git, or nil when there is no index.
+func readIndexHashes() ([]string, error) {
+	index, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(index.Entries))
+	for _, e := range index.Entries {
+		hashes = append(hashes, e.Hash)
+	}
+	// the cache-tree extension references tree objects too
+	for _, ext := range index.Extensions {
+		if ext.Signature == "TREE" {
+			treeHashes, err := parseCacheTreeHashes(ext.Data)
+			if err != nil {
+				return nil, fmt.Errorf("index %s: %s", getIndexPath(), err.Error())
+			}
+			hashes = append(hashes, treeHashes...)
+		}
+	}
+	return hashes, nil
+}
+
+func parseCacheTreeHashes(data []byte) ([]string, error) {
+	// <path>\0<entry_count> <subtrees>\n[<20_byte_sha>], entry_count -1 means invalid
+	hashes := make([]string, 0)
+	for len(data) > 0 {
+		nulIdx := bytes.IndexByte(data, 0)
+		if nulIdx == -1 {
+			return nil, fmt.Errorf("bad cache-tree extension")
+		}
+		data = data[nulIdx+1:]
+		lineEnd := bytes.IndexByte(data, '\n')
+		if lineEnd == -1 {
+			return nil, fmt.Errorf("bad cache-tree extension")
+		}
+		valid := !bytes.HasPrefix(data, []byte("-"))
+		data = data[lineEnd+1:]
+		if valid {
+			if len(data) < 20 {
+				return nil, fmt.Errorf("bad cache-tree extension")
+			}
+			hashes = append(hashes, hex.EncodeToString(data[:20]))
+			data = data[20:]
+		}
+	}
+	return hashes, nil
+}
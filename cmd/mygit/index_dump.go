@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeIndexEntryDebug writes the stat data and flags of an index entry the
+// way ls-files --debug does. The flags are those of the entry with the
+// extended flags in the upper half and the path length left out.
+func writeIndexEntryDebug(w io.Writer, e *IndexEntry) {
+	flags := uint32(e.Flags&^0x0fff) | uint32(e.ExtendedFlags)<<16
+	fmt.Fprintf(w, "  ctime: %d:%d\n", e.CTimeSec, e.CTimeNsec)
+	fmt.Fprintf(w, "  mtime: %d:%d\n", e.MTimeSec, e.MTimeNsec)
+	fmt.Fprintf(w, "  dev: %d\tino: %d\n", e.Dev, e.Ino)
+	fmt.Fprintf(w, "  uid: %d\tgid: %d\n", e.UID, e.GID)
+	fmt.Fprintf(w, "  size: %d\tflags: %x\n", e.Size, flags)
+}
+
+// runIndexDump prints everything the index file holds: its version, each
+// entry with all of its fields, and the extensions, whose contents are
+// dumped as hex.
+func runIndexDump(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: mygit index-dump")
+	}
+	index, err := readIndex()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "version %d, %s\n", index.Version, plural(len(index.Entries), "entry", "entries"))
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		fmt.Fprintf(w, "%06o %s %d\t%s\n", treeModeToIndexMode(e.Mode), e.Hash, e.Stage(), quotePath(e.Path, false))
+		writeIndexEntryDebug(w, e)
+	}
+	for _, ext := range index.Extensions {
+		fmt.Fprintf(w, "extension %s, %s\n", ext.Signature, plural(len(ext.Data), "byte", "bytes"))
+		io.WriteString(w, hex.Dump(ext.Data))
+	}
+	return nil
+}
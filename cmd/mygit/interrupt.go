@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// commandContext is cancelled on the first SIGINT or SIGTERM. Long operations
+// check it between steps so they stop at a point where the repository is
+// consistent. A second signal, or a command that does not stop within
+// interruptGrace, exits straight away after removing lock and temp files.
+var commandContext, cancelCommand = context.WithCancel(context.Background())
+
+var errInterrupted = errors.New("interrupted")
+
+// interruptGrace is how long a cancelled command gets to reach a checkpoint,
+// as one blocked on a prompt or a child process never does.
+const interruptGrace = time.Second
+
+// interruptSignal is the signal that cancelled the command.
+var interruptSignal = syscall.SIGINT
+
+// interruptExitCode is what a shell reports for a process killed by the
+// signal.
+func interruptExitCode() int {
+	return 128 + int(interruptSignal)
+}
+
+func watchInterrupts() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		interruptSignal = (<-signals).(syscall.Signal)
+		cancelCommand()
+		select {
+		case <-signals:
+		case <-time.After(interruptGrace):
+		}
+		exit(interruptExitCode())
+	}()
+}
+
+// checkInterrupted returns errInterrupted once the command was cancelled.
+func checkInterrupted() error {
+	if commandContext.Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
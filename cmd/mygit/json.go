@@ -0,0 +1,16 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonOutput is set by the global --json option. Plumbing commands then
+// write one JSON object per line instead of their text format.
+var jsonOutput bool
+
+func writeJSONLine(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// staleLockAge is how old a lock file has to be before the error for it
+// suggests that it was left behind rather than held by a running process.
+const staleLockAge = 10 * time.Minute
+
+// tempFiles are the lock and temporary files this process has created but
+// not yet renamed into place. exit removes whatever is left, including when
+// the command is stopped by a signal. A directory goes with everything
+// staged in it.
+var tempFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+func registerTempFile(path string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	tempFiles.paths[path] = true
+}
+
+func forgetTempFile(path string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	delete(tempFiles.paths, path)
+}
+
+func removeTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for path := range tempFiles.paths {
+		os.RemoveAll(path)
+		delete(tempFiles.paths, path)
+	}
+}
+
+// createTempFile creates a registered temporary file in dir, to be renamed
+// over its target once complete.
+func createTempFile(dir string, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	registerTempFile(f.Name())
+	return f, nil
+}
+
+// lockFile is an exclusively created <path>.lock. The new content is
+// written to it and it is renamed over path on commit. Without a file it
+// holds nothing, as for an index kept in memory.
+type lockFile struct {
+	Path string
+	file *os.File
+	done bool
+}
+
+func lockFilePath(path string) string {
+	return path + ".lock"
+}
+
+// acquireLock creates the lock file for path, failing if another process
+// holds it.
+func acquireLock(path string) (*lockFile, error) {
+	return acquireLockTimeout(path, 0)
+}
+
+// acquireLockTimeout keeps trying to take a lock held by another process
+// for up to timeout, backing off exponentially with some jitter like git's
+// lock_file_timeout.
+func acquireLockTimeout(path string, timeout time.Duration) (*lockFile, error) {
+	lockPath := lockFilePath(path)
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			registerTempFile(lockPath)
+			return &lockFile{Path: path, file: f}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to create '%s': %s", lockPath, err.Error())
+		}
+		if !time.Now().Before(deadline) {
+			return nil, lockExistsError(lockPath)
+		}
+		// wait between 0.75 and 1.25 times the backoff
+		wait := backoff*3/4 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		time.Sleep(min(wait, time.Until(deadline)))
+		backoff = min(backoff*2, time.Second)
+	}
+}
+
+// lockExistsError explains a lock held by someone else the way git does,
+// pointing out when the lock looks like it was left by a crashed process.
+func lockExistsError(lockPath string) error {
+	if abs, err := filepath.Abs(lockPath); err == nil {
+		lockPath = abs
+	}
+	message := fmt.Sprintf("Unable to create '%s': File exists.\n\n", lockPath)
+	info, err := os.Stat(lockPath)
+	if err == nil && time.Since(info.ModTime()) > staleLockAge {
+		message += fmt.Sprintf("The lock file was last modified %s ago, so it was most\n"+
+			"likely left behind by a git process that crashed or was killed.\n"+
+			"Make sure no git process is running, then remove the file to continue.", time.Since(info.ModTime()).Round(time.Minute))
+		return fmt.Errorf("%s", message)
+	}
+	message += "Another git process seems to be running in this repository, e.g.\n" +
+		"an editor opened by 'git commit'. Please make sure all processes\n" +
+		"are terminated then try again. If it still fails, a git process\n" +
+		"may have crashed in this repository earlier:\n" +
+		"remove the file manually to continue."
+	return fmt.Errorf("%s", message)
+}
+
+func (l *lockFile) Write(data []byte) (int, error) {
+	return l.file.Write(data)
+}
+
+// replace writes data over whatever was written to the lock file so far.
+func (l *lockFile) replace(data []byte) error {
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.Write(data)
+	return err
+}
+
+// commit renames the lock file over its target.
+func (l *lockFile) commit() error {
+	if l.done {
+		return fmt.Errorf("lock for %s already released", l.Path)
+	}
+	l.done = true
+	lockPath := lockFilePath(l.Path)
+	err := l.file.Close()
+	if err == nil {
+		err = os.Rename(lockPath, l.Path)
+	}
+	if err != nil {
+		os.Remove(lockPath)
+	}
+	forgetTempFile(lockPath)
+	return err
+}
+
+// rollback drops the lock, leaving the target untouched. It does nothing
+// once the lock was committed, so it can be deferred.
+func (l *lockFile) rollback() {
+	if l.done {
+		return
+	}
+	l.done = true
+	if l.file == nil {
+		return
+	}
+	lockPath := lockFilePath(l.Path)
+	l.file.Close()
+	os.Remove(lockPath)
+	forgetTempFile(lockPath)
+}
+
+// writeFileAtomic writes through a .lock file that is renamed over the target.
+func writeFileAtomic(filename string, data []byte) error {
+	lock, err := acquireLock(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := lock.Write(data); err != nil {
+		lock.rollback()
+		return err
+	}
+	return lock.commit()
+}
@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type logOptions struct {
+	Patch bool
+	Raw   bool
+	// NoPatch is -s, turning off the diffs show gives by default
+	NoPatch bool
+	// Check reports the whitespace errors each diff adds, setting
+	// WhitespaceErrors when there are any
+	Check            bool
+	WhitespaceErrors bool
+	// DiffFlags loosen how lines are compared in patches
+	DiffFlags diffFlags
+	// WordDiff is the --word-diff mode and WordRegex the regex for words,
+	// "" when not given; Words is set up from them for word diffs
+	WordDiff  string
+	WordRegex string
+	Words     *wordDiff
+	// NoTextconv is --no-textconv, diffing files as stored even when
+	// their diff driver has a textconv command
+	NoTextconv bool
+	// Renames pairs deleted and added files into renames. It is nil until
+	// set by -M, --no-renames or else diff.renames
+	Renames *bool
+	// SkipEmpty leaves out the commits without a diff to show, as
+	// whatchanged does
+	SkipEmpty  bool
+	MergeDiffs bool
+	// Combined shows merges against all parents at once, leaving out the
+	// hunks taken from one parent when Dense
+	Combined    bool
+	Dense       bool
+	FirstParent bool
+	Sort        revSort
+	Reverse     bool
+	// Follow continues the history of a single file under its old name
+	// when a commit renamed it
+	Follow   bool
+	MaxCount int
+	// Oneline shows each commit as its abbreviated hash and subject
+	Oneline bool
+	// Since and Until limit the commits to those committed in between,
+	// ignoring either when zero
+	Since     time.Time
+	Until     time.Time
+	Revisions []string
+	// Pathspecs limits the commits to those changing the paths, and their
+	// diffs to the changes to them
+	Pathspecs pathspec
+	// UseMailmap is nil unless --use-mailmap or --no-use-mailmap is given
+	UseMailmap *bool
+	Mailmap    mailmap
+	// Color is the --color value, "" when not given
+	Color  string
+	Colors colorPalette
+	// Date is the --date mode, "" when not given, and Dates the mode used
+	Date  string
+	Dates dateMode
+	// Decorate is the --decorate mode, "" when not given
+	Decorate    string
+	Decorations *decorations
+}
+
+func parseLogArgs(args []string) (*logOptions, error) {
+	opts := &logOptions{MaxCount: -1}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-p" || arg == "-u" || arg == "--patch":
+			opts.Patch = true
+		case arg == "--raw":
+			opts.Raw = true
+		case arg == "-s" || arg == "--no-patch":
+			opts.NoPatch = true
+		case arg == "--check":
+			opts.Check = true
+		case arg == "-w" || arg == "--ignore-all-space":
+			opts.DiffFlags |= diffIgnoreAllSpace
+		case arg == "-b" || arg == "--ignore-space-change":
+			opts.DiffFlags |= diffIgnoreSpaceChange
+		case arg == "--ignore-blank-lines":
+			opts.DiffFlags |= diffIgnoreBlankLines
+		case arg == "--textconv" || arg == "--no-textconv":
+			opts.NoTextconv = arg == "--no-textconv"
+		case arg == "--word-diff" || strings.HasPrefix(arg, "--word-diff="):
+			opts.WordDiff = "plain"
+			if mode, found := strings.CutPrefix(arg, "--word-diff="); found {
+				if _, err := parseWordDiffMode(mode); err != nil {
+					return nil, err
+				}
+				opts.WordDiff = mode
+			}
+		case strings.HasPrefix(arg, "--word-diff-regex="):
+			opts.WordRegex = strings.TrimPrefix(arg, "--word-diff-regex=")
+			if opts.WordDiff == "" {
+				opts.WordDiff = "plain"
+			}
+		case arg == "--color-words" || strings.HasPrefix(arg, "--color-words="):
+			opts.WordDiff = "color"
+			if regex, found := strings.CutPrefix(arg, "--color-words="); found {
+				opts.WordRegex = regex
+			}
+		case arg == "-M" || arg == "--find-renames" || arg == "--no-renames":
+			renames := arg != "--no-renames"
+			opts.Renames = &renames
+		case arg == "-m":
+			opts.MergeDiffs = true
+		case arg == "-c" || arg == "--cc":
+			opts.Combined, opts.Dense = true, arg == "--cc"
+		case arg == "--topo-order":
+			opts.Sort = sortTopo
+		case arg == "--date-order":
+			opts.Sort = sortDate
+		case arg == "--reverse":
+			opts.Reverse = true
+		case arg == "--oneline":
+			opts.Oneline = true
+		case arg == "--decorate" || arg == "--no-decorate":
+			opts.Decorate = decorateShort
+			if arg == "--no-decorate" {
+				opts.Decorate = decorateNo
+			}
+		case strings.HasPrefix(arg, "--decorate="):
+			mode, err := parseDecorateMode(strings.TrimPrefix(arg, "--decorate="))
+			if err != nil {
+				return nil, err
+			}
+			opts.Decorate = mode
+		case strings.HasPrefix(arg, "--date="):
+			opts.Date = strings.TrimPrefix(arg, "--date=")
+			if _, err := parseDateMode(opts.Date); err != nil {
+				return nil, err
+			}
+		case arg == "--follow":
+			opts.Follow = true
+		case arg == "--first-parent":
+			opts.FirstParent = true
+		case arg == "--use-mailmap" || arg == "--mailmap" || arg == "--no-use-mailmap" || arg == "--no-mailmap":
+			use := !strings.HasPrefix(arg, "--no-")
+			opts.UseMailmap = &use
+		case arg == "--color" || arg == "--no-color" || strings.HasPrefix(arg, "--color="):
+			when, err := parseColorFlag(arg)
+			if err != nil {
+				return nil, err
+			}
+			opts.Color = when
+		case arg == "-n" || arg == "--max-count":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("bad count %s", args[i])
+			}
+			opts.MaxCount = n
+		case strings.HasPrefix(arg, "-n") && isDigits(arg[2:]):
+			opts.MaxCount, _ = strconv.Atoi(arg[2:])
+		case strings.HasPrefix(arg, "--max-count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+			if err != nil {
+				return nil, fmt.Errorf("bad count %s", arg)
+			}
+			opts.MaxCount = n
+		case arg == "--since" || arg == "--after" || arg == "--until" || arg == "--before" ||
+			strings.HasPrefix(arg, "--since=") || strings.HasPrefix(arg, "--after=") ||
+			strings.HasPrefix(arg, "--until=") || strings.HasPrefix(arg, "--before="):
+			name, value, found := strings.Cut(arg, "=")
+			if !found {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("option %s requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			date, err := approxidate(value, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			if name == "--since" || name == "--after" {
+				opts.Since = date
+			} else {
+				opts.Until = date
+			}
+		case len(arg) > 1 && arg[0] == '-' && isDigits(arg[1:]):
+			opts.MaxCount, _ = strconv.Atoi(arg[1:])
+		case arg == "--":
+			pathspecs, err := parsePathspecs(args[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			opts.Pathspecs = pathspecs
+			i = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-" && !strings.HasPrefix(arg, "^"):
+			return nil, fmt.Errorf("unknown option %s", arg)
+		default:
+			opts.Revisions = append(opts.Revisions, arg)
+		}
+	}
+	if len(opts.Revisions) == 0 {
+		if branch, unborn := unbornBranch(); unborn {
+			return nil, errUnbornBranch(branch)
+		}
+		opts.Revisions = []string{"HEAD"}
+	}
+	// -c and --cc show patches unless another format is asked for
+	if opts.Combined && !opts.Raw && !opts.Check {
+		opts.Patch = true
+	}
+	if opts.NoPatch {
+		opts.Patch, opts.Raw, opts.Check = false, false, false
+	}
+	if opts.Follow && len(opts.Pathspecs) != 1 {
+		return nil, fmt.Errorf("--follow requires exactly one pathspec")
+	}
+	return opts, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func formatLogDate(ident Identity) string {
+	return formatDate(ident.When, dateMode{})
+}
+
+func writeCommitHeader(w io.Writer, commit *Commit, fromParent string, decorated *decorations, mm mailmap, colors colorPalette, dates dateMode) {
+	commit = reencodeCommit(commit, logOutputEncoding())
+	if fromParent != "" {
+		fmt.Fprintln(w, colors.paint("commit", fmt.Sprintf("commit %s (from %s)", commit.Hash, fromParent))+decorated.format(commit.Hash))
+	} else {
+		fmt.Fprintln(w, colors.paint("commit", "commit "+commit.Hash)+decorated.format(commit.Hash))
+	}
+	if len(commit.Parents) > 1 {
+		abbrevs := make([]string, 0, len(commit.Parents))
+		for _, p := range commit.Parents {
+			abbrevs = append(abbrevs, abbrevHash(p))
+		}
+		fmt.Fprintf(w, "Merge: %s\n", strings.Join(abbrevs, " "))
+	}
+	name, email := mm.lookup(commit.Author.Name, commit.Author.Email)
+	fmt.Fprintf(w, "Author: %s <%s>\n", name, email)
+	fmt.Fprintf(w, "Date:   %s\n", formatDate(commit.Author.When, dates))
+	fmt.Fprintln(w)
+
+	message := strings.TrimRight(commit.Message, "\n")
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// writeOnelineHeader shows a commit on one line, as --oneline does.
+func writeOnelineHeader(w io.Writer, commit *Commit, fromParent string, decorated *decorations, colors colorPalette) {
+	commit = reencodeCommit(commit, logOutputEncoding())
+	header := abbrevHash(commit.Hash)
+	if fromParent != "" {
+		header += fmt.Sprintf(" (from %s)", abbrevHash(fromParent))
+	}
+	fmt.Fprintf(w, "%s%s %s\n", colors.paint("commit", header), decorated.format(commit.Hash), commit.Subject())
+}
+
+func writeCommitDiff(w io.Writer, opts *logOptions, parentTree string, tree string) error {
+	changes, err := diffTrees(parentTree, tree, "")
+	if err != nil {
+		return err
+	}
+	if opts.Follow {
+		if changes, err = findRenames(changes, opts.Pathspecs); err != nil {
+			return err
+		}
+	}
+	changes = filterChanges(changes, opts.Pathspecs)
+	if opts.Renames != nil && *opts.Renames && !opts.Follow {
+		if changes, err = findRenames(changes, nil); err != nil {
+			return err
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	// files only changed in ignored ways get no patch, not even a header
+	patches := changes
+	if opts.Patch && opts.DiffFlags != 0 {
+		if patches, err = dropIgnoredChanges(changes, opts.DiffFlags); err != nil {
+			return err
+		}
+	}
+	if !opts.Oneline {
+		fmt.Fprintln(w)
+	}
+	if opts.Raw {
+		writeRawDiff(w, changes)
+	}
+	if opts.Check {
+		found, err := writeWhitespaceCheck(w, changes, opts.Colors)
+		if err != nil {
+			return err
+		}
+		opts.WhitespaceErrors = opts.WhitespaceErrors || found
+	}
+	if (opts.Raw || opts.Check) && opts.Patch {
+		fmt.Fprintln(w)
+	}
+	if opts.Patch {
+		return writePatch(w, patches, opts.Colors, patchOptions{Flags: opts.DiffFlags, Words: opts.Words, Textconv: !opts.NoTextconv})
+	}
+	return nil
+}
+
+// parentDiffs returns the parents a commit's diff is shown against: none
+// for merges unless -m or --first-parent is given.
+func parentDiffs(opts *logOptions, commit *Commit) []string {
+	if len(commit.Parents) == 0 {
+		return []string{""}
+	}
+	if opts.FirstParent {
+		return commit.Parents[:1]
+	}
+	if len(commit.Parents) == 1 || opts.MergeDiffs {
+		return commit.Parents
+	}
+	return nil
+}
+
+// changesPaths reports whether a commit is shown when limiting to paths: it
+// changes them compared to each of its parents. A merge taking them from one
+// of its parents is left out, like git's default history simplification
+// without following only that parent.
+func changesPaths(opts *logOptions, commit *Commit) (bool, error) {
+	parents := commit.Parents
+	if len(parents) == 0 {
+		parents = []string{""}
+	}
+	for _, parent := range parents {
+		parentTree := ""
+		if parent != "" {
+			parentCommit, err := readCommit(parent)
+			if err != nil {
+				return false, err
+			}
+			parentTree = parentCommit.Tree
+		}
+		changes, err := diffTrees(parentTree, commit.Tree, "")
+		if err != nil {
+			return false, err
+		}
+		if len(filterChanges(changes, opts.Pathspecs)) == 0 {
+			return false, nil
+		}
+		if opts.FirstParent {
+			break
+		}
+	}
+	return true, nil
+}
+
+// hasLogDiff reports whether the log entry of a commit shows a diff.
+func hasLogDiff(opts *logOptions, commit *Commit) (bool, error) {
+	parents := parentDiffs(opts, commit)
+	if len(parents) == 0 && opts.Combined {
+		parentTrees := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parentTree, err := commitTreeHash(parent)
+			if err != nil {
+				return false, err
+			}
+			parentTrees[i] = parentTree
+		}
+		combined, err := combinedPaths(commit.Tree, parentTrees, opts.Pathspecs)
+		return len(combined) > 0, err
+	}
+	for _, parent := range parents {
+		parentTree := ""
+		if parent != "" {
+			var err error
+			if parentTree, err = commitTreeHash(parent); err != nil {
+				return false, err
+			}
+		}
+		changes, err := diffTrees(parentTree, commit.Tree, "")
+		if err != nil {
+			return false, err
+		}
+		if len(filterChanges(changes, opts.Pathspecs)) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// followRenames switches the path --follow tracks to its old name when the
+// commit renamed it, so older commits are matched under that name.
+func followRenames(opts *logOptions, commit *Commit) error {
+	if len(commit.Parents) == 0 {
+		return nil
+	}
+	parent, err := readCommit(commit.Parents[0])
+	if err != nil {
+		return err
+	}
+	changes, err := diffTrees(parent.Tree, commit.Tree, "")
+	if err != nil {
+		return err
+	}
+	if changes, err = findRenames(changes, opts.Pathspecs); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if c.Status == 'R' && opts.Pathspecs.matches(c.Path) {
+			opts.Pathspecs = pathspec{{Original: c.OldPath, Pattern: c.OldPath, Literal: true}}
+			return nil
+		}
+	}
+	return nil
+}
+
+func writeLogEntry(w io.Writer, opts *logOptions, commit *Commit, first bool) error {
+	showDiff := opts.Patch || opts.Raw || opts.Check
+	parents := []string{""}
+	if showDiff {
+		parents = parentDiffs(opts, commit)
+	}
+	writeHeader := func(fromParent string) {
+		if opts.Oneline {
+			writeOnelineHeader(w, commit, fromParent, opts.Decorations, opts.Colors)
+			return
+		}
+		writeCommitHeader(w, commit, fromParent, opts.Decorations, opts.Mailmap, opts.Colors, opts.Dates)
+	}
+	if showDiff && len(parents) == 0 && opts.Combined {
+		if !first && !opts.Oneline {
+			fmt.Fprintln(w)
+		}
+		writeHeader("")
+		parentTrees := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parentCommit, err := readCommit(parent)
+			if err != nil {
+				return err
+			}
+			parentTrees[i] = parentCommit.Tree
+		}
+		return writeCombinedDiff(w, opts, commit.Tree, parentTrees)
+	}
+	if len(parents) == 0 {
+		parents = []string{""}
+		showDiff = false
+	}
+
+	for i, parent := range parents {
+		if (!first || i > 0) && !opts.Oneline {
+			fmt.Fprintln(w)
+		}
+		fromParent := ""
+		if showDiff && len(parents) > 1 {
+			fromParent = parent
+		}
+		writeHeader(fromParent)
+		if !showDiff {
+			continue
+		}
+
+		parentTree := ""
+		if parent != "" {
+			parentCommit, err := readCommit(parent)
+			if err != nil {
+				return err
+			}
+			parentTree = parentCommit.Tree
+		}
+		if err := writeCommitDiff(w, opts, parentTree, commit.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLog calls visit with the commits the options select, in the order
+// they are shown: sorted by --topo-order or --date-order, limited by the
+// paths and --max-count, and reversed last by --reverse. With --follow, the
+// pathspec is the path at the commit when visit runs.
+func walkLog(opts *logOptions, visit func(commit *Commit, first bool) error) error {
+	include, exclude, err := parseRevisionArgs(opts.Revisions)
+	if err != nil {
+		return err
+	}
+	walk, err := newRevWalk(include, exclude)
+	if err != nil {
+		return err
+	}
+	walk.FirstParent, walk.Sort = opts.FirstParent, opts.Sort
+	walk.Since, walk.Until = opts.Since, opts.Until
+
+	type logCommit struct {
+		commit    *Commit
+		pathspecs pathspec
+	}
+	reversed := make([]logCommit, 0)
+	for n := 0; opts.MaxCount < 0 || n < opts.MaxCount; n++ {
+		commit, err := walk.Next()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		if len(opts.Pathspecs) > 0 {
+			shown, err := changesPaths(opts, commit)
+			if err != nil {
+				return err
+			}
+			if !shown {
+				n--
+				continue
+			}
+		}
+		if opts.SkipEmpty {
+			shown, err := hasLogDiff(opts, commit)
+			if err != nil {
+				return err
+			}
+			if !shown {
+				n--
+				continue
+			}
+		}
+		if opts.Reverse {
+			reversed = append(reversed, logCommit{commit, opts.Pathspecs})
+		} else if err := visit(commit, n == 0); err != nil {
+			return err
+		}
+		if opts.Follow {
+			if err := followRenames(opts, commit); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(reversed) - 1; i >= 0; i-- {
+		opts.Pathspecs = reversed[i].pathspecs
+		if err := visit(reversed[i].commit, i == len(reversed)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupLogOutput loads what showing commits needs beyond the options: the
+// mailmap, colors, decorations and rename detection.
+func setupLogOutput(opts *logOptions) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	useMailmap, err := config.GetBool("log.mailmap", true)
+	if err != nil {
+		return err
+	}
+	if opts.UseMailmap != nil {
+		useMailmap = *opts.UseMailmap
+	}
+	if useMailmap {
+		if opts.Mailmap, err = readMailmap(); err != nil {
+			return err
+		}
+	}
+	// a word diff in color has nothing else to mark the words with
+	if opts.WordDiff == "color" && opts.Color == "" {
+		opts.Color = "always"
+	}
+	if opts.Colors, err = loadColorPalette("diff", diffColorDefaults, opts.Color); err != nil {
+		return err
+	}
+	if opts.WordDiff != "" {
+		style, err := parseWordDiffMode(opts.WordDiff)
+		if err != nil {
+			return err
+		}
+		if opts.WordRegex == "" {
+			opts.WordRegex, _ = config.Get("diff.wordRegex")
+		}
+		if style != nil {
+			opts.Words = &wordDiff{Style: style}
+			if opts.WordRegex != "" {
+				if opts.Words.Regex, err = compileWordRegex(opts.WordRegex); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if opts.Date == "" {
+		opts.Date, _ = config.Get("log.date")
+	}
+	if opts.Date != "" {
+		if opts.Dates, err = parseDateMode(opts.Date); err != nil {
+			return err
+		}
+	}
+	mode, err := decorateMode(config, opts.Decorate)
+	if err != nil {
+		return err
+	}
+	if mode != decorateNo {
+		if opts.Decorations, err = loadDecorations(mode, opts.Colors); err != nil {
+			return err
+		}
+	}
+	if opts.Renames == nil {
+		// diff.renames may also ask for copies, which are found as renames
+		renames := true
+		if value, ok := config.Get("diff.renames"); ok && value != "copies" && value != "copy" {
+			if renames, err = parseConfigBool(value); err != nil {
+				return fmt.Errorf("bad boolean config value '%s' for 'diff.renames'", value)
+			}
+		}
+		opts.Renames = &renames
+	}
+	return nil
+}
+
+func runLog(args []string) error {
+	opts, err := parseLogArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := setupLogOutput(opts); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	err = walkLog(opts, func(commit *Commit, first bool) error {
+		return writeLogEntry(w, opts, commit, first)
+	})
+	return checkStatus(opts, err)
+}
+
+// checkStatus fails a command with --check quietly with status 2 when its
+// diffs added whitespace errors, as git does.
+func checkStatus(opts *logOptions, err error) error {
+	if err == nil && opts.WhitespaceErrors {
+		return exitStatus(2)
+	}
+	return err
+}
+
+// runWhatchanged is log showing the files each commit changed, leaving out
+// the commits that change none, merges among them.
+func runWhatchanged(args []string) error {
+	opts, err := parseLogArgs(args)
+	if err != nil {
+		return err
+	}
+	if !opts.Patch && !opts.NoPatch && !opts.Check {
+		opts.Raw = true
+	}
+	opts.SkipEmpty = true
+	if err := setupLogOutput(opts); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	err = walkLog(opts, func(commit *Commit, first bool) error {
+		return writeLogEntry(w, opts, commit, first)
+	})
+	return checkStatus(opts, err)
+}
+
+// runRevList lists the commits log would show, one hash per line.
+func runRevList(args []string) error {
+	count, leftRight := false, false
+	// the rest are the options rev-list shares with log
+	logArgs := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			logArgs = append(logArgs, args[i:]...)
+			break
+		}
+		switch arg {
+		case "--count":
+			count = true
+		case "--left-right":
+			leftRight = true
+		default:
+			logArgs = append(logArgs, arg)
+		}
+	}
+	opts, err := parseLogArgs(logArgs)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	// a lone A...B is counted by walking both sides together
+	if left, right, ok := parseSymmetricRange(opts.Revisions[0]); ok && count && leftRight &&
+		len(opts.Revisions) == 1 && len(opts.Pathspecs) == 0 && !opts.FirstParent && opts.MaxCount < 0 &&
+		opts.Since.IsZero() && opts.Until.IsZero() {
+		leftHash, err := resolveCommitRevision(left)
+		if err != nil {
+			return err
+		}
+		rightHash, err := resolveCommitRevision(right)
+		if err != nil {
+			return err
+		}
+		ahead, behind, err := countAheadBehind(leftHash, rightHash)
+		if err != nil {
+			return err
+		}
+		return writeRevListCount(w, ahead, behind, true)
+	}
+
+	var leftSide map[string]bool
+	if leftRight {
+		if leftSide, err = leftSideCommits(opts.Revisions); err != nil {
+			return err
+		}
+	}
+	leftCount, rightCount := 0, 0
+	err = walkLog(opts, func(commit *Commit, first bool) error {
+		isLeft := leftSide[commit.Hash]
+		if isLeft {
+			leftCount++
+		} else {
+			rightCount++
+		}
+		if count {
+			return nil
+		}
+		if jsonOutput {
+			record := map[string]string{"oid": commit.Hash}
+			if leftRight {
+				record["side"] = "right"
+				if isLeft {
+					record["side"] = "left"
+				}
+			}
+			return writeJSONLine(w, record)
+		}
+		if leftRight {
+			mark := ">"
+			if isLeft {
+				mark = "<"
+			}
+			_, err := fmt.Fprintln(w, mark+commit.Hash)
+			return err
+		}
+		_, err := fmt.Fprintln(w, commit.Hash)
+		return err
+	})
+	if err != nil || !count {
+		return err
+	}
+	if !leftRight {
+		return writeRevListCount(w, leftCount+rightCount, 0, false)
+	}
+	return writeRevListCount(w, leftCount, rightCount, true)
+}
+
+// leftSideCommits returns the commits of a walk over revisions that come
+// from the left side of an A...B range, which --left-right marks with "<".
+func leftSideCommits(revisions []string) (map[string]bool, error) {
+	tips := make([]string, 0, 1)
+	for _, arg := range revisions {
+		if left, _, ok := parseSymmetricRange(arg); ok && !strings.HasPrefix(arg, "^") {
+			hash, err := resolveCommitRevision(left)
+			if err != nil {
+				return nil, err
+			}
+			tips = append(tips, hash)
+		}
+	}
+	leftSide := map[string]bool{}
+	if len(tips) == 0 {
+		return leftSide, nil
+	}
+	_, exclude, err := parseRevisionArgs(revisions)
+	if err != nil {
+		return nil, err
+	}
+	walk, err := newRevWalk(tips, exclude)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
+			return leftSide, nil
+		}
+		leftSide[commit.Hash] = true
+	}
+}
+
+// writeRevListCount prints what rev-list --count found, split into left and
+// right with --left-right.
+func writeRevListCount(w io.Writer, left int, right int, leftRight bool) error {
+	if jsonOutput && leftRight {
+		return writeJSONLine(w, map[string]int{"left": left, "right": right})
+	}
+	if jsonOutput {
+		return writeJSONLine(w, map[string]int{"count": left})
+	}
+	if leftRight {
+		_, err := fmt.Fprintf(w, "%d\t%d\n", left, right)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d\n", left)
+	return err
+}
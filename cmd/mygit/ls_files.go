@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+const lsFilesUsage = "mygit ls-files [--eol] [--debug] [--] [<pathspec>...]"
+
+// eolStats counts the line endings and characters of a file the way git
+// decides whether it is text.
+type eolStats struct {
+	LoneCR, LoneLF, CRLF    int
+	NUL                     int
+	Printable, Nonprintable int
+}
+
+func gatherEOLStats(data []byte) eolStats {
+	var s eolStats
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				s.CRLF++
+				i++
+			} else {
+				s.LoneCR++
+			}
+		case c == '\n':
+			s.LoneLF++
+		case c == 127:
+			s.Nonprintable++
+		case c == 0:
+			s.NUL++
+			s.Nonprintable++
+		case c < 32 && c != '\b' && c != '\t' && c != '\033' && c != '\014':
+			s.Nonprintable++
+		default:
+			s.Printable++
+		}
+	}
+	// a DOS end-of-file marker does not make a file binary
+	if len(data) > 0 && data[len(data)-1] == '\032' {
+		s.Nonprintable--
+	}
+	return s
+}
+
+// eolClass classifies content as ls-files --eol shows it: "lf", "crlf" or
+// "mixed" line endings, "none" without any, or "-text" when it is binary.
+func eolClass(data []byte) string {
+	if len(data) == 0 {
+		return "none"
+	}
+	s := gatherEOLStats(data)
+	switch {
+	case s.LoneCR > 0 || s.NUL > 0 || s.Printable>>7 < s.Nonprintable:
+		return "-text"
+	case s.CRLF > 0 && s.LoneLF > 0:
+		return "mixed"
+	case s.CRLF > 0:
+		return "crlf"
+	case s.LoneLF > 0:
+		return "lf"
+	}
+	return "none"
+}
+
+// eolAttributes describes the text, crlf and eol attributes of a path as
+// they decide its line ending conversion, "" when none applies.
+func eolAttributes(path string) (string, error) {
+	text, err := getAttribute(path, "text")
+	if err != nil {
+		return "", err
+	}
+	if text == attrUnspecified {
+		if text, err = getAttribute(path, "crlf"); err != nil {
+			return "", err
+		}
+	}
+	if text == attrUnspecified {
+		// the built-in binary macro unsets text
+		if binary, err := getAttribute(path, "binary"); err != nil {
+			return "", err
+		} else if binary == attrSet {
+			text = attrUnset
+		}
+	}
+	action := ""
+	switch text {
+	case attrSet:
+		action = "text"
+	case attrUnset:
+		return "-text", nil
+	case "input":
+		action = "text eol=lf"
+	case "auto":
+		action = "text=auto"
+	}
+
+	eol, err := getAttribute(path, "eol")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case action == "text=auto" && (eol == "lf" || eol == "crlf"):
+		action += " eol=" + eol
+	case eol == "lf" || eol == "crlf":
+		action = "text eol=" + eol
+	}
+	return action, nil
+}
+
+// runLsFiles lists the paths in the index. With --eol each is preceded by
+// the line endings of its staged and worktree content and the attributes
+// that would convert them, and with --debug it is followed by the raw
+// fields of its entry.
+func runLsFiles(args []string) error {
+	eol, debug := false, false
+	flags := newFlagSet()
+	flags.Bool(&eol, "--eol")
+	flags.Bool(&debug, "--debug")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	// like git, only the directory the command was started in is listed
+	// without a pathspec
+	if len(args) == 0 && worktreePrefix != "" {
+		args = []string{"."}
+	}
+	paths, err := parsePathspecs(args)
+	if err != nil {
+		return err
+	}
+	index, err := readIndex()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, e := range index.Entries {
+		if !paths.matches(e.Path) {
+			continue
+		}
+		if eol {
+			staged, worktree := "", ""
+			if e.Mode != modeSymlink && e.Mode != modeGitlink {
+				object, err := parseObject(e.Hash)
+				if err != nil {
+					return err
+				}
+				staged = eolClass(object.Content)
+			}
+			if info, err := os.Lstat(e.Path); err == nil && info.Mode().IsRegular() {
+				data, err := os.ReadFile(e.Path)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %s", e.Path, err.Error())
+				}
+				worktree = eolClass(data)
+			}
+			attrs, err := eolAttributes(e.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "i/%-5s w/%-5s attr/%-17s\t", staged, worktree, attrs)
+		}
+		fmt.Fprintln(w, quotePath(relativePath(e.Path), false))
+		if debug {
+			writeIndexEntryDebug(w, &e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type mailmapEntry struct {
+	Name  string
+	Email string
+	// CommitName is empty when the entry applies to any name for the email
+	CommitName string
+}
+
+// mailmap maps the lowercased commit email to its canonical identities.
+type mailmap map[string][]mailmapEntry
+
+// parseMailmapLine reads "Proper Name <proper@email> Commit Name <commit@email>"
+// where everything except the last email is optional.
+func parseMailmapLine(line string) (mailmapEntry, string, bool) {
+	if hash := strings.IndexByte(line, '#'); hash != -1 {
+		line = line[:hash]
+	}
+	var names, emails []string
+	for {
+		start := strings.IndexByte(line, '<')
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(line[start:], '>')
+		if end == -1 {
+			break
+		}
+		names = append(names, strings.TrimSpace(line[:start]))
+		emails = append(emails, line[start+1:start+end])
+		line = line[start+end+1:]
+	}
+	switch len(emails) {
+	case 1:
+		return mailmapEntry{Name: names[0]}, emails[0], true
+	case 2:
+		return mailmapEntry{Name: names[0], Email: emails[0], CommitName: names[1]}, emails[1], true
+	}
+	return mailmapEntry{}, "", false
+}
+
+func (m mailmap) parse(content string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		entry, commitEmail, ok := parseMailmapLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(commitEmail)
+		entries := m[key]
+		replaced := false
+		for i := range entries {
+			if strings.EqualFold(entries[i].CommitName, entry.CommitName) {
+				// later lines fill in what earlier ones left out
+				if entry.Name != "" {
+					entries[i].Name = entry.Name
+				}
+				if entry.Email != "" {
+					entries[i].Email = entry.Email
+				}
+				replaced = true
+			}
+		}
+		if !replaced {
+			m[key] = append(entries, entry)
+		}
+	}
+}
+
+// readMailmap loads the .mailmap at the top of the worktree followed by the
+// file named by mailmap.file, whose entries take precedence.
+func readMailmap() (mailmap, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{".mailmap"}
+	if file, ok := config.Get("mailmap.file"); ok && file != "" {
+		if strings.HasPrefix(file, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			file = filepath.Join(home, file[2:])
+		}
+		files = append(files, file)
+	}
+
+	m := mailmap{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mailmap %s: %s", file, err.Error())
+		}
+		m.parse(string(content))
+	}
+	return m, nil
+}
+
+// lookup returns the canonical name and email of an identity, preferring an
+// entry for the exact commit name over one for the email alone.
+func (m mailmap) lookup(name string, email string) (string, string) {
+	var match *mailmapEntry
+	entries := m[strings.ToLower(email)]
+	for i := range entries {
+		if entries[i].CommitName == "" && match == nil {
+			match = &entries[i]
+		}
+		if entries[i].CommitName != "" && strings.EqualFold(entries[i].CommitName, name) {
+			match = &entries[i]
+			break
+		}
+	}
+	if match == nil {
+		return name, email
+	}
+	if match.Name != "" {
+		name = match.Name
+	}
+	if match.Email != "" {
+		email = match.Email
+	}
+	return name, email
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"compress/zlib"
-	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -32,94 +33,370 @@ type Object struct {
 	Content []byte
 }
 
+// gitDir is the repository directory, set by --git-dir or GIT_DIR.
+var gitDir = ".git"
+
+// getObjectsDir is where objects are kept, .git/objects unless
+// GIT_OBJECT_DIRECTORY names another directory.
+func getObjectsDir() string {
+	if dir := os.Getenv("GIT_OBJECT_DIRECTORY"); dir != "" {
+		return dir
+	}
+	return filepath.Join(gitDir, "objects")
+}
+
 func getObjectDir(hash string) string {
-	return filepath.Join(".git", "objects", hash[:2])
+	return filepath.Join(getObjectsDir(), hash[:2])
 }
 
 func getObjectPath(hash string) string {
 	return filepath.Join(getObjectDir(hash), hash[2:])
 }
 
-func parseType(data []byte) (_type Type, endIdx int) {
+func parseType(data []byte) (_type Type, endIdx int, err error) {
 	endIdx = slices.Index(data, byte(' '))
+	if endIdx < 0 {
+		return "", 0, fmt.Errorf("missing space after object type")
+	}
 	_type = Type(string(data[:endIdx]))
+	switch _type {
+	case TypeBlob, TypeTree, TypeCommit, TypeTag:
+	default:
+		return "", 0, fmt.Errorf("invalid object type %q", string(_type))
+	}
 	return
 }
 
 func parseSize(data []byte, startIdx int) (size int, endIdx int, err error) {
 	endIdxSliced := slices.Index(data[startIdx:], byte('\000'))
+	if endIdxSliced < 0 {
+		return 0, 0, fmt.Errorf("missing NUL after object size")
+	}
 	endIdx = startIdx + endIdxSliced
-	size, err = strconv.Atoi(string(data[startIdx:endIdx]))
+	sizeStr := string(data[startIdx:endIdx])
+	if sizeStr == "" || (len(sizeStr) > 1 && sizeStr[0] == '0') {
+		return 0, 0, fmt.Errorf("invalid object size %q", sizeStr)
+	}
+	for i := 0; i < len(sizeStr); i++ {
+		if sizeStr[i] < '0' || sizeStr[i] > '9' {
+			return 0, 0, fmt.Errorf("invalid object size %q", sizeStr)
+		}
+	}
+	size, err = strconv.Atoi(sizeStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid object size %q", sizeStr)
+	}
 	return
 }
 
-func parseObject(hash string) (*Object, error) {
-	objectPath := getObjectPath(hash)
+// decodeObject parses the uncompressed "<type> <size>\0<content>" form of an
+// object. It returns an error rather than panicking on any malformed input.
+func decodeObject(data []byte) (*Object, error) {
+	_type, typeEndIdx, err := parseType(data)
+	if err != nil {
+		return nil, err
+	}
+	size, sizeEndIdx, err := parseSize(data, typeEndIdx+1)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		Type:    _type,
+		Size:    size,
+		Content: data[sizeEndIdx+1:],
+	}, nil
+}
+
+// maxObjectHeaderLen bounds how far openObject reads looking for the end of
+// the "<type> <size>\0" header.
+const maxObjectHeaderLen = 64
+
+// objectReader streams the content of a loose object. Size and hash are
+// verified once the content has been read to the end.
+type objectReader struct {
+	Type Type
+	Size int
+
+	hash   string
+	path   string
+	file   io.Closer
+	zr     io.ReadCloser
+	r      *bufio.Reader
+	hasher hash.Hash
+	read   int
+}
 
-	f, err := os.Open(objectPath)
+// openObject reads only the header of a loose object, leaving the content to
+// be streamed through the returned reader.
+func openObject(hash string) (*objectReader, error) {
+	if !isHexHash(hash) {
+		return nil, fmt.Errorf("invalid object name %s", hash)
+	}
+	hash, err := replaceObject(hash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %s: %s", objectPath, err.Error())
+		return nil, err
+	}
+	f, objectPath, err := objects.Open(hash)
+	if err != nil {
+		return nil, err
 	}
-	defer f.Close()
 
-	r, err := zlib.NewReader(f)
+	zr, err := getZlibReader(f)
 	if err != nil {
+		f.Close()
 		return nil, fmt.Errorf("failed to read zlib compressed file %s: %s", objectPath, err.Error())
 	}
-	defer r.Close()
 
-	data, err := io.ReadAll(r)
+	or := &objectReader{hash: hash, path: objectPath, file: f, zr: zr, r: getBufioReader(zr), hasher: getHasher()}
+	header := make([]byte, 0, maxObjectHeaderLen)
+	for {
+		c, err := or.r.ReadByte()
+		if err != nil {
+			or.Close()
+			return nil, fmt.Errorf("failed to parse header in file %s: missing NUL after object size", objectPath)
+		}
+		header = append(header, c)
+		if c == 0 {
+			break
+		}
+		if len(header) == maxObjectHeaderLen {
+			or.Close()
+			return nil, fmt.Errorf("failed to parse header in file %s: header is too long", objectPath)
+		}
+	}
+	object, err := decodeObject(header)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read all from zlib compressed file %s: %s", objectPath, err.Error())
+		or.Close()
+		return nil, fmt.Errorf("failed to parse header in file %s: %s", objectPath, err.Error())
 	}
+	or.Type, or.Size = object.Type, object.Size
+	or.hasher.Write(header)
+	traceObject("read", hash, or.Type, or.Size)
+	return or, nil
+}
 
-	_type, typeEndIdx := parseType(data)
-	size, sizeEndIdx, err := parseSize(data, typeEndIdx+1)
+func (or *objectReader) corrupt(format string, args ...any) error {
+	return fmt.Errorf("loose object %s (stored in %s) is corrupt: %s", or.hash, or.path, fmt.Sprintf(format, args...))
+}
+
+func (or *objectReader) Read(p []byte) (int, error) {
+	if remaining := or.Size - or.read; len(p) > remaining {
+		p = p[:remaining]
+	}
+	if len(p) == 0 {
+		return 0, or.verify()
+	}
+	n, err := or.r.Read(p)
+	or.hasher.Write(p[:n])
+	or.read += n
+	if err == io.EOF {
+		if or.read < or.Size {
+			return n, or.corrupt("truncated, expected %d bytes of content, found %d", or.Size, or.read)
+		}
+		err = nil
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to read zlib compressed file %s: %s", or.path, err.Error())
+	}
+	return n, nil
+}
+
+// verify checks that nothing follows the declared content and that the
+// stored bytes hash to the object name.
+func (or *objectReader) verify() error {
+	extra, err := io.Copy(io.Discard, or.r)
+	if err != nil {
+		return fmt.Errorf("failed to read zlib compressed file %s: %s", or.path, err.Error())
+	}
+	if extra > 0 {
+		return or.corrupt("garbage at end, expected %d bytes of content, found %d", or.Size, int64(or.Size)+extra)
+	}
+	if actual := hex.EncodeToString(or.hasher.Sum(nil)); actual != or.hash {
+		return or.corrupt("hash mismatch, content hashes to %s", actual)
+	}
+	return io.EOF
+}
+
+// Close hands the inflater, buffer and hasher back to their pools, so the
+// reader must not be used afterwards.
+func (or *objectReader) Close() error {
+	if or.zr == nil {
+		return nil
+	}
+	putBufioReader(or.r)
+	putZlibReader(or.zr)
+	putHasher(or.hasher)
+	or.zr, or.r, or.hasher = nil, nil, nil
+	return or.file.Close()
+}
+
+func parseObject(hash string) (*Object, error) {
+	or, err := openObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer or.Close()
+
+	content, err := io.ReadAll(or)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse size in file %s: %s", objectPath, err.Error())
+		return nil, err
 	}
-	content := data[sizeEndIdx+1:]
 	return &Object{
-		Type:    _type,
-		Size:    size,
+		Type:    or.Type,
+		Size:    or.Size,
 		Content: content,
 	}, nil
 }
 
 func createObjectDir(hash string) error {
 	objectDir := getObjectDir(hash)
+	if objectDirs[objectDir] {
+		return nil
+	}
 	if _, err := os.Stat(objectDir); os.IsNotExist(err) {
-		return os.MkdirAll(objectDir, mode)
+		if err := os.MkdirAll(objectDir, mode); err != nil {
+			return err
+		}
 	}
+	objectDirs[objectDir] = true
 	return nil
 }
 
 func saveObjectFile(content []byte, hash []byte) error {
-	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
-	w.Write(content)
-	w.Close()
-
 	hashStr := hex.EncodeToString(hash)
-	err := createObjectDir(hashStr)
+	w, err := objects.Create()
 	if err != nil {
-		return fmt.Errorf("failed create object dir for hash %s: %s", hashStr, err.Error())
+		return fmt.Errorf("failed create temporary object file for hash %s: %s", hashStr, err.Error())
 	}
-
-	err = os.WriteFile(getObjectPath(hashStr), b.Bytes(), mode)
+	zw := getZlibWriter(w)
+	_, err = zw.Write(content)
+	if err == nil {
+		err = zw.Close()
+	}
+	putZlibWriter(zw)
 	if err != nil {
+		w.Abort()
 		return fmt.Errorf("failed write to object file for hash %s: %s", hashStr, err.Error())
 	}
+	if err := w.Commit(hashStr); err != nil {
+		return err
+	}
+	if object, err := decodeObject(content); err == nil {
+		objectWritten(hashStr, object.Type, object.Size)
+	}
 	return nil
 }
 
 func calculateObjectBytesHash(data []byte) []byte {
-	hasher := sha1.New()
+	hasher := getHasher()
+	defer putHasher(hasher)
 	hasher.Write(data)
 	return hasher.Sum(nil)
 }
 
+// writeObject stores content as an object of the given type and returns its
+// hash.
+func writeObject(objectType Type, content []byte) (string, error) {
+	data := append([]byte(fmt.Sprintf("%s %d\u0000", objectType, len(content))), content...)
+	hashBytes := calculateObjectBytesHash(data)
+	if err := saveObjectFile(data, hashBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hashBytes), nil
+}
+
+// bigFileThreshold is core.bigFileThreshold: larger blobs are streamed
+// instead of being read into memory, and diff treats them as binary.
+func bigFileThreshold() int64 {
+	threshold := 512 << 20
+	if config, err := getConfig(); err == nil {
+		if value, err := config.GetInt("core.bigFileThreshold", threshold); err == nil {
+			threshold = value
+		}
+	}
+	return int64(threshold)
+}
+
+// streamObject hashes size bytes read from r as an object of the given type,
+// compressing them into the object store on the way when write is set. Only
+// a buffer of the content is held in memory at a time.
+func streamObject(objectType Type, size int64, r io.Reader, write bool) (string, error) {
+	header := []byte(fmt.Sprintf("%s %d\u0000", objectType, size))
+	hasher := getHasher()
+	defer putHasher(hasher)
+	hasher.Write(header)
+	if !write {
+		n, err := io.Copy(hasher, r)
+		if err == nil && n != size {
+			err = fmt.Errorf("expected %d bytes, read %d", size, n)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to hash object: %s", err.Error())
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	w, err := objects.Create()
+	if err != nil {
+		return "", err
+	}
+	zw := getZlibWriter(w)
+	defer putZlibWriter(zw)
+	zw.Write(header)
+	n, err := io.Copy(io.MultiWriter(zw, hasher), r)
+	if err == nil && n != size {
+		err = fmt.Errorf("expected %d bytes, read %d", size, n)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		w.Abort()
+		return "", fmt.Errorf("failed to write object: %s", err.Error())
+	}
+	hashStr := hex.EncodeToString(hasher.Sum(nil))
+	if err := w.Commit(hashStr); err != nil {
+		return "", err
+	}
+	objectWritten(hashStr, objectType, int(size))
+	return hashStr, nil
+}
+
+// openBigFile opens a file to stream into a blob when it is larger than
+// core.bigFileThreshold and no clean filter applies to it, returning nil
+// otherwise.
+func openBigFile(filename string, filterPath string) (*os.File, int64, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to stat %s: %s", filename, err.Error())
+	}
+	if info.Size() <= bigFileThreshold() {
+		return nil, 0, nil
+	}
+	if filterPath != "" {
+		if driver, err := filterDriver(filterPath); err != nil || driver != "" {
+			return nil, 0, err
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open %s: %s", filename, err.Error())
+	}
+	return f, info.Size(), nil
+}
+
 func writeBlobObject(filename string) ([]byte, error) {
+	if big, size, err := openBigFile(filename, filename); err != nil {
+		return nil, err
+	} else if big != nil {
+		defer big.Close()
+		hash, err := streamObject(TypeBlob, size, big, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save file %s: %s", filename, err.Error())
+		}
+		return hex.DecodeString(hash)
+	}
+
 	srcF, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open %s: %s", filename, err.Error())
@@ -131,12 +408,18 @@ func writeBlobObject(filename string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read all from file %s: %s", filename, err.Error())
 	}
 
-	lineStr := fmt.Sprintf("%s %d\u0000", TypeBlob, len(content))
-	lineBytes := []byte(lineStr)
-	lineBytes = append(lineBytes, content...)
+	content, err = applyFilter("clean", filename, content)
+	if err != nil {
+		return nil, err
+	}
 
-	hashBytes := calculateObjectBytesHash(lineBytes)
-	err = saveObjectFile(lineBytes, hashBytes)
+	data := getBuffer()
+	defer putBuffer(data)
+	fmt.Fprintf(data, "%s %d\u0000", TypeBlob, len(content))
+	data.Write(content)
+
+	hashBytes := calculateObjectBytesHash(data.Bytes())
+	err = saveObjectFile(data.Bytes(), hashBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save file %s: %s", filename, err.Error())
 	}
@@ -150,46 +433,52 @@ type TreeObjectLine struct {
 }
 
 func parseModeName(line []byte) (mode int, name string, err error) {
-	lineParts := bytes.Split(line, []byte(" "))
-	if len(lineParts) != 2 {
-		return 0, "", fmt.Errorf("tree line is invalid")
+	modePart, namePart, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return 0, "", fmt.Errorf("tree line is invalid: missing space after mode")
 	}
-
-	mode, err = strconv.Atoi(string(lineParts[0]))
-	if len(lineParts) != 2 {
-		return 0, "", fmt.Errorf("error parsing mode")
+	if len(modePart) == 0 || len(namePart) == 0 {
+		return 0, "", fmt.Errorf("tree line is invalid: empty mode or name")
 	}
-	name = string(lineParts[1])
+	for _, c := range modePart {
+		if c < '0' || c > '7' {
+			return 0, "", fmt.Errorf("error parsing mode %q", string(modePart))
+		}
+	}
+	mode, err = strconv.Atoi(string(modePart))
+	if err != nil {
+		return 0, "", fmt.Errorf("error parsing mode %q", string(modePart))
+	}
+	name = string(namePart)
 	return
 }
 
-func decodeTreeObjectContent(content []byte) (string, error) {
+func parseTreeEntries(content []byte) ([]TreeObjectLine, error) {
 	// <mode> <name>\0<20_byte_sha>
 	contentPart := content
 	treeObjectLines := make([]TreeObjectLine, 0, 10)
-	for {
+	for len(contentPart) > 0 {
 		nullByteIdx := slices.Index(contentPart, byte('\000'))
+		if nullByteIdx < 0 {
+			return nil, fmt.Errorf("truncated tree entry: missing NUL after name")
+		}
 		mode, name, err := parseModeName(contentPart[:nullByteIdx])
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		if len(contentPart) < nullByteIdx+21 {
+			return nil, fmt.Errorf("truncated tree entry %s: short object hash", name)
 		}
 		hash := contentPart[nullByteIdx+1 : nullByteIdx+21]
 		treeObjectLines = append(treeObjectLines, TreeObjectLine{Mode: mode, Name: name, Hash: hash})
-
-		if nullByteIdx+22 > len(contentPart) {
-			break
-		}
-		contentPart = contentPart[nullByteIdx+22:]
+		contentPart = contentPart[nullByteIdx+21:]
 	}
-
-	output := ""
-	for _, v := range treeObjectLines {
-		output += v.Name + "\n"
-	}
-	return output, nil
+	return treeObjectLines, nil
 }
 
-func writeTreeObject(dirPath string) ([]byte, error) {
+// writeTreeObject writes the objects for a worktree directory, counting each
+// one on the progress meter.
+func writeTreeObject(dirPath string, progress *progress) ([]byte, error) {
 	files, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
@@ -200,9 +489,12 @@ func writeTreeObject(dirPath string) ([]byte, error) {
 		lineBytes []byte
 	}
 
-	entries := make([]entry, 0, len(files)-1)
+	entries := make([]entry, 0, len(files))
 	totalSize := 0
 	for _, file := range files {
+		if err := checkInterrupted(); err != nil {
+			return nil, err
+		}
 
 		if file.Name() == ".git" {
 			continue
@@ -214,26 +506,58 @@ func writeTreeObject(dirPath string) ([]byte, error) {
 		}
 
 		if fileInfo.IsDir() {
-			hashBytes, err := writeTreeObject(filepath.Join(dirPath, fileInfo.Name()))
+			// a nested repository is recorded as a gitlink to its HEAD,
+			// its files belong to it
+			subPath := filepath.Join(dirPath, fileInfo.Name())
+			head, nested, err := submoduleHead(subPath)
+			if err != nil {
+				return nil, err
+			}
+			if nested {
+				if head == "" {
+					continue
+				}
+				name := worktreeName(fileInfo.Name())
+				hashBytes, _ := hex.DecodeString(head)
+				lineBytes := append([]byte(fmt.Sprintf("%d %s\u0000", modeGitlink, name)), hashBytes...)
+				entries = append(entries, entry{name, lineBytes})
+				totalSize += len(lineBytes)
+				continue
+			}
+			hashBytes, err := writeTreeObject(subPath, progress)
 			if err != nil {
 				return nil, err
 			}
-			lineStr := fmt.Sprintf("40000 %s\u0000", fileInfo.Name())
+			// git never tracks directories without files
+			if hex.EncodeToString(hashBytes) == emptyTreeHash {
+				continue
+			}
+			name := worktreeName(fileInfo.Name())
+			lineStr := fmt.Sprintf("40000 %s\u0000", name)
 			lineBytes := append([]byte(lineStr), hashBytes...)
-			entries = append(entries, entry{fileInfo.Name(), lineBytes})
+			entries = append(entries, entry{name, lineBytes})
 			totalSize += len(lineBytes)
 		} else {
 			hashBytes, err := writeBlobObject(filepath.Join(dirPath, fileInfo.Name()))
 			if err != nil {
 				return nil, err
 			}
-			lineStr := fmt.Sprintf("%o %s\u0000", os.FileMode(0o100000)|fileInfo.Mode().Perm(), fileInfo.Name())
+			progress.addBytes(int(fileInfo.Size()))
+			progress.increment()
+			name := worktreeName(fileInfo.Name())
+			lineStr := fmt.Sprintf("%o %s\u0000", os.FileMode(0o100000)|fileInfo.Mode().Perm(), name)
 			lineBytes := append([]byte(lineStr), hashBytes...)
-			entries = append(entries, entry{fileInfo.Name(), lineBytes})
+			entries = append(entries, entry{name, lineBytes})
 			totalSize += len(lineBytes)
 		}
 	}
 
+	// only the top of the worktree is stored when empty, leaving no
+	// dangling empty trees behind for skipped directories
+	if len(entries) == 0 && dirPath != "." {
+		hashBytes, _ := hex.DecodeString(emptyTreeHash)
+		return hashBytes, nil
+	}
 	sort.Slice(entries, func(i, j int) bool { return entries[i].fileName < entries[j].fileName })
 	lineStr := fmt.Sprintf("%s %d\u0000", TypeTree, totalSize)
 	lineBytes := []byte(lineStr)
@@ -246,105 +570,371 @@ func writeTreeObject(dirPath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	progress.increment()
 
 	return hashBytes, nil
 }
 
-func commitTree(treeSha string, parentSha string, message string) ([]byte, error) {
-	content := []byte(fmt.Sprintf(
-		"tree %s\nparent %s\nauthor Max <email@example.com> 0 +0000\ncommitter Max <email@example.com> 0 +0000\n\n%s\n",
-		treeSha,
-		parentSha,
-		message,
-	))
-
-	lineStr := fmt.Sprintf("%s %d\u0000", TypeCommit, len(content))
-	lineBytes := []byte(lineStr)
-	lineBytes = append(lineBytes, content...)
-	hashBytes := calculateObjectBytesHash(lineBytes)
+func commitTree(treeSha string, parentSha string, message string) (string, error) {
+	var parents []string
+	if parentSha != "" {
+		parents = append(parents, parentSha)
+	}
+	author, err := currentIdentity("AUTHOR")
+	if err != nil {
+		return "", err
+	}
+	committer, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return "", err
+	}
+	return writeCommitObject(treeSha, parents, author, committer, message+"\n")
+}
 
-	err := saveObjectFile(lineBytes, hashBytes)
+func runInit(args []string) error {
+	separateGitDir := ""
+	flags := newFlagSet()
+	flags.String(&separateGitDir, "--separate-git-dir")
+	args, err := flags.Parse(args)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if separateGitDir != "" {
+		// the worktree only gets a .git file pointing at the repository
+		if info, err := os.Stat(".git"); err == nil && info.IsDir() {
+			return fmt.Errorf("%s already exists", filepath.Join(".", ".git"))
+		}
+		if gitDir, err = filepath.Abs(separateGitDir); err != nil {
+			return err
+		}
+	}
+	for _, dir := range []string{gitDir, getObjectsDir(), filepath.Join(gitDir, "refs")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %s", dir, err.Error())
+		}
 	}
 
-	return hashBytes, nil
+	if err := probeFilesystem(); err != nil {
+		return err
+	}
+
+	headFileContents := []byte("ref: refs/heads/main\n")
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), headFileContents, mode); err != nil {
+		return fmt.Errorf("failed to write HEAD: %s", err.Error())
+	}
+	if separateGitDir != "" {
+		if err := os.WriteFile(".git", []byte("gitdir: "+gitDir+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write .git: %s", err.Error())
+		}
+	}
+
+	fmt.Println("Initialized git directory")
+	return nil
 }
 
-// Usage: your_program.sh <command> <arg1> <arg2> ...
-func main() {
-	syscall.Umask(0)
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
-		os.Exit(1)
+func runLsTree(args []string) error {
+	nameOnly, nulTerminated, long := false, false, false
+	flags := newFlagSet()
+	flags.Bool(&nameOnly, "--name-only")
+	flags.Bool(&nulTerminated, "-z")
+	flags.Bool(&long, "-l", "--long")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mygit ls-tree [--name-only | -l] [-z] <tree-ish>")
+	}
+	tree, err := resolveTreeish(args[0])
+	if err != nil {
+		return err
+	}
+	object, err := parseObject(tree)
+	if err != nil {
+		return err
+	}
+	entries, err := parseTreeEntries(object.Content)
+	if err != nil {
+		return fmt.Errorf("failed to parse tree object %s: %s", args[0], err.Error())
 	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if jsonOutput {
+		return writeJSONTree(w, entries, nameOnly, long)
+	}
+	for _, e := range entries {
+		name, eol := quotePath(e.Name, false), byte('\n')
+		if nulTerminated {
+			name, eol = e.Name, 0
+		}
+		if nameOnly {
+			fmt.Fprintf(w, "%s%c", name, eol)
+			continue
+		}
+		objectType, _ := treeModeType(e.Mode)
+		if long {
+			// only blobs have a size, read from the object header
+			size := "-"
+			if objectType == TypeBlob {
+				or, err := openObject(hex.EncodeToString(e.Hash))
+				if err != nil {
+					return err
+				}
+				size = strconv.Itoa(or.Size)
+				or.Close()
+			}
+			fmt.Fprintf(w, "%06d %s %s %7s\t%s%c", e.Mode, objectType, hex.EncodeToString(e.Hash), size, name, eol)
+			continue
+		}
+		fmt.Fprintf(w, "%06d %s %s\t%s%c", e.Mode, objectType, hex.EncodeToString(e.Hash), name, eol)
+	}
+	return nil
+}
+
+type jsonTreeEntry struct {
+	Mode string `json:"mode,omitempty"`
+	Type string `json:"type,omitempty"`
+	Oid  string `json:"oid,omitempty"`
+	Size *int   `json:"size,omitempty"`
+	Path string `json:"path"`
+}
 
-	switch command := os.Args[1]; command {
-	case "init":
-		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
+// writeJSONTree is ls-tree with --json, sizes only being given with -l.
+func writeJSONTree(w io.Writer, entries []TreeObjectLine, nameOnly bool, long bool) error {
+	for _, e := range entries {
+		record := jsonTreeEntry{Path: e.Name}
+		if !nameOnly {
+			objectType, _ := treeModeType(e.Mode)
+			record.Mode, record.Type, record.Oid = fmt.Sprintf("%06d", e.Mode), string(objectType), hex.EncodeToString(e.Hash)
+			if long && objectType == TypeBlob {
+				or, err := openObject(record.Oid)
+				if err != nil {
+					return err
+				}
+				record.Size = &or.Size
+				or.Close()
 			}
 		}
+		if err := writeJSONLine(w, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		headFileContents := []byte("ref: refs/heads/main\n")
-		if err := os.WriteFile(".git/HEAD", headFileContents, mode); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
+func runWriteTree(args []string) error {
+	quiet, prefix := false, ""
+	flags := newFlagSet()
+	flags.Bool(&quiet, "-q", "--quiet")
+	flags.String(&prefix, "--prefix")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	// --prefix writes only the tree of that directory
+	dir := "."
+	if prefix != "" {
+		dir = filepath.Clean(prefix)
+		slashed := filepath.ToSlash(dir)
+		outside := filepath.IsAbs(dir) || slashed == ".." || strings.HasPrefix(slashed, "../")
+		gitPath := slashed == ".git" || strings.HasPrefix(slashed, ".git/")
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || outside || gitPath {
+			return fmt.Errorf("git-write-tree: prefix %s not found", prefix)
 		}
+	}
+	batch, err := beginObjectBatch()
+	if err != nil {
+		return err
+	}
+	defer batch.abort()
+	progress := startProgress("Writing objects", 0, quiet)
+	hash, err := writeTreeObject(dir, progress)
+	progress.stop()
+	if err != nil {
+		return err
+	}
+	if err := batch.commit(); err != nil {
+		return err
+	}
+	if dir != "." && hex.EncodeToString(hash) == emptyTreeHash {
+		return fmt.Errorf("git-write-tree: prefix %s not found", prefix)
+	}
+	fmt.Print(hash)
+	return nil
+}
 
-		fmt.Println("Initialized git directory")
-	case "cat-file":
-		object, err := parseObject(os.Args[3])
+func runCommitTree(args []string) error {
+	parent, message := "", ""
+	flags := newFlagSet()
+	flags.String(&parent, "-p")
+	flags.String(&message, "-m")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mygit commit-tree <tree> [-p <parent>] [-m <message>]")
+	}
+	if !flags.Changed("-m") {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on reading object %s\n", err.Error())
-			os.Exit(1)
-		}
-		switch os.Args[2] {
-		case "-t":
-			fmt.Print(object.Type)
-		case "-s":
-			fmt.Print(object.Size)
-		case "-p":
-			fmt.Print(string(object.Content))
+			return fmt.Errorf("failed to read commit message: %s", err.Error())
+		}
+		message = strings.TrimSuffix(string(data), "\n")
+	}
+	if parent != "" {
+		if parent, err = resolveCommitRevision(parent); err != nil {
+			return err
+		}
+	}
+	hash, err := commitTree(args[0], parent, message)
+	if err != nil {
+		return err
+	}
+	fmt.Print(hash)
+	return nil
+}
+
+// Usage: your_program.sh <command> <arg1> <arg2> ...
+func main() {
+	traceStart(os.Args)
+	defer traceExit(0)
+	watchInterrupts()
+	defer removeTempFiles()
+	syscall.Umask(0)
+	defer stopFilterProcesses()
+
+	if dir := os.Getenv("GIT_DIR"); dir != "" {
+		gitDir = dir
+	}
+	// global options come before the command
+	var paginate *bool
+	for len(os.Args) > 1 && len(os.Args[1]) > 1 && os.Args[1][0] == '-' {
+		consumed := 1
+		switch arg := os.Args[1]; {
+		case arg == "-h" || arg == "--help":
+			printCommandList(os.Stdout)
+			exit(0)
+		case arg == "-p" || arg == "--paginate":
+			paginate = new(bool)
+			*paginate = true
+		case arg == "-P" || arg == "--no-pager":
+			paginate = new(bool)
+		case arg == "--json":
+			jsonOutput = true
+		case arg == "--porcelain-errors":
+			porcelainErrors = true
+		case arg == "--in-memory":
+			useMemoryRepository()
+		case arg == "--no-replace-objects":
+			readReplaceRefs = false
+			os.Setenv("GIT_NO_REPLACE_OBJECTS", "1")
+		case arg == "-C" && len(os.Args) > 2:
+			// each -C is relative to the previous one, and an empty path is a no-op
+			if dir := os.Args[2]; dir != "" {
+				if err := os.Chdir(dir); err != nil {
+					if pathErr, ok := err.(*os.PathError); ok {
+						err = pathErr.Err
+					}
+					fmt.Fprintf(os.Stderr, "cannot change to '%s': %s\n", dir, err.Error())
+					exit(128)
+				}
+			}
+			consumed = 2
+		case arg == "-C":
+			fmt.Fprintf(os.Stderr, "no directory given for -C\n%s\n", globalUsage)
+			exit(129)
+		case arg == "--git-dir" && len(os.Args) > 2:
+			gitDir, consumed = os.Args[2], 2
+			os.Setenv("GIT_DIR", gitDir)
+		case strings.HasPrefix(arg, "--git-dir="):
+			gitDir = strings.TrimPrefix(arg, "--git-dir=")
+			os.Setenv("GIT_DIR", gitDir)
+		case arg == "--git-dir":
+			fmt.Fprintf(os.Stderr, "no directory given for --git-dir\n%s\n", globalUsage)
+			exit(129)
 		default:
-			fmt.Fprintf(os.Stderr, "Unknown command %s\n", os.Args)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "unknown option: %s\n%s\n", arg, globalUsage)
+			exit(129)
 		}
-	case "hash-object":
-		hash, err := writeBlobObject(os.Args[3])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on hashing object %s\n", err.Error())
-			os.Exit(1)
+		os.Args = append(os.Args[:1], os.Args[1+consumed:]...)
+	}
+	if len(os.Args) < 2 {
+		printCommandList(os.Stdout)
+		exit(1)
+	}
+	// init creates a repository where it is run, even inside another one
+	if err := discoverGitDir(os.Args[1] != "init"); err != nil {
+		reportError("", &codedError{Code: "repository", Err: err})
+		exit(128)
+	}
+	if os.Args[1] != "init" {
+		if err := checkRepositoryFormat(); err != nil {
+			reportError("", &codedError{Code: "repository", Err: err})
+			exit(128)
 		}
-		fmt.Print(string(hex.EncodeToString(hash)))
-	case "ls-tree":
-		object, err := parseObject(os.Args[3])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on reading object %s\n", err.Error())
-			os.Exit(1)
+	}
+	expansion, err := expandAlias(os.Args[1], os.Args[2:])
+	if err != nil {
+		reportError("", err)
+		exit(128)
+	}
+	if expansion.Shell != "" {
+		exit(runShellAlias(expansion.Shell, expansion.Args))
+	}
+	name, args := expansion.Name, expansion.Args
+	if name == "help" {
+		if err := runHelp(args); err != nil {
+			reportError("Error on help ", err)
+			exit(1)
 		}
-		out, err := decodeTreeObjectContent(object.Content)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		corrected, err := autocorrectCommand(name)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on parsing tree object %s\n", err.Error())
-			os.Exit(1)
+			reportError("", err)
+			exit(128)
 		}
-		fmt.Print(out)
-	case "write-tree":
-		hash, err := writeTreeObject(".")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on writing tree %s\n", err.Error())
-			os.Exit(1)
+		if corrected == "" {
+			exit(1)
 		}
-		fmt.Print(string(hex.EncodeToString(hash)))
-	case "commit-tree":
-		hash, err := commitTree(os.Args[2], os.Args[4], os.Args[6])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on writing tree %s\n", err.Error())
-			os.Exit(1)
+		if expansion, err = expandAlias(corrected, args); err != nil {
+			reportError("", err)
+			exit(128)
 		}
-		fmt.Print(string(hex.EncodeToString(hash)))
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
-		os.Exit(1)
+		if expansion.Shell != "" {
+			exit(runShellAlias(expansion.Shell, expansion.Args))
+		}
+		name, args = expansion.Name, expansion.Args
+		cmd = commands[name]
+	}
+	if isHelpRequest(args) {
+		fmt.Printf("usage: %s\n", cmd.Usage)
+		exit(129)
+	}
+	traceCommand(append([]string{name}, args...))
+	setupPager(name, paginate)
+	defer stopPager()
+
+	if err := cmd.Run(args); err != nil {
+		if err == errInterrupted {
+			exit(interruptExitCode())
+		}
+		if status, ok := err.(exitStatus); ok {
+			exit(int(status))
+		}
+		if err != errQuietFailure {
+			reportError("Error on "+cmd.Action+" ", err)
+		}
+		exit(1)
 	}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the test binary as mygit itself when asked to, so tests can
+// run commands in a repository of their own.
+func TestMain(m *testing.M) {
+	if os.Getenv("MYGIT_TEST_MAIN") == "1" {
+		main()
+		exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMygit runs mygit with args in dir, with no config but the repository's
+// and a fixed identity, and returns what it printed.
+func runMygit(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"MYGIT_TEST_MAIN=1",
+		"HOME="+t.TempDir(),
+		"XDG_CONFIG_HOME=",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_DIR=",
+		"GIT_INDEX_FILE=",
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=C O Mitter",
+		"GIT_COMMITTER_EMAIL=committer@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// mustRunMygit is runMygit for commands the test needs to succeed.
+func mustRunMygit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := runMygit(t, dir, args...)
+	if err != nil {
+		t.Fatalf("mygit %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// newTestRepo creates a repository with files committed in its first commit.
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	mustRunMygit(t, dir, "init")
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mustRunMygit(t, dir, "add", name)
+	}
+	mustRunMygit(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+func FuzzDecodeObject(f *testing.F) {
+	f.Add([]byte("blob 5\x00hello"))
+	f.Add([]byte("tree 0\x00"))
+	f.Add([]byte("commit 12"))
+	f.Add([]byte("blob \x00"))
+	f.Add([]byte("blob -1\x00"))
+	f.Add([]byte("tag 99999999999999999999\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		obj, err := decodeObject(data)
+		if err != nil {
+			return
+		}
+		if !bytes.HasSuffix(data, obj.Content) {
+			t.Fatalf("content %q is not the end of %q", obj.Content, data)
+		}
+		if obj.Size < 0 {
+			t.Fatalf("negative size %d", obj.Size)
+		}
+	})
+}
+
+func FuzzParseTreeEntries(f *testing.F) {
+	hash := bytes.Repeat([]byte{0xab}, 20)
+	f.Add(append([]byte("100644 a\x00"), hash...))
+	f.Add(append(append([]byte("40000 dir\x00"), hash...), append([]byte("120000 link\x00"), hash...)...))
+	f.Add([]byte("100644 a\x00short"))
+	f.Add([]byte("100644 a"))
+	f.Add([]byte("100644\x00"))
+	f.Add([]byte("10064x a\x00"))
+	f.Fuzz(func(t *testing.T, content []byte) {
+		entries, err := parseTreeEntries(content)
+		if err != nil {
+			return
+		}
+		size := 0
+		for _, e := range entries {
+			if len(e.Hash) != 20 {
+				t.Fatalf("entry %s has a %d byte hash", e.Name, len(e.Hash))
+			}
+			if e.Name == "" {
+				t.Fatalf("entry with an empty name")
+			}
+			size += len(e.Name) + 22
+		}
+		// each entry is at least "<mode digit> <name>\0<hash>"
+		if size > len(content) {
+			t.Fatalf("%d entries do not fit in %d bytes", len(entries), len(content))
+		}
+	})
+}
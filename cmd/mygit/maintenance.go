@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maintenanceTasks are the tasks maintenance run knows, in the order they
+// run. Only gc is enabled unless maintenance.<task>.enabled says otherwise.
+var maintenanceTasks = []string{"gc", "commit-graph", "loose-objects", "incremental-repack"}
+
+const tooManyLooseObjects = "There are too many unreachable loose objects; run 'mygit prune' to remove them."
+
+// errUnsupportedTask is returned by the tasks that work on pack files or the
+// commit-graph, neither of which mygit writes.
+func errUnsupportedTask(task string) error {
+	return fmt.Errorf("task '%s' is not supported: mygit has no pack or commit-graph support", task)
+}
+
+func gcLogPath() string {
+	return filepath.Join(gitDir, "gc.log")
+}
+
+// tooManyLoose is the gc.auto heuristic: objects are spread evenly over the
+// fan-out directories, so counting one of them estimates the total the way
+// git does. A gc.auto of 0 disables automatic maintenance.
+func tooManyLoose(config *Config) (bool, error) {
+	limit, err := config.GetInt("gc.auto", 6700)
+	if err != nil {
+		return false, err
+	}
+	if limit <= 0 {
+		return false, nil
+	}
+	files, err := os.ReadDir(filepath.Join(getObjectsDir(), "17"))
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read loose objects: %s", err.Error())
+	}
+	count := 0
+	for _, file := range files {
+		if isHexHash("17" + file.Name()) {
+			count++
+		}
+	}
+	return count > (limit+255)/256, nil
+}
+
+// checkGcLog refuses automatic maintenance while a recent gc.log reports a
+// problem the last background run could not fix, like git's gc.logExpiry.
+func checkGcLog(config *Config) error {
+	info, err := os.Stat(gcLogPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", gcLogPath(), err.Error())
+	}
+	expiry := "1.day.ago"
+	if value, ok := config.Get("gc.logExpiry"); ok {
+		expiry = value
+	}
+	expire, ok, err := parseExpiry(expiry)
+	if err != nil {
+		return fmt.Errorf("failed to parse gc.logExpiry value %s", expiry)
+	}
+	if ok && info.ModTime().Before(expire) {
+		os.Remove(gcLogPath())
+		return nil
+	}
+	data, err := os.ReadFile(gcLogPath())
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", gcLogPath(), err.Error())
+	}
+	return fmt.Errorf("The last gc run reported the following. Please correct the root cause\n"+
+		"and remove %s\n"+
+		"Automatic cleanup will not be performed until the file is removed.\n\n%s", gcLogPath(), data)
+}
+
+func maintenanceTaskEnabled(config *Config, task string) (bool, error) {
+	return config.GetBool("maintenance."+task+".enabled", task == "gc")
+}
+
+// runGcTask expires reflog entries as gc.reflogExpire and
+// gc.reflogExpireUnreachable say, then prunes the unreachable loose objects
+// older than gc.pruneExpire. The grace period spares objects that commands
+// still running have written but not yet referenced. When run automatically
+// and too many loose objects remain, which happens when they are recent or
+// reachable, the warning is returned so it can be recorded in gc.log.
+func runGcTask(config *Config, auto bool) (string, error) {
+	reflogExpiry, err := loadReflogExpiry(config)
+	if err != nil {
+		return "", err
+	}
+	if err := expireReflogs(reflogExpiry); err != nil {
+		return "", err
+	}
+	expiry := "2.weeks.ago"
+	if value, ok := config.Get("gc.pruneExpire"); ok {
+		expiry = value
+	}
+	expire, ok, err := parseExpiry(expiry)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse gc.pruneExpire value %s", expiry)
+	}
+	// no object may be deleted from a precious-objects repository
+	precious, err := preciousObjects()
+	if err != nil {
+		return "", err
+	}
+	if ok && !precious {
+		if err := pruneObjects(pruneOptions{Expire: expire}); err != nil {
+			return "", err
+		}
+	}
+	if !auto {
+		return "", nil
+	}
+	tooMany, err := tooManyLoose(config)
+	if err != nil || !tooMany {
+		return "", err
+	}
+	return tooManyLooseObjects, nil
+}
+
+// startAutoMaintenance runs maintenance after commands that add objects when
+// the gc.auto heuristic says it is needed. By default it runs detached in the
+// background, unless maintenance.autoDetach or gc.autoDetach is false.
+// Failures are reported but do not fail the command that added the objects.
+func startAutoMaintenance(quiet bool) {
+	if err := autoMaintenance(quiet); err != nil && err != errQuietFailure {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+	}
+}
+
+func autoMaintenance(quiet bool) error {
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if enabled, err := config.GetBool("maintenance.auto", true); err != nil || !enabled {
+		return err
+	}
+	if needed, err := tooManyLoose(config); err != nil || !needed {
+		return err
+	}
+	detach, err := config.GetBool("gc.autoDetach", true)
+	if err != nil {
+		return err
+	}
+	if detach, err = config.GetBool("maintenance.autoDetach", detach); err != nil {
+		return err
+	}
+	if !detach {
+		return maintenanceRun([]string{"--auto", "--quiet"})
+	}
+	// report what the last background run left in gc.log here, where it is
+	// seen
+	if err := checkGcLog(config); err != nil {
+		return err
+	}
+
+	if !quiet {
+		fmt.Fprint(os.Stderr, "Auto packing the repository in background for optimum performance.\n"+
+			"See \"mygit help maintenance\" for manual housekeeping.\n")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to start maintenance: %s", err.Error())
+	}
+	cmd := exec.Command(executable, "maintenance", "run", "--auto", "--quiet", "--detach")
+	cmd.Env = append(os.Environ(), "GIT_DIR="+gitDir)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start maintenance: %s", err.Error())
+	}
+	return cmd.Process.Release()
+}
+
+func maintenanceRun(args []string) error {
+	auto, quiet, detached := false, false, false
+	var tasks []string
+	flags := newFlagSet()
+	flags.Bool(&auto, "--auto")
+	flags.Bool(&quiet, "-q", "--quiet")
+	flags.Bool(&detached, "--detach")
+	// --task may be given several times, which flagSet does not collect
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--task" && i+1 < len(args):
+			i++
+			tasks = append(tasks, args[i])
+		case strings.HasPrefix(args[i], "--task="):
+			tasks = append(tasks, strings.TrimPrefix(args[i], "--task="))
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	args, err := flags.Parse(rest)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: mygit maintenance run [--auto] [--quiet] [--task=<task>]")
+	}
+	for i, task := range tasks {
+		known := false
+		for _, name := range maintenanceTasks {
+			known = known || name == task
+		}
+		if !known {
+			return fmt.Errorf("'%s' is not a valid task", task)
+		}
+		for _, other := range tasks[:i] {
+			if other == task {
+				return fmt.Errorf("task '%s' cannot be selected multiple times", task)
+			}
+		}
+	}
+
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if auto {
+		if err := checkGcLog(config); err != nil {
+			return err
+		}
+	}
+	if len(tasks) == 0 {
+		for _, task := range maintenanceTasks {
+			enabled, err := maintenanceTaskEnabled(config, task)
+			if err != nil {
+				return err
+			}
+			if enabled {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+
+	lockPath := filepath.Join(getObjectsDir(), "maintenance")
+	lock, err := acquireLock(lockPath)
+	if err != nil {
+		if _, statErr := os.Stat(lockFilePath(lockPath)); statErr == nil {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "lock file '%s' exists, skipping maintenance\n", lockFilePath(lockPath))
+			}
+			return nil
+		}
+		return err
+	}
+	defer lock.rollback()
+
+	failed := false
+	for _, task := range tasks {
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		if task != "gc" {
+			fmt.Fprintf(os.Stderr, "error: %s\n", errUnsupportedTask(task).Error())
+			failed = true
+			continue
+		}
+		if auto {
+			if needed, err := tooManyLoose(config); err != nil {
+				return err
+			} else if !needed {
+				continue
+			}
+		}
+		warning, err := runGcTask(config, auto)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: task '%s' failed: %s\n", task, err.Error())
+			failed = true
+			continue
+		}
+		if warning == "" {
+			continue
+		}
+		if detached {
+			// nobody sees the output of a background run, the next automatic
+			// run reports it from gc.log instead
+			if err := writeFileAtomic(gcLogPath(), []byte("warning: "+warning+"\n")); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+		}
+	}
+	if failed {
+		return errQuietFailure
+	}
+	return nil
+}
+
+func runMaintenance(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: mygit maintenance run [--auto] [--quiet] [--task=<task>]")
+	}
+	switch args[0] {
+	case "run":
+		return maintenanceRun(args[1:])
+	default:
+		return fmt.Errorf("invalid subcommand: %s", args[0])
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// memoryRepository keeps the objects, refs and index a command writes in
+// memory, so they are gone once it exits. Whatever it does not hold is read
+// from the repository on disk.
+type memoryRepository struct {
+	objects *memoryObjectStore
+	// refs holds the raw content of the refs written, "" for deleted ones
+	refs map[string]string
+	// index is nil until the index is written
+	index *Index
+}
+
+// inMemory is the repository set by --in-memory, nil when commands work on
+// the repository on disk.
+var inMemory *memoryRepository
+
+// useMemoryRepository switches all object, ref and index access to memory.
+func useMemoryRepository() *memoryRepository {
+	repo := &memoryRepository{refs: map[string]string{}, objects: newMemoryObjectStore(objects)}
+	inMemory, objects = repo, repo.objects
+	return repo
+}
+
+// readRef returns the raw content of a ref written in memory; found is
+// false when it has to be read from disk.
+func (repo *memoryRepository) readRef(name string) (content string, found bool, err error) {
+	content, ok := repo.refs[name]
+	if !ok {
+		return "", false, nil
+	}
+	if content == "" {
+		return "", true, errRefNotFound
+	}
+	return content, true, nil
+}
+
+// overlayRefs applies the refs under prefix written in memory to those
+// found on disk.
+func (repo *memoryRepository) overlayRefs(found map[string]string, prefix string) {
+	for name, content := range repo.refs {
+		if !strings.HasPrefix(name, prefix) || name == "HEAD" {
+			continue
+		}
+		delete(found, name)
+		if content == "" {
+			continue
+		}
+		if target, isSymref := strings.CutPrefix(content, "ref: "); isSymref {
+			hash, _, err := resolveRef(target)
+			if err != nil {
+				continue
+			}
+			content = hash
+		}
+		found[name] = content
+	}
+}
+
+// checkRefs is prepare for refs kept in memory: there is nothing to lock,
+// only the old values are checked.
+func (repo *memoryRepository) checkRefs(t *refTransaction) error {
+	for _, u := range t.updates {
+		if !u.CheckOld {
+			continue
+		}
+		current, err := readRawRef(u.Name)
+		if err == errRefNotFound {
+			current = ""
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case current == u.Old:
+		case u.Old == "":
+			return errorWithCode("stale-ref", "cannot lock ref '%s': reference already exists", u.Name)
+		case current == "":
+			return errorWithCode("stale-ref", "cannot lock ref '%s': reference is missing but expected %s", u.Name, u.Old)
+		default:
+			return errorWithCode("stale-ref", "cannot lock ref '%s': is at %s but expected %s", u.Name, current, u.Old)
+		}
+	}
+	return nil
+}
+
+// applyRefs commits a prepared transaction. Nothing is logged since reflogs
+// are not kept in memory.
+func (repo *memoryRepository) applyRefs(t *refTransaction) {
+	for _, u := range t.updates {
+		switch u.Op {
+		case refOpUpdate:
+			repo.refs[u.Name] = u.Value
+		case refOpDelete:
+			repo.refs[u.Name] = ""
+		}
+	}
+}
+
+// readIndex returns a copy of the index written in memory, or nil when it
+// has to be read from disk.
+func (repo *memoryRepository) readIndex() *Index {
+	if repo.index == nil {
+		return nil
+	}
+	index := *repo.index
+	index.Entries = slices.Clone(repo.index.Entries)
+	return &index
+}
+
+// memoryObjectStore holds compressed objects in a map. Objects it does not
+// hold are read from base.
+type memoryObjectStore struct {
+	base    objectStore
+	objects map[string][]byte
+}
+
+func newMemoryObjectStore(base objectStore) *memoryObjectStore {
+	return &memoryObjectStore{base: base, objects: map[string][]byte{}}
+}
+
+func (s *memoryObjectStore) Has(hash string) bool {
+	if _, ok := s.objects[hash]; ok {
+		return true
+	}
+	return s.base.Has(hash)
+}
+
+func (s *memoryObjectStore) Open(hash string) (io.ReadCloser, string, error) {
+	if data, ok := s.objects[hash]; ok {
+		return io.NopCloser(bytes.NewReader(data)), "memory:" + hash, nil
+	}
+	return s.base.Open(hash)
+}
+
+func (s *memoryObjectStore) Iterate(prefix string, fn func(hash string) error) error {
+	found := map[string]bool{}
+	for hash := range s.objects {
+		if strings.HasPrefix(hash, prefix) {
+			found[hash] = true
+		}
+	}
+	err := s.base.Iterate(prefix, func(hash string) error {
+		found[hash] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(found))
+	for hash := range found {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryObjectWriter struct {
+	store *memoryObjectStore
+	bytes.Buffer
+}
+
+func (s *memoryObjectStore) Create() (objectWriter, error) {
+	return &memoryObjectWriter{store: s}, nil
+}
+
+func (w *memoryObjectWriter) Commit(hash string) error {
+	w.store.objects[hash] = bytes.Clone(w.Bytes())
+	return nil
+}
+
+func (w *memoryObjectWriter) Abort() {
+	w.Reset()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInMemoryLeavesDiskAlone(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"a.txt": "a\n", "c.txt": "c\n"})
+	mustRunMygit(t, dir, "switch", "-q", "-c", "feat")
+	if err := os.Remove(filepath.Join(dir, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	mustRunMygit(t, dir, "add", "-A")
+	mustRunMygit(t, dir, "commit", "-q", "-m", "remove c")
+	mustRunMygit(t, dir, "switch", "-q", "-")
+	configPath := filepath.Join(dir, ".git", "config")
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"switch", "feat"},
+		{"checkout", "feat"},
+		{"reset", "--hard", "feat"},
+		{"config", "foo.bar", "baz"},
+	} {
+		out, err := runMygit(t, dir, append([]string{"--in-memory"}, args...)...)
+		if err == nil {
+			t.Errorf("--in-memory %s succeeded:\n%s", strings.Join(args, " "), out)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "c.txt")); err != nil {
+			t.Errorf("--in-memory %s touched the worktree: %v", strings.Join(args, " "), err)
+		}
+		if after, err := os.ReadFile(configPath); err != nil || string(after) != string(config) {
+			t.Errorf("--in-memory %s changed .git/config:\n%s", strings.Join(args, " "), after)
+		}
+	}
+	if out := mustRunMygit(t, dir, "status", "--short"); out != "" {
+		t.Errorf("status after in-memory commands:\n%s", out)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const conflictMarkerSize = 7
+
+// conflictStyle is how conflicts are shown: with both sides, or with the
+// base version between them too, trimmed of what the sides share in the
+// zealous form.
+type conflictStyle int
+
+const (
+	conflictStyleMerge conflictStyle = iota
+	conflictStyleDiff3
+	conflictStyleZdiff3
+)
+
+func parseConflictStyle(name string) (conflictStyle, bool) {
+	switch name {
+	case "merge":
+		return conflictStyleMerge, true
+	case "diff3":
+		return conflictStyleDiff3, true
+	case "zdiff3":
+		return conflictStyleZdiff3, true
+	}
+	return conflictStyleMerge, false
+}
+
+// configConflictStyle is the merge.conflictStyle setting.
+func configConflictStyle() (conflictStyle, error) {
+	config, err := getConfig()
+	if err != nil {
+		return conflictStyleMerge, err
+	}
+	value, ok := config.Get("merge.conflictStyle")
+	if !ok {
+		return conflictStyleMerge, nil
+	}
+	style, ok := parseConflictStyle(value)
+	if !ok {
+		return conflictStyleMerge, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'", value)
+	}
+	return style, nil
+}
+
+// mergeFavor resolves conflicts of a three-way merge to one side, or to
+// both one after the other for favorUnion.
+type mergeFavor int
+
+const (
+	favorNone mergeFavor = iota
+	favorOurs
+	favorTheirs
+	favorUnion
+)
+
+type mergeOptions struct {
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	Favor       mergeFavor
+	Style       conflictStyle
+	// Alnum also joins conflicts separated only by lines without letters
+	// or digits, like merge-file does
+	Alnum      bool
+	MarkerSize int
+}
+
+// lineChange is a run of lines of a file replaced by other lines.
+type lineChange struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+}
+
+func lineChanges(a []string, b []string) []lineChange {
+	changes := make([]lineChange, 0)
+	x, y := 0, 0
+	open := false
+	for _, op := range compactedDiff(a, b) {
+		if op.Kind == ' ' {
+			open = false
+			x++
+			y++
+			continue
+		}
+		if !open {
+			changes = append(changes, lineChange{OldStart: x, NewStart: y})
+			open = true
+		}
+		c := &changes[len(changes)-1]
+		if op.Kind == '-' {
+			c.OldCount++
+			x++
+		} else {
+			c.NewCount++
+			y++
+		}
+	}
+	return changes
+}
+
+// mergeRegion is a part of a three-way merge where a side changed the base:
+// where it starts in the base and in each side and how many lines it
+// covers there. Mode tells what the merge takes: 0 for a conflict, 1 for
+// ours, 2 for theirs, 3 for both and 4 for none, when both sides made the
+// same change and ours has it already.
+type mergeRegion struct {
+	Mode                int
+	Base, BaseCount     int
+	Ours, OursCount     int
+	Theirs, TheirsCount int
+}
+
+func appendMergeRegion(regions []mergeRegion, r mergeRegion) []mergeRegion {
+	if n := len(regions); n > 0 {
+		m := &regions[n-1]
+		if r.Ours <= m.Ours+m.OursCount || r.Theirs <= m.Theirs+m.TheirsCount {
+			if r.Mode != m.Mode {
+				m.Mode = 0
+			}
+			m.BaseCount = r.Base + r.BaseCount - m.Base
+			m.OursCount = r.Ours + r.OursCount - m.Ours
+			m.TheirsCount = r.Theirs + r.TheirsCount - m.Theirs
+			return regions
+		}
+	}
+	return append(regions, r)
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findMergeRegions walks the changes of both sides against the base in
+// order, the way xdiff's merge does: changes of one side not touching a
+// change of the other are taken, and overlapping ones conflict unless they
+// are the same.
+func findMergeRegions(base []string, ours []string, theirs []string) []mergeRegion {
+	oursChanges, theirsChanges := lineChanges(base, ours), lineChanges(base, theirs)
+	regions := make([]mergeRegion, 0)
+	i, j := 0, 0
+	for i < len(oursChanges) && j < len(theirsChanges) {
+		o, t := oursChanges[i], theirsChanges[j]
+		if o.OldStart+o.OldCount < t.OldStart {
+			regions = appendMergeRegion(regions, mergeRegion{Mode: 1,
+				Base: o.OldStart, BaseCount: o.OldCount,
+				Ours: o.NewStart, OursCount: o.NewCount,
+				Theirs: t.NewStart - t.OldStart + o.OldStart, TheirsCount: o.OldCount})
+			i++
+			continue
+		}
+		if t.OldStart+t.OldCount < o.OldStart {
+			regions = appendMergeRegion(regions, mergeRegion{Mode: 2,
+				Base: t.OldStart, BaseCount: t.OldCount,
+				Ours: o.NewStart - o.OldStart + t.OldStart, OursCount: t.OldCount,
+				Theirs: t.NewStart, TheirsCount: t.NewCount})
+			j++
+			continue
+		}
+		if o.OldStart != t.OldStart || o.OldCount != t.OldCount ||
+			!equalLines(ours[o.NewStart:o.NewStart+o.NewCount], theirs[t.NewStart:t.NewStart+t.NewCount]) {
+			// widen the conflict to cover both changes in every file
+			off := o.OldStart - t.OldStart
+			ffo := off + o.OldCount - t.OldCount
+			r := mergeRegion{Base: o.OldStart, Ours: o.NewStart, Theirs: t.NewStart}
+			if off > 0 {
+				r.Base -= off
+				r.Ours -= off
+			} else {
+				r.Theirs += off
+			}
+			r.BaseCount = o.OldStart + o.OldCount - r.Base
+			r.OursCount = o.NewStart + o.NewCount - r.Ours
+			r.TheirsCount = t.NewStart + t.NewCount - r.Theirs
+			if ffo < 0 {
+				r.BaseCount -= ffo
+				r.OursCount -= ffo
+			} else {
+				r.TheirsCount += ffo
+			}
+			regions = appendMergeRegion(regions, r)
+		}
+		oursEnd, theirsEnd := o.OldStart+o.OldCount, t.OldStart+t.OldCount
+		if oursEnd >= theirsEnd {
+			j++
+		}
+		if theirsEnd >= oursEnd {
+			i++
+		}
+	}
+	for ; i < len(oursChanges); i++ {
+		o := oursChanges[i]
+		regions = appendMergeRegion(regions, mergeRegion{Mode: 1,
+			Base: o.OldStart, BaseCount: o.OldCount,
+			Ours: o.NewStart, OursCount: o.NewCount,
+			Theirs: o.OldStart + len(theirs) - len(base), TheirsCount: o.OldCount})
+	}
+	for ; j < len(theirsChanges); j++ {
+		t := theirsChanges[j]
+		regions = appendMergeRegion(regions, mergeRegion{Mode: 2,
+			Base: t.OldStart, BaseCount: t.OldCount,
+			Ours: t.OldStart + len(ours) - len(base), OursCount: t.OldCount,
+			Theirs: t.NewStart, TheirsCount: t.NewCount})
+	}
+	return regions
+}
+
+// refineConflicts shrinks each conflict to the lines where the sides
+// differ, splitting it where they agree.
+func refineConflicts(regions []mergeRegion, ours []string, theirs []string) []mergeRegion {
+	refined := make([]mergeRegion, 0, len(regions))
+	for _, r := range regions {
+		if r.Mode != 0 || r.OursCount == 0 || r.TheirsCount == 0 {
+			refined = append(refined, r)
+			continue
+		}
+		changes := lineChanges(ours[r.Ours:r.Ours+r.OursCount], theirs[r.Theirs:r.Theirs+r.TheirsCount])
+		if len(changes) == 0 {
+			r.Mode = 4
+			refined = append(refined, r)
+			continue
+		}
+		for _, c := range changes {
+			part := r
+			part.Ours, part.OursCount = r.Ours+c.OldStart, c.OldCount
+			part.Theirs, part.TheirsCount = r.Theirs+c.NewStart, c.NewCount
+			refined = append(refined, part)
+		}
+	}
+	return refined
+}
+
+// trimConflicts moves the lines both sides of a conflict start or end with
+// out of it. Unlike refineConflicts it keeps each conflict whole, as the base
+// shown with it covers all of it.
+func trimConflicts(regions []mergeRegion, ours []string, theirs []string) {
+	for i := range regions {
+		r := &regions[i]
+		if r.Mode != 0 {
+			continue
+		}
+		for r.OursCount > 0 && r.TheirsCount > 0 && ours[r.Ours] == theirs[r.Theirs] {
+			r.Ours, r.OursCount = r.Ours+1, r.OursCount-1
+			r.Theirs, r.TheirsCount = r.Theirs+1, r.TheirsCount-1
+		}
+		for r.OursCount > 0 && r.TheirsCount > 0 && ours[r.Ours+r.OursCount-1] == theirs[r.Theirs+r.TheirsCount-1] {
+			r.OursCount--
+			r.TheirsCount--
+		}
+	}
+}
+
+func linesContainAlnum(lines []string) bool {
+	for _, line := range lines {
+		for _, c := range line {
+			if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// simplifyNonConflicts joins conflicts at most three lines apart, which
+// are easier to resolve as one. With alnum, conflicts separated only by
+// lines like "}" are joined too.
+func simplifyNonConflicts(regions []mergeRegion, ours []string, alnum bool) []mergeRegion {
+	if len(regions) == 0 {
+		return regions
+	}
+	simplified := []mergeRegion{regions[0]}
+	for _, next := range regions[1:] {
+		m := &simplified[len(simplified)-1]
+		begin, end := m.Ours+m.OursCount, next.Ours
+		if m.Mode != 0 || next.Mode != 0 || (end-begin > 3 && (!alnum || linesContainAlnum(ours[begin:end]))) {
+			simplified = append(simplified, next)
+			continue
+		}
+		m.BaseCount = next.Base + next.BaseCount - m.Base
+		m.OursCount = next.Ours + next.OursCount - m.Ours
+		m.TheirsCount = next.Theirs + next.TheirsCount - m.Theirs
+	}
+	return simplified
+}
+
+func writeMergeLines(out *bytes.Buffer, lines []string, addNewline bool) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if addNewline && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+func writeConflictMarker(out *bytes.Buffer, marker byte, size int, label string) {
+	out.WriteString(strings.Repeat(string(marker), size))
+	if label != "" {
+		out.WriteString(" " + label)
+	}
+	out.WriteByte('\n')
+}
+
+// mergeContent merges the changes from base to ours and to theirs. Where
+// they conflict both versions are left between conflict markers, unless
+// the options favor a side. It returns the number of conflicts left.
+func mergeContent(base []byte, ours []byte, theirs []byte, opts mergeOptions) ([]byte, int) {
+	baseLines, oursLines, theirsLines := splitLines(base), splitLines(ours), splitLines(theirs)
+	if len(lineChanges(baseLines, oursLines)) == 0 {
+		return theirs, 0
+	}
+	if len(lineChanges(baseLines, theirsLines)) == 0 {
+		return ours, 0
+	}
+	regions := findMergeRegions(baseLines, oursLines, theirsLines)
+	switch opts.Style {
+	case conflictStyleMerge:
+		regions = refineConflicts(regions, oursLines, theirsLines)
+		regions = simplifyNonConflicts(regions, oursLines, opts.Alnum)
+	case conflictStyleZdiff3:
+		trimConflicts(regions, oursLines, theirsLines)
+	}
+
+	markerSize := opts.MarkerSize
+	if markerSize <= 0 {
+		markerSize = conflictMarkerSize
+	}
+	var out bytes.Buffer
+	conflicts, pos := 0, 0
+	for _, r := range regions {
+		if r.Mode == 0 && opts.Favor != favorNone {
+			r.Mode = int(opts.Favor)
+		}
+		if r.Mode == 4 {
+			continue
+		}
+		writeMergeLines(&out, oursLines[pos:r.Ours], false)
+		oursPart := oursLines[r.Ours : r.Ours+r.OursCount]
+		theirsPart := theirsLines[r.Theirs : r.Theirs+r.TheirsCount]
+		switch {
+		case r.Mode == 0:
+			conflicts++
+			writeConflictMarker(&out, '<', markerSize, opts.OursLabel)
+			writeMergeLines(&out, oursPart, true)
+			if opts.Style != conflictStyleMerge {
+				writeConflictMarker(&out, '|', markerSize, opts.BaseLabel)
+				writeMergeLines(&out, baseLines[r.Base:r.Base+r.BaseCount], true)
+			}
+			writeConflictMarker(&out, '=', markerSize, "")
+			writeMergeLines(&out, theirsPart, true)
+			writeConflictMarker(&out, '>', markerSize, opts.TheirsLabel)
+		default:
+			if r.Mode&1 != 0 {
+				writeMergeLines(&out, oursPart, r.Mode&2 != 0)
+			}
+			if r.Mode&2 != 0 {
+				writeMergeLines(&out, theirsPart, false)
+			}
+		}
+		pos = r.Ours + r.OursCount
+	}
+	writeMergeLines(&out, oursLines[pos:], false)
+	return out.Bytes(), conflicts
+}
+
+// mergeBases finds the best common ancestors of two commits: those
+// reachable from both that are not ancestors of another one of them.
+func mergeBases(a string, b string) ([]string, error) {
+	fromA, err := newRevWalk(nil, []string{a})
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, 1)
+	seen := map[string]bool{}
+	stack := []string{b}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if fromA.excluded[hash] {
+			candidates = append(candidates, hash)
+			continue
+		}
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, commit.Parents...)
+	}
+
+	bases := make([]string, 0, len(candidates))
+	for i, c := range candidates {
+		redundant := false
+		for j, other := range candidates {
+			if i == j {
+				continue
+			}
+			if redundant, err = isAncestor(c, other); err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+		if !redundant {
+			bases = append(bases, c)
+		}
+	}
+	return bases, nil
+}
+
+const mergeFileUsage = "mygit merge-file [-p | --stdout] [-q] [--diff3 | --zdiff3] [--ours | --theirs | --union] [--marker-size <n>] [-L <name1> [-L <orig> [-L <name2>]]] <file1> <orig-file> <file2>"
+
+// runMergeFile merges the changes from <orig-file> to <file2> into
+// <file1>. Like git, the exit code is the number of conflicts left.
+func runMergeFile(args []string) error {
+	opts := mergeOptions{Alnum: true}
+	toStdout := false
+	labels := make([]string, 0, 3)
+	files := make([]string, 0, 3)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-p" || arg == "--stdout":
+			toStdout = true
+		case arg == "-q" || arg == "--quiet":
+			// conflicts only show in the exit code anyway
+		case arg == "--ours":
+			opts.Favor = favorOurs
+		case arg == "--theirs":
+			opts.Favor = favorTheirs
+		case arg == "--union":
+			opts.Favor = favorUnion
+		case arg == "--diff3":
+			opts.Style = conflictStyleDiff3
+		case arg == "--zdiff3":
+			opts.Style = conflictStyleZdiff3
+		case arg == "-L" || arg == "--marker-size":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			if arg == "-L" {
+				labels = append(labels, args[i])
+				continue
+			}
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return fmt.Errorf("option --marker-size expects a numerical value")
+			}
+			opts.MarkerSize = n
+		case strings.HasPrefix(arg, "--marker-size="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--marker-size="))
+			if err != nil {
+				return fmt.Errorf("option --marker-size expects a numerical value")
+			}
+			opts.MarkerSize = n
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 3 || len(labels) > 3 {
+		return fmt.Errorf("usage: %s", mergeFileUsage)
+	}
+	// the files name themselves unless labelled
+	names := append(labels, files[len(labels):]...)
+	opts.OursLabel, opts.BaseLabel, opts.TheirsLabel = names[0], names[1], names[2]
+
+	contents := make([][]byte, 3)
+	for i := range files {
+		files[i] = prefixFilename(files[i])
+	}
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", file, err.Error())
+		}
+		if isBinaryContent(content) {
+			return fmt.Errorf("Cannot merge binary files: %s", file)
+		}
+		contents[i] = content
+	}
+	merged, conflicts := mergeContent(contents[1], contents[0], contents[2], opts)
+
+	if toStdout {
+		os.Stdout.Write(merged)
+	} else if err := os.WriteFile(files[0], merged, 0666); err != nil {
+		return fmt.Errorf("could not write %s: %s", files[0], err.Error())
+	}
+	if conflicts > 127 {
+		conflicts = 127
+	}
+	if conflicts > 0 {
+		return exitStatus(conflicts)
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// mergeStage is a version of a conflicted path, as the index would have it
+// at stage 1 for the base, 2 for ours and 3 for theirs.
+type mergeStage struct {
+	Mode  int
+	Hash  string
+	Stage int
+	Path  string
+}
+
+type mergeMessage struct {
+	Path string
+	Text string
+}
+
+// treeMerge merges trees the way git's ort strategy does, without rename
+// detection. Conflicts are left in the merged tree, the files with conflict
+// markers, and recorded in Stages and Messages.
+type treeMerge struct {
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	Style       conflictStyle
+	// MarkerSize is raised when merging merge bases, so their conflicts
+	// stand out from those of the merge using the result
+	MarkerSize int
+	Stages     []mergeStage
+	Messages   []mergeMessage
+}
+
+func (m *treeMerge) clean() bool {
+	return len(m.Stages) == 0
+}
+
+func (m *treeMerge) message(path string, format string, args ...any) {
+	m.Messages = append(m.Messages, mergeMessage{Path: path, Text: fmt.Sprintf(format, args...)})
+}
+
+func (m *treeMerge) addStages(path string, entries ...*TreeObjectLine) {
+	for i, e := range entries {
+		if e != nil {
+			m.Stages = append(m.Stages, mergeStage{Mode: e.Mode, Hash: hex.EncodeToString(e.Hash), Stage: i + 1, Path: path})
+		}
+	}
+}
+
+func sameTreeEntry(a *TreeObjectLine, b *TreeObjectLine) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && bytes.Equal(a.Hash, b.Hash)
+}
+
+func isTreeEntry(e *TreeObjectLine) bool {
+	return e != nil && e.Mode == modeTree
+}
+
+func treeEntryHash(e *TreeObjectLine) string {
+	if e == nil {
+		return ""
+	}
+	return hex.EncodeToString(e.Hash)
+}
+
+func isRegularFileMode(mode int) bool {
+	return mode == 100644 || mode == 100755
+}
+
+// mergeTrees merges the trees ours and theirs, both coming from base. A
+// missing tree is "", and the merged tree is "" when it would be empty.
+func (m *treeMerge) mergeTrees(base string, ours string, theirs string, prefix string) (string, error) {
+	sides := make([]map[string]*TreeObjectLine, 3)
+	names := make([]string, 0)
+	for i, hash := range []string{base, ours, theirs} {
+		entries, err := readTreeOrEmpty(hash)
+		if err != nil {
+			return "", err
+		}
+		sides[i] = make(map[string]*TreeObjectLine, len(entries))
+		for j := range entries {
+			e := &entries[j]
+			if sides[0][e.Name] == nil && sides[1][e.Name] == nil && sides[2][e.Name] == nil {
+				names = append(names, e.Name)
+			}
+			sides[i][e.Name] = e
+		}
+	}
+	sort.Strings(names)
+
+	merged := make([]TreeObjectLine, 0, len(names))
+	for _, name := range names {
+		b, o, t := sides[0][name], sides[1][name], sides[2][name]
+		path := prefix + name
+		switch {
+		case sameTreeEntry(o, t) || sameTreeEntry(b, t):
+			if o != nil {
+				merged = append(merged, *o)
+			}
+		case sameTreeEntry(b, o):
+			if t != nil {
+				merged = append(merged, *t)
+			}
+		case (o == nil || isTreeEntry(o)) && (t == nil || isTreeEntry(t)):
+			baseTree := ""
+			if isTreeEntry(b) {
+				baseTree = treeEntryHash(b)
+			}
+			tree, err := m.mergeTrees(baseTree, treeEntryHash(o), treeEntryHash(t), path+"/")
+			if err != nil {
+				return "", err
+			}
+			if tree != "" {
+				hash, _ := hex.DecodeString(tree)
+				merged = append(merged, TreeObjectLine{Mode: modeTree, Name: name, Hash: hash})
+			}
+		case !isTreeEntry(o) && !isTreeEntry(t):
+			if isTreeEntry(b) {
+				b = nil
+			}
+			e, err := m.mergeFile(path, b, o, t)
+			if err != nil {
+				return "", err
+			}
+			if e != nil {
+				e.Name = name
+				merged = append(merged, *e)
+			}
+		default:
+			entries, err := m.mergeFileDirectory(path, b, o, t)
+			if err != nil {
+				return "", err
+			}
+			merged = append(merged, entries...)
+		}
+	}
+	if len(merged) == 0 {
+		return "", nil
+	}
+	return writeTreeEntries(merged)
+}
+
+// mergeFile merges a path that is not a directory on either side.
+func (m *treeMerge) mergeFile(path string, b *TreeObjectLine, o *TreeObjectLine, t *TreeObjectLine) (*TreeObjectLine, error) {
+	if o == nil || t == nil {
+		kept, modifier, deleter := o, m.OursLabel, m.TheirsLabel
+		if o == nil {
+			kept, modifier, deleter = t, m.TheirsLabel, m.OursLabel
+		}
+		if b == nil {
+			return kept, nil
+		}
+		m.message(path, "CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.", path, deleter, modifier, modifier, path)
+		m.addStages(path, b, o, t)
+		return kept, nil
+	}
+
+	baseMode := 0
+	if b != nil {
+		baseMode = b.Mode
+	}
+	result := TreeObjectLine{Mode: t.Mode}
+	clean := true
+	if o.Mode != t.Mode && o.Mode != baseMode {
+		result.Mode = o.Mode
+		clean = t.Mode == baseMode
+	}
+
+	switch {
+	case bytes.Equal(o.Hash, t.Hash) || (b != nil && bytes.Equal(o.Hash, b.Hash)):
+		result.Hash = t.Hash
+	case b != nil && bytes.Equal(t.Hash, b.Hash):
+		result.Hash = o.Hash
+	case isRegularFileMode(o.Mode) && isRegularFileMode(t.Mode):
+		m.message(path, "Auto-merging %s", path)
+		hash, merged, err := m.mergeBlobs(path, b, o, t)
+		if err != nil {
+			return nil, err
+		}
+		result.Hash = hash
+		clean = clean && merged
+	default:
+		// symlinks and submodules cannot be merged line by line
+		result.Hash = o.Hash
+		clean = false
+	}
+
+	if !clean {
+		reason := "content"
+		if b == nil {
+			reason = "add/add"
+		}
+		m.message(path, "CONFLICT (%s): Merge conflict in %s", reason, path)
+		m.addStages(path, b, o, t)
+	}
+	return &result, nil
+}
+
+// mergeBlobs merges the contents of two versions of a file, reporting
+// whether that went without conflicts.
+func (m *treeMerge) mergeBlobs(path string, b *TreeObjectLine, o *TreeObjectLine, t *TreeObjectLine) ([]byte, bool, error) {
+	contents := make([][]byte, 3)
+	for i, e := range []*TreeObjectLine{b, o, t} {
+		if e == nil {
+			continue
+		}
+		object, err := parseObject(treeEntryHash(e))
+		if err != nil {
+			return nil, false, err
+		}
+		contents[i] = object.Content
+	}
+	if isBinaryContent(contents[0]) || isBinaryContent(contents[1]) || isBinaryContent(contents[2]) {
+		fmt.Fprintf(os.Stderr, "warning: Cannot merge binary files: %s (%s vs. %s)\n", path, m.OursLabel, m.TheirsLabel)
+		return o.Hash, false, nil
+	}
+	opts := mergeOptions{OursLabel: m.OursLabel, BaseLabel: m.BaseLabel, TheirsLabel: m.TheirsLabel, Style: m.Style, MarkerSize: m.MarkerSize}
+	merged, conflicts := mergeContent(contents[0], contents[1], contents[2], opts)
+	hash, err := writeObject(TypeBlob, merged)
+	if err != nil {
+		return nil, false, err
+	}
+	raw, _ := hex.DecodeString(hash)
+	return raw, conflicts == 0, nil
+}
+
+// mergeFileDirectory handles a path that is a directory on one side and a
+// file on the other: the directory stays and the file moves aside to
+// <path>~<side>.
+func (m *treeMerge) mergeFileDirectory(path string, b *TreeObjectLine, o *TreeObjectLine, t *TreeObjectLine) ([]TreeObjectLine, error) {
+	file, dir, label := o, t, m.OursLabel
+	if isTreeEntry(o) {
+		file, dir, label = t, o, m.TheirsLabel
+	}
+	baseTree, baseFile := "", b
+	if isTreeEntry(b) {
+		baseTree, baseFile = treeEntryHash(b), nil
+	}
+	oursTree, theirsTree := treeEntryHash(dir), ""
+	if dir == t {
+		oursTree, theirsTree = "", treeEntryHash(dir)
+	}
+	tree, err := m.mergeTrees(baseTree, oursTree, theirsTree, path+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	name := path[strings.LastIndexByte(path, '/')+1:]
+	moved := name + "~" + label
+	movedPath := path + "~" + label
+	m.message(path, "CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.", path, label, movedPath)
+	if file == o {
+		m.addStages(movedPath, baseFile, file, nil)
+	} else {
+		m.addStages(movedPath, baseFile, nil, file)
+	}
+	entries := []TreeObjectLine{{Mode: file.Mode, Name: moved, Hash: file.Hash}}
+	if tree != "" {
+		hash, _ := hex.DecodeString(tree)
+		entries = append(entries, TreeObjectLine{Mode: modeTree, Name: name, Hash: hash})
+	}
+	return entries, nil
+}
+
+// mergeBaseTree is the tree to merge two commits from, and its label in
+// conflicts: that of their merge base, or with several merge bases their
+// merge, like git's recursive strategies build. depth counts how deep in
+// merging merge bases this is.
+func mergeBaseTree(a string, b string, allowUnrelated bool, style conflictStyle, depth int) (string, string, error) {
+	bases, err := mergeBases(a, b)
+	if err != nil {
+		return "", "", err
+	}
+	if len(bases) == 0 {
+		if !allowUnrelated {
+			return "", "", fmt.Errorf("refusing to merge unrelated histories")
+		}
+		return "", "empty tree", nil
+	}
+	tree, err := commitTreeHash(bases[0])
+	if err != nil {
+		return "", "", err
+	}
+	if len(bases) == 1 {
+		return tree, abbrevHash(bases[0]), nil
+	}
+	for _, next := range bases[1:] {
+		innerBase, innerLabel, err := mergeBaseTree(bases[0], next, true, style, depth+1)
+		if err != nil {
+			return "", "", err
+		}
+		nextTree, err := commitTreeHash(next)
+		if err != nil {
+			return "", "", err
+		}
+		inner := &treeMerge{OursLabel: "Temporary merge branch 1", BaseLabel: innerLabel, TheirsLabel: "Temporary merge branch 2",
+			Style: style, MarkerSize: conflictMarkerSize + 2*(depth+1)}
+		if tree, err = inner.mergeTrees(innerBase, tree, nextTree, ""); err != nil {
+			return "", "", err
+		}
+	}
+	return tree, "merged common ancestors", nil
+}
+
+// writeConflicts writes the conflicted stages of a merge, or only their
+// paths, and with messages set a blank line and the messages about them.
+func (m *treeMerge) writeConflicts(w io.Writer, nameOnly bool, messages bool) {
+	sort.SliceStable(m.Stages, func(i, j int) bool { return m.Stages[i].Path < m.Stages[j].Path })
+	for i, s := range m.Stages {
+		if !nameOnly {
+			fmt.Fprintf(w, "%06d %s %d\t%s\n", s.Mode, s.Hash, s.Stage, quotePath(s.Path, false))
+		} else if i == 0 || m.Stages[i-1].Path != s.Path {
+			fmt.Fprintln(w, quotePath(s.Path, false))
+		}
+	}
+	if messages {
+		fmt.Fprintln(w)
+		sort.SliceStable(m.Messages, func(i, j int) bool { return m.Messages[i].Path < m.Messages[j].Path })
+		for _, msg := range m.Messages {
+			fmt.Fprintln(w, msg.Text)
+		}
+	}
+}
+
+const mergeTreeUsage = "mygit merge-tree --write-tree [--name-only] [--[no-]messages] [--allow-unrelated-histories] <branch1> <branch2>"
+
+// runMergeTree merges two commits without touching the index or the work
+// tree, printing the merged tree and, when there are conflicts, the
+// conflicted paths and messages about them.
+func runMergeTree(args []string) error {
+	writeTree, nameOnly, allowUnrelated := false, false, false
+	showMessages := -1
+	branches := make([]string, 0, 2)
+	for _, arg := range args {
+		switch arg {
+		case "--write-tree":
+			writeTree = true
+		case "--name-only":
+			nameOnly = true
+		case "--messages":
+			showMessages = 1
+		case "--no-messages":
+			showMessages = 0
+		case "--allow-unrelated-histories":
+			allowUnrelated = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			branches = append(branches, arg)
+		}
+	}
+	if !writeTree || len(branches) != 2 {
+		return fmt.Errorf("usage: %s", mergeTreeUsage)
+	}
+	commits := make([]string, 2)
+	for i, branch := range branches {
+		hash, err := resolveCommitRevision(branch)
+		if err != nil {
+			return fmt.Errorf("merge-tree: %s - not something we can merge", branch)
+		}
+		commits[i] = hash
+	}
+
+	style, err := configConflictStyle()
+	if err != nil {
+		return err
+	}
+	baseTree, baseLabel, err := mergeBaseTree(commits[0], commits[1], allowUnrelated, style, 0)
+	if err != nil {
+		return err
+	}
+	oursTree, err := commitTreeHash(commits[0])
+	if err != nil {
+		return err
+	}
+	theirsTree, err := commitTreeHash(commits[1])
+	if err != nil {
+		return err
+	}
+	m := &treeMerge{OursLabel: branches[0], BaseLabel: baseLabel, TheirsLabel: branches[1], Style: style}
+	tree, err := m.mergeTrees(baseTree, oursTree, theirsTree, "")
+	if err != nil {
+		return err
+	}
+	if tree == "" {
+		if tree, err = writeObject(TypeTree, nil); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, tree)
+	m.writeConflicts(w, nameOnly, showMessages == 1 || (showMessages == -1 && !m.clean()))
+	if !m.clean() {
+		w.Flush()
+		return exitStatus(1)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const mergetoolUsage = "mygit mergetool [-y | --[no-]prompt] [-t <tool>] [--[no-]keep-backup] [--] [<file>...]"
+
+type mergetoolOptions struct {
+	Tool    string
+	Command string
+	// Trust takes the tool's exit code as telling whether the merge was
+	// resolved, instead of checking whether the file changed
+	Trust      bool
+	Prompt     bool
+	KeepBackup bool
+}
+
+// stageWorktreeFile records the worktree content of path in the index,
+// replacing its conflict stages, as add does once a conflict is resolved.
+func stageWorktreeFile(index *Index, path string, fileMode int) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", path, err.Error())
+	}
+	entry := IndexEntry{Path: path, Mode: worktreeEntryMode(info, fileMode)}
+	if entry.Mode == modeSymlink {
+		target, err := readLinkTarget(path, info)
+		if err != nil {
+			return err
+		}
+		if entry.Hash, err = writeObject(TypeBlob, []byte(target)); err != nil {
+			return err
+		}
+	} else {
+		hash, err := writeBlobObject(path)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hex.EncodeToString(hash)
+	}
+	entry.setStatData(info)
+	index.set(entry)
+	return nil
+}
+
+// conflictSide describes one side of a conflict the way mergetool lists it.
+func conflictSide(e *IndexEntry) string {
+	switch {
+	case e == nil:
+		return "deleted"
+	case e.Mode == modeSymlink:
+		return "a symbolic link"
+	case e.Mode == modeGitlink:
+		return "submodule commit " + e.Hash
+	}
+	return "modified file"
+}
+
+// mergeToolPath resolves the conflict of one path, either by letting the
+// user pick a side when one was deleted, or by running the merge tool on
+// temporary files holding the base, local and remote versions. The index is
+// updated when the path is resolved.
+func mergeToolPath(index *Index, stages []IndexEntry, opts mergetoolOptions) (bool, error) {
+	path := stages[0].Path
+	var versions [3]*IndexEntry
+	for i := range stages {
+		versions[stages[i].Stage()-1] = &stages[i]
+	}
+	local, remote := versions[1], versions[2]
+
+	if local == nil || remote == nil {
+		fmt.Printf("\nDeleted merge conflict for '%s':\n  {local}: %s\n  {remote}: %s\n", path, conflictSide(local), conflictSide(remote))
+		for {
+			answer, ok := promptLine("Use (m)odified or (d)eleted file, or (a)bort? ")
+			if !ok {
+				return false, nil
+			}
+			switch answer {
+			case "m":
+				return true, stageWorktreeFile(index, path, modeOf(local, remote))
+			case "d":
+				index.remove(path)
+				return true, removeWorktreeFile(path)
+			case "a":
+				return false, nil
+			}
+		}
+	}
+
+	fmt.Printf("\nNormal merge conflict for '%s':\n  {local}: %s\n  {remote}: %s\n", path, conflictSide(local), conflictSide(remote))
+	if opts.Prompt {
+		if _, ok := promptLine(fmt.Sprintf("Hit return to start merge resolution tool (%s): ", opts.Tool)); !ok {
+			return false, nil
+		}
+	}
+	backup, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %s", path, err.Error())
+	}
+
+	// the versions sit next to the file, named after it, so the tool shows
+	// where they come from
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	temps := make([]string, 0, 3)
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+	env := []string{"MERGED=" + path}
+	for i, name := range []string{"BASE", "LOCAL", "REMOTE"} {
+		temp := fmt.Sprintf("%s_%s_%d%s", stem, name, os.Getpid(), ext)
+		var content []byte
+		if e := versions[i]; e != nil {
+			object, err := parseObject(e.Hash)
+			if err != nil {
+				return false, err
+			}
+			if content, err = applyFilter("smudge", path, object.Content); err != nil {
+				return false, err
+			}
+		}
+		if err := os.WriteFile(temp, content, 0o644); err != nil {
+			return false, fmt.Errorf("failed to write %s: %s", temp, err.Error())
+		}
+		temps = append(temps, temp)
+		env = append(env, name+"="+temp)
+	}
+
+	code, err := runTool(opts.Command, env)
+	if err != nil {
+		return false, err
+	}
+	resolved := code == 0
+	if !opts.Trust {
+		merged, err := os.ReadFile(path)
+		resolved = err == nil && !bytes.Equal(merged, backup)
+		if err == nil && !resolved {
+			fmt.Printf("%s seems unchanged.\n", path)
+			for {
+				answer, ok := promptLine("Was the merge successful [y/n]? ")
+				if !ok || answer == "n" {
+					break
+				}
+				if answer == "y" {
+					resolved = true
+					break
+				}
+			}
+		}
+	}
+	if !resolved {
+		fmt.Fprintf(os.Stderr, "merge of %s failed\n", path)
+		if err := os.WriteFile(path, backup, 0o644); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %s", path, err.Error())
+		}
+		return false, nil
+	}
+	if opts.KeepBackup {
+		if err := os.WriteFile(path+".orig", backup, 0o644); err != nil {
+			return false, fmt.Errorf("failed to write %s.orig: %s", path, err.Error())
+		}
+	}
+	return true, stageWorktreeFile(index, path, local.Mode)
+}
+
+// modeOf is the mode of whichever side of a conflict is there.
+func modeOf(local *IndexEntry, remote *IndexEntry) int {
+	if local != nil {
+		return local.Mode
+	}
+	return remote.Mode
+}
+
+// runMergetool runs the merge tool on each unmerged path in turn, staging
+// the paths it resolves. It fails when any path is left unresolved.
+func runMergetool(args []string) error {
+	opts := mergetoolOptions{}
+	noPrompt, prompt, keepBackup, noKeepBackup := false, false, false, false
+	flags := newFlagSet()
+	flags.String(&opts.Tool, "-t", "--tool")
+	flags.Bool(&noPrompt, "-y", "--no-prompt")
+	flags.Bool(&prompt, "--prompt")
+	flags.Bool(&keepBackup, "--keep-backup")
+	flags.Bool(&noKeepBackup, "--no-keep-backup")
+	paths, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if opts.Tool == "" {
+		opts.Tool, _ = config.Get("merge.tool")
+	}
+	if opts.Tool == "" {
+		return fmt.Errorf("no merge tool configured, set merge.tool or use --tool")
+	}
+	if opts.Command, opts.Trust, err = toolCommand(config, opts.Tool, true); err != nil {
+		return err
+	}
+	if opts.Trust, err = config.GetBool("mergetool."+opts.Tool+".trustExitCode", opts.Trust); err != nil {
+		return err
+	}
+	if prompt || noPrompt {
+		opts.Prompt = !noPrompt
+	} else if opts.Prompt, err = config.GetBool("mergetool.prompt", false); err != nil {
+		return err
+	}
+	if keepBackup || noKeepBackup {
+		opts.KeepBackup = !noKeepBackup
+	} else if opts.KeepBackup, err = config.GetBool("mergetool.keepBackup", true); err != nil {
+		return err
+	}
+	ps, err := parsePathspecs(paths)
+	if err != nil {
+		return err
+	}
+
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	conflicts := make([][]IndexEntry, 0)
+	for i := 0; i < len(index.Entries); {
+		end := i + 1
+		if index.Entries[i].Stage() != 0 {
+			end = stagesEnd(index.Entries, i)
+			if ps.matches(index.Entries[i].Path) {
+				conflicts = append(conflicts, slices.Clone(index.Entries[i:end]))
+			}
+		}
+		i = end
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No files need merging")
+		return nil
+	}
+	fmt.Println("Merging:")
+	for _, stages := range conflicts {
+		fmt.Println(stages[0].Path)
+	}
+
+	failed := false
+	for n, stages := range conflicts {
+		resolved, err := mergeToolPath(index, stages, opts)
+		if err != nil {
+			return err
+		}
+		if resolved {
+			continue
+		}
+		failed = true
+		if n == len(conflicts)-1 {
+			break
+		}
+		if answer, ok := promptLine("Continue merging other unresolved paths [y/n]? "); !ok || answer != "y" {
+			break
+		}
+	}
+	if err := writeIndex(index, lock); err != nil {
+		return err
+	}
+	if failed {
+		return exitStatus(1)
+	}
+	return nil
+}
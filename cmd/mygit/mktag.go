@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func runMktag(args []string) error {
+	strict := true
+	for _, arg := range args {
+		switch arg {
+		case "--strict":
+			strict = true
+		case "--no-strict":
+			strict = false
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %s", err.Error())
+	}
+	// by default any problem that is not ignored is fatal
+	checker, err := newFsckChecker(strict, "tag input does not pass fsck")
+	if err != nil {
+		return err
+	}
+	checker.WarningsFatal = strict
+	target, targetType, err := checker.checkTag(content)
+	if err != nil {
+		return err
+	}
+	object, err := parseObject(target)
+	if err != nil {
+		return fmt.Errorf("could not read tagged object '%s'", target)
+	}
+	if object.Type != targetType {
+		return fmt.Errorf("object '%s' tagged as '%s', but is a '%s' type", target, targetType, object.Type)
+	}
+	hash, err := writeObject(TypeTag, content)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeModeType returns the object type an entry with the given mode points at.
+func treeModeType(m int) (Type, bool) {
+	switch m {
+	case 100644, 100755, modeSymlink:
+		return TypeBlob, true
+	case modeTree:
+		return TypeTree, true
+	case modeGitlink:
+		return TypeCommit, true
+	}
+	return "", false
+}
+
+// parseMktreeLine reads an ls-tree line: "<mode> SP <type> SP <object> TAB <path>".
+func parseMktreeLine(line string, allowMissing bool) (TreeObjectLine, error) {
+	entry := TreeObjectLine{}
+	meta, name, ok := strings.Cut(line, "\t")
+	fields := strings.Split(meta, " ")
+	if !ok || len(fields) != 3 || !isHexHash(fields[2]) {
+		return entry, fmt.Errorf("input format error: %s", line)
+	}
+	if strings.Contains(name, "/") {
+		return entry, fmt.Errorf("path %s contains slash", name)
+	}
+	if name == "" || name == "." || name == ".." {
+		return entry, fmt.Errorf("invalid path '%s'", name)
+	}
+	m, err := strconv.Atoi(fields[0])
+	expected, legal := treeModeType(m)
+	if err != nil || !legal {
+		return entry, fmt.Errorf("input format error: %s", line)
+	}
+	if Type(fields[1]) != expected {
+		return entry, fmt.Errorf("entry '%s' object type (%s) doesn't match mode type (%s)", name, fields[1], expected)
+	}
+
+	// submodule commits live in another repository
+	if m != modeGitlink {
+		object, err := parseObject(fields[2])
+		switch {
+		case err != nil && !allowMissing:
+			return entry, fmt.Errorf("entry '%s' object %s is unavailable", name, fields[2])
+		case err == nil && object.Type != expected:
+			return entry, fmt.Errorf("entry '%s' object %s is a %s but specified type was (%s)", name, fields[2], object.Type, expected)
+		}
+	}
+	hash, _ := hex.DecodeString(fields[2])
+	return TreeObjectLine{Mode: m, Name: name, Hash: hash}, nil
+}
+
+// writeTreeEntries sorts entries in tree order and writes the tree object.
+func writeTreeEntries(entries []TreeObjectLine) (string, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		return treeEntryKey(entries[i]) < treeEntryKey(entries[j])
+	})
+	var content bytes.Buffer
+	for i, e := range entries {
+		if i > 0 && entries[i-1].Name == e.Name {
+			return "", fmt.Errorf("duplicate entry '%s'", e.Name)
+		}
+		fmt.Fprintf(&content, "%d %s\u0000", e.Mode, e.Name)
+		content.Write(e.Hash)
+	}
+	return writeObject(TypeTree, content.Bytes())
+}
+
+func runMktree(args []string) error {
+	terminator, allowMissing, batch := byte('\n'), false, false
+	for _, arg := range args {
+		switch arg {
+		case "-z":
+			terminator = 0
+		case "--missing":
+			allowMissing = true
+		case "--batch":
+			batch = true
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	entries := make([]TreeObjectLine, 0)
+	haveTree := false
+	flush := func() error {
+		hash, err := writeTreeEntries(entries)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+		entries, haveTree = entries[:0], false
+		return nil
+	}
+	for {
+		line, err := reader.ReadString(terminator)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read stdin: %s", err.Error())
+		}
+		eof := err == io.EOF
+		line = strings.TrimSuffix(line, string(terminator))
+		switch {
+		case line == "" && eof:
+		case line == "" && batch:
+			// in batch mode an empty line ends each tree
+			if err := flush(); err != nil {
+				return err
+			}
+		case line == "":
+			return fmt.Errorf("input format error: (blank line only valid in batch mode)")
+		default:
+			entry, err := parseMktreeLine(line, allowMissing)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			haveTree = true
+		}
+		if eof {
+			break
+		}
+	}
+	if haveTree || !batch {
+		return flush()
+	}
+	return nil
+}
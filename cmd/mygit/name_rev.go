@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a hop to a merge parent weighs more than any first-parent chain
+const mergeTraversalWeight = 65535
+
+type revName struct {
+	TipName    string
+	TaggerDate int64
+	Generation int
+	Distance   int
+	FromTag    bool
+}
+
+func (n *revName) String() string {
+	if n.Generation == 0 {
+		return n.TipName
+	}
+	return fmt.Sprintf("%s~%d", strings.TrimSuffix(n.TipName, "^0"), n.Generation)
+}
+
+// isBetterThan prefers names based on older tags, then tags over other refs,
+// then fewer hops, then older tips.
+func (n *revName) isBetterThan(taggerDate int64, distance int, fromTag bool) bool {
+	if fromTag && n.FromTag {
+		return n.TaggerDate > taggerDate || (n.TaggerDate == taggerDate && n.Distance > distance)
+	}
+	if n.FromTag != fromTag {
+		return fromTag
+	}
+	if n.Distance != distance {
+		return n.Distance > distance
+	}
+	return n.TaggerDate > taggerDate
+}
+
+type nameRevTip struct {
+	Name       string
+	Commit     string
+	TaggerDate int64
+	FromTag    bool
+	Deref      bool
+}
+
+type nameRev struct {
+	names map[string]*revName
+	// tips maps refs pointing at non-commits (annotated tags) to their names
+	tips map[string]string
+}
+
+// peelTip follows tags down to a commit, returning the date of the outermost
+// tag or of the commit itself.
+func peelTip(hash string) (commit string, date int64, deref bool, err error) {
+	object, err := parseObject(hash)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if object.Type != TypeTag {
+		if object.Type != TypeCommit {
+			return "", 0, false, nil
+		}
+		c, err := readCommit(hash)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return hash, c.Committer.When.Unix(), false, nil
+	}
+	for _, line := range strings.Split(string(object.Content), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "tagger "); ok {
+			if ident, err := parseIdentity(value); err == nil {
+				date = ident.When.Unix()
+			}
+		}
+	}
+	commit, err = peelToCommit(hash)
+	if err != nil {
+		return "", 0, false, nil
+	}
+	return commit, date, true, nil
+}
+
+func newNameRev(tagsOnly bool, shortTags bool) (*nameRev, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	nr := &nameRev{names: map[string]*revName{}, tips: map[string]string{}}
+	tips := make([]nameRevTip, 0, len(refs))
+	for _, r := range refs {
+		fromTag := strings.HasPrefix(r.Name, "refs/tags/")
+		if tagsOnly && !fromTag {
+			continue
+		}
+		name := strings.TrimPrefix(r.Name, "refs/")
+		if shortTags {
+			name = shortenRefName(r.Name)
+		} else if strings.HasPrefix(r.Name, "refs/heads/") {
+			name = strings.TrimPrefix(r.Name, "refs/heads/")
+		}
+		commit, date, deref, err := peelTip(r.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if deref {
+			nr.tips[r.Hash] = name
+		}
+		if commit == "" {
+			continue
+		}
+		tips = append(tips, nameRevTip{Name: name, Commit: commit, TaggerDate: date, FromTag: fromTag, Deref: deref})
+	}
+	// tags are named first, oldest first
+	sort.SliceStable(tips, func(i, j int) bool {
+		if tips[i].FromTag != tips[j].FromTag {
+			return tips[i].FromTag
+		}
+		return tips[i].TaggerDate < tips[j].TaggerDate
+	})
+	for _, tip := range tips {
+		if err := nr.nameFrom(tip); err != nil {
+			return nil, err
+		}
+	}
+	return nr, nil
+}
+
+func (nr *nameRev) update(hash string, taggerDate int64, generation int, distance int, fromTag bool) *revName {
+	name, ok := nr.names[hash]
+	if ok && !name.isBetterThan(taggerDate, distance, fromTag) {
+		return nil
+	}
+	if !ok {
+		name = &revName{}
+		nr.names[hash] = name
+	}
+	name.TaggerDate, name.Generation, name.Distance, name.FromTag = taggerDate, generation, distance, fromTag
+	return name
+}
+
+// nameFrom names the ancestors of a tip, walking first parents before the
+// others.
+func (nr *nameRev) nameFrom(tip nameRevTip) error {
+	start := nr.update(tip.Commit, tip.TaggerDate, 0, 0, tip.FromTag)
+	if start == nil {
+		return nil
+	}
+	start.TipName = tip.Name
+	if tip.Deref {
+		start.TipName += "^0"
+	}
+
+	stack := []string{tip.Commit}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		name := nr.names[hash]
+		toVisit := make([]string, 0, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			generation, distance := name.Generation+1, name.Distance+1
+			if i > 0 {
+				generation, distance = 0, name.Distance+mergeTraversalWeight
+			}
+			parentName := nr.update(parent, tip.TaggerDate, generation, distance, tip.FromTag)
+			if parentName == nil {
+				continue
+			}
+			switch {
+			case i == 0:
+				parentName.TipName = name.TipName
+			case name.Generation > 0:
+				parentName.TipName = fmt.Sprintf("%s~%d^%d", strings.TrimSuffix(name.TipName, "^0"), name.Generation, i+1)
+			default:
+				parentName.TipName = fmt.Sprintf("%s^%d", strings.TrimSuffix(name.TipName, "^0"), i+1)
+			}
+			toVisit = append(toVisit, parent)
+		}
+		for i := len(toVisit) - 1; i >= 0; i-- {
+			stack = append(stack, toVisit[i])
+		}
+	}
+	return nil
+}
+
+// nameOf returns the name of an object, or "" when it cannot be named.
+func (nr *nameRev) nameOf(hash string) string {
+	if name, ok := nr.tips[hash]; ok {
+		return name
+	}
+	if name, ok := nr.names[hash]; ok {
+		return name.String()
+	}
+	return ""
+}
+
+// annotate appends the name of every full object hash found in line.
+func (nr *nameRev) annotate(line string, nameOnly bool) string {
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		end := i
+		for end < len(line) && isHexDigit(line[end]) {
+			end++
+		}
+		if end == i {
+			b.WriteByte(line[i])
+			i++
+			continue
+		}
+		word := line[i:end]
+		name := ""
+		if len(word) == 40 {
+			name = nr.nameOf(word)
+		}
+		switch {
+		case name == "":
+			b.WriteString(word)
+		case nameOnly:
+			b.WriteString(name)
+		default:
+			fmt.Fprintf(&b, "%s (%s)", word, name)
+		}
+		i = end
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f'
+}
+
+func runNameRev(args []string) error {
+	tagsOnly, nameOnly, all, annotateStdin := false, false, false, false
+	revs := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "--tags":
+			tagsOnly = true
+		case "--name-only":
+			nameOnly = true
+		case "--all":
+			all = true
+		case "--annotate-stdin", "--stdin":
+			annotateStdin = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			revs = append(revs, arg)
+		}
+	}
+	if !all && !annotateStdin && len(revs) == 0 {
+		return fmt.Errorf("usage: mygit name-rev [--tags] [--name-only] (--all | --annotate-stdin | <commit>...)")
+	}
+
+	// like git, --tags --name-only prints tag names without "tags/"
+	nr, err := newNameRev(tagsOnly, tagsOnly && nameOnly)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if annotateStdin {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			w.WriteString(nr.annotate(line, nameOnly))
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read stdin: %s", err.Error())
+			}
+		}
+	}
+
+	if all {
+		hashes := make([]string, 0, len(nr.names))
+		for hash := range nr.names {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		for _, hash := range hashes {
+			if nameOnly {
+				fmt.Fprintln(w, nr.nameOf(hash))
+			} else {
+				fmt.Fprintf(w, "%s %s\n", hash, nr.nameOf(hash))
+			}
+		}
+		return nil
+	}
+
+	for _, rev := range revs {
+		hash, err := resolveRevision(rev)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get sha1 for %s. Skipping.\n", rev)
+			continue
+		}
+		name := nr.nameOf(hash)
+		if name == "" {
+			name = "undefined"
+		}
+		if nameOnly {
+			fmt.Fprintln(w, name)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", rev, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// objectDirs are the fan-out directories known to exist, so each is only
+// checked once however many objects go into it.
+var objectDirs = map[string]bool{}
+
+// fsyncObjectFiles reports whether core.fsyncObjectFiles asks for objects
+// to reach the disk before they are moved into place.
+func fsyncObjectFiles() bool {
+	config, err := getConfig()
+	if err != nil {
+		return false
+	}
+	enabled, err := config.GetBool("core.fsyncObjectFiles", false)
+	return err == nil && enabled
+}
+
+// objectBatch stages new loose objects in a temporary directory under
+// .git/objects and moves them into place together on commit: the disk is
+// synced once for the whole batch instead of once per object, and the
+// objects only become visible to other processes once all are written.
+type objectBatch struct {
+	base objectStore
+	dir  string
+	// staged maps the hash of each object written to its staged path
+	staged map[string]string
+	fsync  bool
+}
+
+// beginObjectBatch routes object writes through a batch until it is
+// committed or aborted. Objects kept in memory are not batched.
+func beginObjectBatch() (*objectBatch, error) {
+	if _, loose := objects.(looseObjectStore); !loose {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp(getObjectsDir(), "tmp_objdir_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary object directory: %s", err.Error())
+	}
+	registerTempFile(dir)
+	b := &objectBatch{base: objects, dir: dir, staged: map[string]string{}, fsync: fsyncObjectFiles()}
+	objects = b
+	return b, nil
+}
+
+func (b *objectBatch) Has(hash string) bool {
+	if _, ok := b.staged[hash]; ok {
+		return true
+	}
+	return b.base.Has(hash)
+}
+
+func (b *objectBatch) Open(hash string) (io.ReadCloser, string, error) {
+	path, ok := b.staged[hash]
+	if !ok {
+		return b.base.Open(hash)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, path, fmt.Errorf("failed to open %s: %s", path, err.Error())
+	}
+	return f, path, nil
+}
+
+func (b *objectBatch) Iterate(prefix string, fn func(hash string) error) error {
+	found := map[string]bool{}
+	for hash := range b.staged {
+		if strings.HasPrefix(hash, prefix) {
+			found[hash] = true
+		}
+	}
+	err := b.base.Iterate(prefix, func(hash string) error {
+		found[hash] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(found))
+	for hash := range found {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchObjectWriter writes an object into the batch's directory, where it
+// is renamed after its hash.
+type batchObjectWriter struct {
+	batch *objectBatch
+	f     *os.File
+	bw    *bufio.Writer
+}
+
+func (b *objectBatch) Create() (objectWriter, error) {
+	f, err := createTempFile(b.dir, "tmp_obj_")
+	if err != nil {
+		return nil, fmt.Errorf("failed create temporary object file: %s", err.Error())
+	}
+	return &batchObjectWriter{batch: b, f: f, bw: bufio.NewWriter(f)}, nil
+}
+
+func (w *batchObjectWriter) Write(p []byte) (int, error) {
+	return w.bw.Write(p)
+}
+
+func (w *batchObjectWriter) Commit(hash string) error {
+	defer forgetTempFile(w.f.Name())
+	err := w.bw.Flush()
+	if err == nil {
+		err = w.f.Chmod(mode)
+	}
+	if err == nil && w.batch.fsync {
+		err = startWriteout(w.f)
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	staged := filepath.Join(w.batch.dir, hash)
+	if err == nil {
+		err = os.Rename(w.f.Name(), staged)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return fmt.Errorf("failed write to object file for hash %s: %s", hash, err.Error())
+	}
+	w.batch.staged[hash] = staged
+	return nil
+}
+
+func (w *batchObjectWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+	forgetTempFile(w.f.Name())
+}
+
+// commit moves the staged objects into place, after syncing them when
+// core.fsyncObjectFiles is set, and restores the store the batch wrapped.
+func (b *objectBatch) commit() error {
+	if b == nil {
+		return nil
+	}
+	objects = b.base
+	defer b.remove()
+	if b.fsync && len(b.staged) > 0 {
+		// the writes were started as each object was committed; syncing
+		// any file on the same filesystem waits for them and flushes the
+		// disk's cache
+		f, err := os.CreateTemp(b.dir, "sync_")
+		if err == nil {
+			err = f.Sync()
+			f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to sync objects: %s", err.Error())
+		}
+	}
+	hashes := make([]string, 0, len(b.staged))
+	for hash := range b.staged {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	for _, hash := range hashes {
+		if err := createObjectDir(hash); err != nil {
+			return fmt.Errorf("failed to create object directory for %s: %s", hash, err.Error())
+		}
+		if err := os.Rename(b.staged[hash], getObjectPath(hash)); err != nil {
+			return fmt.Errorf("failed to move object %s into place: %s", hash, err.Error())
+		}
+	}
+	return nil
+}
+
+// abort drops the staged objects and restores the store the batch wrapped.
+// It does nothing once the batch was committed, so it can be deferred.
+func (b *objectBatch) abort() {
+	if b == nil || objects != objectStore(b) {
+		return
+	}
+	objects = b.base
+	b.remove()
+}
+
+func (b *objectBatch) remove() {
+	os.RemoveAll(b.dir)
+	forgetTempFile(b.dir)
+}
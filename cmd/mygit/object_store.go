@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// objectStore keeps objects in the loose format: zlib-compressed
+// "<type> <size>\0<content>". Hashing, compression and verification stay
+// with the callers, a store only holds the bytes.
+type objectStore interface {
+	// Has reports whether the object is stored.
+	Has(hash string) bool
+	// Open returns the compressed object, and where it is kept for error
+	// messages.
+	Open(hash string) (io.ReadCloser, string, error)
+	// Create starts a new object, whose hash is only known once everything
+	// is written.
+	Create() (objectWriter, error)
+	// Iterate calls fn with the stored objects whose hash starts with
+	// prefix, in hash order.
+	Iterate(prefix string, fn func(hash string) error) error
+}
+
+type objectWriter interface {
+	io.Writer
+	// Commit stores what was written under hash.
+	Commit(hash string) error
+	// Abort drops what was written.
+	Abort()
+}
+
+// objects is the store all object reads and writes go through, the loose
+// objects under .git/objects unless replaced.
+var objects objectStore = looseObjectStore{}
+
+// objectWriteHooks are called after each object is written, including
+// objects that were already stored.
+var objectWriteHooks []func(hash string, objectType Type, size int)
+
+func objectWritten(hash string, objectType Type, size int) {
+	traceObject("write", hash, objectType, size)
+	for _, hook := range objectWriteHooks {
+		hook(hash, objectType, size)
+	}
+}
+
+type looseObjectStore struct{}
+
+func (looseObjectStore) Has(hash string) bool {
+	_, err := os.Stat(getObjectPath(hash))
+	return err == nil
+}
+
+func (looseObjectStore) Open(hash string) (io.ReadCloser, string, error) {
+	objectPath := getObjectPath(hash)
+	f, err := os.Open(objectPath)
+	if os.IsNotExist(err) {
+		return nil, objectPath, errorWithCode("missing-object", "failed to open %s: %s", objectPath, err.Error())
+	}
+	if err != nil {
+		return nil, objectPath, fmt.Errorf("failed to open %s: %s", objectPath, err.Error())
+	}
+	return f, objectPath, nil
+}
+
+func (looseObjectStore) Iterate(prefix string, fn func(hash string) error) error {
+	objectsDir := getObjectsDir()
+	dirs := []string{}
+	if len(prefix) >= 2 {
+		if _, err := os.Stat(filepath.Join(objectsDir, prefix[:2])); err == nil {
+			dirs = append(dirs, prefix[:2])
+		}
+	} else {
+		entries, err := os.ReadDir(objectsDir)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", objectsDir, err.Error())
+		}
+		for _, e := range entries {
+			if e.IsDir() && len(e.Name()) == 2 {
+				dirs = append(dirs, e.Name())
+			}
+		}
+	}
+	for _, dir := range dirs {
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", dir, err.Error())
+		}
+		for _, file := range files {
+			hash := dir + file.Name()
+			if !isHexHash(hash) || hash[:min(len(prefix), len(hash))] != prefix {
+				continue
+			}
+			if err := fn(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// looseObjectWriter writes under a temporary name so an interrupted write
+// never leaves a truncated object behind. The fan-out directory is only
+// known once everything is hashed.
+type looseObjectWriter struct {
+	f  *os.File
+	bw *bufio.Writer
+}
+
+func (looseObjectStore) Create() (objectWriter, error) {
+	f, err := createTempFile(getObjectsDir(), "tmp_obj_")
+	if err != nil {
+		return nil, fmt.Errorf("failed create temporary object file: %s", err.Error())
+	}
+	return &looseObjectWriter{f: f, bw: bufio.NewWriter(f)}, nil
+}
+
+func (w *looseObjectWriter) Write(p []byte) (int, error) {
+	return w.bw.Write(p)
+}
+
+func (w *looseObjectWriter) Commit(hash string) error {
+	defer forgetTempFile(w.f.Name())
+	err := w.bw.Flush()
+	if err == nil {
+		err = w.f.Chmod(mode)
+	}
+	if err == nil && fsyncObjectFiles() {
+		err = w.f.Sync()
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = createObjectDir(hash)
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), getObjectPath(hash))
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return fmt.Errorf("failed write to object file for hash %s: %s", hash, err.Error())
+	}
+	return nil
+}
+
+func (w *looseObjectWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+	forgetTempFile(w.f.Name())
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// packIndexEntry is one object of a pack index: where it starts in the pack
+// and the CRC32 of its packed data, which version 1 indexes do not record.
+type packIndexEntry struct {
+	Hash   string
+	Offset uint64
+	CRC    uint32
+}
+
+// parsePackIndex reads a version 1 or 2 pack index, returning its entries
+// in the order stored, sorted by hash.
+func parsePackIndex(data []byte) (version int, entries []packIndexEntry, err error) {
+	version = 1
+	if len(data) >= 8 && string(data[:4]) == "\377tOc" {
+		if version = int(binary.BigEndian.Uint32(data[4:8])); version != 2 {
+			return 0, nil, fmt.Errorf("unknown index version %d", version)
+		}
+		data = data[8:]
+	}
+	if len(data) < 256*4 {
+		return 0, nil, fmt.Errorf("pack index is too small")
+	}
+	count := int(binary.BigEndian.Uint32(data[255*4:]))
+	data = data[256*4:]
+
+	// the size is checked before anything is allocated for count, which a
+	// corrupt index could make arbitrarily large; the index ends with the
+	// pack's checksum and its own
+	if version == 1 {
+		if len(data) < count*24+40 {
+			return 0, nil, fmt.Errorf("pack index is truncated")
+		}
+		entries = make([]packIndexEntry, count)
+		for i := range entries {
+			entry := data[i*24:]
+			entries[i] = packIndexEntry{Hash: hex.EncodeToString(entry[4:24]), Offset: uint64(binary.BigEndian.Uint32(entry))}
+		}
+		return version, entries, nil
+	}
+
+	// hashes, then CRCs, then 31 bit offsets, their top bit set for those
+	// in the table of 64 bit offsets that follows
+	if len(data) < count*28+40 {
+		return 0, nil, fmt.Errorf("pack index is truncated")
+	}
+	crcs, offsets, large := data[count*20:], data[count*24:], data[count*28:len(data)-40]
+	entries = make([]packIndexEntry, count)
+	for i := range entries {
+		offset := uint64(binary.BigEndian.Uint32(offsets[i*4:]))
+		if offset&0x80000000 != 0 {
+			at := int(offset&0x7fffffff) * 8
+			if len(large) < at+8 {
+				return 0, nil, fmt.Errorf("pack index is truncated")
+			}
+			offset = binary.BigEndian.Uint64(large[at:])
+		}
+		entries[i] = packIndexEntry{
+			Hash:   hex.EncodeToString(data[i*20 : i*20+20]),
+			Offset: offset,
+			CRC:    binary.BigEndian.Uint32(crcs[i*4:]),
+		}
+	}
+	return version, entries, nil
+}
+
+// runShowIndex dumps the pack index read from stdin, an object per line as
+// "<offset> <hash>", followed by "(<crc>)" for version 2 indexes.
+func runShowIndex(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: mygit show-index < <pack-idx-file>")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %s", err.Error())
+	}
+	version, entries, err := parsePackIndex(data)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, e := range entries {
+		if version == 1 {
+			fmt.Fprintf(w, "%d %s\n", e.Offset, e.Hash)
+		} else {
+			fmt.Fprintf(w, "%d %s (%08x)\n", e.Offset, e.Hash, e.CRC)
+		}
+	}
+	return nil
+}
+
+// Pack entry types; 5 is reserved.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypeNames = map[int]string{
+	packCommit:   "commit",
+	packTree:     "tree",
+	packBlob:     "blob",
+	packTag:      "tag",
+	packOfsDelta: "ofs-delta",
+	packRefDelta: "ref-delta",
+}
+
+// packEntry is an object as stored in a pack: its data is Size bytes once
+// inflated from Stored bytes, and a delta names its base by offset, or by
+// hash for ref-deltas.
+type packEntry struct {
+	Offset     uint64
+	Type       int
+	Size       uint64
+	Stored     uint64
+	BaseOffset uint64
+	BaseHash   string
+}
+
+// readPackEntries walks the entries of a pack in order, inflating each to
+// find where the next one starts.
+func readPackEntries(data []byte) (version int, entries []packEntry, err error) {
+	if len(data) < 12+20 || string(data[:4]) != "PACK" {
+		return 0, nil, fmt.Errorf("not a pack file")
+	}
+	version = int(binary.BigEndian.Uint32(data[4:8]))
+	if version != 2 && version != 3 {
+		return 0, nil, fmt.Errorf("unknown pack version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	body := data[:len(data)-20]
+	r := bytes.NewReader(body)
+	r.Seek(12, io.SeekStart)
+	entries = make([]packEntry, 0, count)
+	for i := 0; i < count; i++ {
+		e := packEntry{Offset: uint64(len(body) - r.Len())}
+		// type and size: 3 type bits and 4 size bits, then 7 size bits a
+		// byte while the top bit is set
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("pack is truncated at entry %d", i)
+		}
+		e.Type, e.Size = int(c>>4&7), uint64(c&15)
+		for shift := 4; c&0x80 != 0; shift += 7 {
+			if c, err = r.ReadByte(); err != nil {
+				return 0, nil, fmt.Errorf("pack is truncated at entry %d", i)
+			}
+			e.Size |= uint64(c&0x7f) << shift
+		}
+		switch e.Type {
+		case packOfsDelta:
+			// the distance back to the base, big-endian with one added to
+			// each byte but the last
+			c, err := r.ReadByte()
+			distance := uint64(c & 0x7f)
+			for err == nil && c&0x80 != 0 {
+				c, err = r.ReadByte()
+				distance = (distance+1)<<7 | uint64(c&0x7f)
+			}
+			if err != nil || distance > e.Offset {
+				return 0, nil, fmt.Errorf("bad delta base offset at %d", e.Offset)
+			}
+			e.BaseOffset = e.Offset - distance
+		case packRefDelta:
+			base := make([]byte, 20)
+			if _, err := io.ReadFull(r, base); err != nil {
+				return 0, nil, fmt.Errorf("pack is truncated at entry %d", i)
+			}
+			e.BaseHash = hex.EncodeToString(base)
+		case packCommit, packTree, packBlob, packTag:
+		default:
+			return 0, nil, fmt.Errorf("bad object type %d at %d", e.Type, e.Offset)
+		}
+
+		start := r.Len()
+		zr, err := getZlibReader(r)
+		if err != nil {
+			return 0, nil, fmt.Errorf("bad compressed data at %d: %s", e.Offset, err.Error())
+		}
+		inflated, err := io.Copy(io.Discard, zr)
+		putZlibReader(zr)
+		if err != nil || uint64(inflated) != e.Size {
+			return 0, nil, fmt.Errorf("bad compressed data at %d", e.Offset)
+		}
+		e.Stored = uint64(start - r.Len())
+		entries = append(entries, e)
+	}
+	return version, entries, nil
+}
+
+// packTypeStats adds up the entries of a type.
+type packTypeStats struct {
+	Count  int
+	Size   uint64
+	Stored uint64
+}
+
+// compressionRatio is how much of the inflated size is stored, in percent.
+func compressionRatio(stored uint64, size uint64) uint64 {
+	if size == 0 {
+		return 100
+	}
+	return stored * 100 / size
+}
+
+// runPackInfo summarises a pack: its objects by type with how well each
+// type compresses, and how long the chains of deltas are. Ref-delta bases
+// are found through the pack's index when it has one.
+func runPackInfo(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mygit pack-info <pack>")
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(args[0], ".idx"), ".pack")
+	data, err := os.ReadFile(path + ".pack")
+	if err != nil {
+		return fmt.Errorf("failed to read %s.pack: %s", path, err.Error())
+	}
+	version, entries, err := readPackEntries(data)
+	if err != nil {
+		return fmt.Errorf("%s.pack: %s", path, err.Error())
+	}
+	offsets := map[string]uint64{}
+	if idx, err := os.ReadFile(path + ".idx"); err == nil {
+		_, indexed, err := parsePackIndex(idx)
+		if err != nil {
+			return fmt.Errorf("%s.idx: %s", path, err.Error())
+		}
+		for _, e := range indexed {
+			offsets[e.Hash] = e.Offset
+		}
+	}
+
+	byOffset := make(map[uint64]*packEntry, len(entries))
+	byType := map[int]*packTypeStats{}
+	var total packTypeStats
+	for i := range entries {
+		e := &entries[i]
+		byOffset[e.Offset] = e
+		if byType[e.Type] == nil {
+			byType[e.Type] = &packTypeStats{}
+		}
+		for _, s := range []*packTypeStats{byType[e.Type], &total} {
+			s.Count++
+			s.Size += e.Size
+			s.Stored += e.Stored
+		}
+	}
+
+	// depths holds the chain length of each delta, the number of deltas to
+	// apply to get at the object; bases outside the pack count as one
+	depths := map[uint64]int{}
+	var depth func(e *packEntry) int
+	depth = func(e *packEntry) int {
+		if e.Type != packOfsDelta && e.Type != packRefDelta {
+			return 0
+		}
+		if d, ok := depths[e.Offset]; ok {
+			return d
+		}
+		depths[e.Offset] = 1
+		baseOffset, found := e.BaseOffset, e.Type == packOfsDelta
+		if e.Type == packRefDelta {
+			baseOffset, found = offsets[e.BaseHash]
+		}
+		if base := byOffset[baseOffset]; found && base != nil {
+			depths[e.Offset] = depth(base) + 1
+		}
+		return depths[e.Offset]
+	}
+	chains := map[int]int{}
+	for i := range entries {
+		if d := depth(&entries[i]); d > 0 {
+			chains[d]++
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s.pack: version %d, %s\n", path, version, plural(len(entries), "object", "objects"))
+	for _, t := range []int{packCommit, packTree, packBlob, packTag, packOfsDelta, packRefDelta} {
+		if s := byType[t]; s != nil {
+			fmt.Fprintf(w, "  %-10s %8d  %12s stored of %12s  (%d%%)\n", packTypeNames[t], s.Count,
+				humaniseBytes(int64(s.Stored)), humaniseBytes(int64(s.Size)), compressionRatio(s.Stored, s.Size))
+		}
+	}
+	fmt.Fprintf(w, "  %-10s %8d  %12s stored of %12s  (%d%%)\n", "total", total.Count,
+		humaniseBytes(int64(total.Stored)), humaniseBytes(int64(total.Size)), compressionRatio(total.Stored, total.Size))
+
+	if len(chains) > 0 {
+		lengths := make([]int, 0, len(chains))
+		for length := range chains {
+			lengths = append(lengths, length)
+		}
+		sort.Ints(lengths)
+		fmt.Fprintln(w, "delta chains:")
+		for _, length := range lengths {
+			fmt.Fprintf(w, "  chain length = %d: %s\n", length, plural(chains[length], "object", "objects"))
+		}
+	}
+	return nil
+}
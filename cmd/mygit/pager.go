@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// commands whose output is paged unless pager.<cmd> says otherwise
+var pagedCommands = map[string]bool{
+	"log":      true,
+	"shortlog": true,
+}
+
+type pagerProcess struct {
+	cmd    *exec.Cmd
+	stdout *os.File
+	stderr *os.File
+}
+
+var runningPager *pagerProcess
+
+// isTerminal reports whether f is a terminal. /dev/null is a character
+// device too, so it is ruled out explicitly.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// pagerCommand returns the pager to run for command, or "" for none. paginate
+// is nil unless -p/--paginate or -P/--no-pager was given.
+func pagerCommand(command string, paginate *bool) string {
+	config, err := getConfig()
+	if err != nil {
+		config = &Config{}
+	}
+	pager := firstSetting(config, "GIT_PAGER", "core.pager", "PAGER", "", "less")
+
+	enabled := pagedCommands[command]
+	if value, ok := config.Get("pager." + command); ok {
+		if on, err := parseConfigBool(value); err == nil {
+			enabled = on
+		} else {
+			enabled, pager = true, value
+		}
+	}
+	if paginate != nil {
+		enabled = *paginate
+	}
+	if !enabled || pager == "cat" {
+		return ""
+	}
+	return pager
+}
+
+// setupPager starts the pager for command when stdout is a terminal and
+// points os.Stdout (and os.Stderr, if it is a terminal too) at it.
+func setupPager(command string, paginate *bool) {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	pager := pagerCommand(command, paginate)
+	if pager == "" {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if os.Getenv("LV") == "" {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	traceRunCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return
+	}
+	r.Close()
+
+	runningPager = &pagerProcess{cmd: cmd, stdout: os.Stdout, stderr: os.Stderr}
+	os.Stdout = w
+	if isTerminal(os.Stderr) {
+		os.Stderr = w
+	}
+}
+
+// stopPager closes the pipe to the pager and waits for the user to quit it.
+func stopPager() {
+	if runningPager == nil {
+		return
+	}
+	os.Stdout.Close()
+	os.Stdout, os.Stderr = runningPager.stdout, runningPager.stderr
+	runningPager.cmd.Wait()
+	runningPager = nil
+}
+
+// exit stops helper processes before exiting, which deferred calls in main
+// would not get to do.
+func exit(code int) {
+	removeTempFiles()
+	stopPager()
+	stopFilterProcesses()
+	traceExit(code)
+	os.Exit(code)
+}
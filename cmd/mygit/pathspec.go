@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pathspecItem is one parsed pathspec argument. Pattern is relative to the
+// top of the worktree, "" naming everything: like in git, pathspecs are
+// taken from the directory the command was started in unless they have the
+// "top" magic.
+type pathspecItem struct {
+	Original string
+	Pattern  string
+	Exclude  bool
+	Icase    bool
+	Literal  bool
+	Glob     bool
+}
+
+// pathspec limits a command to the paths named by its items. A path matches
+// when one of the positive items names it and no exclude item does; with
+// only exclude items everything else matches, and an empty pathspec matches
+// everything.
+type pathspec []pathspecItem
+
+// pathspecMagicChars are the characters that may start short form magic.
+// Only '/' (top), '!' and '^' (exclude) are known.
+const pathspecMagicChars = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+func parsePathspecs(args []string) (pathspec, error) {
+	ps := make(pathspec, 0, len(args))
+	for _, arg := range args {
+		item, err := parsePathspecItem(arg)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, item)
+	}
+	return ps, nil
+}
+
+// parsePathspecItem parses the magic of a pathspec, either the long form
+// ":(top,icase,exclude)pattern" or the short form ":/!pattern".
+func parsePathspecItem(arg string) (pathspecItem, error) {
+	item := pathspecItem{Original: arg}
+	pattern, top := arg, false
+	switch {
+	case strings.HasPrefix(arg, ":("):
+		end := strings.IndexByte(arg, ')')
+		if end == -1 {
+			return item, fmt.Errorf("Missing ')' at the end of pathspec magic in '%s'", arg)
+		}
+		for _, magic := range strings.Split(arg[2:end], ",") {
+			switch strings.TrimSpace(magic) {
+			case "top":
+				top = true
+			case "":
+			case "exclude":
+				item.Exclude = true
+			case "icase":
+				item.Icase = true
+			case "literal":
+				item.Literal = true
+			case "glob":
+				item.Glob = true
+			default:
+				return item, fmt.Errorf("Invalid pathspec magic '%s' in '%s'", magic, arg)
+			}
+		}
+		pattern = arg[end+1:]
+	case strings.HasPrefix(arg, ":"):
+		i := 1
+		for ; i < len(arg) && strings.IndexByte(pathspecMagicChars, arg[i]) != -1; i++ {
+			switch arg[i] {
+			case '/':
+				top = true
+			case '!', '^':
+				item.Exclude = true
+			case ':':
+			default:
+				return item, fmt.Errorf("Unimplemented pathspec magic '%c' in '%s'", arg[i], arg)
+			}
+			if arg[i] == ':' {
+				i++
+				break
+			}
+		}
+		pattern = arg[i:]
+	}
+	if item.Literal && item.Glob {
+		return item, fmt.Errorf("'literal' and 'glob' pathspec magic are incompatible")
+	}
+
+	if !top && worktreePrefix != "" {
+		pattern = worktreePrefix + pattern
+	}
+	if pattern != "" {
+		pattern = filepath.ToSlash(filepath.Clean(pattern))
+		if pattern == "." {
+			pattern = ""
+		}
+	}
+	if strings.HasPrefix(pattern, "../") || pattern == ".." {
+		return item, fmt.Errorf("%s: '%s' is outside repository", arg, pattern)
+	}
+	item.Pattern = pattern
+	return item, nil
+}
+
+func (item pathspecItem) hasWildcard() bool {
+	return !item.Literal && strings.ContainsAny(item.Pattern, "*?[\\")
+}
+
+// matches reports whether the item names path: the path itself, a file under
+// it when it is a directory, or a path matching it as a glob. Without glob
+// magic wildcards match across '/', like git's default pathspecs.
+func (item pathspecItem) matches(path string) bool {
+	pattern := item.Pattern
+	if pattern == "" {
+		return true
+	}
+	if item.Icase {
+		pattern, path = strings.ToLower(pattern), strings.ToLower(path)
+	}
+	if path == pattern || strings.HasPrefix(path, pattern+"/") {
+		return true
+	}
+	if !item.hasWildcard() {
+		return false
+	}
+	if item.Glob {
+		return wildmatch(pattern, path)
+	}
+	return fnmatch(pattern, path)
+}
+
+// matches reports whether path is selected by ps.
+func (ps pathspec) matches(path string) bool {
+	if len(ps) == 0 {
+		return true
+	}
+	included, positive := false, false
+	for _, item := range ps {
+		if item.Exclude {
+			if item.matches(path) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		included = included || item.matches(path)
+	}
+	return included || !positive
+}
+
+// unmatched returns the first positive item naming none of paths, for the
+// "did not match any file(s)" errors.
+func (ps pathspec) unmatched(paths []string) (pathspecItem, bool) {
+	for _, item := range ps {
+		if item.Exclude {
+			continue
+		}
+		matched := false
+		for _, path := range paths {
+			if matched = item.matches(path); matched {
+				break
+			}
+		}
+		if !matched {
+			return item, true
+		}
+	}
+	return pathspecItem{}, false
+}
+
+// filterChanges keeps the changes to paths selected by ps.
+func filterChanges(changes []fileChange, ps pathspec) []fileChange {
+	if len(ps) == 0 {
+		return changes
+	}
+	filtered := make([]fileChange, 0, len(changes))
+	for _, c := range changes {
+		if ps.matches(c.Path) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const maxPktDataLen = 65516
+
+func writePktLine(w io.Writer, data []byte) error {
+	if len(data) > maxPktDataLen {
+		return fmt.Errorf("pkt-line too long: %d bytes", len(data))
+	}
+	tracePacketLine(true, data, false)
+	_, err := fmt.Fprintf(w, "%04x", len(data)+4)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writePktString(w io.Writer, s string) error {
+	return writePktLine(w, []byte(s+"\n"))
+}
+
+func writePktFlush(w io.Writer) error {
+	tracePacketLine(true, nil, true)
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// writePktData splits arbitrary content into as many pkt-lines as needed and
+// terminates it with a flush packet.
+func writePktData(w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), maxPktDataLen)
+		if err := writePktLine(w, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return writePktFlush(w)
+}
+
+// writePktStream is writePktData for content read from r, holding at most
+// one pkt-line of it in memory.
+func writePktStream(w io.Writer, r io.Reader) error {
+	buf := make([]byte, maxPktDataLen)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := writePktLine(w, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return writePktFlush(w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readPktLine returns the payload of the next pkt-line, or flush=true for a
+// flush packet.
+func readPktLine(r io.Reader) (data []byte, flush bool, err error) {
+	var header [4]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return nil, false, err
+	}
+	length, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("bad pkt-line length %q", header)
+	}
+	if length == 0 {
+		tracePacketLine(false, nil, true)
+		return nil, true, nil
+	}
+	if length < 4 {
+		return nil, false, fmt.Errorf("bad pkt-line length %d", length)
+	}
+	data = make([]byte, length-4)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, false, err
+	}
+	tracePacketLine(false, data, false)
+	return data, false, nil
+}
+
+// readPktStrings reads text pkt-lines up to the next flush packet.
+func readPktStrings(r io.Reader) ([]string, error) {
+	lines := make([]string, 0, 4)
+	for {
+		data, flush, err := readPktLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return lines, nil
+		}
+		if len(data) > 0 && data[len(data)-1] == '\n' {
+			data = data[:len(data)-1]
+		}
+		lines = append(lines, string(data))
+	}
+}
+
+// copyPktData writes binary pkt-lines up to the next flush packet to w.
+func copyPktData(w io.Writer, r io.Reader) error {
+	for {
+		data, flush, err := readPktLine(r)
+		if err != nil || flush {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"hash"
+	"io"
+	"sync"
+)
+
+// The object read and write paths run once per object, so importing a large
+// tree would otherwise allocate a compressor, a hasher and scratch buffers
+// per file. These pools hand them out again once an object is done.
+
+// maxPooledBufferSize keeps the buffer of a single huge object from being
+// held on to after it was written.
+const maxPooledBufferSize = 1 << 20
+
+var (
+	zlibWriters  = sync.Pool{New: func() any { return zlib.NewWriter(nil) }}
+	zlibReaders  sync.Pool
+	bufioReaders = sync.Pool{New: func() any { return bufio.NewReader(nil) }}
+	sha1Hashers  = sync.Pool{New: func() any { return sha1.New() }}
+	buffers      = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+func getZlibWriter(w io.Writer) *zlib.Writer {
+	zw := zlibWriters.Get().(*zlib.Writer)
+	zw.Reset(w)
+	return zw
+}
+
+func putZlibWriter(zw *zlib.Writer) {
+	zw.Reset(nil)
+	zlibWriters.Put(zw)
+}
+
+// getZlibReader starts inflating r, which fails when r does not begin with a
+// zlib header.
+func getZlibReader(r io.Reader) (io.ReadCloser, error) {
+	if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			zlibReaders.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+	return zlib.NewReader(r)
+}
+
+func putZlibReader(zr io.ReadCloser) {
+	zr.Close()
+	zlibReaders.Put(zr)
+}
+
+func getBufioReader(r io.Reader) *bufio.Reader {
+	br := bufioReaders.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putBufioReader(br *bufio.Reader) {
+	br.Reset(nil)
+	bufioReaders.Put(br)
+}
+
+func getHasher() hash.Hash {
+	h := sha1Hashers.Get().(hash.Hash)
+	h.Reset()
+	return h
+}
+
+func putHasher(h hash.Hash) {
+	sha1Hashers.Put(h)
+}
+
+func getBuffer() *bytes.Buffer {
+	b := buffers.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	buffers.Put(b)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// compositionTable lists the canonical compositions of the scripts in the
+// Basic Multilingual Plane below U+3000, as base, combining mark and
+// precomposed character. Hangul is composed algorithmically instead.
+const compositionTable = "" +
+	"A\u0300ÀA\u0301ÁA\u0302ÂA\u0303ÃA\u0308ÄA\u030aÅC\u0327ÇE\u0300È" +
+	"E\u0301ÉE\u0302ÊE\u0308ËI\u0300ÌI\u0301ÍI\u0302ÎI\u0308ÏN\u0303Ñ" +
+	"O\u0300ÒO\u0301ÓO\u0302ÔO\u0303ÕO\u0308ÖU\u0300ÙU\u0301ÚU\u0302Û" +
+	"U\u0308ÜY\u0301Ýa\u0300àa\u0301áa\u0302âa\u0303ãa\u0308äa\u030aå" +
+	"c\u0327çe\u0300èe\u0301ée\u0302êe\u0308ëi\u0300ìi\u0301íi\u0302î" +
+	"i\u0308ïn\u0303ño\u0300òo\u0301óo\u0302ôo\u0303õo\u0308öu\u0300ù" +
+	"u\u0301úu\u0302ûu\u0308üy\u0301ýy\u0308ÿA\u0304Āa\u0304āA\u0306Ă" +
+	"a\u0306ăA\u0328Ąa\u0328ąC\u0301Ćc\u0301ćC\u0302Ĉc\u0302ĉC\u0307Ċ" +
+	"c\u0307ċC\u030cČc\u030cčD\u030cĎd\u030cďE\u0304Ēe\u0304ēE\u0306Ĕ" +
+	"e\u0306ĕE\u0307Ėe\u0307ėE\u0328Ęe\u0328ęE\u030cĚe\u030cěG\u0302Ĝ" +
+	"g\u0302ĝG\u0306Ğg\u0306ğG\u0307Ġg\u0307ġG\u0327Ģg\u0327ģH\u0302Ĥ" +
+	"h\u0302ĥI\u0303Ĩi\u0303ĩI\u0304Īi\u0304īI\u0306Ĭi\u0306ĭI\u0328Į" +
+	"i\u0328įI\u0307İJ\u0302Ĵj\u0302ĵK\u0327Ķk\u0327ķL\u0301Ĺl\u0301ĺ" +
+	"L\u0327Ļl\u0327ļL\u030cĽl\u030cľN\u0301Ńn\u0301ńN\u0327Ņn\u0327ņ" +
+	"N\u030cŇn\u030cňO\u0304Ōo\u0304ōO\u0306Ŏo\u0306ŏO\u030bŐo\u030bő" +
+	"R\u0301Ŕr\u0301ŕR\u0327Ŗr\u0327ŗR\u030cŘr\u030cřS\u0301Śs\u0301ś" +
+	"S\u0302Ŝs\u0302ŝS\u0327Şs\u0327şS\u030cŠs\u030cšT\u0327Ţt\u0327ţ" +
+	"T\u030cŤt\u030cťU\u0303Ũu\u0303ũU\u0304Ūu\u0304ūU\u0306Ŭu\u0306ŭ" +
+	"U\u030aŮu\u030aůU\u030bŰu\u030bűU\u0328Ųu\u0328ųW\u0302Ŵw\u0302ŵ" +
+	"Y\u0302Ŷy\u0302ŷY\u0308ŸZ\u0301Źz\u0301źZ\u0307Żz\u0307żZ\u030cŽ" +
+	"z\u030cžO\u031bƠo\u031bơU\u031bƯu\u031bưA\u030cǍa\u030cǎI\u030cǏ" +
+	"i\u030cǐO\u030cǑo\u030cǒU\u030cǓu\u030cǔÜ\u0304Ǖü\u0304ǖÜ\u0301Ǘ" +
+	"ü\u0301ǘÜ\u030cǙü\u030cǚÜ\u0300Ǜü\u0300ǜÄ\u0304Ǟä\u0304ǟȦ\u0304Ǡ" +
+	"ȧ\u0304ǡÆ\u0304Ǣæ\u0304ǣG\u030cǦg\u030cǧK\u030cǨk\u030cǩO\u0328Ǫ" +
+	"o\u0328ǫǪ\u0304Ǭǫ\u0304ǭƷ\u030cǮʒ\u030cǯj\u030cǰG\u0301Ǵg\u0301ǵ" +
+	"N\u0300Ǹn\u0300ǹÅ\u0301Ǻå\u0301ǻÆ\u0301Ǽæ\u0301ǽØ\u0301Ǿø\u0301ǿ" +
+	"A\u030fȀa\u030fȁA\u0311Ȃa\u0311ȃE\u030fȄe\u030fȅE\u0311Ȇe\u0311ȇ" +
+	"I\u030fȈi\u030fȉI\u0311Ȋi\u0311ȋO\u030fȌo\u030fȍO\u0311Ȏo\u0311ȏ" +
+	"R\u030fȐr\u030fȑR\u0311Ȓr\u0311ȓU\u030fȔu\u030fȕU\u0311Ȗu\u0311ȗ" +
+	"S\u0326Șs\u0326șT\u0326Țt\u0326țH\u030cȞh\u030cȟA\u0307Ȧa\u0307ȧ" +
+	"E\u0327Ȩe\u0327ȩÖ\u0304Ȫö\u0304ȫÕ\u0304Ȭõ\u0304ȭO\u0307Ȯo\u0307ȯ" +
+	"Ȯ\u0304Ȱȯ\u0304ȱY\u0304Ȳy\u0304ȳ\u00a8\u0301\u0385Α\u0301Ά" +
+	"Ε\u0301ΈΗ\u0301ΉΙ\u0301ΊΟ\u0301ΌΥ\u0301ΎΩ\u0301Ώϊ\u0301ΐΙ\u0308Ϊ" +
+	"Υ\u0308Ϋα\u0301άε\u0301έη\u0301ήι\u0301ίϋ\u0301ΰι\u0308ϊυ\u0308ϋ" +
+	"ο\u0301όυ\u0301ύω\u0301ώϒ\u0301ϓϒ\u0308ϔЕ\u0300ЀЕ\u0308ЁГ\u0301Ѓ" +
+	"І\u0308ЇК\u0301ЌИ\u0300ЍУ\u0306ЎИ\u0306Йи\u0306йе\u0300ѐе\u0308ё" +
+	"г\u0301ѓі\u0308їк\u0301ќи\u0300ѝу\u0306ўѴ\u030fѶѵ\u030fѷЖ\u0306Ӂ" +
+	"ж\u0306ӂА\u0306Ӑа\u0306ӑА\u0308Ӓа\u0308ӓЕ\u0306Ӗе\u0306ӗӘ\u0308Ӛ" +
+	"ә\u0308ӛЖ\u0308Ӝж\u0308ӝЗ\u0308Ӟз\u0308ӟИ\u0304Ӣи\u0304ӣИ\u0308Ӥ" +
+	"и\u0308ӥО\u0308Ӧо\u0308ӧӨ\u0308Ӫө\u0308ӫЭ\u0308Ӭэ\u0308ӭУ\u0304Ӯ" +
+	"у\u0304ӯУ\u0308Ӱу\u0308ӱУ\u030bӲу\u030bӳЧ\u0308Ӵч\u0308ӵЫ\u0308Ӹ" +
+	"ы\u0308ӹا\u0653آا\u0654أو\u0654ؤا\u0655إي\u0654ئە\u0654ۀہ\u0654ۂ" +
+	"ے\u0654ۓन\u093cऩर\u093cऱळ\u093cऴ\u09c7\u09be\u09cb" +
+	"\u09c7\u09d7\u09cc\u0b47\u0b56\u0b48\u0b47\u0b3e\u0b4b" +
+	"\u0b47\u0b57\u0b4cஒ\u0bd7ஔ\u0bc6\u0bbe\u0bca\u0bc7\u0bbe\u0bcb" +
+	"\u0bc6\u0bd7\u0bcc\u0c46\u0c56\u0c48\u0cbf\u0cd5\u0cc0" +
+	"\u0cc6\u0cd5\u0cc7\u0cc6\u0cd6\u0cc8\u0cc6\u0cc2\u0cca" +
+	"\u0cca\u0cd5\u0ccb\u0d46\u0d3e\u0d4a\u0d47\u0d3e\u0d4b" +
+	"\u0d46\u0d57\u0d4c\u0dd9\u0dca\u0dda\u0dd9\u0dcf\u0ddc" +
+	"\u0ddc\u0dca\u0ddd\u0dd9\u0ddf\u0ddeဥ\u102eဦᬅ\u1b35ᬆᬇ\u1b35ᬈ" +
+	"ᬉ\u1b35ᬊᬋ\u1b35ᬌᬍ\u1b35ᬎᬑ\u1b35ᬒ\u1b3a\u1b35\u1b3b" +
+	"\u1b3c\u1b35\u1b3d\u1b3e\u1b35\u1b40\u1b3f\u1b35\u1b41" +
+	"\u1b42\u1b35\u1b43A\u0325Ḁa\u0325ḁB\u0307Ḃb\u0307ḃB\u0323Ḅ" +
+	"b\u0323ḅB\u0331Ḇb\u0331ḇÇ\u0301Ḉç\u0301ḉD\u0307Ḋd\u0307ḋD\u0323Ḍ" +
+	"d\u0323ḍD\u0331Ḏd\u0331ḏD\u0327Ḑd\u0327ḑD\u032dḒd\u032dḓĒ\u0300Ḕ" +
+	"ē\u0300ḕĒ\u0301Ḗē\u0301ḗE\u032dḘe\u032dḙE\u0330Ḛe\u0330ḛȨ\u0306Ḝ" +
+	"ȩ\u0306ḝF\u0307Ḟf\u0307ḟG\u0304Ḡg\u0304ḡH\u0307Ḣh\u0307ḣH\u0323Ḥ" +
+	"h\u0323ḥH\u0308Ḧh\u0308ḧH\u0327Ḩh\u0327ḩH\u032eḪh\u032eḫI\u0330Ḭ" +
+	"i\u0330ḭÏ\u0301Ḯï\u0301ḯK\u0301Ḱk\u0301ḱK\u0323Ḳk\u0323ḳK\u0331Ḵ" +
+	"k\u0331ḵL\u0323Ḷl\u0323ḷḶ\u0304Ḹḷ\u0304ḹL\u0331Ḻl\u0331ḻL\u032dḼ" +
+	"l\u032dḽM\u0301Ḿm\u0301ḿM\u0307Ṁm\u0307ṁM\u0323Ṃm\u0323ṃN\u0307Ṅ" +
+	"n\u0307ṅN\u0323Ṇn\u0323ṇN\u0331Ṉn\u0331ṉN\u032dṊn\u032dṋÕ\u0301Ṍ" +
+	"õ\u0301ṍÕ\u0308Ṏõ\u0308ṏŌ\u0300Ṑō\u0300ṑŌ\u0301Ṓō\u0301ṓP\u0301Ṕ" +
+	"p\u0301ṕP\u0307Ṗp\u0307ṗR\u0307Ṙr\u0307ṙR\u0323Ṛr\u0323ṛṚ\u0304Ṝ" +
+	"ṛ\u0304ṝR\u0331Ṟr\u0331ṟS\u0307Ṡs\u0307ṡS\u0323Ṣs\u0323ṣŚ\u0307Ṥ" +
+	"ś\u0307ṥŠ\u0307Ṧš\u0307ṧṢ\u0307Ṩṣ\u0307ṩT\u0307Ṫt\u0307ṫT\u0323Ṭ" +
+	"t\u0323ṭT\u0331Ṯt\u0331ṯT\u032dṰt\u032dṱU\u0324Ṳu\u0324ṳU\u0330Ṵ" +
+	"u\u0330ṵU\u032dṶu\u032dṷŨ\u0301Ṹũ\u0301ṹŪ\u0308Ṻū\u0308ṻV\u0303Ṽ" +
+	"v\u0303ṽV\u0323Ṿv\u0323ṿW\u0300Ẁw\u0300ẁW\u0301Ẃw\u0301ẃW\u0308Ẅ" +
+	"w\u0308ẅW\u0307Ẇw\u0307ẇW\u0323Ẉw\u0323ẉX\u0307Ẋx\u0307ẋX\u0308Ẍ" +
+	"x\u0308ẍY\u0307Ẏy\u0307ẏZ\u0302Ẑz\u0302ẑZ\u0323Ẓz\u0323ẓZ\u0331Ẕ" +
+	"z\u0331ẕh\u0331ẖt\u0308ẗw\u030aẘy\u030aẙſ\u0307ẛA\u0323Ạa\u0323ạ" +
+	"A\u0309Ảa\u0309ảÂ\u0301Ấâ\u0301ấÂ\u0300Ầâ\u0300ầÂ\u0309Ẩâ\u0309ẩ" +
+	"Â\u0303Ẫâ\u0303ẫẠ\u0302Ậạ\u0302ậĂ\u0301Ắă\u0301ắĂ\u0300Ằă\u0300ằ" +
+	"Ă\u0309Ẳă\u0309ẳĂ\u0303Ẵă\u0303ẵẠ\u0306Ặạ\u0306ặE\u0323Ẹe\u0323ẹ" +
+	"E\u0309Ẻe\u0309ẻE\u0303Ẽe\u0303ẽÊ\u0301Ếê\u0301ếÊ\u0300Ềê\u0300ề" +
+	"Ê\u0309Ểê\u0309ểÊ\u0303Ễê\u0303ễẸ\u0302Ệẹ\u0302ệI\u0309Ỉi\u0309ỉ" +
+	"I\u0323Ịi\u0323ịO\u0323Ọo\u0323ọO\u0309Ỏo\u0309ỏÔ\u0301Ốô\u0301ố" +
+	"Ô\u0300Ồô\u0300ồÔ\u0309Ổô\u0309ổÔ\u0303Ỗô\u0303ỗỌ\u0302Ộọ\u0302ộ" +
+	"Ơ\u0301Ớơ\u0301ớƠ\u0300Ờơ\u0300ờƠ\u0309Ởơ\u0309ởƠ\u0303Ỡơ\u0303ỡ" +
+	"Ơ\u0323Ợơ\u0323ợU\u0323Ụu\u0323ụU\u0309Ủu\u0309ủƯ\u0301Ứư\u0301ứ" +
+	"Ư\u0300Ừư\u0300ừƯ\u0309Ửư\u0309ửƯ\u0303Ữư\u0303ữƯ\u0323Ựư\u0323ự" +
+	"Y\u0300Ỳy\u0300ỳY\u0323Ỵy\u0323ỵY\u0309Ỷy\u0309ỷY\u0303Ỹy\u0303ỹ" +
+	"α\u0313ἀα\u0314ἁἀ\u0300ἂἁ\u0300ἃἀ\u0301ἄἁ\u0301ἅἀ\u0342ἆἁ\u0342ἇ" +
+	"Α\u0313ἈΑ\u0314ἉἈ\u0300ἊἉ\u0300ἋἈ\u0301ἌἉ\u0301ἍἈ\u0342ἎἉ\u0342Ἇ" +
+	"ε\u0313ἐε\u0314ἑἐ\u0300ἒἑ\u0300ἓἐ\u0301ἔἑ\u0301ἕΕ\u0313ἘΕ\u0314Ἑ" +
+	"Ἐ\u0300ἚἙ\u0300ἛἘ\u0301ἜἙ\u0301Ἕη\u0313ἠη\u0314ἡἠ\u0300ἢἡ\u0300ἣ" +
+	"ἠ\u0301ἤἡ\u0301ἥἠ\u0342ἦἡ\u0342ἧΗ\u0313ἨΗ\u0314ἩἨ\u0300ἪἩ\u0300Ἣ" +
+	"Ἠ\u0301ἬἩ\u0301ἭἨ\u0342ἮἩ\u0342Ἧι\u0313ἰι\u0314ἱἰ\u0300ἲἱ\u0300ἳ" +
+	"ἰ\u0301ἴἱ\u0301ἵἰ\u0342ἶἱ\u0342ἷΙ\u0313ἸΙ\u0314ἹἸ\u0300ἺἹ\u0300Ἳ" +
+	"Ἰ\u0301ἼἹ\u0301ἽἸ\u0342ἾἹ\u0342Ἷο\u0313ὀο\u0314ὁὀ\u0300ὂὁ\u0300ὃ" +
+	"ὀ\u0301ὄὁ\u0301ὅΟ\u0313ὈΟ\u0314ὉὈ\u0300ὊὉ\u0300ὋὈ\u0301ὌὉ\u0301Ὅ" +
+	"υ\u0313ὐυ\u0314ὑὐ\u0300ὒὑ\u0300ὓὐ\u0301ὔὑ\u0301ὕὐ\u0342ὖὑ\u0342ὗ" +
+	"Υ\u0314ὙὙ\u0300ὛὙ\u0301ὝὙ\u0342Ὗω\u0313ὠω\u0314ὡὠ\u0300ὢὡ\u0300ὣ" +
+	"ὠ\u0301ὤὡ\u0301ὥὠ\u0342ὦὡ\u0342ὧΩ\u0313ὨΩ\u0314ὩὨ\u0300ὪὩ\u0300Ὣ" +
+	"Ὠ\u0301ὬὩ\u0301ὭὨ\u0342ὮὩ\u0342Ὧα\u0300ὰε\u0300ὲη\u0300ὴι\u0300ὶ" +
+	"ο\u0300ὸυ\u0300ὺω\u0300ὼἀ\u0345ᾀἁ\u0345ᾁἂ\u0345ᾂἃ\u0345ᾃἄ\u0345ᾄ" +
+	"ἅ\u0345ᾅἆ\u0345ᾆἇ\u0345ᾇἈ\u0345ᾈἉ\u0345ᾉἊ\u0345ᾊἋ\u0345ᾋἌ\u0345ᾌ" +
+	"Ἅ\u0345ᾍἎ\u0345ᾎἏ\u0345ᾏἠ\u0345ᾐἡ\u0345ᾑἢ\u0345ᾒἣ\u0345ᾓἤ\u0345ᾔ" +
+	"ἥ\u0345ᾕἦ\u0345ᾖἧ\u0345ᾗἨ\u0345ᾘἩ\u0345ᾙἪ\u0345ᾚἫ\u0345ᾛἬ\u0345ᾜ" +
+	"Ἥ\u0345ᾝἮ\u0345ᾞἯ\u0345ᾟὠ\u0345ᾠὡ\u0345ᾡὢ\u0345ᾢὣ\u0345ᾣὤ\u0345ᾤ" +
+	"ὥ\u0345ᾥὦ\u0345ᾦὧ\u0345ᾧὨ\u0345ᾨὩ\u0345ᾩὪ\u0345ᾪὫ\u0345ᾫὬ\u0345ᾬ" +
+	"Ὥ\u0345ᾭὮ\u0345ᾮὯ\u0345ᾯα\u0306ᾰα\u0304ᾱὰ\u0345ᾲα\u0345ᾳά\u0345ᾴ" +
+	"α\u0342ᾶᾶ\u0345ᾷΑ\u0306ᾸΑ\u0304ᾹΑ\u0300ᾺΑ\u0345ᾼ" +
+	"\u00a8\u0342\u1fc1ὴ\u0345ῂη\u0345ῃή\u0345ῄη\u0342ῆῆ\u0345ῇ" +
+	"Ε\u0300ῈΗ\u0300ῊΗ\u0345ῌ\u1fbf\u0300\u1fcd\u1fbf\u0301\u1fce" +
+	"\u1fbf\u0342\u1fcfι\u0306ῐι\u0304ῑϊ\u0300ῒι\u0342ῖϊ\u0342ῗ" +
+	"Ι\u0306ῘΙ\u0304ῙΙ\u0300Ὶ\u1ffe\u0300\u1fdd\u1ffe\u0301\u1fde" +
+	"\u1ffe\u0342\u1fdfυ\u0306ῠυ\u0304ῡϋ\u0300ῢρ\u0313ῤρ\u0314ῥ" +
+	"υ\u0342ῦϋ\u0342ῧΥ\u0306ῨΥ\u0304ῩΥ\u0300ῪΡ\u0314Ῥ" +
+	"\u00a8\u0300\u1fedὼ\u0345ῲω\u0345ῳώ\u0345ῴω\u0342ῶῶ\u0345ῷ" +
+	"Ο\u0300ῸΩ\u0300ῺΩ\u0345ῼ\u2190\u0338\u219a\u2192\u0338\u219b" +
+	"\u2194\u0338\u21ae\u21d0\u0338\u21cd\u21d4\u0338\u21ce" +
+	"\u21d2\u0338\u21cf\u2203\u0338\u2204\u2208\u0338\u2209" +
+	"\u220b\u0338\u220c\u2223\u0338\u2224\u2225\u0338\u2226" +
+	"\u223c\u0338\u2241\u2243\u0338\u2244\u2245\u0338\u2247" +
+	"\u2248\u0338\u2249\u003d\u0338\u2260\u2261\u0338\u2262" +
+	"\u224d\u0338\u226d\u003c\u0338\u226e\u003e\u0338\u226f" +
+	"\u2264\u0338\u2270\u2265\u0338\u2271\u2272\u0338\u2274" +
+	"\u2273\u0338\u2275\u2276\u0338\u2278\u2277\u0338\u2279" +
+	"\u227a\u0338\u2280\u227b\u0338\u2281\u2282\u0338\u2284" +
+	"\u2283\u0338\u2285\u2286\u0338\u2288\u2287\u0338\u2289" +
+	"\u22a2\u0338\u22ac\u22a8\u0338\u22ad\u22a9\u0338\u22ae" +
+	"\u22ab\u0338\u22af\u227c\u0338\u22e0\u227d\u0338\u22e1" +
+	"\u2291\u0338\u22e2\u2292\u0338\u22e3\u22b2\u0338\u22ea" +
+	"\u22b3\u0338\u22eb\u22b4\u0338\u22ec\u22b5\u0338\u22ed"
+
+// combiningClasses are the canonical combining classes of the marks, which
+// decide whether a mark can still compose with its base. Other marks have
+// class 0.
+var combiningClasses = map[rune]int{
+	0x0300: 230, 0x0301: 230, 0x0302: 230, 0x0303: 230, 0x0304: 230,
+	0x0305: 230, 0x0306: 230, 0x0307: 230, 0x0308: 230, 0x0309: 230,
+	0x030a: 230, 0x030b: 230, 0x030c: 230, 0x030d: 230, 0x030e: 230,
+	0x030f: 230, 0x0310: 230, 0x0311: 230, 0x0312: 230, 0x0313: 230,
+	0x0314: 230, 0x0315: 232, 0x0316: 220, 0x0317: 220, 0x0318: 220,
+	0x0319: 220, 0x031a: 232, 0x031b: 216, 0x031c: 220, 0x031d: 220,
+	0x031e: 220, 0x031f: 220, 0x0320: 220, 0x0321: 202, 0x0322: 202,
+	0x0323: 220, 0x0324: 220, 0x0325: 220, 0x0326: 220, 0x0327: 202,
+	0x0328: 202, 0x0329: 220, 0x032a: 220, 0x032b: 220, 0x032c: 220,
+	0x032d: 220, 0x032e: 220, 0x032f: 220, 0x0330: 220, 0x0331: 220,
+	0x0332: 220, 0x0333: 220, 0x0334: 1, 0x0335: 1, 0x0336: 1, 0x0337: 1,
+	0x0338: 1, 0x0339: 220, 0x033a: 220, 0x033b: 220, 0x033c: 220,
+	0x033d: 230, 0x033e: 230, 0x033f: 230, 0x0340: 230, 0x0341: 230,
+	0x0342: 230, 0x0343: 230, 0x0344: 230, 0x0345: 240, 0x0346: 230,
+	0x0347: 220, 0x0348: 220, 0x0349: 220, 0x034a: 230, 0x034b: 230,
+	0x034c: 230, 0x034d: 220, 0x034e: 220, 0x0350: 230, 0x0351: 230,
+	0x0352: 230, 0x0353: 220, 0x0354: 220, 0x0355: 220, 0x0356: 220,
+	0x0357: 230, 0x0358: 232, 0x0359: 220, 0x035a: 220, 0x035b: 230,
+	0x035c: 233, 0x035d: 234, 0x035e: 234, 0x035f: 233, 0x0360: 234,
+	0x0361: 234, 0x0362: 233, 0x0363: 230, 0x0364: 230, 0x0365: 230,
+	0x0366: 230, 0x0367: 230, 0x0368: 230, 0x0369: 230, 0x036a: 230,
+	0x036b: 230, 0x036c: 230, 0x036d: 230, 0x036e: 230, 0x036f: 230,
+	0x0653: 230, 0x0654: 230, 0x0655: 220, 0x093c: 7, 0x0c56: 91,
+	0x0dca: 9,
+}
+
+var compositions map[[2]rune]rune
+
+func loadCompositions() {
+	compositions = map[[2]rune]rune{}
+	table := []rune(compositionTable)
+	for i := 0; i+2 < len(table); i += 3 {
+		compositions[[2]rune{table[i], table[i+1]}] = table[i+2]
+	}
+}
+
+const (
+	hangulS = 0xac00
+	hangulL = 0x1100
+	hangulV = 0x1161
+	hangulT = 0x11a7
+
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+)
+
+func composePair(base rune, mark rune) (rune, bool) {
+	switch {
+	case base >= hangulL && base < hangulL+hangulLCount && mark >= hangulV && mark < hangulV+hangulVCount:
+		return hangulS + ((base-hangulL)*hangulVCount+mark-hangulV)*hangulTCount, true
+	case base >= hangulS && base < hangulS+hangulLCount*hangulVCount*hangulTCount && (base-hangulS)%hangulTCount == 0 &&
+		mark > hangulT && mark < hangulT+hangulTCount:
+		return base + mark - hangulT, true
+	}
+	if compositions == nil {
+		loadCompositions()
+	}
+	composed, ok := compositions[[2]rune{base, mark}]
+	return composed, ok
+}
+
+// precompose converts a decomposed (NFD) string to its composed (NFC) form,
+// for filesystems such as the one of macOS that hand out decomposed names.
+func precompose(s string) string {
+	ascii := true
+	for i := 0; i < len(s) && ascii; i++ {
+		ascii = s[i] < utf8.RuneSelf
+	}
+	if ascii {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	starter, lastClass := -1, 0
+	for _, r := range s {
+		class := combiningClasses[r]
+		// a mark composes with the last starter unless a mark of the same
+		// or a higher class is in between
+		adjacent := starter == len(out)-1
+		if starter >= 0 && (adjacent || lastClass != 0 && lastClass < class) {
+			if composed, ok := composePair(out[starter], r); ok {
+				out[starter] = composed
+				continue
+			}
+		}
+		if class == 0 {
+			starter, lastClass = len(out), 0
+		} else {
+			lastClass = class
+		}
+		out = append(out, r)
+	}
+	var b strings.Builder
+	for _, r := range out {
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressDelay is how long an operation runs before its progress is shown,
+// so quick ones stay silent.
+const progressDelay = 2 * time.Second
+
+// progress reports the advance of a long operation on stderr, like git's
+// "Updating files:  42% (420/1000)". A nil progress reports nothing.
+type progress struct {
+	Title string
+	// Total is 0 when the amount of work is not known up front
+	Total int
+
+	count     int
+	bytes     int64
+	start     time.Time
+	lastDraw  time.Time
+	lastPct   int
+	lastWidth int
+	shown     bool
+}
+
+// startProgress returns a progress meter for title, or nil when quiet is
+// set or stderr is not a terminal.
+func startProgress(title string, total int, quiet bool) *progress {
+	if quiet || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return &progress{Title: title, Total: total, start: time.Now(), lastPct: -1}
+}
+
+// update records that count units of work are done.
+func (p *progress) update(count int) {
+	if p == nil {
+		return
+	}
+	p.count = count
+	now := time.Now()
+	if now.Sub(p.start) < progressDelay {
+		return
+	}
+	if p.Total > 0 {
+		if pct := p.count * 100 / p.Total; pct != p.lastPct {
+			p.lastPct = pct
+			p.draw(now, "")
+		}
+		return
+	}
+	if now.Sub(p.lastDraw) >= time.Second {
+		p.draw(now, "")
+	}
+}
+
+// increment records one more unit of work done.
+func (p *progress) increment() {
+	if p != nil {
+		p.update(p.count + 1)
+	}
+}
+
+// addBytes counts processed bytes for the throughput figure.
+func (p *progress) addBytes(n int) {
+	if p != nil {
+		p.bytes += int64(n)
+	}
+}
+
+// stop prints the final state followed by ", done." if anything was shown.
+func (p *progress) stop() {
+	if p == nil || !p.shown {
+		return
+	}
+	p.draw(time.Now(), ", done.")
+}
+
+func (p *progress) draw(now time.Time, suffix string) {
+	p.shown, p.lastDraw = true, now
+	line := fmt.Sprintf("%s: %d", p.Title, p.count)
+	if p.Total > 0 {
+		line = fmt.Sprintf("%s: %3d%% (%d/%d)", p.Title, p.count*100/p.Total, p.count, p.Total)
+	}
+	if p.bytes > 0 {
+		rate := int64(0)
+		if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+			rate = int64(float64(p.bytes) / elapsed)
+		}
+		line += ", " + humaniseBytes(p.bytes) + " | " + humaniseBytes(rate) + "/s"
+	}
+	line += suffix
+	// pad with spaces to wipe a longer previous line
+	padding := max(p.lastWidth-len(line), 0)
+	p.lastWidth = len(line)
+	end := "\r"
+	if suffix != "" {
+		end = "\n"
+	}
+	fmt.Fprint(os.Stderr, line+strings.Repeat(" ", padding)+end)
+}
+
+// humaniseBytes formats a byte count the way git's progress output does.
+func humaniseBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		x := n >> 20
+		return fmt.Sprintf("%d.%02d GiB", x>>10, (x&1023)*100/1024)
+	case n >= 1<<20:
+		x := n >> 10
+		return fmt.Sprintf("%d.%02d MiB", x>>10, (x&1023)*100/1024)
+	case n >= 1<<10:
+		return fmt.Sprintf("%d.%02d KiB", n>>10, (n&1023)*100/1024)
+	case n == 1:
+		return "1 byte"
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
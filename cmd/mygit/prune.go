@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listLooseObjects returns the hashes of all stored objects.
+func listLooseObjects() ([]string, error) {
+	hashes := make([]string, 0, 64)
+	err := objects.Iterate("", func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	return hashes, err
+}
+
+func readReflogHashes() ([]string, error) {
+	hashes := make([]string, 0)
+	logsDir := filepath.Join(gitDir, "logs")
+	err := filepath.WalkDir(logsDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			// <old> <new> <identity>\t<message>
+			fields := strings.SplitN(scanner.Text(), " ", 3)
+			if len(fields) < 2 {
+				continue
+			}
+			for _, hash := range fields[:2] {
+				if isHexHash(hash) && hash != zeroHash {
+					hashes = append(hashes, hash)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflogs: %s", err.Error())
+	}
+	return hashes, nil
+}
+
+// reachabilityRoots collects everything git itself treats as reachable:
+// refs, HEAD and other pseudo-refs, reflog entries and the index.
+func reachabilityRoots() ([]string, error) {
+	roots := make([]string, 0, 16)
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		roots = append(roots, ref.Hash)
+	}
+	for _, name := range []string{"HEAD", "ORIG_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD"} {
+		hash, _, err := resolveRef(name)
+		if err == nil {
+			roots = append(roots, hash)
+		} else if err != errRefNotFound {
+			return nil, err
+		}
+	}
+	reflogHashes, err := readReflogHashes()
+	if err != nil {
+		return nil, err
+	}
+	indexHashes, err := readIndexHashes()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, reflogHashes...)
+	return append(roots, indexHashes...), nil
+}
+
+// markReachable walks commits, trees and tags from the roots. Blobs are
+// marked without being read.
+func markReachable(roots []string) (map[string]bool, error) {
+	reachable := map[string]bool{}
+	stack := append([]string{}, roots...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[hash] {
+			continue
+		}
+		reachable[hash] = true
+
+		object, err := parseObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk reachable objects: %s", err.Error())
+		}
+		switch object.Type {
+		case TypeCommit:
+			commit, err := parseCommitContent(hash, object.Content)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, commit.Tree)
+			stack = append(stack, commit.Parents...)
+		case TypeTree:
+			entries, err := parseTreeEntries(object.Content)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				entryHash := hex.EncodeToString(e.Hash)
+				switch e.Mode {
+				case modeGitlink:
+				case modeTree:
+					stack = append(stack, entryHash)
+				default:
+					reachable[entryHash] = true
+				}
+			}
+		case TypeTag:
+			target, _, _ := strings.Cut(string(object.Content), "\n")
+			if target, ok := strings.CutPrefix(target, "object "); ok {
+				stack = append(stack, target)
+			}
+		}
+	}
+	return reachable, nil
+}
+
+// parseExpiry reads an expiry date such as gc.pruneExpire: "now", "never",
+// or any date approxidate takes, like "2.weeks.ago" or "@<timestamp>". never
+// is reported as ok=false.
+func parseExpiry(value string) (expire time.Time, ok bool, err error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	switch value {
+	case "now", "all":
+		return time.Now(), true, nil
+	case "never", "false":
+		return time.Time{}, false, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true, nil
+	}
+	if expire, err := approxidate(value, time.Now()); err == nil {
+		return expire, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("malformed expiration date '%s'", value)
+}
+
+type pruneOptions struct {
+	DryRun  bool
+	Verbose bool
+	// Expire spares unreachable objects modified after it, the zero time
+	// prunes them all
+	Expire time.Time
+}
+
+// expired reports whether a file was last modified before the expiry date.
+func (opts pruneOptions) expired(path string) bool {
+	if opts.Expire.IsZero() {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().Before(opts.Expire)
+}
+
+// pruneObjects removes unreachable loose objects, and temporary object files
+// left behind by interrupted writes.
+func pruneObjects(opts pruneOptions) error {
+	if inMemory != nil {
+		// refs changed in memory must not cost objects on disk
+		return fmt.Errorf("cannot prune an in-memory repository")
+	}
+	if precious, err := preciousObjects(); err != nil {
+		return err
+	} else if precious {
+		return fmt.Errorf("cannot prune in a precious-objects repo")
+	}
+	// reachability is about the stored objects; replace refs are roots
+	saved := readReplaceRefs
+	readReplaceRefs = false
+	defer func() { readReplaceRefs = saved }()
+
+	roots, err := reachabilityRoots()
+	if err != nil {
+		return err
+	}
+	reachable, err := markReachable(roots)
+	if err != nil {
+		return err
+	}
+	loose, err := listLooseObjects()
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range loose {
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		if reachable[hash] || !opts.expired(getObjectPath(hash)) {
+			continue
+		}
+		if opts.DryRun || opts.Verbose {
+			object, err := parseObject(hash)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s\n", hash, object.Type)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Remove(getObjectPath(hash)); err != nil {
+			return fmt.Errorf("failed to remove object %s: %s", hash, err.Error())
+		}
+		// drop the fan-out directory once it is empty
+		os.Remove(getObjectDir(hash))
+	}
+
+	// streamed objects are written to the top of objects/ until hashed
+	temps, err := filepath.Glob(filepath.Join(getObjectsDir(), "tmp_obj_*"))
+	if err != nil {
+		return err
+	}
+	fanOutTemps, err := filepath.Glob(filepath.Join(getObjectsDir(), "??", "tmp_obj_*"))
+	if err != nil {
+		return err
+	}
+	temps = append(temps, fanOutTemps...)
+	for _, path := range temps {
+		if !opts.expired(path) {
+			continue
+		}
+		if opts.DryRun || opts.Verbose {
+			fmt.Printf("Removing stale temporary file %s\n", path)
+		}
+		if !opts.DryRun {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+func runPrune(args []string) error {
+	opts := pruneOptions{}
+	expire := ""
+	flags := newFlagSet()
+	flags.Bool(&opts.DryRun, "-n", "--dry-run")
+	flags.Bool(&opts.Verbose, "-v", "--verbose")
+	flags.String(&expire, "--expire")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: mygit prune [-n] [-v] [--expire <time>]")
+	}
+	if expire != "" {
+		date, ok, err := parseExpiry(expire)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		opts.Expire = date
+	}
+	return pruneObjects(opts)
+}
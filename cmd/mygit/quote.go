@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+)
+
+var quoteEscapes = map[byte]string{
+	'\a': `\a`,
+	'\b': `\b`,
+	'\t': `\t`,
+	'\n': `\n`,
+	'\v': `\v`,
+	'\f': `\f`,
+	'\r': `\r`,
+	'"':  `\"`,
+	'\\': `\\`,
+}
+
+// quotePathNonASCII is core.quotePath: whether bytes outside ASCII are
+// quoted as octal escapes or printed as they are.
+func quotePathNonASCII() bool {
+	config, err := getConfig()
+	if err != nil {
+		return true
+	}
+	quote, err := config.GetBool("core.quotePath", true)
+	return err != nil || quote
+}
+
+// quotePath returns path in git's C-style quoting when printing it as is
+// would be ambiguous: it has control characters, '"' or '\', or bytes
+// outside ASCII unless core.quotePath is false. quoteSpaces also quotes
+// paths with a space, as the short status format does. Output for -z is
+// never quoted.
+func quotePath(path string, quoteSpaces bool) string {
+	nonASCII := quotePathNonASCII()
+	needsQuoting := func(c byte) bool {
+		return c < 0x20 || c == 0x7f || c == '"' || c == '\\' || c >= 0x80 && nonASCII
+	}
+	quote := quoteSpaces && strings.IndexByte(path, ' ') != -1
+	for i := 0; i < len(path) && !quote; i++ {
+		quote = needsQuoting(path[i])
+	}
+	if !quote {
+		return path
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case quoteEscapes[c] != "":
+			b.WriteString(quoteEscapes[c])
+		case needsQuoting(c):
+			b.WriteByte('\\')
+			b.WriteByte('0' + c>>6)
+			b.WriteByte('0' + c>>3&7)
+			b.WriteByte('0' + c&7)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// refFilter selects the refs a listing shows: those under Prefix matching
+// one of Patterns, if any. Refs whose commit contains one of Contains, is
+// reachable from one of Merged, or from none of NoMerged are kept when those
+// are given.
+type refFilter struct {
+	Prefix   string
+	Patterns []string
+	Contains []string
+	Merged   []string
+	NoMerged []string
+}
+
+// matches applies the patterns like tag and branch listings do: a glob
+// against the name without its refs/... prefix, with wildcards matching '/'.
+func (f refFilter) matches(name string) bool {
+	if len(f.Patterns) == 0 {
+		return true
+	}
+	short := shortenRefName(name)
+	for _, pattern := range f.Patterns {
+		if fnmatch(pattern, short) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCommits resolves the commits given to the reachability filters.
+func (f *refFilter) resolveCommits() error {
+	for _, list := range [][]string{f.Contains, f.Merged, f.NoMerged} {
+		for i, spec := range list {
+			hash, err := resolveCommitRevision(spec)
+			if err != nil {
+				return fmt.Errorf("malformed object name %s", spec)
+			}
+			list[i] = hash
+		}
+	}
+	return nil
+}
+
+// isAncestor reports whether ancestor is reachable from tip.
+func isAncestor(ancestor string, tip string) (bool, error) {
+	walk, err := newRevWalk([]string{ancestor}, []string{tip})
+	if err != nil {
+		return false, err
+	}
+	commit, err := walk.Next()
+	if err != nil {
+		return false, err
+	}
+	return commit == nil, nil
+}
+
+// reachesAny reports whether tip reaches one of commits, the other way
+// round with reverse.
+func reachesAny(tip string, commits []string, reverse bool) (bool, error) {
+	for _, c := range commits {
+		ancestor, descendant := c, tip
+		if reverse {
+			ancestor, descendant = tip, c
+		}
+		if ok, err := isAncestor(ancestor, descendant); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// acceptsCommit applies the reachability filters to the commit of a ref.
+func (f refFilter) acceptsCommit(commit string) (bool, error) {
+	if len(f.Contains) > 0 {
+		if ok, err := reachesAny(commit, f.Contains, false); err != nil || !ok {
+			return false, err
+		}
+	}
+	if len(f.Merged) > 0 {
+		if ok, err := reachesAny(commit, f.Merged, true); err != nil || !ok {
+			return false, err
+		}
+	}
+	if len(f.NoMerged) > 0 {
+		if ok, err := reachesAny(commit, f.NoMerged, true); err != nil || ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (f refFilter) filtersCommits() bool {
+	return len(f.Contains) > 0 || len(f.Merged) > 0 || len(f.NoMerged) > 0
+}
+
+func (f refFilter) filter() ([]Ref, error) {
+	refs, err := listRefs(f.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]Ref, 0, len(refs))
+	for _, r := range refs {
+		if !f.matches(r.Name) {
+			continue
+		}
+		if f.filtersCommits() {
+			commit, err := peelToCommit(r.Hash)
+			if err != nil {
+				continue
+			}
+			if ok, err := f.acceptsCommit(commit); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+var refSortFields = []string{"refname", "objectname", "objecttype", "creatordate", "taggerdate", "committerdate"}
+
+// refSortKey is one --sort key. Version compares the field as version
+// numbers, and a "-" before the key reverses the order.
+type refSortKey struct {
+	Field   string
+	Version bool
+	Reverse bool
+}
+
+func parseRefSortKey(key string) (refSortKey, error) {
+	k := refSortKey{}
+	field, reversed := strings.CutPrefix(key, "-")
+	k.Reverse = reversed
+	for _, prefix := range []string{"version:", "v:"} {
+		if rest, ok := strings.CutPrefix(field, prefix); ok {
+			field, k.Version = rest, true
+		}
+	}
+	for _, f := range refSortFields {
+		if f == field {
+			k.Field = f
+			return k, nil
+		}
+	}
+	return k, fmt.Errorf("unknown field name: %s", field)
+}
+
+// refSortValue is what a ref is sorted by for one field: Text for names,
+// Date for the date fields.
+type refSortValue struct {
+	Text string
+	Date int64
+}
+
+func loadRefSortValue(r Ref, field string) (refSortValue, error) {
+	switch field {
+	case "refname":
+		return refSortValue{Text: r.Name}, nil
+	case "objectname":
+		return refSortValue{Text: r.Hash}, nil
+	}
+	object, err := parseObject(r.Hash)
+	if err != nil {
+		return refSortValue{}, err
+	}
+	if field == "objecttype" {
+		return refSortValue{Text: string(object.Type)}, nil
+	}
+	header := ""
+	switch {
+	case object.Type == TypeTag && field != "committerdate":
+		header = "tagger "
+	case object.Type == TypeCommit && field != "taggerdate":
+		header = "committer "
+	default:
+		return refSortValue{}, nil
+	}
+	for _, line := range strings.Split(string(object.Content), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, header); ok {
+			if ident, err := parseIdentity(value); err == nil {
+				return refSortValue{Date: ident.When.Unix()}, nil
+			}
+		}
+	}
+	return refSortValue{}, nil
+}
+
+// versionCompare orders strings the way strverscmp(3) does, so that runs of
+// digits compare as numbers: v1.9 sorts before v1.10.
+func versionCompare(a string, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	start := i
+	for start > 0 && isDigit(a[start-1]) {
+		start--
+	}
+	runA, runB := digitRun(a[start:]), digitRun(b[start:])
+	// a run with a leading zero is a fraction and compares as a string
+	if runA != "" && runB != "" && runA[0] != '0' && runB[0] != '0' && len(runA) != len(runB) {
+		if len(runA) < len(runB) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a[i:], b[i:])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) string {
+	end := 0
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	return s[:end]
+}
+
+func compareRefSortValues(key refSortKey, a refSortValue, b refSortValue) int {
+	switch {
+	case strings.HasSuffix(key.Field, "date"):
+		if a.Date < b.Date {
+			return -1
+		} else if a.Date > b.Date {
+			return 1
+		}
+		return 0
+	case key.Version:
+		return versionCompare(a.Text, b.Text)
+	}
+	return strings.Compare(a.Text, b.Text)
+}
+
+// sortRefs sorts refs by keys, the first being the primary one. Refs equal
+// for all keys stay in ref name order, whichever way the keys go.
+func sortRefs(refs []Ref, keys []refSortKey) error {
+	values := make([][]refSortValue, len(refs))
+	for i, r := range refs {
+		values[i] = make([]refSortValue, len(keys))
+		for k, key := range keys {
+			value, err := loadRefSortValue(r, key.Field)
+			if err != nil {
+				return err
+			}
+			values[i][k] = value
+		}
+	}
+	order := make([]int, len(refs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		for k, key := range keys {
+			cmp := compareRefSortValues(key, values[a][k], values[b][k])
+			if key.Reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return refs[a].Name < refs[b].Name
+	})
+	sorted := make([]Ref, len(refs))
+	for i, index := range order {
+		sorted[i] = refs[index]
+	}
+	copy(refs, sorted)
+	return nil
+}
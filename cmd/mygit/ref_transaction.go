@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type refOp int
+
+const (
+	refOpUpdate refOp = iota
+	refOpDelete
+	refOpVerify
+)
+
+// refUpdate is one queued change of a ref transaction. Value is a hash or a
+// "ref: <target>" symbolic ref.
+type refUpdate struct {
+	Op       refOp
+	Name     string
+	Value    string
+	CheckOld bool
+	// Old is the value the ref must hold at prepare time when CheckOld is
+	// set, "" for a ref that must not exist
+	Old     string
+	Message string
+
+	lock    *lockFile
+	oldHash string
+	logHead bool
+}
+
+type refTransactionState int
+
+const (
+	refTransactionOpen refTransactionState = iota
+	refTransactionPrepared
+	refTransactionClosed
+)
+
+// refTransaction updates several refs all-or-nothing: prepare locks every
+// ref and checks the expected old values before commit changes any of them.
+type refTransaction struct {
+	updates    []*refUpdate
+	state      refTransactionState
+	packedLock *lockFile
+}
+
+func beginRefTransaction() *refTransaction {
+	return &refTransaction{}
+}
+
+// update queues setting name to value. With checkOld the ref must still hold
+// old, or not exist when old is "". A non-empty message is logged in the
+// reflogs of the ref and of HEAD when HEAD points at it.
+func (t *refTransaction) update(name string, value string, checkOld bool, old string, message string) error {
+	return t.queue(&refUpdate{Op: refOpUpdate, Name: name, Value: value, CheckOld: checkOld, Old: old, Message: message})
+}
+
+// create queues a new ref that must not exist yet.
+func (t *refTransaction) create(name string, value string, message string) error {
+	return t.update(name, value, true, "", message)
+}
+
+// delete queues removing a ref along with its reflog.
+func (t *refTransaction) delete(name string, checkOld bool, old string) error {
+	return t.queue(&refUpdate{Op: refOpDelete, Name: name, CheckOld: checkOld, Old: old})
+}
+
+// verify queues a check that the ref holds old, without changing it.
+func (t *refTransaction) verify(name string, old string) error {
+	return t.queue(&refUpdate{Op: refOpVerify, Name: name, CheckOld: true, Old: old})
+}
+
+func (t *refTransaction) queue(u *refUpdate) error {
+	if t.state != refTransactionOpen {
+		return fmt.Errorf("ref transaction is no longer open")
+	}
+	if u.Name != "HEAD" && !isValidRefName(u.Name) {
+		return fmt.Errorf("refusing to update ref with bad name '%s'", u.Name)
+	}
+	for _, other := range t.updates {
+		if other.Name == u.Name {
+			return fmt.Errorf("multiple updates for ref '%s' not allowed", u.Name)
+		}
+	}
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+// packedRefsLockTimeout is how long to wait for packed-refs.lock,
+// core.packedRefsTimeout milliseconds.
+func packedRefsLockTimeout() time.Duration {
+	timeout := 1000
+	if config, err := getConfig(); err == nil {
+		if value, err := config.GetInt("core.packedRefsTimeout", timeout); err == nil {
+			timeout = value
+		}
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// prepare locks all refs, in name order so concurrent transactions cannot
+// deadlock, and checks their old values. Nothing is changed yet; on error the
+// transaction is aborted.
+func (t *refTransaction) prepare() error {
+	if t.state != refTransactionOpen {
+		return fmt.Errorf("ref transaction is no longer open")
+	}
+	if err := t.lockAll(); err != nil {
+		t.abort()
+		return err
+	}
+	if err := t.runHook("prepared"); err != nil {
+		t.abort()
+		if err == errQuietFailure {
+			return fmt.Errorf("in 'prepared' phase, update aborted by the reference-transaction hook")
+		}
+		return err
+	}
+	t.state = refTransactionPrepared
+	return nil
+}
+
+// runHook tells the reference-transaction hook about the queued updates in
+// the given state, one "<old> <new> <ref>" line each on its stdin. Refs kept
+// in memory are not reported.
+func (t *refTransaction) runHook(state string) error {
+	if inMemory != nil {
+		return nil
+	}
+	var b bytes.Buffer
+	for _, u := range t.updates {
+		old, value := u.oldHash, strings.Replace(u.Value, "ref: ", "ref:", 1)
+		if old == "" {
+			old = zeroHash
+		}
+		switch u.Op {
+		case refOpDelete:
+			value = zeroHash
+		case refOpVerify:
+			value = old
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", old, value, u.Name)
+	}
+	return runHookInput("reference-transaction", nil, b.Bytes(), state)
+}
+
+func (t *refTransaction) lockAll() error {
+	sort.Slice(t.updates, func(i, j int) bool { return t.updates[i].Name < t.updates[j].Name })
+	if inMemory != nil {
+		return inMemory.checkRefs(t)
+	}
+	headRef := ""
+	if content, err := readRawRef("HEAD"); err == nil {
+		headRef, _ = strings.CutPrefix(content, "ref: ")
+	}
+	deletedPacked := map[string]bool{}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range t.updates {
+		refPath := getRefPath(u.Name)
+		if err := os.MkdirAll(filepath.Dir(refPath), mode); err != nil {
+			return fmt.Errorf("failed to create directory for ref %s: %s", u.Name, err.Error())
+		}
+		lock, err := acquireLockTimeout(refPath, refLockTimeout())
+		if err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %s", u.Name, err.Error())
+		}
+		u.lock = lock
+
+		current, err := readRawRef(u.Name)
+		if err == errRefNotFound {
+			current = ""
+		} else if err != nil {
+			return err
+		}
+		if u.CheckOld {
+			switch {
+			case current == u.Old:
+			case u.Old == "":
+				return errorWithCode("stale-ref", "cannot lock ref '%s': reference already exists", u.Name)
+			case current == "":
+				return errorWithCode("stale-ref", "cannot lock ref '%s': reference is missing but expected %s", u.Name, u.Old)
+			default:
+				return errorWithCode("stale-ref", "cannot lock ref '%s': is at %s but expected %s", u.Name, current, u.Old)
+			}
+		}
+		u.oldHash, _, _ = resolveRef(u.Name)
+		u.logHead = u.Name != "HEAD" && u.Name == headRef
+
+		switch u.Op {
+		case refOpUpdate:
+			if _, err := lock.Write([]byte(u.Value + "\n")); err != nil {
+				return fmt.Errorf("failed to write ref %s: %s", u.Name, err.Error())
+			}
+		case refOpDelete:
+			if _, ok := packed[u.Name]; ok {
+				deletedPacked[u.Name] = true
+			}
+		}
+	}
+
+	if len(deletedPacked) == 0 {
+		return nil
+	}
+	lock, err := acquireLockTimeout(filepath.Join(gitDir, "packed-refs"), packedRefsLockTimeout())
+	if err != nil {
+		return fmt.Errorf("cannot lock packed-refs: %s", err.Error())
+	}
+	t.packedLock = lock
+	return writePackedRefsWithout(lock, deletedPacked)
+}
+
+// writePackedRefsWithout copies packed-refs to lock, dropping the given refs
+// and their peeled lines.
+func writePackedRefsWithout(lock *lockFile, deleted map[string]bool) error {
+	packedPath := filepath.Join(gitDir, "packed-refs")
+	data, err := os.ReadFile(packedPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", packedPath, err.Error())
+	}
+	var b bytes.Buffer
+	skipping := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "^") {
+			if !skipping {
+				b.WriteString(line + "\n")
+			}
+			continue
+		}
+		_, name, _ := strings.Cut(line, " ")
+		skipping = line != "" && line[0] != '#' && deleted[name]
+		if !skipping {
+			b.WriteString(line + "\n")
+		}
+	}
+	if _, err := lock.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write packed-refs: %s", err.Error())
+	}
+	return nil
+}
+
+// commit applies a prepared transaction, preparing it first if needed, and
+// writes the reflogs.
+func (t *refTransaction) commit() error {
+	if t.state == refTransactionOpen {
+		if err := t.prepare(); err != nil {
+			return err
+		}
+	}
+	if t.state != refTransactionPrepared {
+		return fmt.Errorf("ref transaction is no longer open")
+	}
+	t.state = refTransactionClosed
+	if inMemory != nil {
+		inMemory.applyRefs(t)
+		return nil
+	}
+
+	if t.packedLock != nil {
+		if err := t.packedLock.commit(); err != nil {
+			t.abort()
+			return fmt.Errorf("failed to write packed-refs: %s", err.Error())
+		}
+	}
+	var firstErr error
+	for _, u := range t.updates {
+		var err error
+		switch u.Op {
+		case refOpUpdate:
+			err = u.lock.commit()
+		case refOpDelete:
+			err = os.Remove(getRefPath(u.Name))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			if logErr := os.Remove(getReflogPath(u.Name)); err == nil && logErr != nil && !os.IsNotExist(logErr) {
+				err = logErr
+			}
+			u.lock.rollback()
+		case refOpVerify:
+			u.lock.rollback()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to update ref %s: %s", u.Name, err.Error())
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	// the refs are updated already, so the hook cannot fail the transaction
+	t.runHook("committed")
+
+	for _, u := range t.updates {
+		if u.Op != refOpUpdate || u.Message == "" {
+			continue
+		}
+		newHash, _, err := resolveRef(u.Name)
+		if err != nil {
+			// a symbolic ref to an unborn branch has nothing to log
+			continue
+		}
+		if err := appendReflog(u.Name, u.oldHash, newHash, u.Message); err != nil {
+			return err
+		}
+		if u.logHead {
+			if err := appendReflog("HEAD", u.oldHash, newHash, u.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// abort releases all locks without changing any ref.
+func (t *refTransaction) abort() {
+	if t.state == refTransactionPrepared {
+		t.runHook("aborted")
+	}
+	t.state = refTransactionClosed
+	for _, u := range t.updates {
+		if u.lock != nil {
+			u.lock.rollback()
+		}
+	}
+	if t.packedLock != nil {
+		t.packedLock.rollback()
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type reflogEntry struct {
+	Old      string
+	New      string
+	Identity Identity
+	Message  string
+}
+
+func getReflogPath(ref string) string {
+	return filepath.Join(gitDir, "logs", filepath.FromSlash(ref))
+}
+
+// appendReflog records a ref update; an empty old hash means the ref was
+// created. Repositories kept in memory have no reflogs.
+func appendReflog(ref string, oldHash string, newHash string, message string) error {
+	if inMemory != nil {
+		return nil
+	}
+	if oldHash == "" {
+		oldHash = zeroHash
+	}
+	ident, err := reflogIdentity()
+	if err != nil {
+		return err
+	}
+	logPath := getReflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(logPath), mode); err != nil {
+		return fmt.Errorf("failed to create directory for reflog %s: %s", ref, err.Error())
+	}
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog %s: %s", ref, err.Error())
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s %s\t%s\n", oldHash, newHash, ident.String(), message); err != nil {
+		return fmt.Errorf("failed to write reflog %s: %s", ref, err.Error())
+	}
+	return nil
+}
+
+// readReflog returns the entries of a ref's reflog, oldest first.
+func readReflog(ref string) ([]reflogEntry, error) {
+	data, err := os.ReadFile(getReflogPath(ref))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflog %s: %s", ref, err.Error())
+	}
+	entries := make([]reflogEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if e, ok := parseReflogLine(scanner.Text()); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// parseReflogLine parses one "<old> <new> <identity>\t<message>" line of a
+// reflog; ok is false when the line is not one.
+func parseReflogLine(text string) (e reflogEntry, ok bool) {
+	line, message, _ := strings.Cut(text, "\t")
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 3 {
+		return reflogEntry{}, false
+	}
+	ident, err := parseIdentity(fields[2])
+	if err != nil {
+		return reflogEntry{}, false
+	}
+	return reflogEntry{Old: fields[0], New: fields[1], Identity: ident, Message: message}, true
+}
+
+// lastCheckout finds the most recent "checkout: moving from <from> to <to>"
+// entry of the HEAD reflog.
+func lastCheckout() (entry reflogEntry, from string, to string, found bool, err error) {
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		return reflogEntry{}, "", "", false, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		rest, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		toIdx := strings.LastIndex(rest, " to ")
+		if toIdx == -1 {
+			continue
+		}
+		return entries[i], rest[:toIdx], rest[toIdx+4:], true, nil
+	}
+	return reflogEntry{}, "", "", false, nil
+}
+
+// reflogExpiry says which reflog entries expire: those older than Expire,
+// and those older than ExpireUnreachable whose commit can no longer be
+// reached from the ref. A zero time, as "never" gives, keeps the entries.
+type reflogExpiry struct {
+	Expire            time.Time
+	ExpireUnreachable time.Time
+	DryRun            bool
+	Verbose           bool
+}
+
+// loadReflogExpiry reads gc.reflogExpire and gc.reflogExpireUnreachable,
+// which default to 90 and 30 days like in git.
+func loadReflogExpiry(config *Config) (reflogExpiry, error) {
+	exp := reflogExpiry{}
+	for _, setting := range []struct {
+		key, fallback string
+		date          *time.Time
+	}{
+		{"gc.reflogExpire", "90.days.ago", &exp.Expire},
+		{"gc.reflogExpireUnreachable", "30.days.ago", &exp.ExpireUnreachable},
+	} {
+		value := setting.fallback
+		if configured, ok := config.Get(setting.key); ok {
+			value = configured
+		}
+		date, _, err := parseExpiry(value)
+		if err != nil {
+			return exp, fmt.Errorf("failed to parse %s value %s", setting.key, value)
+		}
+		*setting.date = date
+	}
+	return exp, nil
+}
+
+// expiredAt reports whether an entry made at when is at or before expire.
+func expiredAt(when time.Time, expire time.Time) bool {
+	return !expire.IsZero() && !when.After(expire)
+}
+
+// listReflogs returns the names of the refs that have a reflog.
+func listReflogs() ([]string, error) {
+	logsDir := filepath.Join(gitDir, "logs")
+	refs := make([]string, 0)
+	err := filepath.WalkDir(logsDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(logsDir, p)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reflogs: %s", err.Error())
+	}
+	return refs, nil
+}
+
+// reachableCommits returns the commits reachable from tip.
+func reachableCommits(tip string) (map[string]bool, error) {
+	reachable := map[string]bool{}
+	if tip == "" {
+		return reachable, nil
+	}
+	walk, err := newRevWalk([]string{tip}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
+			return reachable, nil
+		}
+		reachable[commit.Hash] = true
+	}
+}
+
+// expireReflog drops the expired entries of a ref's reflog. The ref stays
+// locked while its reflog is rewritten, so no update is logged in between.
+// Only entries that parse can expire: the other lines of a hand-edited or
+// damaged reflog are kept as they are, like the entries that do not expire.
+func expireReflog(ref string, exp reflogExpiry) error {
+	if inMemory != nil {
+		return fmt.Errorf("cannot expire reflogs of an in-memory repository")
+	}
+	var lock *lockFile
+	if !exp.DryRun {
+		var err error
+		if lock, err = acquireLockTimeout(getRefPath(ref), refLockTimeout()); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %s", ref, err.Error())
+		}
+		defer lock.rollback()
+	}
+	data, err := os.ReadFile(getReflogPath(ref))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read reflog %s: %s", ref, err.Error())
+	}
+	tip, _, err := resolveRef(ref)
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+
+	// reachability is only worked out once an entry needs it
+	var reachable map[string]bool
+	var b bytes.Buffer
+	dropped := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		e, ok := parseReflogLine(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			b.WriteString(line)
+			continue
+		}
+		expired := expiredAt(e.Identity.When, exp.Expire)
+		if !expired && expiredAt(e.Identity.When, exp.ExpireUnreachable) {
+			if reachable == nil {
+				if reachable, err = reachableCommits(tip); err != nil {
+					return err
+				}
+			}
+			expired = !reachable[e.New]
+		}
+		if expired {
+			dropped++
+			if exp.Verbose {
+				verb := "prune"
+				if exp.DryRun {
+					verb = "would prune"
+				}
+				fmt.Printf("%s %s\n", verb, e.Message)
+			}
+			continue
+		}
+		b.WriteString(line)
+	}
+	if dropped == 0 || exp.DryRun {
+		return nil
+	}
+	if err := writeFileAtomic(getReflogPath(ref), b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write reflog %s: %s", ref, err.Error())
+	}
+	return nil
+}
+
+// expireReflogs expires the reflogs of all refs.
+func expireReflogs(exp reflogExpiry) error {
+	refs, err := listReflogs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := expireReflog(ref, exp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reflogRef finds the ref whose reflog name asks for: the first of its
+// dwimRefs candidates that has one.
+func reflogRef(name string) (string, error) {
+	for _, candidate := range dwimRefs(name) {
+		if _, err := os.Stat(getReflogPath(candidate)); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("reflog could not be found: '%s'", name)
+}
+
+const reflogUsage = "mygit reflog expire [-n | --dry-run] [--verbose] [--expire=<time>] [--expire-unreachable=<time>] (--all | <refs>...)"
+
+func runReflog(args []string) error {
+	if len(args) == 0 || args[0] != "expire" {
+		return fmt.Errorf("usage: %s", reflogUsage)
+	}
+	all, expire, expireUnreachable := false, "", ""
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	exp, err := loadReflogExpiry(config)
+	if err != nil {
+		return err
+	}
+	flags := newFlagSet()
+	flags.Bool(&exp.DryRun, "-n", "--dry-run")
+	flags.Bool(&exp.Verbose, "--verbose")
+	flags.Bool(&all, "--all")
+	flags.String(&expire, "--expire")
+	flags.String(&expireUnreachable, "--expire-unreachable")
+	refs, err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if all == (len(refs) > 0) {
+		return fmt.Errorf("usage: %s", reflogUsage)
+	}
+	for _, option := range []struct {
+		value string
+		date  *time.Time
+	}{{expire, &exp.Expire}, {expireUnreachable, &exp.ExpireUnreachable}} {
+		if option.value == "" {
+			continue
+		}
+		if *option.date, _, err = parseExpiry(option.value); err != nil {
+			return err
+		}
+	}
+	if all {
+		return expireReflogs(exp)
+	}
+	for _, name := range refs {
+		ref, err := reflogRef(name)
+		if err != nil {
+			return err
+		}
+		if err := expireReflog(ref, exp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
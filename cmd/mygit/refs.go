@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const maxSymrefDepth = 5
+
+var errRefNotFound = errors.New("ref not found")
+
+type Ref struct {
+	Name string
+	Hash string
+}
+
+func getRefPath(name string) string {
+	return filepath.Join(gitDir, filepath.FromSlash(name))
+}
+
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// readPackedRefs returns the refs from .git/packed-refs, ignoring the peeled
+// "^<hash>" lines.
+func readPackedRefs() (map[string]string, error) {
+	refs := map[string]string{}
+	packedPath := filepath.Join(gitDir, "packed-refs")
+	data, err := os.ReadFile(packedPath)
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", packedPath, err.Error())
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, found := strings.Cut(line, " ")
+		if !found || !isHexHash(hash) {
+			return nil, fmt.Errorf("bad line in %s: %q", packedPath, line)
+		}
+		refs[name] = hash
+	}
+	return refs, nil
+}
+
+// readRawRef returns the unresolved content of a ref: either a hash or a
+// "ref: <target>" symbolic reference.
+func readRawRef(name string) (string, error) {
+	if inMemory != nil {
+		if content, found, err := inMemory.readRef(name); found {
+			return content, err
+		}
+	}
+	data, err := os.ReadFile(getRefPath(name))
+	if err == nil && (name == "FETCH_HEAD" || name == "MERGE_HEAD") {
+		// these list one object per line, the first one is what they resolve to
+		firstLine, _, _ := strings.Cut(string(data), "\n")
+		hash, _, _ := strings.Cut(firstLine, "\t")
+		return strings.TrimSpace(hash), nil
+	}
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.EISDIR) {
+		return "", fmt.Errorf("failed to read ref %s: %s", name, err.Error())
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	if hash, ok := packed[name]; ok {
+		return hash, nil
+	}
+	return "", errRefNotFound
+}
+
+// resolveRef follows symbolic refs until it reaches a hash, returning the name
+// of the final ref as well.
+func resolveRef(name string) (hash string, target string, err error) {
+	target = name
+	for i := 0; i < maxSymrefDepth; i++ {
+		content, err := readRawRef(target)
+		if err != nil {
+			return "", target, err
+		}
+		next, isSymref := strings.CutPrefix(content, "ref: ")
+		if !isSymref {
+			if !isHexHash(content) {
+				return "", target, fmt.Errorf("ref %s is corrupt: %q", target, content)
+			}
+			return content, target, nil
+		}
+		target = strings.TrimSpace(next)
+	}
+	return "", target, fmt.Errorf("symbolic ref %s nests too deeply", name)
+}
+
+// listRefs returns all loose and packed refs under prefix (e.g. "refs/heads/"),
+// sorted by name.
+func listRefs(prefix string) ([]Ref, error) {
+	found := map[string]string{}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range packed {
+		if strings.HasPrefix(name, prefix) {
+			found[name] = hash
+		}
+	}
+
+	root := filepath.Join(gitDir, "refs")
+	err = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(gitDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, ".lock") {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		content := strings.TrimSpace(string(data))
+		if target, isSymref := strings.CutPrefix(content, "ref: "); isSymref {
+			content, _, err = resolveRef(strings.TrimSpace(target))
+			if err != nil {
+				// dangling symbolic refs are skipped like git does
+				return nil
+			}
+		}
+		found[name] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %s", err.Error())
+	}
+	if inMemory != nil {
+		inMemory.overlayRefs(found, prefix)
+	}
+
+	refs := make([]Ref, 0, len(found))
+	for name, hash := range found {
+		refs = append(refs, Ref{Name: name, Hash: hash})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// refFormat relaxes the checks checkRefFormat makes.
+type refFormat uint
+
+const (
+	// refAllowOnelevel allows names without a "/", like HEAD or FETCH_HEAD
+	refAllowOnelevel refFormat = 1 << iota
+	// refRefspecPattern allows a single "*" standing for a component
+	refRefspecPattern
+)
+
+// checkRefFormat applies git's check-ref-format rules to a ref name: each
+// "/" separated component is non-empty, does not start with "." or end in
+// ".lock", and the name has no "..", "@{", control characters, or any of
+// " ~^:?*[\", does not end in "." and is not "@" alone.
+func checkRefFormat(name string, format refFormat) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	components := strings.Split(name, "/")
+	if len(components) < 2 && format&refAllowOnelevel == 0 {
+		return false
+	}
+	for _, component := range components {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	stars := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '*' && format&refRefspecPattern != 0 {
+			stars++
+			continue
+		}
+		if c < 0x20 || c == 0x7f || strings.IndexByte(" ~^:?*[\\", c) != -1 {
+			return false
+		}
+	}
+	return stars <= 1
+}
+
+// isValidRefName applies git's check-ref-format rules to a full ref name.
+func isValidRefName(name string) bool {
+	return checkRefFormat(name, refAllowOnelevel)
+}
+
+// refLockTimeout is how long to wait for another process to release a ref,
+// core.filesRefLockTimeout milliseconds.
+func refLockTimeout() time.Duration {
+	timeout := 100
+	if config, err := getConfig(); err == nil {
+		if value, err := config.GetInt("core.filesRefLockTimeout", timeout); err == nil {
+			timeout = value
+		}
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// writeRefFile replaces a ref file through a .lock file so readers never see
+// a partially written ref.
+func writeRefFile(name string, content string) error {
+	t := beginRefTransaction()
+	if err := t.update(name, content, false, "", ""); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// compareAndSwapRef writes the ref only if it still holds old, which is
+// checked while the ref is locked so a concurrent update is not lost. An
+// empty old means the ref must not exist yet.
+func compareAndSwapRef(name string, content string, old string) error {
+	t := beginRefTransaction()
+	if err := t.update(name, content, true, old, ""); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+func writeSymbolicRef(name string, target string) error {
+	return writeRefFile(name, "ref: "+target)
+}
+
+func deleteRefFile(name string) error {
+	t := beginRefTransaction()
+	if err := t.delete(name, false, ""); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// getNamespacePrefix maps GIT_NAMESPACE=a/b to "refs/namespaces/a/refs/namespaces/b/".
+func getNamespacePrefix() string {
+	namespace := strings.Trim(os.Getenv("GIT_NAMESPACE"), "/")
+	if namespace == "" {
+		return ""
+	}
+	prefix := ""
+	for _, part := range strings.Split(namespace, "/") {
+		if part != "" {
+			prefix += "refs/namespaces/" + part + "/"
+		}
+	}
+	return prefix
+}
+
+// namespacedRefName returns the storage name of a ref inside the current
+// namespace, so every namespace gets its own HEAD and refs/ hierarchy.
+func namespacedRefName(name string) string {
+	prefix := getNamespacePrefix()
+	if prefix == "" || strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return prefix + name
+}
+
+func stripNamespace(name string) string {
+	return strings.TrimPrefix(name, getNamespacePrefix())
+}
+
+func shortenRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return strings.TrimPrefix(name, "refs/")
+}
+
+// currentBranch returns the full name of the branch HEAD points to, or
+// ok=false when HEAD is detached.
+func currentBranch() (name string, ok bool, err error) {
+	content, err := readRawRef("HEAD")
+	if err != nil {
+		return "", false, err
+	}
+	target, isSymref := strings.CutPrefix(content, "ref: ")
+	if !isSymref {
+		return "", false, nil
+	}
+	return strings.TrimSpace(target), true, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// rewriteURL applies the longest matching url.<base>.insteadOf (or
+// pushInsteadOf for push URLs) prefix.
+func rewriteURL(config *Config, rawURL string, key string) (string, bool) {
+	bestBase, bestPrefix := "", ""
+	for _, e := range config.entries {
+		if e.Section != "url" || e.Key != key || e.Subsection == "" {
+			continue
+		}
+		if strings.HasPrefix(rawURL, e.Value) && len(e.Value) > len(bestPrefix) {
+			bestBase, bestPrefix = e.Subsection, e.Value
+		}
+	}
+	if bestPrefix == "" {
+		return rawURL, false
+	}
+	return bestBase + rawURL[len(bestPrefix):], true
+}
+
+func rewriteFetchURL(config *Config, rawURL string) string {
+	rewritten, _ := rewriteURL(config, rawURL, "insteadof")
+	return rewritten
+}
+
+func rewritePushURL(config *Config, rawURL string) string {
+	if rewritten, ok := rewriteURL(config, rawURL, "pushinsteadof"); ok {
+		return rewritten
+	}
+	return rewriteFetchURL(config, rawURL)
+}
+
+// remoteURLs returns the fetch or push URLs of a remote, after rewriting. A
+// name that is not a configured remote is treated as a URL itself.
+func remoteURLs(name string, push bool) ([]string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	urls := config.GetAll("remote." + name + ".url")
+	if len(urls) == 0 {
+		urls = []string{name}
+	}
+
+	if push {
+		if pushURLs := config.GetAll("remote." + name + ".pushurl"); len(pushURLs) > 0 {
+			rewritten := make([]string, 0, len(pushURLs))
+			for _, u := range pushURLs {
+				rewritten = append(rewritten, rewriteFetchURL(config, u))
+			}
+			return rewritten, nil
+		}
+	}
+
+	rewritten := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if push {
+			rewritten = append(rewritten, rewritePushURL(config, u))
+		} else {
+			rewritten = append(rewritten, rewriteFetchURL(config, u))
+		}
+	}
+	return rewritten, nil
+}
+
+func runLsRemote(args []string) error {
+	getURL := false
+	names := make([]string, 0, 1)
+	for _, arg := range args {
+		switch {
+		case arg == "--get-url":
+			getURL = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if !getURL {
+		return fmt.Errorf("listing remote refs is not supported, only --get-url")
+	}
+	if len(names) > 1 {
+		return fmt.Errorf("usage: ls-remote --get-url [<remote>]")
+	}
+	name := "origin"
+	if len(names) == 1 {
+		name = names[0]
+	}
+	urls, err := remoteURLs(name, false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, urls[0])
+	return nil
+}
+
+func runRemote(args []string) error {
+	if len(args) == 0 || args[0] != "get-url" {
+		return fmt.Errorf("usage: remote get-url [--push] [--all] <name>")
+	}
+	push, all := false, false
+	names := make([]string, 0, 1)
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--push":
+			push = true
+		case arg == "--all":
+			all = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("usage: remote get-url [--push] [--all] <name>")
+	}
+
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.GetAll("remote."+names[0]+".url")) == 0 {
+		return fmt.Errorf("no such remote '%s'", names[0])
+	}
+	urls, err := remoteURLs(names[0], push)
+	if err != nil {
+		return err
+	}
+	if !all {
+		urls = urls[:1]
+	}
+	for _, u := range urls {
+		fmt.Fprintln(os.Stdout, u)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import "sort"
+
+// minRenameScore is git's default -M threshold: at least half of the
+// content must be kept for a deleted and an added file to pair up.
+const minRenameScore = 50
+
+// findRenames pairs deleted and added files into renames: first those with
+// the same content, then the most similar ones scoring minRenameScore or
+// more. Renames take the place of the addition. Only additions selected by
+// targets are paired, which lets --follow look for the renames of one file.
+func findRenames(changes []fileChange, targets pathspec) ([]fileChange, error) {
+	deleted, added := make([]int, 0), make([]int, 0)
+	for i, c := range changes {
+		switch {
+		case c.Status == 'D' && c.OldMode != modeGitlink:
+			deleted = append(deleted, i)
+		case c.Status == 'A' && c.NewMode != modeGitlink && targets.matches(c.Path):
+			added = append(added, i)
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return changes, nil
+	}
+
+	source := map[int]int{}
+	score := map[int]int{}
+	used := map[int]bool{}
+	for _, a := range added {
+		for _, d := range deleted {
+			if !used[d] && changes[d].OldHash == changes[a].NewHash && changes[a].NewHash != emptyBlobHash {
+				source[a], score[a], used[d] = d, 100, true
+				break
+			}
+		}
+	}
+
+	type candidate struct{ added, deleted, score int }
+	candidates := make([]candidate, 0)
+	for _, a := range added {
+		if _, ok := source[a]; ok {
+			continue
+		}
+		newBlob, err := readBlobForDiff(changes[a].NewHash, changes[a].NewMode)
+		if err != nil {
+			return nil, err
+		}
+		if newBlob.Big || newBlob.Size == 0 {
+			continue
+		}
+		for _, d := range deleted {
+			if used[d] {
+				continue
+			}
+			oldBlob, err := readBlobForDiff(changes[d].OldHash, changes[d].OldMode)
+			if err != nil {
+				return nil, err
+			}
+			if oldBlob.Big || oldBlob.Size == 0 {
+				continue
+			}
+			if s := similarity(oldBlob.Content, newBlob.Content); s >= minRenameScore {
+				candidates = append(candidates, candidate{a, d, s})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	for _, c := range candidates {
+		if _, ok := source[c.added]; ok || used[c.deleted] {
+			continue
+		}
+		source[c.added], score[c.added], used[c.deleted] = c.deleted, c.score, true
+	}
+	if len(source) == 0 {
+		return changes, nil
+	}
+
+	renamed := make([]fileChange, 0, len(changes)-len(source))
+	for i, c := range changes {
+		if used[i] {
+			continue
+		}
+		if d, ok := source[i]; ok {
+			old := changes[d]
+			c.Status, c.OldPath, c.Score = 'R', old.Path, score[i]
+			c.OldMode, c.OldHash = old.OldMode, old.OldHash
+		}
+		renamed = append(renamed, c)
+	}
+	return renamed, nil
+}
+
+// similarity scores how much of the content of two files is shared, from 0
+// to 100: the bytes of the lines they have in common over the size of the
+// larger file, a line-based take on git's estimate_similarity.
+func similarity(old []byte, new []byte) int {
+	lines := map[string]int{}
+	for _, line := range splitLines(old) {
+		lines[line]++
+	}
+	shared := 0
+	for _, line := range splitLines(new) {
+		if lines[line] > 0 {
+			lines[line]--
+			shared += len(line)
+		}
+	}
+	return shared * 100 / max(len(old), len(new))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxReplaceDepth is how many replacements of replacements are followed,
+// the same limit as git's.
+const maxReplaceDepth = 5
+
+// readReplaceRefs is cleared by --no-replace-objects, GIT_NO_REPLACE_OBJECTS
+// and core.useReplaceRefs=false, and by commands that must see the objects
+// as stored, such as prune.
+var readReplaceRefs = os.Getenv("GIT_NO_REPLACE_OBJECTS") == ""
+
+// replacements maps replaced objects to their replacements, loaded on first
+// use.
+var replacements map[string]string
+
+func replaceRefBase() string {
+	if base := os.Getenv("GIT_REPLACE_REF_BASE"); base != "" {
+		return strings.TrimSuffix(base, "/") + "/"
+	}
+	return "refs/replace/"
+}
+
+func loadReplacements() error {
+	replacements = map[string]string{}
+	if config, err := getConfig(); err == nil {
+		if use, err := config.GetBool("core.useReplaceRefs", true); err == nil && !use {
+			return nil
+		}
+	}
+	base := replaceRefBase()
+	refs, err := listRefs(base)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		if old := strings.TrimPrefix(r.Name, base); isHexHash(old) {
+			replacements[old] = r.Hash
+		}
+	}
+	return nil
+}
+
+// replaceObject returns the object to read in place of hash, following
+// replacements of replacements.
+func replaceObject(hash string) (string, error) {
+	if !readReplaceRefs {
+		return hash, nil
+	}
+	if replacements == nil {
+		if err := loadReplacements(); err != nil {
+			return "", err
+		}
+	}
+	for i := 0; i < maxReplaceDepth; i++ {
+		replacement, ok := replacements[hash]
+		if !ok {
+			return hash, nil
+		}
+		hash = replacement
+	}
+	return "", fmt.Errorf("replace depth too high for object %s", hash)
+}
+
+// objectTypeOf reads the type of an object as stored, ignoring replacements.
+func objectTypeOf(hash string) (Type, error) {
+	saved := readReplaceRefs
+	readReplaceRefs = false
+	defer func() { readReplaceRefs = saved }()
+	or, err := openObject(hash)
+	if err != nil {
+		return "", err
+	}
+	defer or.Close()
+	return or.Type, nil
+}
+
+func listReplaceRefs(pattern string, format string) error {
+	base := replaceRefBase()
+	refs, err := listRefs(base)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, r := range refs {
+		old := strings.TrimPrefix(r.Name, base)
+		if pattern != "" && !wildmatch(pattern, old) {
+			continue
+		}
+		switch format {
+		case "short":
+			fmt.Fprintln(w, old)
+		case "medium":
+			fmt.Fprintf(w, "%s -> %s\n", old, r.Hash)
+		case "long":
+			oldType, err := objectTypeOf(old)
+			if err != nil {
+				return err
+			}
+			newType, err := objectTypeOf(r.Hash)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s (%s) -> %s (%s)\n", old, oldType, r.Hash, newType)
+		}
+	}
+	return nil
+}
+
+func deleteReplaceRefs(names []string) error {
+	failed := false
+	for _, name := range names {
+		hash, err := resolveRevision(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to resolve '%s' as a valid ref\n", name)
+			failed = true
+			continue
+		}
+		refName := replaceRefBase() + hash
+		old, err := readRawRef(refName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: replace ref '%s' not found\n", hash)
+			failed = true
+			continue
+		}
+		t := beginRefTransaction()
+		if err := t.delete(refName, true, old); err != nil {
+			return err
+		}
+		if err := t.commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted replace ref '%s'\n", hash)
+	}
+	if failed {
+		return errQuietFailure
+	}
+	return nil
+}
+
+func createReplaceRef(object string, replacement string, force bool) error {
+	saved := readReplaceRefs
+	readReplaceRefs = false
+	defer func() { readReplaceRefs = saved }()
+
+	oldHash, err := resolveRevision(object)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref", object)
+	}
+	newHash, err := resolveRevision(replacement)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref", replacement)
+	}
+	if oldHash == newHash {
+		return fmt.Errorf("new object is the same as the old one: '%s'", oldHash)
+	}
+	oldType, err := objectTypeOf(oldHash)
+	if err != nil {
+		return err
+	}
+	newType, err := objectTypeOf(newHash)
+	if err != nil {
+		return err
+	}
+	if oldType != newType && !force {
+		return fmt.Errorf("Objects must be of the same type.\n"+
+			"'%s' points to a replaced object of type '%s'\n"+
+			"while '%s' points to a replacement object of type '%s'.", object, oldType, replacement, newType)
+	}
+
+	refName := replaceRefBase() + oldHash
+	t := beginRefTransaction()
+	if force {
+		err = t.update(refName, newHash, false, "", "")
+	} else {
+		err = t.create(refName, newHash, "")
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.commit(); err != nil {
+		if _, readErr := readRawRef(refName); readErr == nil && !force {
+			return fmt.Errorf("replace ref '%s' already exists", refName)
+		}
+		return err
+	}
+	return nil
+}
+
+func runReplace(args []string) error {
+	force, deleteRefs, list := false, false, false
+	format := "short"
+	flags := newFlagSet()
+	flags.Bool(&force, "-f", "--force")
+	flags.Bool(&deleteRefs, "-d", "--delete")
+	flags.Bool(&list, "-l", "--list")
+	flags.String(&format, "--format")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if format != "short" && format != "medium" && format != "long" {
+		return fmt.Errorf("invalid replace format '%s'\nvalid formats are 'short', 'medium' and 'long'", format)
+	}
+	switch {
+	case deleteRefs:
+		if len(args) == 0 {
+			return fmt.Errorf("-d needs at least one argument")
+		}
+		return deleteReplaceRefs(args)
+	case list || len(args) == 0:
+		if len(args) > 1 {
+			return fmt.Errorf("only one pattern can be given with -l")
+		}
+		pattern := ""
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		return listReplaceRefs(pattern, format)
+	case len(args) != 2:
+		return fmt.Errorf("usage: mygit replace [-f] <object> <replacement>")
+	}
+	return createReplaceRef(args[0], args[1], force)
+}
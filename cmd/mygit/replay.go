@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const replayUsage = "mygit replay (--onto <newbase> | --advance <branch>) <revision-range>..."
+
+// replayConflict is a commit that did not apply cleanly, with the merge
+// that found its conflicts.
+type replayConflict struct {
+	Commit string
+	Tree   string
+	Merge  *treeMerge
+}
+
+// replayer picks commits onto new bases in memory: each is merged with the
+// tree it is replayed onto and written as a new commit, leaving the index,
+// the worktree and the refs alone.
+type replayer struct {
+	Onto  string
+	Style conflictStyle
+	// replayed maps the commits picked to their new versions
+	replayed  map[string]string
+	committer Identity
+}
+
+func newReplayer(onto string) (*replayer, error) {
+	style, err := configConflictStyle()
+	if err != nil {
+		return nil, err
+	}
+	committer, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return nil, err
+	}
+	return &replayer{Onto: onto, Style: style, replayed: map[string]string{}, committer: committer}, nil
+}
+
+// mapped returns the new version of a commit, or the base everything is
+// replayed onto when it was not picked.
+func (r *replayer) mapped(hash string) string {
+	if replayed, ok := r.replayed[hash]; ok {
+		return replayed
+	}
+	return r.Onto
+}
+
+// pick replays one commit onto the new version of its parent, returning
+// the conflict that stopped it, if any. The author and message are kept.
+func (r *replayer) pick(commit *Commit) (*replayConflict, error) {
+	if len(commit.Parents) > 1 {
+		return nil, fmt.Errorf("replaying merge commits is not supported yet")
+	}
+	parent := ""
+	if len(commit.Parents) == 1 {
+		parent = commit.Parents[0]
+	}
+	base := r.mapped(parent)
+	baseTree, err := commitTreeHash(parent)
+	if err != nil {
+		return nil, err
+	}
+	oursTree, err := commitTreeHash(base)
+	if err != nil {
+		return nil, err
+	}
+	label := abbrevHash(commit.Hash)
+	m := &treeMerge{OursLabel: abbrevHash(base), BaseLabel: "parent of " + label, TheirsLabel: label, Style: r.Style}
+	tree, err := m.mergeTrees(baseTree, oursTree, commit.Tree, "")
+	if err != nil {
+		return nil, err
+	}
+	if tree == "" {
+		if tree, err = writeObject(TypeTree, nil); err != nil {
+			return nil, err
+		}
+	}
+	if !m.clean() {
+		return &replayConflict{Commit: commit.Hash, Tree: tree, Merge: m}, nil
+	}
+	hash, err := writeRewrittenCommit(commit, tree, []string{base}, &r.committer)
+	if err != nil {
+		return nil, err
+	}
+	r.replayed[commit.Hash] = hash
+	return nil, nil
+}
+
+// replay picks the commits reachable from include but not from exclude,
+// parents first. It stops at the first conflict.
+func (r *replayer) replay(include []string, exclude []string) (last string, conflict *replayConflict, err error) {
+	walk, err := newRevWalk(include, exclude)
+	if err != nil {
+		return "", nil, err
+	}
+	walk.Sort = sortTopo
+	var commits []*Commit
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if commit == nil {
+			break
+		}
+		commits = append(commits, commit)
+	}
+	last = r.Onto
+	for i := len(commits) - 1; i >= 0; i-- {
+		if err := checkInterrupted(); err != nil {
+			return "", nil, err
+		}
+		if conflict, err := r.pick(commits[i]); err != nil || conflict != nil {
+			return "", conflict, err
+		}
+		last = r.replayed[commits[i].Hash]
+	}
+	return last, nil, nil
+}
+
+// replayRefs returns the refs named by the positive revisions of a range,
+// which --onto moves to their replayed commits.
+func replayRefs(args []string) []string {
+	var refs []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "^") {
+			continue
+		}
+		if _, right, ok := strings.Cut(arg, ".."); ok {
+			arg = strings.TrimPrefix(right, ".")
+		}
+		if name, err := resolveBranchArg(arg); err == nil {
+			refs = append(refs, name)
+		}
+	}
+	return refs
+}
+
+// writeReplayConflict reports the commit that did not apply, the tree with
+// its conflicts and what they are, as merge-tree would.
+func writeReplayConflict(w io.Writer, c *replayConflict) {
+	fmt.Fprintf(w, "conflict %s\n%s\n", c.Commit, c.Tree)
+	c.Merge.writeConflicts(w, false, true)
+}
+
+// runReplay rebases commits onto a new base without a worktree, printing
+// the ref updates to make in the format of update-ref --stdin. With
+// --advance the commits are picked onto a branch, which is moved to the
+// last of them.
+func runReplay(args []string) error {
+	onto, advance := "", ""
+	flags := newFlagSet()
+	flags.String(&onto, "--onto")
+	flags.String(&advance, "--advance")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case onto != "" && advance != "":
+		return fmt.Errorf("options '--onto' and '--advance' cannot be used together")
+	case onto == "" && advance == "", len(args) == 0:
+		return fmt.Errorf("usage: %s", replayUsage)
+	}
+
+	var refs []string
+	if advance != "" {
+		branch, err := resolveBranchArg(advance)
+		if err != nil {
+			return fmt.Errorf("argument to --advance must be a reference")
+		}
+		refs, onto = []string{branch}, branch
+	}
+	base, err := resolveCommitRevision(onto)
+	if err != nil {
+		return fmt.Errorf("could not resolve '%s'", onto)
+	}
+	include, exclude, err := parseRevisionArgs(args)
+	if err != nil {
+		return err
+	}
+	if advance == "" {
+		refs = replayRefs(args)
+	}
+
+	r, err := newReplayer(base)
+	if err != nil {
+		return err
+	}
+	last, conflict, err := r.replay(include, exclude)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if conflict != nil {
+		writeReplayConflict(w, conflict)
+		w.Flush()
+		return exitStatus(1)
+	}
+	for _, ref := range refs {
+		old, _, err := resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		updated := last
+		if advance == "" {
+			tip, err := peelToCommit(old)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if updated, ok = r.replayed[tip]; !ok {
+				continue
+			}
+		}
+		fmt.Fprintf(w, "update %s %s %s\n", ref, updated, old)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// repositoryExtensions are the extensions.* settings git defines, and
+// whether they are only valid with core.repositoryFormatVersion 1. Git
+// ignores unknown extensions in version 0 repositories.
+var repositoryExtensions = map[string]bool{
+	"noop":               false,
+	"preciousobjects":    false,
+	"partialclone":       false,
+	"worktreeconfig":     false,
+	"noop-v1":            true,
+	"objectformat":       true,
+	"compatobjectformat": true,
+	"refstorage":         true,
+}
+
+// supportedExtension reports whether mygit can work in a repository with
+// an extension set to value: a partial clone has objects missing, and only
+// SHA-1 objects and refs stored as files can be read.
+func supportedExtension(name string, value string) bool {
+	switch name {
+	case "partialclone", "compatobjectformat":
+		return false
+	case "objectformat":
+		return strings.EqualFold(value, "sha1")
+	case "refstorage":
+		return value == "files"
+	}
+	return true
+}
+
+// extensionList formats a list of extensions the way git reports them.
+func extensionList(one string, many string, names []string) error {
+	header := one
+	if len(names) > 1 {
+		header = many
+	}
+	return fmt.Errorf("%s\n\t%s", header, strings.Join(names, "\n\t"))
+}
+
+// checkRepositoryFormat refuses repositories mygit could damage: those of a
+// newer format version, and those using extensions it does not know or
+// does not implement.
+func checkRepositoryFormat() error {
+	entries, err := readConfigFile(getConfigPath())
+	if err != nil {
+		return err
+	}
+	local := &Config{entries: entries}
+	version, err := local.GetInt("core.repositoryFormatVersion", 0)
+	if err != nil {
+		return err
+	}
+	if version > 1 {
+		return fmt.Errorf("Expected git repo version <= 1, found %d", version)
+	}
+
+	var unknown, v1Only, unsupported []string
+	for _, e := range entries {
+		if e.Section != "extensions" || e.Subsection != "" {
+			continue
+		}
+		onlyV1, known := repositoryExtensions[e.Key]
+		switch {
+		case !known && version == 0:
+		case !known:
+			unknown = append(unknown, e.Key)
+		case onlyV1 && version == 0:
+			v1Only = append(v1Only, e.Key)
+		case !supportedExtension(e.Key, e.Value):
+			unsupported = append(unsupported, e.Key+" = "+e.Value)
+		}
+	}
+	switch {
+	case len(v1Only) > 0:
+		return extensionList("repo version is 0, but v1-only extension found:", "repo version is 0, but v1-only extensions found:", v1Only)
+	case len(unknown) > 0:
+		return extensionList("unknown repository extension found:", "unknown repository extensions found:", unknown)
+	case len(unsupported) > 0:
+		return extensionList("repository extension not supported by mygit found:", "repository extensions not supported by mygit found:", unsupported)
+	}
+	return nil
+}
+
+// preciousObjects reports whether extensions.preciousObjects asks for no
+// object to ever be deleted from the repository.
+func preciousObjects() (bool, error) {
+	entries, err := readConfigFile(getConfigPath())
+	if err != nil {
+		return false, err
+	}
+	local := &Config{entries: entries}
+	return local.GetBool("extensions.preciousObjects", false)
+}
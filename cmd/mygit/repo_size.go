@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// pathSize is how much history stores under one path: the blobs first seen
+// there and their total size.
+type pathSize struct {
+	Path  string
+	Size  int64
+	Blobs int
+}
+
+// sizeReport counts the objects reachable from the refs. Like rev-list
+// --objects, each tree and blob is visited once, and blobs are attributed to
+// the first path they are found at.
+type sizeReport struct {
+	commits, trees, blobs int
+	blobBytes             int64
+	seen                  map[string]bool
+	files                 map[string]*pathSize
+	dirs                  map[string]*pathSize
+}
+
+func (r *sizeReport) add(sizes map[string]*pathSize, name string, size int64) {
+	entry, ok := sizes[name]
+	if !ok {
+		entry = &pathSize{Path: name}
+		sizes[name] = entry
+	}
+	entry.Size += size
+	entry.Blobs++
+}
+
+func (r *sizeReport) addTree(hash string, prefix string) error {
+	if r.seen[hash] {
+		return nil
+	}
+	r.seen[hash] = true
+	r.trees++
+	entries, err := readTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		entryHash := hex.EncodeToString(e.Hash)
+		name := prefix + e.Name
+		switch {
+		case e.Mode == modeTree:
+			if err := r.addTree(entryHash, name+"/"); err != nil {
+				return err
+			}
+		case e.Mode == modeGitlink || r.seen[entryHash]:
+		default:
+			r.seen[entryHash] = true
+			or, err := openObject(entryHash)
+			if err != nil {
+				return err
+			}
+			size := int64(or.Size)
+			or.Close()
+			r.blobs++
+			r.blobBytes += size
+			r.add(r.files, name, size)
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				r.add(r.dirs, dir+"/", size)
+			}
+		}
+	}
+	return nil
+}
+
+// largest returns the n biggest paths, biggest first.
+func largest(sizes map[string]*pathSize, n int) []*pathSize {
+	sorted := make([]*pathSize, 0, len(sizes))
+	for _, s := range sizes {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Size != sorted[j].Size {
+			return sorted[i].Size > sorted[j].Size
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted[:min(n, len(sorted))]
+}
+
+func runRepoSize(args []string) error {
+	top := "10"
+	f := newFlagSet()
+	f.String(&top, "--top", "-n")
+	if positional, err := f.Parse(args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return fmt.Errorf("unexpected argument %s", positional[0])
+	}
+	n, err := strconv.Atoi(top)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid --top value %s", top)
+	}
+
+	_, tips, err := historyRefs()
+	if err != nil {
+		return err
+	}
+	walk, err := newRevWalk(tips, nil)
+	if err != nil {
+		return err
+	}
+	r := &sizeReport{seen: map[string]bool{}, files: map[string]*pathSize{}, dirs: map[string]*pathSize{}}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		r.commits++
+		if err := r.addTree(commit.Tree, ""); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "%d commits, %d trees, %d blobs, %s of blobs\n", r.commits, r.trees, r.blobs, humaniseBytes(r.blobBytes))
+	for _, section := range []struct {
+		title, noun string
+		sizes       map[string]*pathSize
+	}{{"Largest files", "version", r.files}, {"Largest directories", "blob", r.dirs}} {
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, s := range largest(section.sizes, n) {
+			count := fmt.Sprintf("%d %s", s.Blobs, section.noun)
+			if s.Blobs != 1 {
+				count += "s"
+			}
+			fmt.Fprintf(w, "%12s  %-12s  %s\n", humaniseBytes(s.Size), count, quotePath(s.Path, false))
+		}
+	}
+	return nil
+}
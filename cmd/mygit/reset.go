@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// branch state files left by an interrupted merge, cherry-pick or revert
+var branchStateFiles = []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "CHERRY_PICK_HEAD", "REVERT_HEAD", "AUTO_MERGE"}
+
+// removeBranchState clears the branch state files, which are kept on disk
+// even under --in-memory and so are left alone there.
+func removeBranchState() error {
+	if inMemory != nil {
+		return nil
+	}
+	for _, name := range branchStateFiles {
+		err := os.Remove(filepath.Join(gitDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// updateHeadRef moves whatever HEAD points to (a branch or a detached HEAD)
+// from expected to hash, failing if another process moved it in between.
+func updateHeadRef(hash string, expected string, reflogMessage string) error {
+	_, target, err := resolveRef("HEAD")
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+	// the transaction logs the move in HEAD's reflog too
+	t := beginRefTransaction()
+	if err := t.update(target, hash, true, expected, reflogMessage); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// resetIndex makes the index match a tree, keeping the stat data of entries
+// whose content does not change.
+func resetIndex(index *Index, tree string) error {
+	files, err := flattenTree(tree, "")
+	if err != nil {
+		return err
+	}
+	entries := make([]IndexEntry, 0, len(files))
+	for _, f := range files {
+		entry := IndexEntry{Path: f.Path, Mode: f.Mode, Hash: f.Hash}
+		if i := index.find(f.Path); i != -1 && index.Entries[i].Hash == f.Hash && index.Entries[i].Mode == f.Mode {
+			entry = index.Entries[i]
+		}
+		entries = append(entries, entry)
+	}
+	index.Entries = entries
+	return nil
+}
+
+// resetWorktree overwrites the worktree with the index, deleting the tracked
+// files in oldIndex that are no longer tracked. Files marked skip-worktree
+// are left alone.
+func resetWorktree(oldIndex *Index, index *Index) error {
+	for _, e := range oldIndex.Entries {
+		if index.find(e.Path) == -1 {
+			if err := removeWorktreeFile(e.Path); err != nil {
+				return err
+			}
+		}
+	}
+	for i, e := range index.Entries {
+		if e.skipWorktree() {
+			continue
+		}
+		clean, err := worktreeContentMatches(index, &index.Entries[i])
+		if err != nil {
+			return err
+		}
+		if clean {
+			continue
+		}
+		entry, err := checkoutFile(e.Path, e.Mode, e.Hash)
+		if err != nil {
+			return err
+		}
+		entry.Flags, entry.ExtendedFlags = e.Flags, e.ExtendedFlags
+		index.Entries[i] = entry
+	}
+	return nil
+}
+
+func printUnstagedChanges(index *Index) error {
+	header := false
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		clean, err := worktreeMatchesIndex(index, e)
+		if err != nil {
+			return err
+		}
+		if clean {
+			continue
+		}
+		status := 'M'
+		if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+			status = 'D'
+		}
+		if !header {
+			fmt.Println("Unstaged changes after reset:")
+			header = true
+		}
+		fmt.Printf("%c\t%s\n", status, e.Path)
+	}
+	return nil
+}
+
+func runReset(args []string) error {
+	resetMode, quiet := "mixed", false
+	names, pathspecs := make([]string, 0, 1), []string(nil)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			pathspecs = append(make([]string, 0), args[i+1:]...)
+			i = len(args)
+		case arg == "--soft" || arg == "--mixed" || arg == "--hard":
+			resetMode = strings.TrimPrefix(arg, "--")
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if pathspecs == nil && len(names) > 0 {
+		if _, err := resolveTreeish(names[0]); err != nil {
+			pathspecs, names = names, nil
+		} else if len(names) > 1 {
+			pathspecs, names = names[1:], names[:1]
+		}
+	}
+	rev := "HEAD"
+	if len(names) == 1 {
+		rev = names[0]
+	}
+
+	if pathspecs != nil {
+		if resetMode != "mixed" {
+			return fmt.Errorf("cannot do %s reset with paths", resetMode)
+		}
+		err := restorePaths(restoreOptions{Source: rev, Staged: true, Pathspecs: pathspecs, IgnoreUnmatched: true})
+		if err != nil || quiet {
+			return err
+		}
+		index, err := readIndex()
+		if err != nil {
+			return err
+		}
+		return printUnstagedChanges(index)
+	}
+
+	if resetMode == "soft" {
+		if _, err := os.Stat(filepath.Join(gitDir, "MERGE_HEAD")); err == nil {
+			return fmt.Errorf("cannot do a soft reset in the middle of a merge")
+		}
+	}
+	// on an unborn branch HEAD is the empty tree, and stays unborn
+	_, unborn := unbornBranch()
+	unborn = unborn && len(names) == 0
+	target := ""
+	if !unborn {
+		hash, err := resolveCommitRevision(rev)
+		if err != nil {
+			return fmt.Errorf("failed to resolve '%s' as a valid revision", rev)
+		}
+		target = hash
+	}
+	tree, err := commitTreeHash(target)
+	if err != nil {
+		return err
+	}
+	// a soft reset leaves the index alone, the others hold its lock from
+	// before HEAD moves until the new index is written
+	var oldIndex *Index
+	var lock *lockFile
+	if resetMode != "soft" {
+		oldIndex, lock, err = lockIndex()
+		if err != nil {
+			return err
+		}
+		defer lock.rollback()
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		if err := writeRefFile("ORIG_HEAD", head); err != nil {
+			return err
+		}
+	}
+	if !unborn {
+		if err := updateHeadRef(target, head, "reset: moving to "+rev); err != nil {
+			return err
+		}
+	}
+	if resetMode == "soft" {
+		return nil
+	}
+
+	index := &Index{Version: oldIndex.Version, Entries: append([]IndexEntry(nil), oldIndex.Entries...)}
+	if err := resetIndex(index, tree); err != nil {
+		return err
+	}
+	if resetMode == "hard" {
+		if err := resetWorktree(oldIndex, index); err != nil {
+			return err
+		}
+	}
+	if err := writeIndex(index, lock); err != nil {
+		return err
+	}
+	if err := removeBranchState(); err != nil {
+		return err
+	}
+
+	if quiet {
+		return nil
+	}
+	if resetMode == "hard" {
+		if unborn {
+			return nil
+		}
+		commit, err := readCommit(target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %s %s\n", abbrevHash(target), commit.Subject())
+		return nil
+	}
+	return printUnstagedChanges(index)
+}
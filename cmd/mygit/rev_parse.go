@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func runRevParse(args []string) error {
+	verify, quiet := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--verify":
+			verify = true
+		case "-q", "--quiet":
+			quiet = true
+		}
+	}
+
+	// output options apply to the revisions that follow them
+	abbrevRef, symbolicFullName := false, false
+	shortLen := 0
+	revCount := 0
+	for _, arg := range args {
+		switch {
+		case arg == "--verify" || arg == "-q" || arg == "--quiet":
+		case arg == "--short":
+			shortLen = defaultAbbrevLength()
+		case strings.HasPrefix(arg, "--short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil {
+				return fmt.Errorf("bad value for %s", arg)
+			}
+			shortLen = min(max(n, minAbbrev), 40)
+		case arg == "--abbrev-ref":
+			abbrevRef = true
+		case arg == "--symbolic-full-name":
+			symbolicFullName = true
+		case arg == "--is-shallow-repository":
+			shallow, err := isShallowRepository()
+			if err != nil {
+				return err
+			}
+			fmt.Println(shallow)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			revCount++
+			if verify && revCount > 1 {
+				return fmt.Errorf("needed a single revision")
+			}
+			if err := printRevParse(arg, abbrevRef, symbolicFullName, shortLen); err != nil {
+				if quiet {
+					return errQuietFailure
+				}
+				if verify {
+					return fmt.Errorf("needed a single revision")
+				}
+				return err
+			}
+		}
+	}
+	if verify && revCount == 0 {
+		return fmt.Errorf("needed a single revision")
+	}
+	return nil
+}
+
+func printRevParse(rev string, abbrevRef bool, symbolicFullName bool, shortLen int) error {
+	if abbrevRef || symbolicFullName {
+		name, err := revisionRefName(rev)
+		if err != nil {
+			return err
+		}
+		if abbrevRef && name != "HEAD" {
+			name = shortenRefName(name)
+		}
+		fmt.Println(name)
+		return nil
+	}
+
+	hash, err := resolveRevision(rev)
+	if err != nil {
+		return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", rev)
+	}
+	if shortLen > 0 {
+		hash = uniqueAbbrev(hash, shortLen)
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// revisionRefName returns the full name of the ref a revision names, with
+// HEAD resolved to its branch; a detached HEAD stays "HEAD".
+func revisionRefName(rev string) (string, error) {
+	if rev == "@" {
+		rev = "HEAD"
+	}
+	for _, suffix := range []string{"@{upstream}", "@{u}"} {
+		if branch, found := strings.CutSuffix(rev, suffix); found {
+			return resolveUpstreamRef(branch)
+		}
+	}
+	for _, candidate := range dwimRefs(rev) {
+		_, target, err := resolveRef(candidate)
+		if err == nil {
+			return target, nil
+		}
+		if err != errRefNotFound {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", rev)
+}
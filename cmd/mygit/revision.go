@@ -0,0 +1,772 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAbbrev = 7
+	minAbbrev     = 4
+)
+
+// abbrevLength is the length hashes are shortened to, worked out once.
+var abbrevLength = 0
+
+// defaultAbbrevLength is core.abbrev, a length or "no" for whole hashes. When
+// it is unset or "auto", the length grows with the number of objects like
+// git's: a repository of about 2^n objects expects a collision among
+// prefixes of n/2 bits, so hashes get one hex digit per 4 of those bits, and
+// never fewer than defaultAbbrev.
+func defaultAbbrevLength() int {
+	if abbrevLength > 0 {
+		return abbrevLength
+	}
+	abbrevLength = defaultAbbrev
+	config, err := getConfig()
+	if err != nil {
+		return abbrevLength
+	}
+	value, _ := config.Get("core.abbrev")
+	switch strings.ToLower(value) {
+	case "", "auto":
+		count := 0
+		objects.Iterate("", func(string) error {
+			count++
+			return nil
+		})
+		if _, packed, err := countPackedObjects(); err == nil {
+			count += packed
+		}
+		bits := 0
+		for ; count > 0; count >>= 1 {
+			bits++
+		}
+		abbrevLength = max((bits+1)/2, defaultAbbrev)
+	case "no", "false", "off":
+		abbrevLength = 40
+	default:
+		if n, err := strconv.Atoi(value); err == nil {
+			abbrevLength = min(max(n, minAbbrev), 40)
+		}
+	}
+	return abbrevLength
+}
+
+// abbrevHash shortens a hash to the configured length, or to however much
+// longer it takes to tell it apart from the other stored objects.
+func abbrevHash(hash string) string {
+	return uniqueAbbrev(hash, defaultAbbrevLength())
+}
+
+// uniqueAbbrev shortens a hash to at least n digits, taking more while
+// another stored object shares the prefix.
+func uniqueAbbrev(hash string, n int) string {
+	for ; n < len(hash); n++ {
+		matches, err := findObjectsByPrefix(hash[:n])
+		if err != nil || len(matches) == 0 || len(matches) == 1 && matches[0] == hash {
+			return hash[:n]
+		}
+	}
+	return hash
+}
+
+func isHexPrefix(s string) bool {
+	if len(s) < 4 || len(s) > 40 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// findObjectsByPrefix lists the stored objects whose hash starts with prefix.
+func findObjectsByPrefix(prefix string) ([]string, error) {
+	matches := make([]string, 0, 1)
+	err := objects.Iterate(prefix, func(hash string) error {
+		matches = append(matches, hash)
+		return nil
+	})
+	return matches, err
+}
+
+func objectExists(hash string) bool {
+	return objects.Has(hash)
+}
+
+// dwimRefs returns the refnames a short name may refer to, in git's lookup
+// order.
+func dwimRefs(name string) []string {
+	return []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	}
+}
+
+func resolveRefName(name string) (string, error) {
+	if name == "@" {
+		name = "HEAD"
+	}
+	if name == "HEAD" && getNamespacePrefix() != "" {
+		hash, _, err := resolveRef(namespacedRefName(name))
+		if err != errRefNotFound {
+			return hash, err
+		}
+	}
+	for _, candidate := range dwimRefs(name) {
+		hash, _, err := resolveRef(candidate)
+		if err == nil {
+			return hash, nil
+		}
+		if err != errRefNotFound {
+			return "", err
+		}
+	}
+	return "", errRefNotFound
+}
+
+// resolveUpstreamRef resolves "<branch>@{upstream}" (or @{u}) to the ref the
+// branch tracks; an empty branch means the current one.
+func resolveUpstreamRef(branch string) (string, error) {
+	if branch == "" || branch == "HEAD" || branch == "@" {
+		current, ok, err := currentBranch()
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+		branch = current
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	if _, _, err := resolveRef("refs/heads/" + branch); err != nil {
+		return "", fmt.Errorf("no such branch: '%s'", branch)
+	}
+	return upstreamOf("refs/heads/" + branch)
+}
+
+func resolveBaseRevision(name string) (string, error) {
+	for _, suffix := range []string{"@{upstream}", "@{u}"} {
+		if branch, found := strings.CutSuffix(name, suffix); found {
+			upstream, err := resolveUpstreamRef(branch)
+			if err != nil {
+				return "", err
+			}
+			hash, _, err := resolveRef(upstream)
+			if err != nil {
+				return "", fmt.Errorf("upstream branch '%s' does not exist", shortenRefName(upstream))
+			}
+			return hash, nil
+		}
+	}
+	if isHexHash(name) && objectExists(name) {
+		return name, nil
+	}
+	hash, err := resolveRefName(name)
+	if err == nil {
+		return hash, nil
+	}
+	if err != errRefNotFound {
+		return "", err
+	}
+	if isHexPrefix(name) {
+		matches, err := findObjectsByPrefix(name)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("short object ID %s is ambiguous", name)
+		}
+	}
+	return "", unknownRevision(name)
+}
+
+// peelToCommit dereferences annotated tags until it reaches a commit.
+func peelToCommit(hash string) (string, error) {
+	return peelObject(hash, TypeCommit)
+}
+
+// peelObject dereferences hash until it reaches an object of type want:
+// annotated tags to the object they point at, and a commit to its tree when
+// a tree is wanted. An empty want peels tags only, stopping at the first
+// object that is not one.
+func peelObject(hash string, want Type) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		object, err := parseObject(hash)
+		if err != nil {
+			return "", err
+		}
+		if object.Type == want || want == "" && object.Type != TypeTag {
+			return hash, nil
+		}
+		switch {
+		case object.Type == TypeTag:
+			target, _, found := strings.Cut(string(object.Content), "\n")
+			target, isObject := strings.CutPrefix(target, "object ")
+			if !found || !isObject {
+				return "", fmt.Errorf("tag %s is corrupt", hash)
+			}
+			hash = target
+		case object.Type == TypeCommit && want == TypeTree:
+			return commitTreeHash(hash)
+		default:
+			return "", fmt.Errorf("object %s is a %s, not a %s", hash, object.Type, want)
+		}
+	}
+	return "", fmt.Errorf("tag chain at %s is too deep", hash)
+}
+
+// resolveRevision resolves names like "main", "HEAD~2", "abc123^2" or
+// "v1.0^{commit}" to an object hash. "^{<type>}" peels the object to that
+// type, "^{}" peels tags to what they point at, and "^{object}" only checks
+// that the object exists. "<rev>:<path>" names the entry at path in the
+// tree of rev.
+func resolveRevision(spec string) (string, error) {
+	if rev, path, found := strings.Cut(spec, ":"); found && rev != "" {
+		tree, err := resolveTreeish(rev)
+		if err != nil {
+			return "", err
+		}
+		hash, _, err := lookupTreePath(tree, revisionPath(path))
+		if err != nil {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+		}
+		return hash, nil
+	}
+	baseEnd := strings.IndexAny(spec, "~^")
+	if baseEnd == -1 {
+		baseEnd = len(spec)
+	}
+	if baseEnd == 0 {
+		return "", unknownRevision(spec)
+	}
+	hash, err := resolveBaseRevision(spec[:baseEnd])
+	if err != nil {
+		return "", err
+	}
+
+	rest := spec[baseEnd:]
+	for len(rest) > 0 {
+		op := rest[0]
+		rest = rest[1:]
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end == -1 {
+				return "", unknownRevision(spec)
+			}
+			want := Type(rest[1:end])
+			rest = rest[end+1:]
+			switch want {
+			case "object":
+				if !objectExists(hash) {
+					return "", unknownRevision(spec)
+				}
+			case "", TypeCommit, TypeTree, TypeBlob, TypeTag:
+				if hash, err = peelObject(hash, want); err != nil {
+					return "", fmt.Errorf("%s: %s", spec, err.Error())
+				}
+			default:
+				return "", unknownRevision(spec)
+			}
+			continue
+		}
+		digitsEnd := 0
+		for digitsEnd < len(rest) && '0' <= rest[digitsEnd] && rest[digitsEnd] <= '9' {
+			digitsEnd++
+		}
+		n := 1
+		if digitsEnd > 0 {
+			n, _ = strconv.Atoi(rest[:digitsEnd])
+		}
+		rest = rest[digitsEnd:]
+
+		hash, err = peelToCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			commit, err := readCommit(hash)
+			if err != nil {
+				return "", err
+			}
+			if n > len(commit.Parents) {
+				return "", fmt.Errorf("revision '%s' does not exist", spec)
+			}
+			hash = commit.Parents[n-1]
+			continue
+		}
+		for i := 0; i < n; i++ {
+			commit, err := readCommit(hash)
+			if err != nil {
+				return "", err
+			}
+			if len(commit.Parents) == 0 {
+				return "", fmt.Errorf("revision '%s' does not exist", spec)
+			}
+			hash = commit.Parents[0]
+		}
+	}
+	return hash, nil
+}
+
+// revisionPath cleans the path of a "<rev>:<path>" that starts with "./"
+// or "../", which git takes relative to the current directory: the top of
+// the worktree, where commands run from.
+func revisionPath(name string) string {
+	if !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+		return name
+	}
+	if name = path.Clean(name); name == "." {
+		return ""
+	}
+	return name
+}
+
+// lookupTreePath finds path in a tree, returning the hash and mode of its
+// entry. An empty path is the tree itself, and a directory may be named with
+// a trailing slash.
+func lookupTreePath(tree string, path string) (string, int, error) {
+	hash, mode := tree, modeTree
+	if path == "" {
+		return hash, mode, nil
+	}
+	for _, name := range strings.Split(strings.TrimSuffix(path, "/"), "/") {
+		if mode != modeTree {
+			return "", 0, errRefNotFound
+		}
+		entries, err := readTree(hash)
+		if err != nil {
+			return "", 0, err
+		}
+		i := slices.IndexFunc(entries, func(e TreeObjectLine) bool { return e.Name == name })
+		if i == -1 {
+			return "", 0, errRefNotFound
+		}
+		hash, mode = hex.EncodeToString(entries[i].Hash), entries[i].Mode
+	}
+	if strings.HasSuffix(path, "/") && mode != modeTree {
+		return "", 0, errRefNotFound
+	}
+	return hash, mode, nil
+}
+
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// dateQueue orders commits newest committer date first like commitQueue,
+// breaking ties by insertion order as git's prio_queue does.
+type dateQueue struct {
+	commits []*Commit
+	order   []int
+	next    int
+}
+
+func (q *dateQueue) Len() int { return len(q.commits) }
+func (q *dateQueue) Less(i, j int) bool {
+	if !q.commits[i].Committer.When.Equal(q.commits[j].Committer.When) {
+		return q.commits[i].Committer.When.After(q.commits[j].Committer.When)
+	}
+	return q.order[i] < q.order[j]
+}
+func (q *dateQueue) Swap(i, j int) {
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+	q.order[i], q.order[j] = q.order[j], q.order[i]
+}
+func (q *dateQueue) Push(x interface{}) {
+	q.commits = append(q.commits, x.(*Commit))
+	q.order = append(q.order, q.next)
+	q.next++
+}
+func (q *dateQueue) Pop() interface{} {
+	c := q.commits[len(q.commits)-1]
+	q.commits, q.order = q.commits[:len(q.commits)-1], q.order[:len(q.order)-1]
+	return c
+}
+
+// revSort is the order a revWalk yields commits in.
+type revSort int
+
+const (
+	// sortDefault goes newest committer date first as the walk goes, which
+	// can show a parent before a child with a skewed date
+	sortDefault revSort = iota
+	// sortDate shows no parent before all of its children, and otherwise
+	// keeps committer date order (--date-order)
+	sortDate
+	// sortTopo shows no parent before all of its children either, and
+	// keeps each line of history together instead of interleaving them
+	// by date (--topo-order)
+	sortTopo
+)
+
+// revWalk yields commits reachable from the included tips but not from the
+// excluded ones, newest committer date first.
+type revWalk struct {
+	queue    commitQueue
+	seen     map[string]bool
+	excluded map[string]bool
+	// FirstParent follows only the first parent of merge commits
+	FirstParent bool
+	// Sort other than sortDefault walks everything up front, then yields
+	// the commits from sorted
+	Sort    revSort
+	sorted  []*Commit
+	limited bool
+	// Since stops the walk at commits committed before it, and Until
+	// leaves out those committed after it but walks on past them
+	Since time.Time
+	Until time.Time
+}
+
+func newRevWalk(include []string, exclude []string) (*revWalk, error) {
+	w := &revWalk{seen: map[string]bool{}, excluded: map[string]bool{}}
+	for _, hash := range exclude {
+		if err := w.markExcluded(hash); err != nil {
+			return nil, err
+		}
+	}
+	for _, hash := range include {
+		if err := w.push(hash); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *revWalk) markExcluded(hash string) error {
+	stack := []string{hash}
+	for len(stack) > 0 {
+		hash = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.excluded[hash] {
+			continue
+		}
+		w.excluded[hash] = true
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, commit.Parents...)
+	}
+	return nil
+}
+
+func (w *revWalk) push(hash string) error {
+	if w.seen[hash] || w.excluded[hash] {
+		return nil
+	}
+	w.seen[hash] = true
+	commit, err := readCommit(hash)
+	if err != nil {
+		return err
+	}
+	heap.Push(&w.queue, commit)
+	return nil
+}
+
+// Next returns the next commit, or nil when the walk is done.
+func (w *revWalk) Next() (*Commit, error) {
+	if w.Sort == sortDefault {
+		return w.walk()
+	}
+	if !w.limited {
+		if err := w.sortCommits(); err != nil {
+			return nil, err
+		}
+		w.limited = true
+	}
+	if len(w.sorted) == 0 {
+		return nil, nil
+	}
+	commit := w.sorted[0]
+	w.sorted = w.sorted[1:]
+	return commit, nil
+}
+
+// sortCommits walks all commits and orders them with topoSortCommits.
+func (w *revWalk) sortCommits() error {
+	all := make([]*Commit, 0)
+	for {
+		commit, err := w.walk()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		all = append(all, commit)
+	}
+	w.sorted = topoSortCommits(all, w.Sort)
+	return nil
+}
+
+// topoSortCommits orders commits like git's sort_in_topological_order: a
+// commit becomes ready once all of its children among them are shown. Ready
+// commits are taken newest first for sortDate and most recently readied
+// first for sortTopo, which keeps following one line of history.
+func topoSortCommits(all []*Commit, order revSort) []*Commit {
+	// children counts the children of each commit among all
+	children := make(map[string]int, len(all))
+	byHash := make(map[string]*Commit, len(all))
+	for _, c := range all {
+		children[c.Hash] = 0
+		byHash[c.Hash] = c
+	}
+	for _, c := range all {
+		for _, parent := range c.Parents {
+			if _, ok := children[parent]; ok {
+				children[parent]++
+			}
+		}
+	}
+
+	var ready dateQueue
+	stack := make([]*Commit, 0)
+	put := func(c *Commit) {
+		if order == sortTopo {
+			stack = append(stack, c)
+		} else {
+			heap.Push(&ready, c)
+		}
+	}
+	// the tips go out in the order given, so the stack takes them reversed
+	for i := range all {
+		c := all[i]
+		if order == sortTopo {
+			c = all[len(all)-1-i]
+		}
+		if children[c.Hash] == 0 {
+			put(c)
+		}
+	}
+	sorted := make([]*Commit, 0, len(all))
+	for {
+		var commit *Commit
+		if order == sortTopo {
+			if len(stack) == 0 {
+				break
+			}
+			commit, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		} else {
+			if ready.Len() == 0 {
+				break
+			}
+			commit = heap.Pop(&ready).(*Commit)
+		}
+		for _, parent := range commit.Parents {
+			if _, ok := children[parent]; !ok {
+				continue
+			}
+			if children[parent]--; children[parent] == 0 {
+				put(byHash[parent])
+			}
+		}
+		sorted = append(sorted, commit)
+	}
+	return sorted
+}
+
+// walk returns the next commit in the order the walk finds them.
+func (w *revWalk) walk() (*Commit, error) {
+	for w.queue.Len() > 0 {
+		if err := checkInterrupted(); err != nil {
+			return nil, err
+		}
+		commit := heap.Pop(&w.queue).(*Commit)
+		// like git, the history behind a commit older than Since is not
+		// walked, though a commit dated before its parent can hide some
+		if !w.Since.IsZero() && commit.Committer.When.Before(w.Since) {
+			continue
+		}
+		parents := commit.Parents
+		if w.FirstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, parent := range parents {
+			if err := w.push(parent); err != nil {
+				return nil, err
+			}
+		}
+		if !w.Until.IsZero() && commit.Committer.When.After(w.Until) {
+			continue
+		}
+		return commit, nil
+	}
+	return nil, nil
+}
+
+// countAheadBehind counts the commits only reachable from local (ahead) and
+// only reachable from upstream (behind). Both sides are walked together,
+// newest first, and the walk stops once only commits reachable from both
+// are left, so it costs as much as the two have diverged rather than the
+// whole history. Like git without generation numbers, a commit dated before
+// its parent can end the walk early.
+func countAheadBehind(local string, upstream string) (ahead int, behind int, err error) {
+	const (
+		sideLocal = 1 << iota
+		sideUpstream
+		sideBoth = sideLocal | sideUpstream
+	)
+	sides := map[string]int{}
+	var queue commitQueue
+	paint := func(hash string, side int) error {
+		if sides[hash]|side == sides[hash] {
+			return nil
+		}
+		sides[hash] |= side
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		heap.Push(&queue, commit)
+		return nil
+	}
+	if err := paint(local, sideLocal); err != nil {
+		return 0, 0, err
+	}
+	if err := paint(upstream, sideUpstream); err != nil {
+		return 0, 0, err
+	}
+	for queue.Len() > 0 {
+		if err := checkInterrupted(); err != nil {
+			return 0, 0, err
+		}
+		pending := false
+		for _, c := range queue {
+			pending = pending || sides[c.Hash] != sideBoth
+		}
+		if !pending {
+			break
+		}
+		commit := heap.Pop(&queue).(*Commit)
+		for _, parent := range commit.Parents {
+			if err := paint(parent, sides[commit.Hash]); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	for _, side := range sides {
+		switch side {
+		case sideLocal:
+			ahead++
+		case sideUpstream:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// parseSymmetricRange splits "A...B" into its sides, either of which
+// defaults to HEAD.
+func parseSymmetricRange(arg string) (left string, right string, ok bool) {
+	left, right, ok = strings.Cut(arg, "...")
+	if !ok {
+		return "", "", false
+	}
+	if left == "" {
+		left = "HEAD"
+	}
+	if right == "" {
+		right = "HEAD"
+	}
+	return left, right, true
+}
+
+// parseRevisionArgs splits "A", "^A", "A..B" and "A...B" arguments into
+// commits to include and exclude. A symmetric range includes both sides and
+// excludes their merge bases.
+func parseRevisionArgs(args []string) (include []string, exclude []string, err error) {
+	for _, arg := range args {
+		negative := false
+		if strings.HasPrefix(arg, "^") {
+			negative = true
+			arg = arg[1:]
+		}
+		if left, right, isSymmetric := parseSymmetricRange(arg); isSymmetric && !negative {
+			leftHash, err := resolveCommitRevision(left)
+			if err != nil {
+				return nil, nil, err
+			}
+			rightHash, err := resolveCommitRevision(right)
+			if err != nil {
+				return nil, nil, err
+			}
+			bases, err := mergeBases(leftHash, rightHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, leftHash, rightHash)
+			exclude = append(exclude, bases...)
+			continue
+		}
+		if from, to, isRange := strings.Cut(arg, ".."); isRange && !negative {
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			fromHash, err := resolveCommitRevision(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			toHash, err := resolveCommitRevision(to)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, fromHash)
+			include = append(include, toHash)
+			continue
+		}
+		hash, err := resolveCommitRevision(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if negative {
+			exclude = append(exclude, hash)
+		} else {
+			include = append(include, hash)
+		}
+	}
+	return include, exclude, nil
+}
+
+func resolveCommitRevision(spec string) (string, error) {
+	hash, err := resolveRevision(spec)
+	if err != nil {
+		return "", err
+	}
+	return peelToCommit(hash)
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type rewriteOptions struct {
+	// Paths keeps only the matching files, or drops them with InvertPaths
+	Paths       pathspec
+	InvertPaths bool
+	// Subdirectory makes the tree of this directory the new root
+	Subdirectory string
+	// MaxBlobSize drops blobs bigger than it, 0 keeps all of them
+	MaxBlobSize int
+}
+
+// historyRewriter rewrites commits parents first, so the new parents of a
+// commit are always known when it is rewritten.
+type historyRewriter struct {
+	opts rewriteOptions
+	// trees maps a path prefix and an old tree to the new tree, "" when
+	// nothing is left of it
+	trees     map[string]string
+	blobSizes map[string]int
+	// commits maps old commits to new ones, "" when a commit and all of
+	// its ancestors were pruned
+	commits map[string]string
+	// newTrees holds the trees of the rewritten commits, "" when empty
+	newTrees map[string]string
+}
+
+func (r *historyRewriter) keepBlob(path string, hash string) (bool, error) {
+	if len(r.opts.Paths) > 0 && r.opts.Paths.matches(path) == r.opts.InvertPaths {
+		return false, nil
+	}
+	if r.opts.MaxBlobSize == 0 {
+		return true, nil
+	}
+	size, ok := r.blobSizes[hash]
+	if !ok {
+		or, err := openObject(hash)
+		if err != nil {
+			return false, err
+		}
+		size = or.Size
+		or.Close()
+		r.blobSizes[hash] = size
+	}
+	return size <= r.opts.MaxBlobSize, nil
+}
+
+// rewriteTree applies the path and blob filters to the tree at prefix.
+// Directories left without files are dropped, as git never records them.
+func (r *historyRewriter) rewriteTree(hash string, prefix string) (string, error) {
+	key := prefix + "\x00" + hash
+	if tree, ok := r.trees[key]; ok {
+		return tree, nil
+	}
+	entries, err := readTree(hash)
+	if err != nil {
+		return "", err
+	}
+	kept := make([]TreeObjectLine, 0, len(entries))
+	changed := false
+	for _, e := range entries {
+		path := prefix + e.Name
+		keep := true
+		switch {
+		case e.Mode == modeTree:
+			sub, err := r.rewriteTree(fmt.Sprintf("%x", e.Hash), path+"/")
+			if err != nil {
+				return "", err
+			}
+			if sub != fmt.Sprintf("%x", e.Hash) {
+				changed = true
+				e.Hash, _ = hex.DecodeString(sub)
+			}
+			keep = sub != ""
+		case e.Mode == modeGitlink:
+			keep = len(r.opts.Paths) == 0 || r.opts.Paths.matches(path) != r.opts.InvertPaths
+		default:
+			if keep, err = r.keepBlob(path, fmt.Sprintf("%x", e.Hash)); err != nil {
+				return "", err
+			}
+		}
+		if !keep {
+			changed = true
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	tree := hash
+	switch {
+	case len(kept) == 0:
+		tree = ""
+	case changed:
+		if tree, err = writeTreeEntries(kept); err != nil {
+			return "", err
+		}
+	}
+	r.trees[key] = tree
+	return tree, nil
+}
+
+// rootTree returns the rewritten tree of a commit, "" when nothing is left
+// of it.
+func (r *historyRewriter) rootTree(hash string) (string, error) {
+	tree, err := r.rewriteTree(hash, "")
+	if err != nil {
+		return "", err
+	}
+	if r.opts.Subdirectory != "" {
+		for _, name := range strings.Split(r.opts.Subdirectory, "/") {
+			if tree == "" {
+				break
+			}
+			entries, err := readTree(tree)
+			if err != nil {
+				return "", err
+			}
+			tree = ""
+			for _, e := range entries {
+				if e.Name == name && e.Mode == modeTree {
+					tree = fmt.Sprintf("%x", e.Hash)
+				}
+			}
+		}
+	}
+	return tree, nil
+}
+
+// rewriteCommit writes the new version of commit, or prunes it when the
+// filters left it without changes of its own. Commits that were empty to
+// begin with are kept.
+func (r *historyRewriter) rewriteCommit(commit *Commit) error {
+	tree, err := r.rootTree(commit.Tree)
+	if err != nil {
+		return err
+	}
+	parents := make([]string, 0, len(commit.Parents))
+	for _, p := range commit.Parents {
+		parent := r.commits[p]
+		duplicate := parent == ""
+		for _, seen := range parents {
+			duplicate = duplicate || seen == parent
+		}
+		if !duplicate {
+			parents = append(parents, parent)
+		}
+	}
+
+	if len(parents) <= 1 {
+		parentTree := ""
+		if len(parents) == 1 {
+			parentTree = r.newTrees[parents[0]]
+		}
+		wasEmpty := false
+		switch len(commit.Parents) {
+		case 0:
+			entries, err := readTree(commit.Tree)
+			if err != nil {
+				return err
+			}
+			wasEmpty = len(entries) == 0
+		case 1:
+			oldParentTree, err := commitTreeHash(commit.Parents[0])
+			if err != nil {
+				return err
+			}
+			wasEmpty = oldParentTree == commit.Tree
+		}
+		if tree == parentTree && !wasEmpty {
+			r.commits[commit.Hash] = ""
+			if len(parents) == 1 {
+				r.commits[commit.Hash] = parents[0]
+			}
+			return nil
+		}
+	}
+
+	hash, newTree := commit.Hash, tree
+	if newTree == "" {
+		if newTree, err = writeTreeEntries(nil); err != nil {
+			return err
+		}
+	}
+	if newTree != commit.Tree || strings.Join(parents, " ") != strings.Join(commit.Parents, " ") {
+		if hash, err = writeRewrittenCommit(commit, newTree, parents, nil); err != nil {
+			return err
+		}
+	}
+	r.commits[commit.Hash] = hash
+	r.newTrees[hash] = tree
+	return nil
+}
+
+// writeRewrittenCommit copies a commit with a new tree and parents, and a
+// new committer unless it is nil. Other headers are kept, except signatures
+// which no longer match.
+func writeRewrittenCommit(commit *Commit, tree string, parents []string, committer *Identity) (string, error) {
+	object, err := parseObject(commit.Hash)
+	if err != nil {
+		return "", err
+	}
+	header, message, hasMessage := strings.Cut(string(object.Content), "\n\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	skip := false
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			key, _, _ := strings.Cut(line, " ")
+			skip = key == "tree" || key == "parent" || key == "gpgsig" || key == "gpgsig-sha256"
+		}
+		switch {
+		case skip:
+		case committer != nil && strings.HasPrefix(line, "committer "):
+			fmt.Fprintf(&b, "committer %s\n", *committer)
+		default:
+			b.WriteString(line + "\n")
+		}
+	}
+	if hasMessage {
+		b.WriteString("\n" + message)
+	}
+	return writeObject(TypeCommit, []byte(b.String()))
+}
+
+// rewriteRef returns the new value of a ref: the rewritten commit, or a copy
+// of an annotated tag pointing at it. "" means the ref has nothing left to
+// point at.
+func (r *historyRewriter) rewriteRef(hash string) (string, error) {
+	object, err := parseObject(hash)
+	if err != nil {
+		return "", err
+	}
+	switch object.Type {
+	case TypeCommit:
+		return r.commits[hash], nil
+	case TypeTag:
+		firstLine, rest, _ := strings.Cut(string(object.Content), "\n")
+		target, ok := strings.CutPrefix(firstLine, "object ")
+		if !ok {
+			return "", fmt.Errorf("tag %s is corrupt", hash)
+		}
+		newTarget, err := r.rewriteRef(target)
+		switch {
+		case err != nil || newTarget == "":
+			return "", err
+		case newTarget == target:
+			return hash, nil
+		}
+		return writeObject(TypeTag, []byte("object "+newTarget+"\n"+rest))
+	}
+	return hash, nil
+}
+
+func newHistoryRewriter(opts rewriteOptions) *historyRewriter {
+	return &historyRewriter{
+		opts:      opts,
+		trees:     map[string]string{},
+		blobSizes: map[string]int{},
+		commits:   map[string]string{},
+		newTrees:  map[string]string{},
+	}
+}
+
+// rewriteCommits rewrites the commits reachable from tips but not from
+// exclude, returning them in the order rewritten.
+func (r *historyRewriter) rewriteCommits(tips []string, exclude []string) ([]string, error) {
+	walk, err := newRevWalk(tips, exclude)
+	if err != nil {
+		return nil, err
+	}
+	walk.Sort = sortTopo
+	all := make([]*Commit, 0)
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
+			break
+		}
+		all = append(all, commit)
+	}
+
+	order := make([]string, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		if err := checkInterrupted(); err != nil {
+			return nil, err
+		}
+		if err := r.rewriteCommit(all[i]); err != nil {
+			return nil, err
+		}
+		order = append(order, all[i].Hash)
+	}
+	return order, nil
+}
+
+// historyRefs returns all refs and a detached HEAD, with the commits they
+// point to as the tips of the history.
+func historyRefs() ([]Ref, []string, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, nil, err
+	}
+	head, headTarget, err := resolveRef("HEAD")
+	if err != nil && err != errRefNotFound {
+		return nil, nil, err
+	}
+	if err == nil && headTarget == "HEAD" {
+		refs = append(refs, Ref{Name: "HEAD", Hash: head})
+	}
+	tips := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if commit, err := peelToCommit(ref.Hash); err == nil {
+			tips = append(tips, commit)
+		}
+	}
+	return refs, tips, nil
+}
+
+// rewriteHistory rewrites every commit reachable from the refs and a
+// detached HEAD, then moves the refs to the new commits in one transaction.
+// It returns the old commits in the order rewritten with the commit map.
+func rewriteHistory(opts rewriteOptions) ([]string, map[string]string, error) {
+	refs, tips, err := historyRefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	r := newHistoryRewriter(opts)
+	order, err := r.rewriteCommits(tips, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := beginRefTransaction()
+	for _, ref := range refs {
+		value, err := r.rewriteRef(ref.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case value == ref.Hash:
+			continue
+		case value == "":
+			err = t.delete(ref.Name, true, ref.Hash)
+		default:
+			err = t.update(ref.Name, value, true, ref.Hash, "rewrite-history")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := t.commit(); err != nil {
+		return nil, nil, err
+	}
+	return order, r.commits, nil
+}
+
+// runRewriteHistory rewrites all history with the filters, printing each
+// old commit with its new one. With --dry-run the objects and refs are only
+// written in memory, and the ref updates that would be made are printed in
+// the format of update-ref --stdin instead.
+func runRewriteHistory(args []string) error {
+	var opts rewriteOptions
+	dryRun := false
+	paths := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--path", "--subdirectory-filter", "--strip-blobs-bigger-than":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("option %s requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+		case "--invert-paths":
+			opts.InvertPaths = true
+			continue
+		case "-n", "--dry-run":
+			dryRun = true
+			continue
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+		switch name {
+		case "--path":
+			paths = append(paths, value)
+		case "--subdirectory-filter":
+			opts.Subdirectory = strings.Trim(value, "/")
+		case "--strip-blobs-bigger-than":
+			size, err := parseConfigInt(value)
+			if err != nil || size <= 0 {
+				return fmt.Errorf("invalid size %s", value)
+			}
+			opts.MaxBlobSize = size
+		}
+	}
+	if opts.InvertPaths && len(paths) == 0 {
+		return fmt.Errorf("--invert-paths requires --path")
+	}
+	var err error
+	if opts.Paths, err = parsePathspecs(paths); err != nil {
+		return err
+	}
+	if len(paths) == 0 && opts.Subdirectory == "" && opts.MaxBlobSize == 0 {
+		return fmt.Errorf("nothing to do: give --path, --subdirectory-filter or --strip-blobs-bigger-than")
+	}
+	if dryRun {
+		return dryRunRewriteHistory(opts)
+	}
+
+	// the worktree is checked out again from the new HEAD, which is only
+	// safe when it has no changes of its own
+	status, err := collectStatus(untrackedNo)
+	if err != nil {
+		return err
+	}
+	if len(status.Entries) > 0 {
+		return fmt.Errorf("cannot rewrite history: you have local changes; commit them first")
+	}
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+
+	order, commits, err := rewriteHistory(opts)
+	if err != nil {
+		return err
+	}
+	head, err := resolveHead()
+	if err != nil {
+		return err
+	}
+	if head != status.Head {
+		tree, err := commitTreeHash(head)
+		if err != nil {
+			return err
+		}
+		newIndex := &Index{Version: index.Version, Entries: append([]IndexEntry(nil), index.Entries...)}
+		if err := resetIndex(newIndex, tree); err != nil {
+			return err
+		}
+		if err := resetWorktree(index, newIndex); err != nil {
+			return err
+		}
+		if err := writeIndex(newIndex, lock); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, old := range order {
+		value := commits[old]
+		if value == "" {
+			value = zeroHash
+		}
+		fmt.Fprintf(w, "%s %s\n", old, value)
+	}
+	return nil
+}
+
+// dryRunRewriteHistory rewrites history in memory and prints the ref
+// updates it would make, leaving the repository as it is.
+func dryRunRewriteHistory(opts rewriteOptions) error {
+	before, _, err := historyRefs()
+	if err != nil {
+		return err
+	}
+	useMemoryRepository()
+	if _, _, err := rewriteHistory(opts); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, ref := range before {
+		value, _, err := resolveRef(ref.Name)
+		switch {
+		case err == errRefNotFound:
+			fmt.Fprintf(w, "delete %s %s\n", ref.Name, ref.Hash)
+		case err != nil:
+			return err
+		case value != ref.Hash:
+			fmt.Fprintf(w, "update %s %s %s\n", ref.Name, value, ref.Hash)
+		}
+	}
+	return nil
+}
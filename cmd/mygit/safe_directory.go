@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// currentUID is the user a repository has to belong to: the effective user,
+// or the one sudo was run by when running as root under sudo.
+func currentUID() int {
+	uid := os.Geteuid()
+	if uid == 0 {
+		if sudo, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+			return sudo
+		}
+	}
+	return uid
+}
+
+// ownerOf returns the user owning path.
+func ownerOf(path string) (int, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("cannot tell who owns %s", path)
+	}
+	if os.Getenv("GIT_TEST_ASSUME_DIFFERENT_OWNER") == "1" {
+		return int(st.Uid) + 1, nil
+	}
+	return int(st.Uid), nil
+}
+
+// safeDirectoryListed reports whether safe.directory allows dir. Only the
+// system and global config are read, as the repository's own config is
+// what cannot be trusted. "*" allows every directory, a value ending in
+// "/*" the directories below it, and an empty value resets the list.
+func safeDirectoryListed(dir string) (bool, error) {
+	safe := false
+	for _, scope := range []configScope{scopeSystem, scopeGlobal} {
+		for _, path := range configFiles(scope) {
+			entries, err := readConfigWithIncludes(path, 0)
+			if err != nil {
+				return false, err
+			}
+			for _, e := range entries {
+				if e.Section != "safe" || e.Subsection != "" || e.Key != "directory" {
+					continue
+				}
+				value := expandHomePath(e.Value)
+				switch {
+				case e.NoValue || value == "":
+					safe = false
+				case value == "*":
+					safe = true
+				case strings.HasSuffix(value, "/*"):
+					if strings.HasPrefix(dir+"/", strings.TrimSuffix(value, "*")) {
+						safe = true
+					}
+				case filepath.Clean(value) == dir:
+					safe = true
+				}
+			}
+		}
+	}
+	return safe, nil
+}
+
+// checkOwnership refuses a discovered repository whose worktree, .git file
+// or repository directory belongs to another user, unless safe.directory
+// lists it: its config could make mygit run any command it names, such as
+// a hook or a filter, as the current user.
+func checkOwnership(worktree string, gitFile string) error {
+	repository, err := filepath.Abs(gitDir)
+	if err != nil {
+		return err
+	}
+	uid := currentUID()
+	var foreign string
+	for _, path := range []string{worktree, gitFile, repository} {
+		if path == "" {
+			continue
+		}
+		owner, err := ownerOf(path)
+		if err != nil {
+			return err
+		}
+		if owner != uid {
+			foreign = fmt.Sprintf("'%s' is owned by:\n\t%d\nbut the current user is:\n\t%d\n", path, owner, uid)
+			break
+		}
+	}
+	if foreign == "" {
+		return nil
+	}
+
+	dir := worktree
+	if dir == "" {
+		dir = repository
+	}
+	if safe, err := safeDirectoryListed(dir); err != nil || safe {
+		return err
+	}
+	return fmt.Errorf("detected dubious ownership in repository at '%s'\n%sTo add an exception for this directory, call:\n\n\tgit config --global --add safe.directory %s", dir, foreign, dir)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type shortlogGroup struct {
+	Author   string
+	Subjects []string
+}
+
+func runShortlog(args []string) error {
+	summary, numbered, showEmail := false, false, false
+	revisions := make([]string, 0, 1)
+	for _, arg := range args {
+		switch {
+		case arg == "-s" || arg == "--summary":
+			summary = true
+		case arg == "-n" || arg == "--numbered":
+			numbered = true
+		case arg == "-e" || arg == "--email":
+			showEmail = true
+		case arg == "-sn" || arg == "-ns":
+			summary, numbered = true, true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "^"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			revisions = append(revisions, arg)
+		}
+	}
+	if len(revisions) == 0 {
+		if branch, unborn := unbornBranch(); unborn {
+			return errUnbornBranch(branch)
+		}
+		revisions = []string{"HEAD"}
+	}
+	include, exclude, err := parseRevisionArgs(revisions)
+	if err != nil {
+		return err
+	}
+	walk, err := newRevWalk(include, exclude)
+	if err != nil {
+		return err
+	}
+	mm, err := readMailmap()
+	if err != nil {
+		return err
+	}
+
+	groups := map[string]*shortlogGroup{}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return err
+		}
+		if commit == nil {
+			break
+		}
+		name, email := mm.lookup(commit.Author.Name, commit.Author.Email)
+		author := name
+		if showEmail {
+			author = fmt.Sprintf("%s <%s>", name, email)
+		}
+		group, ok := groups[author]
+		if !ok {
+			group = &shortlogGroup{Author: author}
+			groups[author] = group
+		}
+		group.Subjects = append(group.Subjects, commit.Subject())
+	}
+
+	sorted := make([]*shortlogGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if numbered && len(sorted[i].Subjects) != len(sorted[j].Subjects) {
+			return len(sorted[i].Subjects) > len(sorted[j].Subjects)
+		}
+		return sorted[i].Author < sorted[j].Author
+	})
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, g := range sorted {
+		if summary {
+			fmt.Fprintf(w, "%6d\t%s\n", len(g.Subjects), g.Author)
+			continue
+		}
+		fmt.Fprintf(w, "%s (%d):\n", g.Author, len(g.Subjects))
+		// subjects are listed oldest first
+		for i := len(g.Subjects) - 1; i >= 0; i-- {
+			fmt.Fprintf(w, "      %s\n", g.Subjects[i])
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
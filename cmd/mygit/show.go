@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// showTag writes the tagger and message of an annotated tag and returns the
+// object it points at.
+func showTag(w io.Writer, content []byte, dates dateMode) (string, error) {
+	header, message, _ := strings.Cut(string(content), "\n\n")
+	target, name := "", ""
+	var tagger *Identity
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "object":
+			target = value
+		case "tag":
+			name = value
+		case "tagger":
+			ident, err := parseIdentity(value)
+			if err != nil {
+				return "", err
+			}
+			tagger = &ident
+		}
+	}
+	if target == "" {
+		return "", fmt.Errorf("bad tag %s", name)
+	}
+	fmt.Fprintf(w, "tag %s\n", name)
+	if tagger != nil {
+		fmt.Fprintf(w, "Tagger: %s <%s>\n", tagger.Name, tagger.Email)
+		fmt.Fprintf(w, "Date:   %s\n", formatDate(tagger.When, dates))
+	}
+	fmt.Fprintf(w, "\n%s", message)
+	return target, nil
+}
+
+func showTree(w io.Writer, spec string, hash string) error {
+	entries, err := readTree(hash)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "tree %s\n\n", spec)
+	for _, e := range entries {
+		if e.Mode == modeTree {
+			fmt.Fprintf(w, "%s/\n", e.Name)
+		} else {
+			fmt.Fprintln(w, e.Name)
+		}
+	}
+	return nil
+}
+
+// runShow shows each object given: commits as log entries with their diff,
+// merges as a dense combined one, tags followed by what they point at, trees
+// as the names in them and blobs as they are.
+func runShow(args []string) error {
+	opts, err := parseLogArgs(args)
+	if err != nil {
+		return err
+	}
+	if !opts.Raw && !opts.NoPatch && !opts.Check {
+		opts.Patch = true
+	}
+	if !opts.MergeDiffs && !opts.FirstParent && !opts.Combined {
+		opts.Combined, opts.Dense = true, true
+	}
+	if err := setupLogOutput(opts); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	// like git, a blank line goes before each commit, tag or tree after the
+	// first of them, but not around blobs
+	shown := false
+	for _, spec := range opts.Revisions {
+		hash, err := resolveRevision(spec)
+		if err != nil {
+			return fmt.Errorf("bad revision '%s'", spec)
+		}
+		name := spec
+		for hash != "" {
+			object, err := parseObject(hash)
+			if err != nil {
+				return err
+			}
+			if object.Type != TypeBlob && shown {
+				fmt.Fprintln(w)
+			}
+			target := ""
+			switch object.Type {
+			case TypeBlob:
+				w.Write(object.Content)
+			case TypeTree:
+				err = showTree(w, name, hash)
+				shown = true
+			case TypeTag:
+				target, err = showTag(w, object.Content, opts.Dates)
+				shown = true
+			case TypeCommit:
+				var commit *Commit
+				if commit, err = readCommit(hash); err == nil {
+					err = writeLogEntry(w, opts, commit, true)
+				}
+				shown = true
+			}
+			if err != nil {
+				return err
+			}
+			hash, name = target, target
+		}
+	}
+	return checkStatus(opts, nil)
+}
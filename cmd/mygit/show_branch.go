@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const showBranchUsage = "mygit show-branch [-a | -r] [--more=<n> | --list] [--sparse] [--no-name] [<rev>...]"
+
+// maxShowBranchRevs is how many revisions fit the bit set marking the
+// branches each commit is on.
+const maxShowBranchRevs = 63
+
+// commitName names a commit after the branch it was reached from, as
+// "<head>", "<head>^" or "<head>~<generation>".
+type commitName struct {
+	head       string
+	generation int
+}
+
+func (n *commitName) String() string {
+	switch n.generation {
+	case 0:
+		return n.head
+	case 1:
+		return n.head + "^"
+	}
+	return fmt.Sprintf("%s~%d", n.head, n.generation)
+}
+
+// showBranchRefs lists the branches shown when none are given: the local
+// ones, the remote-tracking ones with remotes, or both with all.
+func showBranchRefs(all bool, remotes bool) ([]string, error) {
+	prefixes := []string{"refs/heads/"}
+	if remotes {
+		prefixes = []string{"refs/remotes/"}
+	}
+	if all {
+		prefixes = append(prefixes, "refs/remotes/")
+	}
+	names := make([]string, 0)
+	for _, prefix := range prefixes {
+		refs, err := listRefs(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if strings.HasSuffix(ref.Name, "/HEAD") {
+				continue
+			}
+			names = append(names, shortenRefName(ref.Name))
+		}
+	}
+	return names, nil
+}
+
+// joinBranches walks back from the heads newest first, marking each commit
+// with a bit per head it is reachable from, until only commits reachable
+// from all of them are left, and extra more after that. It returns the
+// commits in the order they were seen, and their marks.
+func joinBranches(heads []*Commit, extra int) ([]*Commit, map[string]uint64, error) {
+	allRevs := uint64(1)<<len(heads) - 1
+	flags := map[string]uint64{}
+	// poisoned commits are reachable from a merge point, so no longer
+	// interesting
+	poisoned := map[string]bool{}
+	var queue dateQueue
+	for i, c := range heads {
+		if flags[c.Hash] == 0 {
+			heap.Push(&queue, c)
+		}
+		flags[c.Hash] |= 1 << i
+	}
+	seen := make([]*Commit, 0)
+	isSeen := map[string]bool{}
+	markSeen := func(c *Commit) bool {
+		if isSeen[c.Hash] {
+			return false
+		}
+		isSeen[c.Hash] = true
+		seen = append(seen, c)
+		return true
+	}
+	for queue.Len() > 0 {
+		if err := checkInterrupted(); err != nil {
+			return nil, nil, err
+		}
+		interesting := false
+		for _, c := range queue.commits {
+			interesting = interesting || !poisoned[c.Hash]
+		}
+		commit := heap.Pop(&queue).(*Commit)
+		if !interesting && extra <= 0 {
+			break
+		}
+		markSeen(commit)
+		flag, poison := flags[commit.Hash], poisoned[commit.Hash]
+		if flag&allRevs == allRevs {
+			poison = true
+		}
+		for _, hash := range commit.Parents {
+			if flags[hash]&flag == flag && (!poison || poisoned[hash]) {
+				continue
+			}
+			parent, err := readCommit(hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			if markSeen(parent) && !interesting {
+				extra--
+			}
+			flags[hash] |= flag
+			poisoned[hash] = poisoned[hash] || poison
+			heap.Push(&queue, parent)
+		}
+	}
+
+	// poison everything below the merge points among the commits seen
+	for changed := true; changed; {
+		changed = false
+		for _, c := range seen {
+			if flags[c.Hash]&allRevs != allRevs && !poisoned[c.Hash] {
+				continue
+			}
+			for _, hash := range c.Parents {
+				if !poisoned[hash] {
+					poisoned[hash], changed = true, true
+				}
+			}
+		}
+	}
+	return seen, flags, nil
+}
+
+// nameCommits names the commits after the heads: along the first parent
+// chains first, then through the other parents of merges as "<name>^<n>".
+func nameCommits(commits []*Commit, heads []*Commit, headNames []string) map[string]*commitName {
+	names := map[string]*commitName{}
+	byHash := make(map[string]*Commit, len(commits))
+	for _, c := range commits {
+		byHash[c.Hash] = c
+		for i, head := range heads {
+			if head.Hash == c.Hash && names[c.Hash] == nil {
+				names[c.Hash] = &commitName{head: headNames[i]}
+			}
+		}
+	}
+	nameFirstParentChain := func(c *Commit) int {
+		named := 0
+		for c != nil && names[c.Hash] != nil && len(c.Parents) > 0 {
+			parent := c.Parents[0]
+			if names[parent] != nil {
+				break
+			}
+			names[parent] = &commitName{head: names[c.Hash].head, generation: names[c.Hash].generation + 1}
+			named++
+			c = byHash[parent]
+		}
+		return named
+	}
+	for named := 1; named > 0; {
+		named = 0
+		for _, c := range commits {
+			named += nameFirstParentChain(c)
+		}
+	}
+	for named := 1; named > 0; {
+		named = 0
+		for _, c := range commits {
+			name := names[c.Hash]
+			if name == nil {
+				continue
+			}
+			for nth, parent := range c.Parents {
+				if names[parent] != nil {
+					continue
+				}
+				head := name.String() + "^"
+				if nth > 0 {
+					head = fmt.Sprintf("%s^%d", name, nth+1)
+				}
+				names[parent] = &commitName{head: head}
+				named++
+				nameFirstParentChain(byHash[parent])
+			}
+		}
+	}
+	return names
+}
+
+// runShowBranch shows the commits on the given branches, newest first,
+// down to where they all meet: a column per branch marks the commits on it
+// with "*" for the current branch, "+" for the others and "-" for merges.
+func runShowBranch(args []string) error {
+	all, remotes, list, sparse, noName := false, false, false, false, false
+	more := ""
+	flags := newFlagSet()
+	flags.Bool(&all, "-a", "--all")
+	flags.Bool(&remotes, "-r", "--remotes")
+	flags.String(&more, "--more")
+	flags.Bool(&list, "--list")
+	flags.Bool(&sparse, "--sparse")
+	flags.Bool(&noName, "--no-name")
+	revs, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	extra := 0
+	if more != "" {
+		if extra, err = strconv.Atoi(more); err != nil {
+			return fmt.Errorf("invalid --more value '%s'", more)
+		}
+	}
+	if list {
+		extra = -1
+	}
+	if len(revs) == 0 || all || remotes {
+		refs, err := showBranchRefs(all, remotes)
+		if err != nil {
+			return err
+		}
+		revs = append(revs, refs...)
+	}
+	if len(revs) == 0 {
+		return fmt.Errorf("no revs to be shown")
+	}
+	if len(revs) > maxShowBranchRevs {
+		return fmt.Errorf("cannot handle more than %d revs", maxShowBranchRevs)
+	}
+
+	heads := make([]*Commit, len(revs))
+	for i, rev := range revs {
+		hash, err := resolveCommitRevision(rev)
+		if err != nil {
+			return err
+		}
+		if heads[i], err = readCommit(hash); err != nil {
+			return err
+		}
+	}
+	headAt := -1
+	if branch, onBranch, err := currentBranch(); err == nil && onBranch {
+		for i, rev := range revs {
+			if rev == branch || rev == shortenRefName(branch) || rev == strings.TrimPrefix(branch, "refs/") {
+				headAt = i
+				break
+			}
+		}
+	}
+
+	seen, marks, err := joinBranches(heads, extra)
+	if err != nil {
+		return err
+	}
+	commits := topoSortCommits(seen, sortTopo)
+	names := nameCommits(commits, heads, revs)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if len(heads) > 1 || extra < 0 {
+		// --list shows the heads alone, without the columns to line up with
+		for i, head := range heads {
+			indent, marker := strings.Repeat(" ", i), '!'
+			if extra < 0 {
+				indent, marker = "", ' '
+			}
+			if i == headAt {
+				marker = '*'
+			}
+			fmt.Fprintf(w, "%s%c [%s] %s\n", indent, marker, revs[i], head.Subject())
+		}
+		if extra < 0 {
+			return nil
+		}
+		fmt.Fprintln(w, strings.Repeat("-", len(heads)))
+	}
+
+	allRevs := uint64(1)<<len(heads) - 1
+	shownMergePoint := false
+	for _, c := range commits {
+		flag := marks[c.Hash]
+		shownMergePoint = shownMergePoint || flag&allRevs == allRevs
+		if len(heads) > 1 {
+			isMerge := len(c.Parents) > 1
+			if isMerge && !sparse && omitInDense(c, heads, flag) {
+				continue
+			}
+			for i := range heads {
+				switch {
+				case flag&(1<<i) == 0:
+					w.WriteByte(' ')
+				case isMerge:
+					w.WriteByte('-')
+				case i == headAt:
+					w.WriteByte('*')
+				default:
+					w.WriteByte('+')
+				}
+			}
+			w.WriteByte(' ')
+		}
+		if !noName {
+			name := abbrevHash(c.Hash)
+			if n := names[c.Hash]; n != nil {
+				name = n.String()
+			}
+			fmt.Fprintf(w, "[%s] ", name)
+		}
+		fmt.Fprintln(w, c.Subject())
+		if shownMergePoint {
+			if extra--; extra < 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// omitInDense tells whether a merge is left out of the matrix: one that is
+// not a head and is reachable from a single head adds nothing to compare.
+func omitInDense(c *Commit, heads []*Commit, flag uint64) bool {
+	for _, head := range heads {
+		if head.Hash == c.Hash {
+			return false
+		}
+	}
+	count := 0
+	for ; flag != 0; flag &= flag - 1 {
+		count++
+	}
+	return count == 1
+}
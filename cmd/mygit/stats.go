@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type branchStats struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+}
+
+type contributorStats struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+}
+
+type directoryStats struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Blobs int    `json:"blobs"`
+}
+
+// repoStats summarises a repository for health dashboards. Object types are
+// counted among the loose objects, since packed ones are only counted.
+type repoStats struct {
+	Branches         []branchStats      `json:"branches"`
+	Contributors     int                `json:"contributors"`
+	TopContributors  []contributorStats `json:"top_contributors"`
+	Objects          map[Type]int       `json:"objects"`
+	Loose            int                `json:"loose"`
+	Packed           int                `json:"packed"`
+	Packs            int                `json:"packs"`
+	AverageTreeDepth float64            `json:"average_tree_depth"`
+	MaxTreeDepth     int                `json:"max_tree_depth"`
+	Directories      []directoryStats   `json:"largest_directories"`
+}
+
+// countPackedObjects counts the packs and the objects their indexes list.
+func countPackedObjects() (packs int, packed int, err error) {
+	indexes, err := filepath.Glob(filepath.Join(getObjectsDir(), "pack", "pack-*.idx"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, path := range indexes {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to read %s: %s", path, err.Error())
+		}
+		_, entries, err := parsePackIndex(data)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		packs++
+		packed += len(entries)
+	}
+	return packs, packed, nil
+}
+
+// countCommits counts the commits reachable from tip.
+func countCommits(tip string) (int, error) {
+	walk, err := newRevWalk([]string{tip}, nil)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return 0, err
+		}
+		if commit == nil {
+			return count, nil
+		}
+		count++
+	}
+}
+
+// collectStats walks the branches, the whole history for the contributors
+// and directory sizes, the stored objects and the tree at HEAD.
+func collectStats(top int) (*repoStats, error) {
+	stats := &repoStats{Objects: map[Type]int{}}
+	branches, err := listRefs("refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range branches {
+		commits, err := countCommits(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		stats.Branches = append(stats.Branches, branchStats{Name: shortenRefName(ref.Name), Commits: commits})
+	}
+
+	mm, err := readMailmap()
+	if err != nil {
+		return nil, err
+	}
+	_, tips, err := historyRefs()
+	if err != nil {
+		return nil, err
+	}
+	walk, err := newRevWalk(tips, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &sizeReport{seen: map[string]bool{}, files: map[string]*pathSize{}, dirs: map[string]*pathSize{}}
+	authors := map[string]int{}
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
+			break
+		}
+		name, email := mm.lookup(commit.Author.Name, commit.Author.Email)
+		authors[fmt.Sprintf("%s <%s>", name, email)]++
+		if err := r.addTree(commit.Tree, ""); err != nil {
+			return nil, err
+		}
+	}
+	stats.Contributors = len(authors)
+	for name, commits := range authors {
+		stats.TopContributors = append(stats.TopContributors, contributorStats{Name: name, Commits: commits})
+	}
+	sort.Slice(stats.TopContributors, func(i, j int) bool {
+		a, b := stats.TopContributors[i], stats.TopContributors[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		return a.Name < b.Name
+	})
+	stats.TopContributors = stats.TopContributors[:min(top, len(stats.TopContributors))]
+	for _, dir := range largest(r.dirs, top) {
+		stats.Directories = append(stats.Directories, directoryStats{Path: dir.Path, Size: dir.Size, Blobs: dir.Blobs})
+	}
+
+	err = objects.Iterate("", func(hash string) error {
+		or, err := openObject(hash)
+		if err != nil {
+			return err
+		}
+		or.Close()
+		stats.Objects[or.Type]++
+		stats.Loose++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Packs, stats.Packed, err = countPackedObjects(); err != nil {
+		return nil, err
+	}
+
+	if head, err := resolveHead(); err == nil && head != "" {
+		tree, err := commitTreeHash(head)
+		if err != nil {
+			return nil, err
+		}
+		files, err := flattenTree(tree, "")
+		if err != nil {
+			return nil, err
+		}
+		total := 0
+		for _, f := range files {
+			depth := strings.Count(f.Path, "/") + 1
+			total += depth
+			stats.MaxTreeDepth = max(stats.MaxTreeDepth, depth)
+		}
+		if len(files) > 0 {
+			stats.AverageTreeDepth = float64(total) / float64(len(files))
+		}
+	}
+	return stats, nil
+}
+
+func runStats(args []string) error {
+	top := "10"
+	f := newFlagSet()
+	f.String(&top, "--top", "-n")
+	if positional, err := f.Parse(args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return fmt.Errorf("unexpected argument %s", positional[0])
+	}
+	n, err := strconv.Atoi(top)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid --top value %s", top)
+	}
+	stats, err := collectStats(n)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if jsonOutput {
+		return writeJSONLine(w, stats)
+	}
+	fmt.Fprintf(w, "Branches:\n")
+	width := 0
+	for _, b := range stats.Branches {
+		width = max(width, len(b.Name))
+	}
+	for _, b := range stats.Branches {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, b.Name, plural(b.Commits, "commit", "commits"))
+	}
+
+	fmt.Fprintf(w, "\nContributors: %d\n", stats.Contributors)
+	for _, c := range stats.TopContributors {
+		fmt.Fprintf(w, "%6d  %s\n", c.Commits, c.Name)
+	}
+
+	counts := make([]string, 0, 4)
+	for _, t := range []Type{TypeCommit, TypeTree, TypeBlob, TypeTag} {
+		counts = append(counts, plural(stats.Objects[t], string(t), string(t)+"s"))
+	}
+	fmt.Fprintf(w, "\nLoose objects: %s\n", strings.Join(counts, ", "))
+	share := 0
+	if total := stats.Loose + stats.Packed; total > 0 {
+		share = stats.Packed * 100 / total
+	}
+	fmt.Fprintf(w, "Storage: %d loose, %d packed in %s (%d%% packed)\n", stats.Loose, stats.Packed, plural(stats.Packs, "pack", "packs"), share)
+	fmt.Fprintf(w, "Tree depth at HEAD: %.2f average, %d deepest\n", stats.AverageTreeDepth, stats.MaxTreeDepth)
+
+	fmt.Fprintf(w, "\nLargest directories:\n")
+	for _, d := range stats.Directories {
+		fmt.Fprintf(w, "%12s  %-12s  %s\n", humaniseBytes(d.Size), plural(d.Blobs, "blob", "blobs"), quotePath(d.Path, false))
+	}
+	return nil
+}
@@ -0,0 +1,976 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type statusFormat int
+
+const (
+	statusLong statusFormat = iota
+	statusShort
+	statusPorcelainV2
+)
+
+// untrackedMode is how status lists untracked files: not at all, with the
+// directories holding no tracked files collapsed to "dir/", or one by one.
+type untrackedMode int
+
+const (
+	untrackedNo untrackedMode = iota
+	untrackedNormal
+	untrackedAll
+)
+
+func parseUntrackedMode(value string) (untrackedMode, error) {
+	switch value {
+	case "no":
+		return untrackedNo, nil
+	case "normal":
+		return untrackedNormal, nil
+	case "all":
+		return untrackedAll, nil
+	}
+	return 0, fmt.Errorf("invalid untracked files mode '%s'", value)
+}
+
+// loadUntrackedMode reads status.showUntrackedFiles, normal when unset.
+func loadUntrackedMode() (untrackedMode, error) {
+	config, err := getConfig()
+	if err != nil {
+		return 0, err
+	}
+	if value, ok := config.Get("status.showuntrackedfiles"); ok {
+		return parseUntrackedMode(value)
+	}
+	return untrackedNormal, nil
+}
+
+// statusEntry is a tracked path that differs between HEAD, the index and the
+// worktree. Index and Worktree hold the X and Y letters of the short format,
+// ' ' when unchanged.
+type statusEntry struct {
+	Path         string
+	OrigPath     string
+	Index        byte
+	Worktree     byte
+	Score        int
+	HeadMode     int
+	IndexMode    int
+	WorktreeMode int
+	HeadHash     string
+	IndexHash    string
+	// Stages holds the conflicting index entries of an unmerged path
+	Stages [3]*IndexEntry
+}
+
+// newCommits reports whether the entry is a submodule with another commit
+// checked out than the index records.
+func (e statusEntry) newCommits() bool {
+	return e.Worktree == 'M' && e.IndexMode == modeGitlink && e.WorktreeMode == modeGitlink
+}
+
+type repoStatus struct {
+	Entries       []statusEntry
+	Untracked     []string
+	UntrackedMode untrackedMode
+	Head          string
+	Branch        string
+	OnBranch      bool
+	// tracked is keyed by foldPath, with the directories holding tracked
+	// files as "dir/"
+	tracked map[string]bool
+	// Relative is set when paths are shown relative to the directory the
+	// command was started in, which formats meant for scripts do not do
+	Relative bool
+}
+
+func (s *repoStatus) displayPath(path string) string {
+	if !s.Relative {
+		return path
+	}
+	return relativePath(path)
+}
+
+// limitTo drops the entries and untracked files outside ps. A rename is kept
+// when either of its paths is selected, and a collapsed directory that is
+// not is opened up for what ps selects below it.
+func (s *repoStatus) limitTo(ps pathspec) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	entries := s.Entries[:0]
+	for _, e := range s.Entries {
+		if ps.matches(e.Path) || e.OrigPath != "" && ps.matches(e.OrigPath) {
+			entries = append(entries, e)
+		}
+	}
+	s.Entries = entries
+	untracked, err := s.untrackedMatching(ps, s.Untracked)
+	s.Untracked = untracked
+	return err
+}
+
+func (s *repoStatus) untrackedMatching(ps pathspec, paths []string) ([]string, error) {
+	var selected []string
+	for _, path := range paths {
+		if ps.matches(path) {
+			selected = append(selected, path)
+			continue
+		}
+		dir, collapsed := strings.CutSuffix(path, "/")
+		if !collapsed {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			continue
+		}
+		below, err := untrackedFiles(s.tracked, dir, untrackedNormal)
+		if err != nil {
+			return nil, err
+		}
+		if below, err = s.untrackedMatching(ps, below); err != nil {
+			return nil, err
+		}
+		selected = append(selected, below...)
+	}
+	return selected, nil
+}
+
+// unmergedStatus gives the XY of a conflict from the stages present.
+var unmergedStatus = map[int]string{
+	1: "DD",
+	2: "AU",
+	3: "UD",
+	4: "UA",
+	5: "DU",
+	6: "AA",
+	7: "UU",
+}
+
+func changeLetter(oldMode int, oldHash string, newMode int, newHash string) byte {
+	switch {
+	case oldMode == newMode && oldHash == newHash:
+		return ' '
+	case oldMode == modeSymlink || newMode == modeSymlink || oldMode == modeGitlink || newMode == modeGitlink:
+		if oldMode != newMode {
+			return 'T'
+		}
+	}
+	return 'M'
+}
+
+// worktreeStatus compares an index entry with the worktree, returning the Y
+// letter and the worktree mode (0 when the file is gone).
+func worktreeStatus(index *Index, entry *IndexEntry) (byte, int, error) {
+	if entry.assumeUnchanged() || entry.skipWorktree() {
+		return ' ', entry.Mode, nil
+	}
+	info, err := os.Lstat(entry.Path)
+	if os.IsNotExist(err) {
+		return 'D', 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	worktreeMode := worktreeEntryMode(info, entry.Mode)
+	if entry.Mode == modeGitlink && worktreeMode == modeGitlink {
+		// a submodule is modified when another commit is checked out in
+		// it; one that is not populated is left alone
+		head, _, err := submoduleHead(entry.Path)
+		if err != nil {
+			return 0, 0, err
+		}
+		if head != "" && head != entry.Hash {
+			return 'M', worktreeMode, nil
+		}
+		return ' ', worktreeMode, nil
+	}
+	clean, err := worktreeMatchesIndex(index, entry)
+	if err != nil {
+		return 0, 0, err
+	}
+	if clean {
+		return ' ', worktreeMode, nil
+	}
+	if worktreeMode != entry.Mode && (worktreeMode == modeSymlink || entry.Mode == modeSymlink || worktreeMode == modeGitlink || entry.Mode == modeGitlink) {
+		return 'T', worktreeMode, nil
+	}
+	return 'M', worktreeMode, nil
+}
+
+// untrackedFiles lists worktree files that are neither in the index nor
+// ignored, with nested repositories shown as "dir/". tracked is keyed by
+// foldPath, and has the directories holding tracked files as "dir/": in
+// mode untrackedNormal the others are shown as "dir/" when anything in them
+// is untracked, without listing their files.
+func untrackedFiles(tracked map[string]bool, dir string, mode untrackedMode) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %s", dir, err.Error())
+	}
+	files := make([]string, 0)
+	for _, e := range entries {
+		if err := checkInterrupted(); err != nil {
+			return nil, err
+		}
+		if e.Name() == ".git" {
+			continue
+		}
+		path := e.Name()
+		if dir != "." {
+			path = dir + "/" + e.Name()
+		}
+		name := worktreeName(path)
+		if tracked[foldPath(name)] {
+			continue
+		}
+		if ignored, err := isIgnored(name, e.IsDir()); err != nil {
+			return nil, err
+		} else if ignored {
+			continue
+		}
+		if !e.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			files = append(files, name+"/")
+			continue
+		}
+		if mode == untrackedNormal && !tracked[foldPath(name)+"/"] {
+			if found, err := hasUntrackedFiles(path); err != nil {
+				return nil, err
+			} else if found {
+				files = append(files, name+"/")
+			}
+			continue
+		}
+		sub, err := untrackedFiles(tracked, path, mode)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sub...)
+	}
+	return files, nil
+}
+
+// hasUntrackedFiles reports whether a directory without tracked files
+// holds anything not ignored, stopping at the first such file.
+func hasUntrackedFiles(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read directory %s: %s", dir, err.Error())
+	}
+	for _, e := range entries {
+		if err := checkInterrupted(); err != nil {
+			return false, err
+		}
+		path := dir + "/" + e.Name()
+		if ignored, err := isIgnored(worktreeName(path), e.IsDir()); err != nil {
+			return false, err
+		} else if ignored {
+			continue
+		}
+		if !e.IsDir() {
+			return true, nil
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			return true, nil
+		}
+		if found, err := hasUntrackedFiles(path); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// detectRenames pairs paths deleted from the index with added paths of the
+// same content, like git's exact rename detection.
+func detectRenames(entries []statusEntry) []statusEntry {
+	deleted := map[string][]int{}
+	for i, e := range entries {
+		if e.Index == 'D' && e.Stages == [3]*IndexEntry{} {
+			deleted[e.HeadHash] = append(deleted[e.HeadHash], i)
+		}
+	}
+	if len(deleted) == 0 {
+		return entries
+	}
+	renamed := map[int]bool{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Index != 'A' || len(deleted[e.IndexHash]) == 0 {
+			continue
+		}
+		from := deleted[e.IndexHash][0]
+		deleted[e.IndexHash] = deleted[e.IndexHash][1:]
+		renamed[from] = true
+		e.Index, e.Score = 'R', 100
+		e.OrigPath = entries[from].Path
+		e.HeadMode, e.HeadHash = entries[from].HeadMode, entries[from].HeadHash
+	}
+	kept := make([]statusEntry, 0, len(entries)-len(renamed))
+	for i, e := range entries {
+		if !renamed[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func collectStatus(untracked untrackedMode) (*repoStatus, error) {
+	status := &repoStatus{UntrackedMode: untracked}
+	var err error
+	if status.Branch, status.OnBranch, err = currentBranch(); err != nil {
+		return nil, err
+	}
+	if status.Head, err = resolveHead(); err != nil {
+		return nil, err
+	}
+	headTree, err := commitTreeHash(status.Head)
+	if err != nil {
+		return nil, err
+	}
+	headFiles, err := flattenTree(headTree, "")
+	if err != nil {
+		return nil, err
+	}
+	head := make(map[string]treeFile, len(headFiles))
+	for _, f := range headFiles {
+		head[f.Path] = f
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool, len(index.Entries))
+	entries := make([]statusEntry, 0)
+	for i := 0; i < len(index.Entries); i++ {
+		e := &index.Entries[i]
+		tracked[e.Path] = true
+		h, inHead := head[e.Path]
+		entry := statusEntry{Path: e.Path, HeadMode: h.Mode, HeadHash: h.Hash}
+		if !inHead {
+			entry.HeadHash = zeroHash
+		}
+
+		if e.Stage() != 0 {
+			mask := 0
+			for ; i < len(index.Entries) && index.Entries[i].Path == e.Path; i++ {
+				stage := index.Entries[i].Stage()
+				if stage > 0 {
+					entry.Stages[stage-1] = &index.Entries[i]
+					mask |= 1 << (stage - 1)
+				}
+			}
+			i--
+			xy := unmergedStatus[mask]
+			entry.Index, entry.Worktree = xy[0], xy[1]
+			if info, err := os.Lstat(e.Path); err == nil {
+				entry.WorktreeMode = worktreeFileMode(info)
+			}
+			entries = append(entries, entry)
+			continue
+		}
+
+		entry.IndexMode, entry.IndexHash = e.Mode, e.Hash
+		if inHead {
+			entry.Index = changeLetter(h.Mode, h.Hash, e.Mode, e.Hash)
+		} else {
+			entry.Index = 'A'
+		}
+		if entry.Worktree, entry.WorktreeMode, err = worktreeStatus(index, e); err != nil {
+			return nil, err
+		}
+		if entry.Index != ' ' || entry.Worktree != ' ' {
+			entries = append(entries, entry)
+		}
+	}
+	for _, f := range headFiles {
+		if !tracked[f.Path] {
+			entries = append(entries, statusEntry{Path: f.Path, Index: 'D', Worktree: ' ', HeadMode: f.Mode, HeadHash: f.Hash, IndexHash: zeroHash})
+		}
+	}
+	writeRefreshedIndex(index)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	status.Entries = detectRenames(entries)
+
+	if untracked != untrackedNo {
+		status.tracked = make(map[string]bool, 2*len(tracked))
+		for path := range tracked {
+			path = foldPath(path)
+			status.tracked[path] = true
+			for dir := path; strings.Contains(dir, "/"); {
+				dir = dir[:strings.LastIndex(dir, "/")]
+				if status.tracked[dir+"/"] {
+					break
+				}
+				status.tracked[dir+"/"] = true
+			}
+		}
+		if status.Untracked, err = untrackedFiles(status.tracked, ".", untracked); err != nil {
+			return nil, err
+		}
+		sort.Strings(status.Untracked)
+	}
+	return status, nil
+}
+
+// upstreamStatus returns the short upstream name and the ahead/behind counts
+// of the current branch; found is false when the upstream ref is gone.
+func (s *repoStatus) upstreamStatus() (name string, ahead int, behind int, found bool, err error) {
+	if !s.OnBranch {
+		return "", 0, 0, false, nil
+	}
+	upstream, err := upstreamOf(s.Branch)
+	if err != nil {
+		return "", 0, 0, false, nil
+	}
+	name = shortenRefName(upstream)
+	upstreamHash, _, err := resolveRef(upstream)
+	if err == errRefNotFound || s.Head == "" {
+		return name, 0, 0, false, nil
+	}
+	if err != nil {
+		return "", 0, 0, false, err
+	}
+	ahead, behind, err = countAheadBehind(s.Head, upstreamHash)
+	return name, ahead, behind, err == nil, err
+}
+
+func writeShortBranchHeader(w io.Writer, s *repoStatus, colors colorPalette) error {
+	fmt.Fprint(w, colors.wrap("header", "## "))
+	switch {
+	case !s.OnBranch:
+		fmt.Fprint(w, colors.wrap("nobranch", "HEAD (no branch)"))
+	case s.Head == "":
+		fmt.Fprint(w, colors.wrap("header", "No commits yet on ")+colors.wrap("localbranch", shortenRefName(s.Branch)))
+	default:
+		fmt.Fprint(w, colors.wrap("localbranch", shortenRefName(s.Branch)))
+		upstream, aheadCount, behindCount, found, err := s.upstreamStatus()
+		if err != nil {
+			return err
+		}
+		if upstream != "" {
+			fmt.Fprint(w, colors.wrap("header", "...")+colors.wrap("remotebranch", upstream))
+		}
+		ahead, behind := colors.wrap("localbranch", strconv.Itoa(aheadCount)), colors.wrap("remotebranch", strconv.Itoa(behindCount))
+		switch {
+		case upstream != "" && !found:
+			fmt.Fprint(w, colors.wrap("header", " [")+colors.wrap("header", "gone")+colors.wrap("header", "]"))
+		case aheadCount > 0 && behindCount > 0:
+			fmt.Fprint(w, colors.wrap("header", " [")+colors.wrap("header", "ahead ")+ahead+colors.wrap("header", ", behind ")+behind+colors.wrap("header", "]"))
+		case aheadCount > 0:
+			fmt.Fprint(w, colors.wrap("header", " [")+colors.wrap("header", "ahead ")+ahead+colors.wrap("header", "]"))
+		case behindCount > 0:
+			fmt.Fprint(w, colors.wrap("header", " [")+colors.wrap("header", "behind ")+behind+colors.wrap("header", "]"))
+		}
+	}
+	return nil
+}
+
+// writeShortStatus writes the short format, which is also porcelain v1 when
+// colors is nil.
+func writeShortStatus(w io.Writer, s *repoStatus, branch bool, eol byte, colors colorPalette) error {
+	if branch {
+		if err := writeShortBranchHeader(w, s, colors); err != nil {
+			return err
+		}
+		w.Write([]byte{eol})
+	}
+	// -z output is for scripts and is never quoted
+	quote := func(path string) string {
+		if eol == 0 {
+			return path
+		}
+		return quotePath(s.displayPath(path), true)
+	}
+	status := func(slot string, c byte) string {
+		if c == ' ' {
+			return " "
+		}
+		return colors.wrap(slot, string(c))
+	}
+	for _, e := range s.Entries {
+		if e.Stages != [3]*IndexEntry{} {
+			fmt.Fprintf(w, "%s ", colors.wrap("unmerged", string([]byte{e.Index, e.Worktree})))
+		} else {
+			fmt.Fprintf(w, "%s%s ", status("added", e.Index), status("changed", e.Worktree))
+		}
+		switch {
+		case e.OrigPath != "" && eol == 0:
+			fmt.Fprintf(w, "%s\x00%s", e.Path, e.OrigPath)
+		case e.OrigPath != "":
+			fmt.Fprintf(w, "%s -> %s", quote(e.OrigPath), quote(e.Path))
+		default:
+			fmt.Fprint(w, quote(e.Path))
+		}
+		w.Write([]byte{eol})
+	}
+	for _, path := range s.Untracked {
+		fmt.Fprintf(w, "%s %s", colors.wrap("untracked", "??"), quote(path))
+		w.Write([]byte{eol})
+	}
+	return nil
+}
+
+type jsonBranchStatus struct {
+	Branch   string `json:"branch,omitempty"`
+	Detached bool   `json:"detached,omitempty"`
+	Oid      string `json:"oid,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Gone     bool   `json:"gone,omitempty"`
+	Ahead    int    `json:"ahead,omitempty"`
+	Behind   int    `json:"behind,omitempty"`
+}
+
+type jsonStatusEntry struct {
+	Index    string `json:"index"`
+	Worktree string `json:"worktree"`
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"`
+}
+
+// writeJSONStatus is the short format with --json, untracked files having
+// "?" on both sides. With -b the branch comes first.
+func writeJSONStatus(w io.Writer, s *repoStatus, branch bool) error {
+	if branch {
+		header := jsonBranchStatus{Detached: !s.OnBranch, Oid: s.Head}
+		if s.OnBranch {
+			header.Branch = shortenRefName(s.Branch)
+			upstream, ahead, behind, found, err := s.upstreamStatus()
+			if err != nil {
+				return err
+			}
+			header.Upstream, header.Gone, header.Ahead, header.Behind = upstream, upstream != "" && !found, ahead, behind
+		}
+		if err := writeJSONLine(w, header); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.Entries {
+		if err := writeJSONLine(w, jsonStatusEntry{Index: string(e.Index), Worktree: string(e.Worktree), Path: e.Path, OrigPath: e.OrigPath}); err != nil {
+			return err
+		}
+	}
+	for _, path := range s.Untracked {
+		if err := writeJSONLine(w, jsonStatusEntry{Index: "?", Worktree: "?", Path: path}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func v2Letter(c byte) byte {
+	if c == ' ' {
+		return '.'
+	}
+	return c
+}
+
+func v2Submodule(modes ...int) string {
+	for _, m := range modes {
+		if m == modeGitlink {
+			return "S..."
+		}
+	}
+	return "N..."
+}
+
+func writePorcelainV2(w io.Writer, s *repoStatus, branch bool, eol byte) error {
+	if branch {
+		oid, head := s.Head, shortenRefName(s.Branch)
+		if oid == "" {
+			oid = "(initial)"
+		}
+		if !s.OnBranch {
+			head = "(detached)"
+		}
+		fmt.Fprintf(w, "# branch.oid %s%c# branch.head %s%c", oid, eol, head, eol)
+		upstream, ahead, behind, found, err := s.upstreamStatus()
+		if err != nil {
+			return err
+		}
+		if upstream != "" {
+			fmt.Fprintf(w, "# branch.upstream %s%c", upstream, eol)
+		}
+		if found {
+			fmt.Fprintf(w, "# branch.ab +%d -%d%c", ahead, behind, eol)
+		}
+	}
+	quote := func(path string) string {
+		if eol == 0 {
+			return path
+		}
+		return quotePath(s.displayPath(path), false)
+	}
+	for _, e := range s.Entries {
+		xy := []byte{v2Letter(e.Index), v2Letter(e.Worktree)}
+		switch {
+		case e.Stages != [3]*IndexEntry{}:
+			modes, hashes := [3]int{}, [3]string{zeroHash, zeroHash, zeroHash}
+			for i, stage := range e.Stages {
+				if stage != nil {
+					modes[i], hashes[i] = stage.Mode, stage.Hash
+				}
+			}
+			fmt.Fprintf(w, "u %s %s %06d %06d %06d %06d %s %s %s %s", xy, v2Submodule(modes[0], modes[1], modes[2]),
+				modes[0], modes[1], modes[2], e.WorktreeMode, hashes[0], hashes[1], hashes[2], quote(e.Path))
+		case e.OrigPath != "":
+			sep := byte('\t')
+			if eol == 0 {
+				sep = 0
+			}
+			fmt.Fprintf(w, "2 %s %s %06d %06d %06d %s %s %c%d %s%c%s", xy, v2Submodule(e.HeadMode, e.IndexMode, e.WorktreeMode),
+				e.HeadMode, e.IndexMode, e.WorktreeMode, e.HeadHash, e.IndexHash, e.Index, e.Score, quote(e.Path), sep, quote(e.OrigPath))
+		case e.newCommits():
+			fmt.Fprintf(w, "1 %s SC.. %06d %06d %06d %s %s %s", xy,
+				e.HeadMode, e.IndexMode, e.WorktreeMode, e.HeadHash, e.IndexHash, quote(e.Path))
+		default:
+			fmt.Fprintf(w, "1 %s %s %06d %06d %06d %s %s %s", xy, v2Submodule(e.HeadMode, e.IndexMode, e.WorktreeMode),
+				e.HeadMode, e.IndexMode, e.WorktreeMode, e.HeadHash, e.IndexHash, quote(e.Path))
+		}
+		w.Write([]byte{eol})
+	}
+	for _, path := range s.Untracked {
+		fmt.Fprintf(w, "? %s%c", quote(path), eol)
+	}
+	return nil
+}
+
+// statusLabelWidth fits the longest label, "typechange:", plus a space.
+const statusLabelWidth = 12
+
+var unmergedLabels = map[string]string{
+	"DD": "both deleted:",
+	"AU": "added by us:",
+	"UD": "deleted by them:",
+	"UA": "added by them:",
+	"DU": "deleted by us:",
+	"AA": "both added:",
+	"UU": "both modified:",
+}
+
+// unmergedLabelWidth fits the longest label, "deleted by them:", plus a
+// space.
+const unmergedLabelWidth = 17
+
+func changeLabel(c byte) string {
+	switch c {
+	case 'A':
+		return "new file:"
+	case 'D':
+		return "deleted:"
+	case 'R':
+		return "renamed:"
+	case 'T':
+		return "typechange:"
+	}
+	return "modified:"
+}
+
+func writeUpstreamStatus(w io.Writer, s *repoStatus, header func(string)) error {
+	upstream, ahead, behind, found, err := s.upstreamStatus()
+	if err != nil || upstream == "" {
+		return err
+	}
+	defer fmt.Fprintln(w)
+	switch {
+	case !found:
+		header(fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.", upstream))
+		header(`  (use "git branch --unset-upstream" to fixup)`)
+	case ahead == 0 && behind == 0:
+		header(fmt.Sprintf("Your branch is up to date with '%s'.", upstream))
+	case behind == 0:
+		header(fmt.Sprintf("Your branch is ahead of '%s' by %s.", upstream, plural(ahead, "commit", "commits")))
+		header(`  (use "git push" to publish your local commits)`)
+	case ahead == 0:
+		header(fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.", upstream, plural(behind, "commit", "commits")))
+		header(`  (use "git pull" to update your local branch)`)
+	default:
+		header(fmt.Sprintf("Your branch and '%s' have diverged,", upstream))
+		header(fmt.Sprintf("and have %d and %d different commits each, respectively.", ahead, behind))
+		header(`  (use "git pull" to merge the remote branch into yours)`)
+	}
+	return nil
+}
+
+func writeLongStatus(w io.Writer, s *repoStatus, columns columnOptions, colors colorPalette) error {
+	// header prints a line of the header color, the color of everything that
+	// is not a path
+	header := func(line string) {
+		fmt.Fprintln(w, colors.wrap("header", line))
+	}
+	if s.OnBranch {
+		// git prints this line in pieces, starting with an empty one
+		fmt.Fprintln(w, colors.wrap("header", "")+colors.wrap("header", "On branch ")+colors.wrap("branch", shortenRefName(s.Branch)))
+	} else {
+		description, err := detachedHeadDescription(s.Head)
+		if err != nil {
+			return err
+		}
+		description = strings.Trim(description, "()")
+		// like git, the revision is in the header color, not the warning's
+		at := len(description)
+		if strings.HasPrefix(description, "HEAD detached ") {
+			at = strings.LastIndexByte(description, ' ') + 1
+		}
+		fmt.Fprintln(w, colors.wrap("nobranch", description[:at])+colors.wrap("header", description[at:]))
+	}
+	if err := writeUpstreamStatus(w, s, header); err != nil {
+		return err
+	}
+	staged, unstaged, unmerged := []statusEntry{}, []statusEntry{}, []statusEntry{}
+	unstagedDeletion := false
+	for _, e := range s.Entries {
+		if e.Stages != [3]*IndexEntry{} {
+			unmerged = append(unmerged, e)
+			continue
+		}
+		if e.Index != ' ' {
+			staged = append(staged, e)
+		}
+		if e.Worktree != ' ' {
+			unstaged = append(unstaged, e)
+			unstagedDeletion = unstagedDeletion || e.Worktree == 'D'
+		}
+	}
+
+	merging, err := mergeHeads()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(merging) > 0 && len(unmerged) > 0:
+		header("You have unmerged paths.")
+		header(`  (fix conflicts and run "git commit")`)
+		header(`  (use "git merge --abort" to abort the merge)`)
+		fmt.Fprintln(w)
+	case len(merging) > 0:
+		header("All conflicts fixed but you are still merging.")
+		header(`  (use "git commit" to conclude merge)`)
+		fmt.Fprintln(w)
+	}
+	if s.Head == "" {
+		fmt.Fprint(w, "\nNo commits yet\n\n")
+	}
+
+	if len(staged) > 0 {
+		header("Changes to be committed:")
+		switch {
+		case len(merging) > 0:
+		case s.Head == "":
+			header(`  (use "git rm --cached <file>..." to unstage)`)
+		default:
+			header(`  (use "git restore --staged <file>..." to unstage)`)
+		}
+		for _, e := range staged {
+			path := quotePath(s.displayPath(e.Path), false)
+			if e.OrigPath != "" {
+				path = quotePath(s.displayPath(e.OrigPath), false) + " -> " + path
+			}
+			fmt.Fprintf(w, "%s%s\n", colors.wrap("header", "\t"), colors.wrap("added", fmt.Sprintf("%-*s%s", statusLabelWidth, changeLabel(e.Index), path)))
+		}
+		header("")
+	}
+	if len(unmerged) > 0 {
+		header("Unmerged paths:")
+		switch {
+		case len(merging) > 0:
+		case s.Head == "":
+			header(`  (use "git rm --cached <file>..." to unstage)`)
+		default:
+			header(`  (use "git restore --staged <file>..." to unstage)`)
+		}
+		bothDeleted, deleteModify, other := false, false, false
+		for _, e := range unmerged {
+			switch string([]byte{e.Index, e.Worktree}) {
+			case "DD":
+				bothDeleted = true
+			case "UD", "DU":
+				deleteModify = true
+			default:
+				other = true
+			}
+		}
+		switch {
+		case !bothDeleted && !deleteModify:
+			header(`  (use "git add <file>..." to mark resolution)`)
+		case bothDeleted && !deleteModify && !other:
+			header(`  (use "git rm <file>..." to mark resolution)`)
+		default:
+			header(`  (use "git add/rm <file>..." as appropriate to mark resolution)`)
+		}
+		for _, e := range unmerged {
+			label := unmergedLabels[string([]byte{e.Index, e.Worktree})]
+			fmt.Fprintf(w, "%s%s\n", colors.wrap("header", "\t"), colors.wrap("unmerged", fmt.Sprintf("%-*s%s", unmergedLabelWidth, label, quotePath(s.displayPath(e.Path), false))))
+		}
+		header("")
+	}
+	if len(unstaged) > 0 {
+		header("Changes not staged for commit:")
+		if unstagedDeletion {
+			header(`  (use "git add/rm <file>..." to update what will be committed)`)
+		} else {
+			header(`  (use "git add <file>..." to update what will be committed)`)
+		}
+		header(`  (use "git restore <file>..." to discard changes in working directory)`)
+		for _, e := range unstaged {
+			path := quotePath(s.displayPath(e.Path), false)
+			if e.newCommits() {
+				path += " (new commits)"
+			}
+			fmt.Fprintf(w, "%s%s\n", colors.wrap("header", "\t"), colors.wrap("changed", fmt.Sprintf("%-*s%s", statusLabelWidth, changeLabel(e.Worktree), path)))
+		}
+		header("")
+	}
+	if s.UntrackedMode == untrackedNo && len(staged) > 0 {
+		header("Untracked files not listed (use -u option to show untracked files)")
+	}
+	if len(s.Untracked) > 0 {
+		header("Untracked files:")
+		header(`  (use "git add <file>..." to include in what will be committed)`)
+		paths := make([]string, len(s.Untracked))
+		for i, path := range s.Untracked {
+			paths[i] = quotePath(s.displayPath(path), false)
+		}
+		// a row of columns is colored as a whole, a single path on its own
+		indent, nl := colors.wrap("header", "\t"), "\n"
+		if colors["untracked"] != "" {
+			indent, nl = indent+colors["untracked"], colorReset+"\n"
+			if columns.active() && columns.Layout != "plain" {
+				indent = colors["header"] + "\t" + colors["untracked"]
+			}
+		}
+		printColumns(w, paths, columns, indent, nl, 1)
+		fmt.Fprintln(w)
+	}
+
+	if len(staged) > 0 {
+		return nil
+	}
+	switch {
+	case len(unstaged) > 0 || len(unmerged) > 0:
+		header(`no changes added to commit (use "git add" and/or "git commit -a")`)
+	case len(s.Untracked) > 0:
+		header(`nothing added to commit but untracked files present (use "git add" to track)`)
+	case s.Head == "":
+		header(`nothing to commit (create/copy files and use "git add" to track)`)
+	case s.UntrackedMode == untrackedNo:
+		header("nothing to commit (use -u to show untracked files)")
+	default:
+		header("nothing to commit, working tree clean")
+	}
+	return nil
+}
+
+func runStatus(args []string) error {
+	format, branch, eol, porcelain := statusLong, false, byte('\n'), false
+	paths := make([]string, 0)
+	columns, err := loadColumnOptions("status")
+	if err != nil {
+		return err
+	}
+	untracked, err := loadUntrackedMode()
+	if err != nil {
+		return err
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if isColumnFlag(arg) {
+			if err := columns.parseFlag(arg); err != nil {
+				return err
+			}
+			continue
+		}
+		switch arg {
+		case "-s", "--short":
+			format = statusShort
+		case "--porcelain", "--porcelain=v1":
+			format, porcelain = statusShort, true
+		case "--porcelain=v2":
+			format, porcelain = statusPorcelainV2, true
+		case "-b", "--branch":
+			branch = true
+		case "-z":
+			eol = 0
+		case "-u", "--untracked-files":
+			untracked = untrackedAll
+		default:
+			if mode, ok := strings.CutPrefix(arg, "-u"); ok {
+				if untracked, err = parseUntrackedMode(mode); err != nil {
+					return err
+				}
+				continue
+			}
+			if mode, ok := strings.CutPrefix(arg, "--untracked-files="); ok {
+				if untracked, err = parseUntrackedMode(mode); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	pathspecs, err := parsePathspecs(paths)
+	if err != nil {
+		return err
+	}
+	// like git, -z implies the porcelain format
+	if eol == 0 && format == statusLong {
+		format = statusShort
+	}
+
+	var colors colorPalette
+	if !porcelain && eol != 0 {
+		if colors, err = loadColorPalette("status", statusColorDefaults, ""); err != nil {
+			return err
+		}
+	}
+
+	status, err := collectStatus(untracked)
+	if err != nil {
+		return err
+	}
+	if err := status.limitTo(pathspecs); err != nil {
+		return err
+	}
+	// like git, -z and porcelain v1 output always name paths from the top
+	if eol != 0 && !(porcelain && format == statusShort) {
+		config, err := getConfig()
+		if err != nil {
+			return err
+		}
+		if status.Relative, err = config.GetBool("status.relativePaths", true); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if jsonOutput {
+		return writeJSONStatus(w, status, branch)
+	}
+	switch format {
+	case statusShort:
+		return writeShortStatus(w, status, branch, eol, colors)
+	case statusPorcelainV2:
+		return writePorcelainV2(w, status, branch, eol)
+	}
+	columns.finalize()
+	return writeLongStatus(w, status, columns, colors)
+}
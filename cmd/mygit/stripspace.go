@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stripSpace normalizes a message the way every command taking one does:
+// trailing whitespace goes, runs of blank lines become one, blank lines at
+// either end are dropped and the last line ends in a newline. When comment
+// is not empty, lines starting with it are removed too.
+func stripSpace(message string, comment string) string {
+	var b strings.Builder
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if comment != "" && strings.HasPrefix(line, comment) {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r\v\f")
+		if line == "" {
+			blank = b.Len() > 0
+			continue
+		}
+		if blank {
+			b.WriteString("\n")
+			blank = false
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// commentLines turns every line of message into a comment, with a space
+// after the comment character unless the line is empty or starts with a tab.
+func commentLines(message string, comment string) string {
+	if message == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(message, "\n"), "\n") {
+		b.WriteString(comment)
+		if line != "" && line[0] != '\t' {
+			b.WriteString(" ")
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func runStripspace(args []string) error {
+	strip, comment := false, false
+	f := newFlagSet()
+	f.Bool(&strip, "-s", "--strip-comments")
+	f.Bool(&comment, "-c", "--comment-lines")
+	if positional, err := f.Parse(args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return fmt.Errorf("unexpected argument %s", positional[0])
+	}
+	if strip && comment {
+		return fmt.Errorf("options '-s' and '-c' cannot be used together")
+	}
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %s", err.Error())
+	}
+
+	char := "#"
+	if strip || comment {
+		// outside a repository only the global config is there to read
+		if config, err := getConfig(); err == nil {
+			char = commentChar(config)
+		}
+	}
+	output := ""
+	switch {
+	case comment:
+		output = commentLines(string(input), char)
+	case strip:
+		output = stripSpace(string(input), char)
+	default:
+		output = stripSpace(string(input), "")
+	}
+	_, err = os.Stdout.WriteString(output)
+	return err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const subtreeUsage = "mygit subtree add --prefix=<prefix> <commit>\n   or: mygit subtree split --prefix=<prefix> [-b <branch>] [<commit>]"
+
+// subtreeTrailers reads the git-subtree-* lines subtree add leaves in the
+// message of the commit it makes.
+func subtreeTrailers(message string) (dir string, split string) {
+	for _, line := range strings.Split(message, "\n") {
+		if value, ok := strings.CutPrefix(line, "git-subtree-dir: "); ok {
+			dir = strings.Trim(strings.TrimSpace(value), "/")
+		}
+		if value, ok := strings.CutPrefix(line, "git-subtree-split: "); ok {
+			split = strings.TrimSpace(value)
+		}
+	}
+	return dir, split
+}
+
+// insertSubtree returns root with tree placed at path, creating the
+// directories leading to it.
+func insertSubtree(root string, path string, tree string) (string, error) {
+	entries, err := readTreeOrEmpty(root)
+	if err != nil {
+		return "", err
+	}
+	name, rest, nested := strings.Cut(path, "/")
+	kept := make([]TreeObjectLine, 0, len(entries)+1)
+	existing := ""
+	for _, e := range entries {
+		if e.Name != name {
+			kept = append(kept, e)
+			continue
+		}
+		if !nested || e.Mode != modeTree {
+			return "", fmt.Errorf("prefix '%s' already exists", path)
+		}
+		existing = hex.EncodeToString(e.Hash)
+	}
+	hash := tree
+	if nested {
+		if hash, err = insertSubtree(existing, rest, tree); err != nil {
+			return "", err
+		}
+	}
+	rawHash, _ := hex.DecodeString(hash)
+	kept = append(kept, TreeObjectLine{Mode: modeTree, Name: name, Hash: rawHash})
+	return writeTreeEntries(kept)
+}
+
+// subtreeAdd merges the history of commit into HEAD under prefix, with the
+// trailers that let subtree split find it again.
+func subtreeAdd(prefix string, spec string) error {
+	if _, err := os.Lstat(filepath.FromSlash(prefix)); err == nil {
+		return fmt.Errorf("prefix '%s' already exists.", prefix)
+	}
+	split, err := resolveCommitRevision(spec)
+	if err != nil {
+		return fmt.Errorf("'%s' does not refer to a commit", spec)
+	}
+	status, err := collectStatus(untrackedNo)
+	if err != nil {
+		return err
+	}
+	if len(status.Entries) > 0 {
+		return fmt.Errorf("working tree has modifications.  Cannot add.")
+	}
+	head := status.Head
+	headTree, err := commitTreeHash(head)
+	if err != nil {
+		return err
+	}
+	splitTree, err := commitTreeHash(split)
+	if err != nil {
+		return err
+	}
+	tree, err := insertSubtree(headTree, prefix, splitTree)
+	if err != nil {
+		return err
+	}
+
+	author, err := currentIdentity("AUTHOR")
+	if err != nil {
+		return err
+	}
+	committer, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return err
+	}
+	parents := []string{split}
+	message := fmt.Sprintf("Add '%s/' from commit '%s'\n\ngit-subtree-dir: %s\n", prefix, split, prefix)
+	if head != "" {
+		parents = []string{head, split}
+		message += "git-subtree-mainline: " + head + "\n"
+	}
+	message += "git-subtree-split: " + split + "\n"
+	hash, err := writeCommitObject(tree, parents, author, committer, message)
+	if err != nil {
+		return err
+	}
+
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	if err := updateHeadRef(hash, head, "subtree add: "+prefix); err != nil {
+		return err
+	}
+	newIndex := &Index{Version: index.Version, Entries: append([]IndexEntry(nil), index.Entries...)}
+	if err := resetIndex(newIndex, tree); err != nil {
+		return err
+	}
+	if err := resetWorktree(index, newIndex); err != nil {
+		return err
+	}
+	if err := writeIndex(newIndex, lock); err != nil {
+		return err
+	}
+	fmt.Printf("Added dir '%s'\n", prefix)
+	return nil
+}
+
+// subtreeSplit rewrites the history of spec into the history of prefix
+// alone and returns the new tip. Commits brought in by subtree add are kept
+// as they are, so the split history continues the one that was added.
+func subtreeSplit(prefix string, spec string) (string, error) {
+	tip, err := resolveCommitRevision(spec)
+	if err != nil {
+		return "", fmt.Errorf("'%s' does not refer to a commit", spec)
+	}
+	r := newHistoryRewriter(rewriteOptions{Subdirectory: prefix})
+	walk, err := newRevWalk([]string{tip}, nil)
+	if err != nil {
+		return "", err
+	}
+	added := make([]string, 0)
+	for {
+		commit, err := walk.Next()
+		if err != nil {
+			return "", err
+		}
+		if commit == nil {
+			break
+		}
+		dir, split := subtreeTrailers(commit.Message)
+		if dir != prefix || split == "" || r.commits[split] != "" {
+			continue
+		}
+		splitTree, err := commitTreeHash(split)
+		if err != nil {
+			return "", err
+		}
+		r.commits[split], r.newTrees[split] = split, splitTree
+		added = append(added, split)
+	}
+	if _, err := r.rewriteCommits([]string{tip}, added); err != nil {
+		return "", err
+	}
+	if r.commits[tip] == "" {
+		return "", fmt.Errorf("no new revisions were found")
+	}
+	return r.commits[tip], nil
+}
+
+func runSubtree(args []string) error {
+	prefix, branch := "", ""
+	flags := newFlagSet()
+	flags.String(&prefix, "--prefix", "-P")
+	flags.String(&branch, "-b", "--branch")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", subtreeUsage)
+	}
+	prefix = strings.Trim(filepath.ToSlash(filepath.Clean(prefix)), "/")
+	if prefix == "" || prefix == "." {
+		return fmt.Errorf("you must provide the --prefix option.")
+	}
+
+	switch args[0] {
+	case "add":
+		switch len(args) {
+		case 2:
+			return subtreeAdd(prefix, args[1])
+		case 3:
+			// there is no fetch to bring in <ref> from <repository>
+			return fmt.Errorf("adding from a repository needs a fetch; fetch %s from %s first and add the fetched commit", args[2], args[1])
+		}
+		return fmt.Errorf("usage: %s", subtreeUsage)
+	case "split":
+		if len(args) > 2 {
+			return fmt.Errorf("usage: %s", subtreeUsage)
+		}
+		spec := "HEAD"
+		if len(args) == 2 {
+			spec = args[1]
+		}
+		hash, err := subtreeSplit(prefix, spec)
+		if err != nil {
+			return err
+		}
+		if branch != "" {
+			t := beginRefTransaction()
+			if err := t.create("refs/heads/"+branch, hash, "subtree split"); err != nil {
+				return err
+			}
+			if err := t.commit(); err != nil {
+				return err
+			}
+		}
+		fmt.Println(hash)
+		return nil
+	}
+	return fmt.Errorf("unknown command '%s'", args[0])
+}
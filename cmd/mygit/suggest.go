@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// similarityFloor is git's SIMILARITY_FLOOR: names further from what was
+// typed than this are not suggested.
+const similarityFloor = 7
+
+// commonCommands are those git's autocorrect prefers when what was typed
+// is the start of their name.
+var commonCommands = map[string]bool{
+	"add": true, "bisect": true, "branch": true, "commit": true, "diff": true,
+	"grep": true, "init": true, "log": true, "merge": true, "mv": true,
+	"reset": true, "restore": true, "rm": true, "show": true, "status": true,
+	"switch": true, "tag": true,
+}
+
+// levenshtein is git's weighted edit distance from a to b, with the costs
+// of swapping two neighbouring bytes, substituting, inserting and deleting
+// one.
+func levenshtein(a string, b string, swap int, substitution int, insertion int, deletion int) int {
+	row0 := make([]int, len(b)+1)
+	row1 := make([]int, len(b)+1)
+	row2 := make([]int, len(b)+1)
+	for j := range row1 {
+		row1[j] = j * insertion
+	}
+	for i := 0; i < len(a); i++ {
+		row2[0] = (i + 1) * deletion
+		for j := 0; j < len(b); j++ {
+			cost := 0
+			if a[i] != b[j] {
+				cost = substitution
+			}
+			row2[j+1] = row1[j] + cost
+			if i > 0 && j > 0 && a[i-1] == b[j] && a[i] == b[j-1] && row2[j+1] > row0[j-1]+swap {
+				row2[j+1] = row0[j-1] + swap
+			}
+			row2[j+1] = min(row2[j+1], row1[j+1]+deletion, row2[j]+insertion)
+		}
+		row0, row1, row2 = row1, row2, row0
+	}
+	return row1[len(b)]
+}
+
+// closestNames returns the candidates most similar to name, best first,
+// scoring 0 for those preferred outright and otherwise one more than the
+// edit distance. Nothing is returned unless the closest of the others are
+// within similarityFloor.
+func closestNames(name string, candidates []string, preferred func(string) bool) []string {
+	type scored struct {
+		name  string
+		score int
+	}
+	all := make([]scored, 0, len(candidates))
+	for _, candidate := range candidates {
+		if preferred(candidate) {
+			all = append(all, scored{candidate, 0})
+			continue
+		}
+		all = append(all, scored{candidate, levenshtein(name, candidate, 0, 2, 1, 3) + 1})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score < all[j].score
+		}
+		return all[i].name < all[j].name
+	})
+
+	// like git, the preferred names are followed by the closest others,
+	// and how close those are decides whether any are suggested
+	n := 0
+	for n < len(all) && all[n].score == 0 {
+		n++
+	}
+	best := similarityFloor + 1
+	if n < len(all) {
+		best = all[n].score
+		for n < len(all) && all[n].score == best {
+			n++
+		}
+	}
+	if best >= similarityFloor {
+		return nil
+	}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = all[i].name
+	}
+	return names
+}
+
+// refSuggestion is a hint naming the branches, tags and remote-tracking
+// branches closest to a name that did not resolve, "" when none is close.
+// A name differing only in case is taken to be what was meant.
+func refSuggestion(name string) string {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return ""
+	}
+	var candidates, similar []string
+	for _, ref := range refs {
+		if short := shortenRefName(ref.Name); short != ref.Name {
+			candidates = append(candidates, short)
+			if strings.EqualFold(short, name) {
+				similar = append(similar, short)
+			}
+		}
+	}
+	if len(similar) == 0 {
+		similar = closestNames(name, candidates, func(string) bool { return false })
+	}
+	switch len(similar) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("\nhint: Did you mean '%s'?", similar[0])
+	}
+	return "\nhint: Did you mean one of these?\nhint: \t" + strings.Join(similar, "\nhint: \t")
+}
+
+// unknownRevisionError is a revision that names nothing. The ref names
+// close to it are only looked up when it is reported.
+type unknownRevisionError struct {
+	Name string
+}
+
+func (e *unknownRevisionError) Error() string {
+	return fmt.Sprintf("unknown revision '%s'", e.Name)
+}
+
+func unknownRevision(name string) error {
+	return &codedError{Code: "unknown-revision", Err: &unknownRevisionError{Name: name}}
+}
+
+// autocorrect modes of help.autocorrect, besides a delay in tenths of a
+// second
+const (
+	autocorrectImmediately = -1
+	autocorrectNever       = -2
+	autocorrectPrompt      = -3
+)
+
+func loadAutocorrect() (int, error) {
+	config, err := getConfig()
+	if err != nil {
+		return 0, err
+	}
+	value, ok := config.Get("help.autocorrect")
+	switch {
+	case !ok:
+		return 0, nil
+	case value == "never":
+		return autocorrectNever, nil
+	case value == "immediate":
+		return autocorrectImmediately, nil
+	case value == "prompt":
+		return autocorrectPrompt, nil
+	}
+	if on, err := parseConfigBool(value); err == nil {
+		if on {
+			return autocorrectImmediately, nil
+		}
+		return 0, nil
+	}
+	delay, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for 'help.autocorrect'", value)
+	}
+	return max(delay, autocorrectImmediately), nil
+}
+
+// autocorrectCommand handles a command name that is neither a command nor
+// an alias: it returns the one command or alias close to it when
+// help.autocorrect allows running that instead, and otherwise reports the
+// name with the closest ones and returns "".
+func autocorrectCommand(name string) (string, error) {
+	autocorrect, err := loadAutocorrect()
+	if err != nil {
+		return "", err
+	}
+	if autocorrect == autocorrectNever {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", name)
+		return "", nil
+	}
+	candidates := make([]string, 0, len(commands))
+	for command := range commands {
+		candidates = append(candidates, command)
+	}
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range config.entries {
+		if e.Section == "alias" && e.Subsection == "" && commands[e.Key] == nil {
+			candidates = append(candidates, e.Key)
+		}
+	}
+	similar := closestNames(name, candidates, func(candidate string) bool {
+		return commonCommands[candidate] && strings.HasPrefix(candidate, name)
+	})
+
+	if autocorrect != 0 && len(similar) == 1 {
+		assumed := similar[0]
+		fmt.Fprintf(os.Stderr, "WARNING: You called a mygit command named '%s', which does not exist.\n", name)
+		switch autocorrect {
+		case autocorrectImmediately:
+			fmt.Fprintf(os.Stderr, "Continuing under the assumption that you meant '%s'.\n", assumed)
+		case autocorrectPrompt:
+			answer, err := credentialPrompt(fmt.Sprintf("Run '%s' instead [y/N]? ", assumed), false)
+			if err != nil || !strings.HasPrefix(strings.ToLower(answer), "y") {
+				return "", nil
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Continuing in %0.1f seconds, assuming that you meant '%s'.\n", float64(autocorrect)/10, assumed)
+			time.Sleep(time.Duration(autocorrect) * 100 * time.Millisecond)
+		}
+		return assumed, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %s\n", name)
+	if len(similar) > 0 {
+		header := "The most similar command is"
+		if len(similar) > 1 {
+			header = "The most similar commands are"
+		}
+		fmt.Fprintf(os.Stderr, "\n%s\n", header)
+		for _, s := range similar {
+			fmt.Fprintf(os.Stderr, "\t%s\n", s)
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errQuietFailure = fmt.Errorf("")
+
+// exitStatus fails a command quietly with its own exit code, for commands
+// whose code carries a result.
+type exitStatus int
+
+func (s exitStatus) Error() string { return "" }
+
+func runSymbolicRef(args []string) error {
+	quiet, short, deleteRef := false, false, false
+	names := make([]string, 0, 2)
+	for _, arg := range args {
+		switch arg {
+		case "-q", "--quiet":
+			quiet = true
+		case "--short":
+			short = true
+		case "-d", "--delete":
+			deleteRef = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 || len(names) > 2 || (deleteRef && len(names) != 1) {
+		return fmt.Errorf("usage: symbolic-ref [-q] [--short] [-d] <name> [<ref>]")
+	}
+	name := namespacedRefName(names[0])
+
+	if deleteRef {
+		content, err := readRawRef(name)
+		if err != nil {
+			return fmt.Errorf("cannot delete %s: %s", names[0], err.Error())
+		}
+		if !strings.HasPrefix(content, "ref: ") {
+			return fmt.Errorf("cannot delete %s, not a symbolic ref", names[0])
+		}
+		return deleteRefFile(name)
+	}
+
+	if len(names) == 2 {
+		target := names[1]
+		if name == namespacedRefName("HEAD") && !strings.HasPrefix(target, "refs/") {
+			return fmt.Errorf("refusing to point HEAD outside of refs/")
+		}
+		if !isValidRefName(target) {
+			return fmt.Errorf("refusing to set '%s' to invalid ref '%s'", names[0], target)
+		}
+		return writeSymbolicRef(name, namespacedRefName(target))
+	}
+
+	content, err := readRawRef(name)
+	if err == errRefNotFound && name != names[0] {
+		// fall back to the top-level HEAD for namespaces without their own
+		content, err = readRawRef(names[0])
+	}
+	if err != nil {
+		if quiet {
+			return errQuietFailure
+		}
+		return fmt.Errorf("no such ref %s", names[0])
+	}
+	target, isSymref := strings.CutPrefix(content, "ref: ")
+	if !isSymref {
+		if quiet {
+			return errQuietFailure
+		}
+		return fmt.Errorf("ref %s is not a symbolic ref", names[0])
+	}
+	target = stripNamespace(target)
+	if short {
+		target = shortenRefName(target)
+	}
+	fmt.Fprintln(os.Stdout, target)
+	return nil
+}
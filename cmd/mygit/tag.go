@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tagUsage = "mygit tag [-a] [-f] [-m <msg> | -F <file>] <tagname> [<commit>]\n   or: mygit tag -d <tagname>...\n   or: mygit tag [-l] [--[no-]column[=<options>]] [--sort=<key>] [--contains [<commit>]] [<pattern>...]\n   or: mygit tag -v <tagname>..."
+
+// tagSortKeys are the keys of --sort, falling back to tag.sort. Like git,
+// the last --sort given is the primary key.
+func tagSortKeys(flags []string) ([]refSortKey, error) {
+	if len(flags) == 0 {
+		config, err := getConfig()
+		if err != nil {
+			return nil, err
+		}
+		value, ok := config.Get("tag.sort")
+		if !ok {
+			return nil, nil
+		}
+		flags = []string{value}
+	}
+	keys := make([]refSortKey, 0, len(flags))
+	for i := len(flags) - 1; i >= 0; i-- {
+		key, err := parseRefSortKey(flags[i])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func listTags(patterns []string, sortFlags []string, contains []string, columns columnOptions) error {
+	keys, err := tagSortKeys(sortFlags)
+	if err != nil {
+		return err
+	}
+	filter := refFilter{Prefix: "refs/tags/", Patterns: patterns, Contains: contains}
+	if err := filter.resolveCommits(); err != nil {
+		return err
+	}
+	tags, err := filter.filter()
+	if err != nil {
+		return err
+	}
+	if err := sortRefs(tags, keys); err != nil {
+		return err
+	}
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = strings.TrimPrefix(t.Name, "refs/tags/")
+	}
+	columns.finalize()
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	printColumns(w, names, columns, "", "\n", 2)
+	return nil
+}
+
+func deleteTags(names []string) error {
+	failed := false
+	for _, name := range names {
+		refName := "refs/tags/" + name
+		hash, _, err := resolveRef(refName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			failed = true
+			continue
+		}
+		t := beginRefTransaction()
+		if err := t.delete(refName, true, hash); err != nil {
+			return err
+		}
+		if err := t.commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted tag '%s' (was %s)\n", name, abbrevHash(hash))
+	}
+	if failed {
+		return errQuietFailure
+	}
+	return nil
+}
+
+// verifyTags checks the signatures of annotated tags. The signed part of
+// each tag is printed, and what gpg says about the signature goes to stderr.
+func verifyTags(names []string) error {
+	failed := false
+	for _, name := range names {
+		hash, _, err := resolveRef("refs/tags/" + name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			failed = true
+			continue
+		}
+		object, err := parseObject(hash)
+		if err != nil {
+			return err
+		}
+		if object.Type != TypeTag {
+			fmt.Fprintf(os.Stderr, "error: %s: cannot verify a non-tag object of type %s.\n", name, object.Type)
+			failed = true
+			continue
+		}
+		payload, signature := splitSignature(object.Content)
+		os.Stdout.Write(payload)
+		if signature == nil {
+			fmt.Fprintf(os.Stderr, "error: no signature found\n")
+			failed = true
+			continue
+		}
+		good, output, err := verifySignature(payload, signature)
+		if err != nil {
+			return err
+		}
+		os.Stderr.Write(output)
+		failed = failed || !good
+	}
+	if failed {
+		return errQuietFailure
+	}
+	return nil
+}
+
+// tagMessage is the message of an annotated tag, from -m or -F or else
+// edited in TAG_EDITMSG.
+func tagMessage(name string, messages []string, messageFile string) (string, error) {
+	config, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	comment := commentChar(config)
+	switch {
+	case len(messages) > 0 && messageFile != "":
+		return "", fmt.Errorf("options -m and -F cannot be used together")
+	case len(messages) > 0:
+		return applyCleanup(strings.Join(messages, "\n\n"), cleanupStrip, comment), nil
+	case messageFile == "-":
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read message from stdin: %s", err.Error())
+		}
+		return applyCleanup(string(content), cleanupStrip, comment), nil
+	case messageFile != "":
+		content, err := os.ReadFile(prefixFilename(messageFile))
+		if err != nil {
+			return "", fmt.Errorf("could not open or read '%s': %s", messageFile, err.Error())
+		}
+		return applyCleanup(string(content), cleanupStrip, comment), nil
+	}
+
+	editMsgPath := filepath.Join(gitDir, "TAG_EDITMSG")
+	hint := fmt.Sprintf("\n%s\n%s Write a message for tag:\n%s   %s\n%s Lines starting with '%s' will be ignored.\n%s\n", comment, comment, comment, name, comment, comment, comment)
+	if err := os.WriteFile(editMsgPath, []byte(hint), 0644); err != nil {
+		return "", fmt.Errorf("failed to write TAG_EDITMSG: %s", err.Error())
+	}
+	if err := launchEditor(editMsgPath); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(editMsgPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read TAG_EDITMSG: %s", err.Error())
+	}
+	message := applyCleanup(string(content), cleanupStrip, comment)
+	if message == "" {
+		return "", fmt.Errorf("no tag message?")
+	}
+	return message, nil
+}
+
+// createTag points refs/tags/<name> at target, or with annotate at a new tag
+// object for it carrying a message and the committer as tagger.
+func createTag(name string, target string, annotate bool, messages []string, messageFile string, force bool) error {
+	refName := "refs/tags/" + name
+	if !isValidRefName(refName) {
+		return fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+	hash, err := resolveRevision(target)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve '%s' as a valid ref.", target)
+	}
+	old, _, err := resolveRef(refName)
+	if err != nil && err != errRefNotFound {
+		return err
+	}
+	if old != "" && !force {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+
+	if annotate || len(messages) > 0 || messageFile != "" {
+		object, err := parseObject(hash)
+		if err != nil {
+			return err
+		}
+		message, err := tagMessage(name, messages, messageFile)
+		if err != nil {
+			return err
+		}
+		tagger, err := currentIdentity("COMMITTER")
+		if err != nil {
+			return err
+		}
+		content := fmt.Sprintf("object %s\ntype %s\ntag %s\ntagger %s\n\n%s", hash, object.Type, name, tagger.String(), message)
+		if hash, err = writeObject(TypeTag, []byte(content)); err != nil {
+			return err
+		}
+	}
+
+	t := beginRefTransaction()
+	if err := t.update(refName, hash, true, old, ""); err != nil {
+		return err
+	}
+	if err := t.commit(); err != nil {
+		return err
+	}
+	if old != "" && old != hash {
+		fmt.Printf("Updated tag '%s' (was %s)\n", name, abbrevHash(old))
+	}
+	return nil
+}
+
+func runTag(args []string) error {
+	mode := ""
+	var sortFlags, contains, messages []string
+	messageFile := ""
+	annotate, force := false, false
+	names := make([]string, 0, 2)
+	columns, err := loadColumnOptions("tag")
+	if err != nil {
+		return err
+	}
+	setMode := func(m string) error {
+		if mode != "" && mode != m {
+			return fmt.Errorf("options '-%s' and '-%s' cannot be used together", mode, m)
+		}
+		mode = m
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var err error
+		switch {
+		case arg == "-l" || arg == "--list":
+			err = setMode("l")
+		case arg == "-d" || arg == "--delete":
+			err = setMode("d")
+		case arg == "-v" || arg == "--verify":
+			err = setMode("v")
+		case arg == "--sort" || arg == "-m" || arg == "--message" || arg == "-F" || arg == "--file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			switch arg {
+			case "--sort":
+				sortFlags = append(sortFlags, args[i])
+			case "-F", "--file":
+				messageFile = args[i]
+			default:
+				messages = append(messages, args[i])
+			}
+		case strings.HasPrefix(arg, "--sort="):
+			sortFlags = append(sortFlags, strings.TrimPrefix(arg, "--sort="))
+		case strings.HasPrefix(arg, "--message="):
+			messages = append(messages, strings.TrimPrefix(arg, "--message="))
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			messages = append(messages, arg[2:])
+		case strings.HasPrefix(arg, "--file="):
+			messageFile = strings.TrimPrefix(arg, "--file=")
+		case arg == "--contains":
+			// the commit defaults to HEAD when --contains comes last
+			if i+1 < len(args) {
+				i++
+				contains = append(contains, args[i])
+			} else {
+				contains = append(contains, "HEAD")
+			}
+		case strings.HasPrefix(arg, "--contains="):
+			contains = append(contains, strings.TrimPrefix(arg, "--contains="))
+		case arg == "-a" || arg == "--annotate":
+			annotate = true
+		case arg == "-f" || arg == "--force":
+			force = true
+		case isColumnFlag(arg):
+			err = columns.parseFlag(arg)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			names = append(names, arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if mode == "" && (len(names) == 0 || len(contains) > 0) {
+		mode = "l"
+	}
+	if mode != "l" && len(contains) > 0 {
+		return fmt.Errorf("--contains option is only allowed in list mode")
+	}
+	if mode != "" && (annotate || force || len(messages) > 0 || messageFile != "") {
+		return fmt.Errorf("-a, -f, -m and -F are only allowed when creating a tag")
+	}
+
+	switch mode {
+	case "l":
+		return listTags(names, sortFlags, contains, columns)
+	case "d":
+		return deleteTags(names)
+	case "v":
+		return verifyTags(names)
+	}
+	switch len(names) {
+	case 1:
+		return createTag(names[0], "HEAD", annotate, messages, messageFile, force)
+	case 2:
+		return createTag(names[0], names[1], annotate, messages, messageFile, force)
+	}
+	return fmt.Errorf("too many arguments")
+}
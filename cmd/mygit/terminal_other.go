@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// terminalWidth cannot ask the terminal where there is no portable ioctl,
+// leaving $COLUMNS and the default.
+func terminalWidth(f *os.File) (int, bool) {
+	return 0, false
+}
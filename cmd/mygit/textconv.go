@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// textconvDriver returns the diff driver selected by the path's diff
+// attribute and its textconv command, "" when it has none.
+func textconvDriver(pathname string) (driver string, command string, err error) {
+	driver, err = getAttribute(pathname, "diff")
+	if err != nil {
+		return "", "", err
+	}
+	if driver == attrUnspecified || driver == attrSet || driver == attrUnset {
+		return "", "", nil
+	}
+	config, err := getConfig()
+	if err != nil {
+		return "", "", err
+	}
+	command, _ = config.Get("diff." + driver + ".textconv")
+	return driver, command, nil
+}
+
+// runTextconv converts content with a textconv command, which is given a
+// temporary file holding it, named after the path so tools can go by its
+// extension.
+func runTextconv(command string, pathname string, content []byte) ([]byte, error) {
+	f, err := createTempFile(os.TempDir(), "*_"+filepath.Base(pathname))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp-file: %s", err.Error())
+	}
+	defer forgetTempFile(f.Name())
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to write temp-file: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, f.Name())
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to read files to diff: textconv '%s' failed: %s", command, err.Error())
+	}
+	return out.Bytes(), nil
+}
+
+// textconvCache keeps the text of converted blobs as notes, one per blob
+// named by its hash, under refs/notes/textconv/<driver>. The notes commit
+// records the command, so the cache is dropped when the command changes.
+type textconvCache struct {
+	ref     string
+	command string
+	// notes maps blob hashes to the hashes of their text
+	notes map[string]string
+}
+
+// textconvCaches holds the caches loaded, nil for drivers without
+// diff.<driver>.cachetextconv.
+var textconvCaches = map[string]*textconvCache{}
+
+func loadTextconvCache(driver string, command string) (*textconvCache, error) {
+	if cache, ok := textconvCaches[driver]; ok {
+		return cache, nil
+	}
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	if enabled, err := config.GetBool("diff."+driver+".cachetextconv", false); err != nil || !enabled {
+		textconvCaches[driver] = nil
+		return nil, err
+	}
+	cache := &textconvCache{ref: "refs/notes/textconv/" + driver, command: command, notes: map[string]string{}}
+	textconvCaches[driver] = cache
+	head, _, err := resolveRef(cache.ref)
+	if err != nil {
+		return cache, nil
+	}
+	commit, err := readCommit(head)
+	if err != nil || strings.TrimSpace(commit.Message) != command {
+		return cache, nil
+	}
+	files, err := flattenTree(commit.Tree, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		// notes trees may fan out into directories named by the first
+		// digits of the hash
+		if hash := strings.ReplaceAll(f.Path, "/", ""); isHexHash(hash) {
+			cache.notes[hash] = f.Hash
+		}
+	}
+	return cache, nil
+}
+
+// put records the text of a blob and commits the notes, without a parent
+// as the cache keeps no history. A cache that cannot be written is only
+// not kept.
+func (c *textconvCache) put(blob string, text []byte) error {
+	note, err := writeObject(TypeBlob, text)
+	if err != nil {
+		return err
+	}
+	c.notes[blob] = note
+	entries := make([]TreeObjectLine, 0, len(c.notes))
+	for name, hash := range c.notes {
+		hashBytes, _ := hex.DecodeString(hash)
+		entries = append(entries, TreeObjectLine{Mode: 100644, Name: name, Hash: hashBytes})
+	}
+	tree, err := writeTreeEntries(entries)
+	if err != nil {
+		return err
+	}
+	ident, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return nil
+	}
+	commit, err := writeCommitObject(tree, nil, ident, ident, c.command)
+	if err != nil {
+		return err
+	}
+	t := beginRefTransaction()
+	if err := t.update(c.ref, commit, false, "", ""); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// textconvBlob converts one side of a file change when it is a regular
+// file whose path has a textconv driver, reading blobs too big to diff in
+// full. converted is false when there is nothing to convert.
+func textconvBlob(pathname string, hash string, mode int, blob diffBlob) (_ diffBlob, converted bool, err error) {
+	if hash == zeroHash || mode/1000 != 100 {
+		return blob, false, nil
+	}
+	driver, command, err := textconvDriver(pathname)
+	if err != nil || command == "" {
+		return blob, false, err
+	}
+	cache, err := loadTextconvCache(driver, command)
+	if err != nil {
+		return blob, false, err
+	}
+	if cache != nil {
+		if note, ok := cache.notes[hash]; ok {
+			if object, err := parseObject(note); err == nil {
+				return diffBlob{Content: object.Content, Size: len(object.Content)}, true, nil
+			}
+		}
+	}
+
+	content := blob.Content
+	if blob.Big {
+		object, err := parseObject(hash)
+		if err != nil {
+			return blob, false, err
+		}
+		content = object.Content
+	}
+	text, err := runTextconv(command, pathname, content)
+	if err != nil {
+		return blob, false, err
+	}
+	if cache != nil {
+		if err := cache.put(hash, text); err != nil {
+			return blob, false, err
+		}
+	}
+	return diffBlob{Content: text, Size: len(text)}, true, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traceKey is a trace destination configured by an environment variable:
+// "1", "2" or "true" for stderr, a file descriptor from 3 to 9, or an
+// absolute path to append to.
+type traceKey struct {
+	Env    string
+	opened bool
+	file   *os.File
+	stderr bool
+}
+
+var (
+	traceGeneral     = &traceKey{Env: "GIT_TRACE"}
+	tracePerformance = &traceKey{Env: "GIT_TRACE_PERFORMANCE"}
+	tracePacket      = &traceKey{Env: "GIT_TRACE_PACKET"}
+	trace2Event      = &traceKey{Env: "GIT_TRACE2_EVENT"}
+)
+
+var (
+	traceStartTime = time.Now()
+	traceSid       string
+	traceChildID   int
+)
+
+func (k *traceKey) open() {
+	k.opened = true
+	value := os.Getenv(k.Env)
+	switch strings.ToLower(value) {
+	case "", "0", "false":
+		return
+	case "1", "2", "true":
+		k.stderr = true
+		return
+	}
+	if fd, err := strconv.Atoi(value); err == nil && fd >= 3 && fd <= 9 {
+		k.file = os.NewFile(uintptr(fd), k.Env)
+		return
+	}
+	if !filepath.IsAbs(value) {
+		fmt.Fprintf(os.Stderr, "warning: unknown trace value for '%s': %s\n", k.Env, value)
+		fmt.Fprintln(os.Stderr, "         If you want to trace into a file, then please set it")
+		fmt.Fprintln(os.Stderr, "         to an absolute pathname (starting with /)")
+		return
+	}
+	f, err := os.OpenFile(value, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not open '%s' for tracing: %s\n", value, err.Error())
+		return
+	}
+	k.file = f
+}
+
+// writer returns where the key traces to, or nil when tracing is off.
+// stderr is looked up on every call, as the pager may swap it.
+func (k *traceKey) writer() io.Writer {
+	if !k.opened {
+		k.open()
+	}
+	if k.stderr {
+		return os.Stderr
+	}
+	if k.file != nil {
+		return k.file
+	}
+	return nil
+}
+
+func (k *traceKey) enabled() bool {
+	return k.writer() != nil
+}
+
+// printf writes one trace line prefixed with the time and the file:line of
+// the code depth frames above it, padded like git's.
+func (k *traceKey) printf(depth int, format string, args ...any) {
+	w := k.writer()
+	if w == nil {
+		return
+	}
+	now := time.Now()
+	prefix := fmt.Sprintf("%s.%06d", now.Format("15:04:05"), now.Nanosecond()/1000)
+	if _, file, line, ok := runtime.Caller(depth + 1); ok {
+		prefix += fmt.Sprintf(" %s:%d", filepath.Base(file), line)
+	}
+	// the whole line goes out in one write so concurrent traces don't mix
+	io.WriteString(w, fmt.Sprintf("%-40s", prefix)+fmt.Sprintf(format, args...)+"\n")
+}
+
+// quoteTraceArgs joins argv, quoting arguments the shell would split or expand.
+func quoteTraceArgs(argv []string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		plain := arg != ""
+		for _, c := range arg {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./=:,+@%^", c)) {
+				plain = false
+				break
+			}
+		}
+		if plain {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// trace2 appends a JSON event to GIT_TRACE2_EVENT.
+func trace2(event string, fields map[string]any) {
+	w := trace2Event.writer()
+	if w == nil {
+		return
+	}
+	if traceSid == "" {
+		host, _ := os.Hostname()
+		hostHash := uint32(0)
+		for i := 0; i < len(host); i++ {
+			hostHash = hostHash*31 + uint32(host[i])
+		}
+		traceSid = fmt.Sprintf("%s.%06dZ-H%08x-P%08x", traceStartTime.UTC().Format("20060102T150405"), traceStartTime.Nanosecond()/1000, hostHash, os.Getpid())
+	}
+	fields["event"] = event
+	fields["sid"] = traceSid
+	fields["thread"] = "main"
+	fields["time"] = time.Now().UTC().Format("2006-01-02T15:04:05.000000Z")
+	if _, file, line, ok := runtime.Caller(2); ok {
+		fields["file"], fields["line"] = filepath.Base(file), line
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	w.Write(append(data, '\n'))
+}
+
+// traceStart reports the start of the process with its full argv.
+func traceStart(argv []string) {
+	trace2("version", map[string]any{"evt": "3", "exe": "mygit"})
+	trace2("start", map[string]any{"t_abs": time.Since(traceStartTime).Seconds(), "argv": argv})
+}
+
+// traceCommand reports the command being dispatched.
+func traceCommand(argv []string) {
+	traceGeneral.printf(1, "trace: built-in: mygit %s", quoteTraceArgs(argv))
+	trace2("cmd_name", map[string]any{"name": argv[0], "hierarchy": argv[0]})
+}
+
+// traceExit reports the exit code and how long the command took.
+func traceExit(code int) {
+	elapsed := time.Since(traceStartTime)
+	tracePerformance.printf(1, "performance: %.9f s: git command: mygit %s", elapsed.Seconds(), quoteTraceArgs(os.Args[1:]))
+	trace2("exit", map[string]any{"t_abs": elapsed.Seconds(), "code": code})
+	trace2("atexit", map[string]any{"t_abs": elapsed.Seconds(), "code": code})
+}
+
+// traceAlias reports the expansion of an alias.
+func traceAlias(name string, expansion string) {
+	traceGeneral.printf(1, "trace: alias expansion: %s => %s", name, expansion)
+}
+
+// traceRunCommand reports a child process about to be started.
+func traceRunCommand(cmd *exec.Cmd) {
+	traceGeneral.printf(1, "trace: run_command: %s", quoteTraceArgs(cmd.Args))
+	trace2("child_start", map[string]any{"child_id": traceChildID, "child_class": "?", "argv": cmd.Args})
+	traceChildID++
+}
+
+// traceObject reports an object read from or written to the object store.
+func traceObject(action string, hash string, objectType Type, size int) {
+	traceGeneral.printf(1, "trace: object %s: %s %s %d", action, hash, objectType, size)
+	trace2("data", map[string]any{"category": "object", "key": action, "value": fmt.Sprintf("%s %s %d", hash, objectType, size), "nesting": 1})
+}
+
+// tracePacketLine reports a pkt-line sent (out) or received, as
+// GIT_TRACE_PACKET does.
+func tracePacketLine(out bool, data []byte, flush bool) {
+	if !tracePacket.enabled() {
+		return
+	}
+	direction := '<'
+	if out {
+		direction = '>'
+	}
+	var b strings.Builder
+	if flush {
+		b.WriteString("0000")
+	}
+	for _, c := range []byte(strings.TrimSuffix(string(data), "\n")) {
+		if c < ' ' || c >= 0x7f {
+			fmt.Fprintf(&b, "\\%o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	tracePacket.printf(1, "packet: %12s%c %s", "mygit", direction, b.String())
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// prefixes of trailers git itself adds, a block containing one of them is
+// recognized even when most of its lines are not trailers
+var gitGeneratedTrailerPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+const defaultTrailerSeparators = ":"
+
+type trailerConf struct {
+	Where     string
+	IfExists  string
+	IfMissing string
+}
+
+type trailerItem struct {
+	// Token is empty for the non-trailer lines of a trailer block
+	Token string
+	Value string
+}
+
+type trailerArg struct {
+	trailerItem
+	Conf trailerConf
+}
+
+// trailerInfo splits a message into the text before the trailer block, the
+// block itself and what follows it (blank lines, comments, a patch).
+type trailerInfo struct {
+	Before          string
+	Items           []trailerItem
+	After           string
+	BlankLineBefore bool
+	separators      string
+}
+
+type trailerOptions struct {
+	OnlyTrailers bool
+	OnlyInput    bool
+	TrimEmpty    bool
+	Unfold       bool
+	NoDivider    bool
+}
+
+type trailerConfig struct {
+	Separators string
+	Default    trailerConf
+	// Tokens maps trailer.<name>.key values (or the names) to their settings
+	Tokens []trailerTokenConf
+}
+
+type trailerTokenConf struct {
+	Name string
+	Key  string
+	Conf trailerConf
+}
+
+func loadTrailerConfig() (*trailerConfig, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trailerConfig{
+		Separators: defaultTrailerSeparators,
+		Default:    trailerConf{Where: "end", IfExists: "addIfDifferentNeighbor", IfMissing: "add"},
+	}
+	if separators, ok := config.Get("trailer.separators"); ok && separators != "" {
+		tc.Separators = separators
+	}
+	for _, setting := range []struct {
+		key    string
+		target *string
+	}{{"trailer.where", &tc.Default.Where}, {"trailer.ifexists", &tc.Default.IfExists}, {"trailer.ifmissing", &tc.Default.IfMissing}} {
+		if value, ok := config.Get(setting.key); ok {
+			*setting.target = value
+		}
+	}
+
+	byName := map[string]int{}
+	for _, e := range config.entries {
+		if e.Section != "trailer" || e.Subsection == "" {
+			continue
+		}
+		i, ok := byName[e.Subsection]
+		if !ok {
+			i = len(tc.Tokens)
+			byName[e.Subsection] = i
+			tc.Tokens = append(tc.Tokens, trailerTokenConf{Name: e.Subsection, Key: e.Subsection})
+		}
+		switch e.Key {
+		case "key":
+			tc.Tokens[i].Key = e.Value
+		case "where":
+			tc.Tokens[i].Conf.Where = e.Value
+		case "ifexists":
+			tc.Tokens[i].Conf.IfExists = e.Value
+		case "ifmissing":
+			tc.Tokens[i].Conf.IfMissing = e.Value
+		}
+	}
+	return tc, nil
+}
+
+// tokenConf finds the configured trailer a token refers to, matching a
+// prefix of the key or name case-insensitively like git.
+func (tc *trailerConfig) tokenConf(token string) *trailerTokenConf {
+	for i := range tc.Tokens {
+		t := &tc.Tokens[i]
+		key := strings.TrimRight(t.Key, tc.Separators+" ")
+		if strings.EqualFold(token, key) || strings.EqualFold(token, t.Name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// findTrailerSeparator returns the position of the separator after a token of
+// alphanumerics and dashes, optionally followed by whitespace, or -1.
+func findTrailerSeparator(line string, separators string) int {
+	whitespaceFound := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if strings.IndexByte(separators, c) != -1 {
+			return i
+		}
+		if !whitespaceFound && (isAlnum(c) || c == '-') {
+			continue
+		}
+		if i != 0 && (c == ' ' || c == '\t') {
+			whitespaceFound = true
+			continue
+		}
+		break
+	}
+	return -1
+}
+
+func isAlnum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// messageLines splits a message into lines keeping their terminators.
+func messageLines(message string) []string {
+	return splitLines([]byte(message))
+}
+
+// findPatchStart returns the offset of a "---" divider line, or len(message).
+func findPatchStart(lines []string) int {
+	offset := 0
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "---"); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r') {
+			return offset
+		}
+		offset += len(line)
+	}
+	return offset
+}
+
+// parseTrailerInfo locates the trailer block: the last paragraph (never the
+// title) whose lines are all trailers, or that holds a git-generated or
+// configured trailer and consists of at least 25% trailers.
+func parseTrailerInfo(message string, tc *trailerConfig, opts trailerOptions) *trailerInfo {
+	lines := messageLines(message)
+
+	messageEnd := len(message)
+	if !opts.NoDivider {
+		messageEnd = findPatchStart(lines)
+	}
+	// trailing comments and blank lines are outside the block
+	lastLine := 0
+	offset := 0
+	for i, line := range lines {
+		if offset >= messageEnd {
+			break
+		}
+		lastLine = i + 1
+		offset += len(line)
+	}
+	blockEnd := messageEnd
+	for lastLine > 0 && (isBlankLine(lines[lastLine-1]) || strings.HasPrefix(lines[lastLine-1], "#")) {
+		lastLine--
+		blockEnd -= len(lines[lastLine])
+	}
+
+	// the first paragraph is the title and cannot be trailers
+	titleEnd := 0
+	for titleEnd < lastLine && (strings.HasPrefix(lines[titleEnd], "#") || !isBlankLine(lines[titleEnd])) {
+		titleEnd++
+	}
+
+	blockStartLine := lastLine
+	trailerLines, nonTrailerLines, possibleContinuationLines := 0, 0, 0
+	recognized, onlySpaces := false, true
+	for l := lastLine - 1; l >= titleEnd; l-- {
+		line := lines[l]
+		if strings.HasPrefix(line, "#") {
+			nonTrailerLines += possibleContinuationLines
+			possibleContinuationLines = 0
+			continue
+		}
+		if isBlankLine(line) {
+			if onlySpaces {
+				continue
+			}
+			nonTrailerLines += possibleContinuationLines
+			if (recognized && trailerLines*3 >= nonTrailerLines) || (trailerLines > 0 && nonTrailerLines == 0) {
+				blockStartLine = l + 1
+			}
+			break
+		}
+		onlySpaces = false
+
+		generated := false
+		for _, prefix := range gitGeneratedTrailerPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				generated = true
+			}
+		}
+		separatorPos := findTrailerSeparator(line, tc.Separators)
+		switch {
+		case generated:
+			trailerLines++
+			possibleContinuationLines = 0
+			recognized = true
+		case separatorPos >= 1 && line[0] != ' ' && line[0] != '\t':
+			trailerLines++
+			possibleContinuationLines = 0
+			if tc.tokenConf(strings.TrimSpace(line[:separatorPos])) != nil {
+				recognized = true
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			possibleContinuationLines++
+		default:
+			nonTrailerLines += 1 + possibleContinuationLines
+			possibleContinuationLines = 0
+		}
+	}
+
+	blockStart := 0
+	for _, line := range lines[:blockStartLine] {
+		blockStart += len(line)
+	}
+	if blockStartLine == lastLine {
+		blockStart = blockEnd
+	}
+	info := &trailerInfo{
+		Before:     message[:blockStart],
+		After:      message[blockEnd:],
+		separators: tc.Separators,
+	}
+	beforeLines := messageLines(info.Before)
+	info.BlankLineBefore = len(beforeLines) > 0 && isBlankLine(beforeLines[len(beforeLines)-1])
+
+	// continuation lines are folded into the trailer before them
+	last := -1
+	rawItems := make([]string, 0)
+	for _, line := range lines[blockStartLine:lastLine] {
+		if last != -1 && (line[0] == ' ' || line[0] == '\t') {
+			rawItems[last] += line
+			continue
+		}
+		rawItems = append(rawItems, line)
+		last = -1
+		if findTrailerSeparator(line, tc.Separators) >= 1 {
+			last = len(rawItems) - 1
+		}
+	}
+	for _, raw := range rawItems {
+		if strings.HasPrefix(raw, "#") {
+			continue
+		}
+		separatorPos := findTrailerSeparator(raw, tc.Separators)
+		if separatorPos < 1 {
+			info.Items = append(info.Items, trailerItem{Value: strings.TrimRight(raw, "\n")})
+			continue
+		}
+		item := trailerItem{
+			Token: strings.TrimSpace(raw[:separatorPos]),
+			Value: strings.TrimSpace(raw[separatorPos+1:]),
+		}
+		if t := tc.tokenConf(item.Token); t != nil {
+			item.Token = t.Key
+		}
+		info.Items = append(info.Items, item)
+	}
+	return info
+}
+
+// parseTrailerArg parses a --trailer argument, which may also use '=' as
+// separator.
+func parseTrailerArg(arg string, tc *trailerConfig, conf trailerConf) (trailerArg, error) {
+	separatorPos := findTrailerSeparator(arg, tc.Separators+"=")
+	if separatorPos == 0 {
+		return trailerArg{}, fmt.Errorf("empty trailer token in trailer '%s'", arg)
+	}
+	item := trailerItem{Token: strings.TrimSpace(arg)}
+	if separatorPos > 0 {
+		item.Token = strings.TrimSpace(arg[:separatorPos])
+		item.Value = strings.TrimSpace(arg[separatorPos+1:])
+	}
+	if t := tc.tokenConf(item.Token); t != nil {
+		item.Token = t.Key
+		for _, setting := range []struct{ configured, target *string }{
+			{&t.Conf.Where, &conf.Where}, {&t.Conf.IfExists, &conf.IfExists}, {&t.Conf.IfMissing, &conf.IfMissing},
+		} {
+			if *setting.configured != "" && *setting.target == "" {
+				*setting.target = *setting.configured
+			}
+		}
+	}
+	for _, setting := range []struct{ target, fallback *string }{
+		{&conf.Where, &tc.Default.Where}, {&conf.IfExists, &tc.Default.IfExists}, {&conf.IfMissing, &tc.Default.IfMissing},
+	} {
+		if *setting.target == "" {
+			*setting.target = *setting.fallback
+		}
+	}
+	return trailerArg{trailerItem: item, Conf: conf}, nil
+}
+
+func tokensMatch(a string, b string, separators string) bool {
+	a, b = strings.TrimRight(a, separators), strings.TrimRight(b, separators)
+	n := min(len(a), len(b))
+	return strings.EqualFold(a[:n], b[:n])
+}
+
+func (info *trailerInfo) sameTrailer(item trailerItem, arg trailerArg) bool {
+	return item.Token != "" && tokensMatch(item.Token, arg.Token, info.separators) && strings.EqualFold(item.Value, arg.Value)
+}
+
+func (info *trailerInfo) insertAt(i int, arg trailerArg) {
+	info.Items = append(info.Items, trailerItem{})
+	copy(info.Items[i+1:], info.Items[i:])
+	info.Items[i] = arg.trailerItem
+}
+
+// apply adds a --trailer argument to the block following its where, ifExists
+// and ifMissing settings.
+func (info *trailerInfo) apply(arg trailerArg) {
+	where := strings.ToLower(arg.Conf.Where)
+	afterOrEnd := where == "after" || where == "end"
+	middle := where == "after" || where == "before"
+
+	// look for an existing trailer with the same token, from the end when
+	// adding after
+	found := -1
+	for k := range info.Items {
+		i := k
+		if afterOrEnd {
+			i = len(info.Items) - 1 - k
+		}
+		if info.Items[i].Token != "" && tokensMatch(info.Items[i].Token, arg.Token, info.separators) {
+			found = i
+			break
+		}
+	}
+
+	if found == -1 {
+		if strings.ToLower(arg.Conf.IfMissing) == "donothing" {
+			return
+		}
+		if afterOrEnd {
+			info.Items = append(info.Items, arg.trailerItem)
+		} else {
+			info.insertAt(0, arg)
+		}
+		return
+	}
+
+	on := 0
+	if afterOrEnd {
+		on = len(info.Items) - 1
+	}
+	if middle {
+		on = found
+	}
+	insertPos := on
+	if afterOrEnd {
+		insertPos = on + 1
+	}
+	insert := func() { info.insertAt(insertPos, arg) }
+
+	switch strings.ToLower(arg.Conf.IfExists) {
+	case "donothing":
+	case "replace":
+		insert()
+		if insertPos <= found {
+			found++
+		}
+		info.Items = append(info.Items[:found], info.Items[found+1:]...)
+	case "add":
+		insert()
+	case "addifdifferent":
+		for _, item := range info.Items {
+			if info.sameTrailer(item, arg) {
+				return
+			}
+		}
+		insert()
+	default:
+		// addIfDifferentNeighbor
+		if !info.sameTrailer(info.Items[on], arg) {
+			insert()
+		}
+	}
+}
+
+func unfoldTrailerValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func (info *trailerInfo) formatItem(item trailerItem) string {
+	if item.Token == "" {
+		return item.Value + "\n"
+	}
+	// a configured key may already end in a separator, like "Signed-off-by: "
+	trimmed := strings.TrimRight(item.Token, " \t")
+	if trimmed == "" {
+		return ""
+	}
+	if strings.IndexByte(info.separators, trimmed[len(trimmed)-1]) != -1 {
+		return item.Token + item.Value + "\n"
+	}
+	return item.Token + info.separators[:1] + " " + item.Value + "\n"
+}
+
+func (info *trailerInfo) write(w io.Writer, opts trailerOptions) {
+	if !opts.OnlyTrailers {
+		io.WriteString(w, info.Before)
+		if !info.BlankLineBefore {
+			io.WriteString(w, "\n")
+		}
+	}
+	for _, item := range info.Items {
+		if opts.Unfold {
+			item.Value = unfoldTrailerValue(item.Value)
+		}
+		if opts.TrimEmpty && item.Token != "" && item.Value == "" {
+			continue
+		}
+		if opts.OnlyTrailers && item.Token == "" {
+			continue
+		}
+		io.WriteString(w, info.formatItem(item))
+	}
+	if !opts.OnlyTrailers {
+		io.WriteString(w, info.After)
+	}
+}
+
+// addTrailers applies trailer arguments to a message and returns the result.
+func addTrailers(message string, args []trailerArg, tc *trailerConfig, opts trailerOptions) string {
+	info := parseTrailerInfo(message, tc, opts)
+	if !opts.OnlyInput {
+		for _, arg := range args {
+			info.apply(arg)
+		}
+	}
+	var b strings.Builder
+	info.write(&b, opts)
+	return b.String()
+}
+
+// signOffTrailer returns the Signed-off-by trailer for the committer.
+func signOffTrailer(tc *trailerConfig) (trailerArg, error) {
+	ident, err := currentIdentity("COMMITTER")
+	if err != nil {
+		return trailerArg{}, err
+	}
+	return parseTrailerArg(fmt.Sprintf("Signed-off-by: %s <%s>", ident.Name, ident.Email), tc, trailerConf{})
+}
+
+func runInterpretTrailers(args []string) error {
+	tc, err := loadTrailerConfig()
+	if err != nil {
+		return err
+	}
+	opts := trailerOptions{}
+	inPlace := false
+	conf := trailerConf{}
+	trailerArgs := make([]trailerArg, 0)
+	files := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		needsValue := name == "--trailer" || name == "--where" || name == "--if-exists" || name == "--if-missing"
+		if needsValue && !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("option %s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch {
+		case name == "--trailer":
+			trailer, err := parseTrailerArg(value, tc, conf)
+			if err != nil {
+				// like git, a bad trailer is reported but does not stop the others
+				fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+				continue
+			}
+			trailerArgs = append(trailerArgs, trailer)
+		case name == "--where":
+			conf.Where = value
+		case name == "--if-exists":
+			conf.IfExists = value
+		case name == "--if-missing":
+			conf.IfMissing = value
+		case arg == "--no-where":
+			conf.Where = ""
+		case arg == "--no-if-exists":
+			conf.IfExists = ""
+		case arg == "--no-if-missing":
+			conf.IfMissing = ""
+		case arg == "--in-place":
+			inPlace = true
+		case arg == "--trim-empty":
+			opts.TrimEmpty = true
+		case arg == "--only-trailers":
+			opts.OnlyTrailers = true
+		case arg == "--only-input":
+			opts.OnlyInput = true
+		case arg == "--unfold":
+			opts.Unfold = true
+		case arg == "--parse":
+			opts.OnlyTrailers, opts.OnlyInput, opts.Unfold = true, true, true
+		case arg == "--no-divider":
+			opts.NoDivider = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if opts.OnlyInput && len(trailerArgs) > 0 {
+		return fmt.Errorf("--trailer with --only-input does not make sense")
+	}
+
+	if len(files) == 0 {
+		if inPlace {
+			return fmt.Errorf("no input file given for in-place editing")
+		}
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read standard input: %s", err.Error())
+		}
+		fmt.Print(addTrailers(string(input), trailerArgs, tc, opts))
+		return nil
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, file := range files {
+		file = prefixFilename(file)
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read input file '%s': %s", file, err.Error())
+		}
+		output := addTrailers(string(input), trailerArgs, tc, opts)
+		if inPlace {
+			if err := writeFileAtomic(file, []byte(output)); err != nil {
+				return fmt.Errorf("could not write %s: %s", file, err.Error())
+			}
+			continue
+		}
+		w.WriteString(output)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeTempFile writes content to a new file in dir, named by pattern as
+// for os.CreateTemp, and returns its path. The file is left for the caller
+// to remove.
+func writeTempFile(dir string, pattern string, content []byte) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %s", err.Error())
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write %s: %s", f.Name(), err.Error())
+	}
+	return f.Name(), nil
+}
+
+// unpackBlob writes the content of a blob to a new file in dir, named by
+// pattern, and returns its path. When path is given the content goes
+// through its smudge filter, so it reads like the checked out file.
+func unpackBlob(dir string, pattern string, hash string, path string) (string, error) {
+	object, err := parseObject(hash)
+	if err != nil {
+		return "", err
+	}
+	if object.Type != TypeBlob {
+		return "", fmt.Errorf("unable to read blob object %s", hash)
+	}
+	content := object.Content
+	if path != "" {
+		if content, err = applyFilter("smudge", path, content); err != nil {
+			return "", err
+		}
+	}
+	return writeTempFile(dir, pattern, content)
+}
+
+// runUnpackFile writes a blob to a .merge_file_XXXXXX file at the top of
+// the worktree and prints its name, for scripts that need a file holding
+// some historical content.
+func runUnpackFile(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mygit unpack-file <blob>")
+	}
+	hash, err := resolveRevision(args[0])
+	if err != nil {
+		return fmt.Errorf("not a valid object name %s", args[0])
+	}
+	name, err := unpackBlob(".", ".merge_file_*", hash, "")
+	if err != nil {
+		return err
+	}
+	fmt.Println(filepath.Base(name))
+	return nil
+}
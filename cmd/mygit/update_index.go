@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const updateIndexUsage = "mygit update-index [--[no-]assume-unchanged] [--[no-]skip-worktree] [--] <file>..."
+
+// runUpdateIndex sets or clears the assume-unchanged and skip-worktree bits
+// of index entries. As in git, each option applies to the paths after it.
+func runUpdateIndex(args []string) error {
+	var assumeUnchanged, skipWorktree *bool
+	yes, no := true, false
+	index, lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+
+	dashDash := false
+	for _, arg := range args {
+		if !dashDash && strings.HasPrefix(arg, "-") {
+			switch arg {
+			case "--":
+				dashDash = true
+			case "--assume-unchanged":
+				assumeUnchanged = &yes
+			case "--no-assume-unchanged":
+				assumeUnchanged = &no
+			case "--skip-worktree":
+				skipWorktree = &yes
+			case "--no-skip-worktree":
+				skipWorktree = &no
+			default:
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			continue
+		}
+		if assumeUnchanged == nil && skipWorktree == nil {
+			return fmt.Errorf("usage: %s", updateIndexUsage)
+		}
+		i := index.find(filepath.ToSlash(prefixFilename(filepath.Clean(arg))))
+		if i == -1 {
+			return fmt.Errorf("Unable to mark file %s", arg)
+		}
+		e := &index.Entries[i]
+		if assumeUnchanged != nil {
+			e.Flags &^= indexFlagAssumeValid
+			if *assumeUnchanged {
+				e.Flags |= indexFlagAssumeValid
+			}
+		}
+		if skipWorktree != nil {
+			e.ExtendedFlags &^= indexFlagSkipWorktree
+			if *skipWorktree {
+				e.ExtendedFlags |= indexFlagSkipWorktree
+			}
+		}
+	}
+	return writeIndex(index, lock)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// updateRefTarget returns the ref to change for name: the ref it points to,
+// unless noDeref asks to change a symbolic ref itself.
+func updateRefTarget(name string, noDeref bool) (string, error) {
+	if noDeref {
+		return name, nil
+	}
+	_, target, err := resolveRef(name)
+	if err != nil && err != errRefNotFound {
+		return "", err
+	}
+	return target, nil
+}
+
+// parseUpdateRefValue resolves an object name given to update-ref. The zero
+// hash comes back as "", a ref that must not exist.
+func parseUpdateRefValue(command string, ref string, what string, value string) (string, error) {
+	if value == zeroHash {
+		return "", nil
+	}
+	hash, err := resolveRevision(value)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: invalid <%s>: %s", command, ref, what, value)
+	}
+	return hash, nil
+}
+
+func runUpdateRef(args []string) error {
+	message := ""
+	deleteRef, noDeref, fromStdin, nulTerminated := false, false, false, false
+	flags := newFlagSet()
+	flags.String(&message, "-m")
+	flags.Bool(&deleteRef, "-d")
+	flags.Bool(&noDeref, "--no-deref")
+	flags.Bool(&fromStdin, "--stdin")
+	flags.Bool(&nulTerminated, "-z")
+	args, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(message, "\n") {
+		return fmt.Errorf("refusing to perform update with newline in message")
+	}
+	if fromStdin {
+		if deleteRef || len(args) != 0 {
+			return fmt.Errorf("usage: mygit update-ref [-m <reason>] [--no-deref] [-z] --stdin")
+		}
+		return updateRefsFromStdin(bufio.NewReader(os.Stdin), message, nulTerminated)
+	}
+	if nulTerminated {
+		return fmt.Errorf("-z only makes sense with --stdin")
+	}
+
+	if deleteRef && (len(args) < 1 || len(args) > 2) || !deleteRef && (len(args) < 2 || len(args) > 3) {
+		return fmt.Errorf("usage: mygit update-ref [-m <reason>] [--no-deref] (-d <ref> [<old-oid>] | <ref> <new-oid> [<old-oid>])")
+	}
+	name, err := updateRefTarget(args[0], noDeref)
+	if err != nil {
+		return err
+	}
+	oldArg := ""
+	if deleteRef && len(args) == 2 {
+		oldArg = args[1]
+	} else if !deleteRef && len(args) == 3 {
+		oldArg = args[2]
+	}
+	old, err := parseUpdateRefValue("update", args[0], "old-oid", oldArg)
+	if oldArg != "" && err != nil {
+		return err
+	}
+
+	t := beginRefTransaction()
+	if deleteRef {
+		if oldArg != "" && old == "" {
+			return fmt.Errorf("delete %s: zero <old-oid>", args[0])
+		}
+		err = t.delete(name, oldArg != "", old)
+	} else {
+		var value string
+		value, err = parseUpdateRefValue("update", args[0], "new-oid", args[1])
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			err = t.delete(name, oldArg != "", old)
+		} else {
+			err = t.update(name, value, oldArg != "", old, message)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// updateRefReader reads update-ref --stdin commands, either one per line
+// with space separated arguments, or with -z as NUL terminated fields where
+// an empty field is a missing value.
+type updateRefReader struct {
+	r             *bufio.Reader
+	nulTerminated bool
+	fields        []string
+}
+
+func (u *updateRefReader) readToken() (string, error) {
+	token, err := u.r.ReadString(0)
+	if err == io.EOF && token != "" {
+		return "", fmt.Errorf("unterminated -z input: %s", token)
+	}
+	return strings.TrimSuffix(token, "\x00"), err
+}
+
+// next returns the command and its first argument, with the rest of its
+// arguments available from arg.
+func (u *updateRefReader) next() (string, string, error) {
+	var line string
+	var err error
+	if u.nulTerminated {
+		line, err = u.readToken()
+	} else {
+		line, err = u.r.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+	}
+	if err != nil {
+		return "", "", err
+	}
+	command, rest, _ := strings.Cut(line, " ")
+	if u.nulTerminated {
+		u.fields = nil
+		return command, rest, nil
+	}
+	u.fields = strings.Split(rest, " ")
+	if rest == "" {
+		u.fields = nil
+	}
+	first := ""
+	if len(u.fields) > 0 {
+		first, u.fields = u.fields[0], u.fields[1:]
+	}
+	return command, first, nil
+}
+
+// arg returns the next argument of the current command, "" when missing.
+func (u *updateRefReader) arg() (string, error) {
+	if u.nulTerminated {
+		token, err := u.readToken()
+		if err == io.EOF {
+			return "", fmt.Errorf("unexpected end of input")
+		}
+		return token, err
+	}
+	if len(u.fields) == 0 {
+		return "", nil
+	}
+	value := u.fields[0]
+	u.fields = u.fields[1:]
+	return value, nil
+}
+
+// updateRefsFromStdin runs the commands read from r in ref transactions:
+// one for everything when no "start" is given, or as delimited by
+// start/prepare/commit/abort.
+func updateRefsFromStdin(r *bufio.Reader, message string, nulTerminated bool) error {
+	in := &updateRefReader{r: r, nulTerminated: nulTerminated}
+	var t *refTransaction
+	defer func() {
+		if t != nil {
+			t.abort()
+		}
+	}()
+	noDeref := false
+	for {
+		command, ref, err := in.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			t = beginRefTransaction()
+		}
+
+		switch command {
+		case "start", "prepare", "commit", "abort":
+			if ref != "" {
+				return fmt.Errorf("%s: extra input: %s", command, ref)
+			}
+			switch command {
+			case "prepare":
+				err = t.prepare()
+			case "commit":
+				err = t.commit()
+				t = nil
+			case "abort":
+				t.abort()
+				t = nil
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: ok\n", command)
+			continue
+		case "option":
+			if ref != "no-deref" {
+				return fmt.Errorf("option unknown: %s", ref)
+			}
+			noDeref = true
+			continue
+		case "update", "create", "delete", "verify":
+		default:
+			return fmt.Errorf("unknown command: %s", command)
+		}
+
+		if ref == "" {
+			return fmt.Errorf("%s: missing <ref>", command)
+		}
+		name, err := updateRefTarget(ref, noDeref)
+		if err != nil {
+			return err
+		}
+		noDeref = false
+
+		values := make([]string, 0, 2)
+		switch command {
+		case "update":
+			for i := 0; i < 2; i++ {
+				value, err := in.arg()
+				if err != nil {
+					return err
+				}
+				values = append(values, value)
+			}
+			if values[0] == "" && !nulTerminated {
+				return fmt.Errorf("update %s: missing <new-oid>", ref)
+			}
+		default:
+			value, err := in.arg()
+			if err != nil {
+				return err
+			}
+			if value == "" && command == "create" {
+				return fmt.Errorf("create %s: missing <new-oid>", ref)
+			}
+			values = append(values, value)
+		}
+		if len(in.fields) > 0 {
+			return fmt.Errorf("%s %s: extra input: %s", command, ref, strings.Join(in.fields, " "))
+		}
+
+		resolved := make([]string, len(values))
+		for i, value := range values {
+			if value == "" {
+				continue
+			}
+			what := "old-oid"
+			if i == 0 && (command == "update" || command == "create") {
+				what = "new-oid"
+			}
+			if resolved[i], err = parseUpdateRefValue(command, ref, what, value); err != nil {
+				return err
+			}
+		}
+
+		switch command {
+		case "update":
+			if resolved[0] == "" {
+				err = t.delete(name, values[1] != "", resolved[1])
+			} else {
+				err = t.update(name, resolved[0], values[1] != "", resolved[1], message)
+			}
+		case "create":
+			if resolved[0] == "" {
+				return fmt.Errorf("create %s: zero <new-oid>", ref)
+			}
+			err = t.create(name, resolved[0], message)
+		case "delete":
+			if values[0] != "" && resolved[0] == "" {
+				return fmt.Errorf("delete %s: zero <old-oid>", ref)
+			}
+			err = t.delete(name, values[0] != "", resolved[0])
+		case "verify":
+			err = t.verify(name, resolved[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// commit unless the input prepared a transaction it did not commit
+	if t == nil || t.state == refTransactionPrepared {
+		return nil
+	}
+	err := t.commit()
+	t = nil
+	return err
+}
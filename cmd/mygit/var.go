@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+type gitVariable struct {
+	Name  string
+	Value func(config *Config) (string, error)
+}
+
+var gitVariables = []gitVariable{
+	{"GIT_COMMITTER_IDENT", func(*Config) (string, error) { return identVariable("COMMITTER") }},
+	{"GIT_AUTHOR_IDENT", func(*Config) (string, error) { return identVariable("AUTHOR") }},
+	{"GIT_EDITOR", func(config *Config) (string, error) { return editorCommand(config), nil }},
+	{"GIT_PAGER", func(config *Config) (string, error) {
+		return firstSetting(config, "GIT_PAGER", "core.pager", "PAGER", "", "less"), nil
+	}},
+	{"GIT_DEFAULT_BRANCH", func(config *Config) (string, error) {
+		// init always creates main unless init.defaultBranch says otherwise
+		return firstSetting(config, "", "init.defaultBranch", "", "", "main"), nil
+	}},
+}
+
+func identVariable(kind string) (string, error) {
+	ident, err := currentIdentity(kind)
+	if err != nil {
+		return "", err
+	}
+	return ident.String(), nil
+}
+
+// firstSetting returns the first non-empty value of the environment variable
+// env, the config key, the two fallback environment variables and def.
+func firstSetting(config *Config, env string, key string, fallback1 string, fallback2 string, def string) string {
+	if value := os.Getenv(env); env != "" && value != "" {
+		return value
+	}
+	if value, ok := config.Get(key); ok && value != "" {
+		return value
+	}
+	for _, name := range []string{fallback1, fallback2} {
+		if value := os.Getenv(name); name != "" && value != "" {
+			return value
+		}
+	}
+	return def
+}
+
+func runVar(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mygit var (-l | <variable>)")
+	}
+	config, err := getConfig()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "-l" {
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		for _, e := range config.entries {
+			fmt.Fprintln(w, formatConfigEntry(e))
+		}
+		for _, v := range gitVariables {
+			value, err := v.Value(config)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s=%s\n", v.Name, value)
+		}
+		return nil
+	}
+
+	for _, v := range gitVariables {
+		if v.Name == args[0] {
+			value, err := v.Value(config)
+			if err != nil {
+				return err
+			}
+			fmt.Println(value)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown variable %s", args[0])
+}
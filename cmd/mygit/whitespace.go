@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wsRule is a set of the whitespace errors checked for in added lines.
+type wsRule uint
+
+const (
+	wsBlankAtEOL wsRule = 1 << iota
+	wsSpaceBeforeTab
+	wsBlankAtEOF
+	// wsCRAtEOL is not an error but lets a line end in CR LF without the CR
+	// counting as trailing whitespace
+	wsCRAtEOL
+)
+
+const wsDefaultRule = wsBlankAtEOL | wsSpaceBeforeTab | wsBlankAtEOF
+
+var wsRuleNames = map[string]wsRule{
+	"blank-at-eol":     wsBlankAtEOL,
+	"space-before-tab": wsSpaceBeforeTab,
+	"blank-at-eof":     wsBlankAtEOF,
+	"trailing-space":   wsBlankAtEOL | wsBlankAtEOF,
+	"cr-at-eol":        wsCRAtEOL,
+}
+
+// wsErrorNames describes the errors, in the order they are listed.
+var wsErrorNames = []struct {
+	rule wsRule
+	name string
+}{
+	{wsBlankAtEOL, "trailing whitespace"},
+	{wsSpaceBeforeTab, "space before tab in indent"},
+	{wsBlankAtEOF, "new blank line at EOF"},
+}
+
+// parseWhitespaceRule applies a core.whitespace value, a comma separated
+// list of errors to check for with "-" turning one off, to the default
+// rule. Like git, names it does not know are ignored.
+func parseWhitespaceRule(value string) wsRule {
+	rule := wsDefaultRule
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		off := strings.HasPrefix(name, "-")
+		bits, ok := wsRuleNames[strings.TrimPrefix(name, "-")]
+		switch {
+		case !ok:
+		case off:
+			rule &^= bits
+		default:
+			rule |= bits
+		}
+	}
+	return rule
+}
+
+// whitespaceRuleFor is the rule for a path: its whitespace attribute when
+// set, which checks for every error, unset or lists them, and core.whitespace
+// otherwise.
+func whitespaceRuleFor(path string) wsRule {
+	value, err := getAttribute(path, "whitespace")
+	if err != nil {
+		value = attrUnspecified
+	}
+	switch value {
+	case attrSet:
+		return wsBlankAtEOL | wsSpaceBeforeTab | wsBlankAtEOF
+	case attrUnset:
+		return 0
+	case attrUnspecified:
+		if config, err := getConfig(); err == nil {
+			value, _ = config.Get("core.whitespace")
+		}
+	}
+	return parseWhitespaceRule(value)
+}
+
+// checkWhitespace finds the errors in an added line and paints it for a
+// patch, the parts in error in the whitespace color. Like git, a tab after
+// spaces in the indent is left unpainted.
+func checkWhitespace(line string, rule wsRule, colors colorPalette) (wsRule, string) {
+	line = strings.TrimSuffix(line, "\n")
+	cr := ""
+	if rule&wsCRAtEOL != 0 && strings.HasSuffix(line, "\r") {
+		line, cr = line[:len(line)-1], "\r"
+	}
+	found := wsRule(0)
+	trailing := len(line)
+	if rule&wsBlankAtEOL != 0 {
+		trailing = len(strings.TrimRight(line, " \t\r\v\f"))
+		if trailing < len(line) {
+			found |= wsBlankAtEOL
+		}
+	}
+
+	var b strings.Builder
+	written := 0
+	for i := 0; i < len(line) && (line[i] == ' ' || line[i] == '\t'); i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		spaced := rule&wsSpaceBeforeTab != 0 && written < i
+		if spaced {
+			found |= wsSpaceBeforeTab
+		}
+		// an indent of only whitespace is painted as trailing whitespace
+		if i >= trailing {
+			continue
+		}
+		if spaced {
+			b.WriteString(colors.paint("whitespace", line[written:i]))
+			b.WriteByte('\t')
+		} else {
+			b.WriteString(line[written : i+1])
+		}
+		written = i + 1
+	}
+	if written < trailing {
+		b.WriteString(colors.paint("new", line[written:trailing]))
+	}
+	if trailing < len(line) {
+		b.WriteString(colors.paint("whitespace", line[trailing:]))
+	}
+	b.WriteString(cr)
+	return found, b.String()
+}
+
+// describeWhitespaceErrors lists the errors found, as in "trailing
+// whitespace, space before tab in indent".
+func describeWhitespaceErrors(found wsRule) string {
+	names := make([]string, 0, 2)
+	for _, e := range wsErrorNames {
+		if found&e.rule != 0 {
+			names = append(names, e.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeWhitespaceCheck reports each whitespace error the changes add as
+// "<path>:<line>: <errors>." followed by the line, as --check does, and tells
+// whether there were any. Binary files are not checked.
+func writeWhitespaceCheck(w io.Writer, changes []fileChange, colors colorPalette) (bool, error) {
+	found := false
+	for _, c := range changes {
+		if c.Status == 'D' || c.NewMode == modeGitlink {
+			continue
+		}
+		rule := whitespaceRuleFor(c.Path)
+		if rule&^wsCRAtEOL == 0 {
+			continue
+		}
+		oldBlob, err := readBlobForDiff(c.OldHash, c.OldMode)
+		if err != nil {
+			return false, err
+		}
+		newBlob, err := readBlobForDiff(c.NewHash, c.NewMode)
+		if err != nil {
+			return false, err
+		}
+		if oldBlob.isBinary() || newBlob.isBinary() {
+			continue
+		}
+		ops := compactedDiff(splitLines(oldBlob.Content), splitLines(newBlob.Content))
+
+		// the blank lines added last, with nothing but deletions after them,
+		// are new blank lines at the end of the file
+		blankAtEOF := len(ops)
+		for i := len(ops) - 1; i >= 0; i-- {
+			if ops[i].Kind == '-' {
+				continue
+			}
+			if ops[i].Kind != '+' || !isBlankLine(ops[i].Line) {
+				break
+			}
+			blankAtEOF = i
+		}
+
+		lineNo, blankLineNo := 0, 0
+		for i, op := range ops {
+			if op.Kind == '-' {
+				continue
+			}
+			lineNo++
+			if i == blankAtEOF {
+				blankLineNo = lineNo
+			}
+			if op.Kind != '+' {
+				continue
+			}
+			errs, painted := checkWhitespace(op.Line, rule, colors)
+			if errs == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s:%d: %s.\n", c.Path, lineNo, describeWhitespaceErrors(errs))
+			fmt.Fprintln(w, colors.paint("new", "+")+painted)
+			found = true
+		}
+		if blankLineNo > 0 && rule&wsBlankAtEOF != 0 {
+			fmt.Fprintf(w, "%s:%d: %s.\n", c.Path, blankLineNo, describeWhitespaceErrors(wsBlankAtEOF))
+			found = true
+		}
+	}
+	return found, nil
+}
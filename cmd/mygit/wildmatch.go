@@ -0,0 +1,182 @@
+package main
+
+import "strings"
+
+// wildmatch matches a path against a git-style glob: '*' and '?' never match
+// '/', while a "**" component matches any number of directories.
+func wildmatch(pattern string, name string) bool {
+	return wildmatchFrom(pattern, name, false)
+}
+
+func wildmatchFold(pattern string, name string) bool {
+	return wildmatchFrom(pattern, name, true)
+}
+
+func wildmatchFrom(pattern string, name string, fold bool) bool {
+	return wildmatchAt(pattern, 0, name, fold, true) == wmMatch
+}
+
+// fnmatch matches like fnmatch(3) without FNM_PATHNAME: wildcards match '/'
+// too, as in pathspecs without glob magic.
+func fnmatch(pattern string, name string) bool {
+	return wildmatchAt(pattern, 0, name, false, false) == wmMatch
+}
+
+// results of wildmatchAt, as in git: besides matching or not, a failure can
+// tell the '*' being tried further out that no later position can match
+// either, so that patterns with many stars do not take exponential time.
+const (
+	wmMatch = iota
+	wmNoMatch
+	// the name ran out: a later start only leaves less of it
+	wmAbortAll
+	// a '*' reached a '/': only an enclosing "**" can carry on past it
+	wmAbortToStarStar
+)
+
+func wildmatchAt(pattern string, p int, name string, fold bool, pathname bool) int {
+	for p < len(pattern) {
+		c := pattern[p]
+		if len(name) == 0 && c != '*' {
+			return wmAbortAll
+		}
+		switch c {
+		case '?':
+			if pathname && name[0] == '/' {
+				return wmNoMatch
+			}
+			p, name = p+1, name[1:]
+		case '*':
+			stars := p
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			atBoundary := stars == 0 || pattern[stars-1] == '/'
+			if pathname && p-stars >= 2 && atBoundary && (p == len(pattern) || pattern[p] == '/') {
+				// "**" as a whole component matches any number of directories
+				if p == len(pattern) {
+					return wmMatch
+				}
+				for {
+					if r := wildmatchAt(pattern, p+1, name, fold, pathname); r == wmMatch || r == wmAbortAll {
+						return r
+					}
+					slashIdx := strings.IndexByte(name, '/')
+					if slashIdx == -1 {
+						return wmAbortAll
+					}
+					name = name[slashIdx+1:]
+				}
+			}
+			if p == len(pattern) {
+				if pathname && strings.IndexByte(name, '/') != -1 {
+					return wmAbortToStarStar
+				}
+				return wmMatch
+			}
+			for i := 0; i < len(name); i++ {
+				if r := wildmatchAt(pattern, p, name[i:], fold, pathname); r != wmNoMatch {
+					return r
+				}
+				if pathname && name[i] == '/' {
+					return wmAbortToStarStar
+				}
+			}
+			return wmAbortAll
+		case '[':
+			if pathname && name[0] == '/' {
+				return wmNoMatch
+			}
+			matched, width, ok := matchCharClass(pattern[p:], name[0], fold)
+			if !ok {
+				// an unterminated class is matched literally
+				if name[0] != '[' {
+					return wmNoMatch
+				}
+				p, name = p+1, name[1:]
+				continue
+			}
+			if !matched {
+				return wmNoMatch
+			}
+			p, name = p+width, name[1:]
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+				c = pattern[p]
+			}
+			fallthrough
+		default:
+			if !equalByte(c, name[0], fold) {
+				return wmNoMatch
+			}
+			p, name = p+1, name[1:]
+		}
+	}
+	if len(name) != 0 {
+		return wmNoMatch
+	}
+	return wmMatch
+}
+
+func matchCharClass(pattern string, c byte, fold bool) (matched bool, width int, ok bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+	first := true
+	for i < len(pattern) {
+		lo := pattern[i]
+		if lo == ']' && !first {
+			return matched != negate, i + 1, true
+		}
+		first = false
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			if hi == '\\' && i+3 < len(pattern) {
+				i++
+				hi = pattern[i+2]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		} else if fold {
+			lc := lowerByte(c)
+			uc := upperByte(c)
+			if (lo <= lc && lc <= hi) || (lo <= uc && uc <= hi) {
+				matched = true
+			}
+		}
+		i++
+	}
+	return false, 0, false
+}
+
+func equalByte(a byte, b byte, fold bool) bool {
+	if a == b {
+		return true
+	}
+	return fold && lowerByte(a) == lowerByte(b)
+}
+
+func lowerByte(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func upperByte(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// wordDiffMark is how one kind of text is shown in a word diff: its color
+// slot and the marks around it.
+type wordDiffMark struct {
+	Slot   string
+	Prefix string
+	Suffix string
+}
+
+// wordDiffStyle is a --word-diff mode. Newline ends each line of the
+// word diff.
+type wordDiffStyle struct {
+	Old, New, Context wordDiffMark
+	Newline           string
+	// Porcelain context lines keep their marker and are ended by Newline
+	Porcelain bool
+}
+
+var wordDiffStyles = map[string]*wordDiffStyle{
+	"plain": {
+		Old:     wordDiffMark{"old", "[-", "-]"},
+		New:     wordDiffMark{"new", "{+", "+}"},
+		Context: wordDiffMark{Slot: "context"},
+		Newline: "\n",
+	},
+	"color": {
+		Old:     wordDiffMark{Slot: "old"},
+		New:     wordDiffMark{Slot: "new"},
+		Context: wordDiffMark{Slot: "context"},
+		Newline: "\n",
+	},
+	"porcelain": {
+		Old:       wordDiffMark{"old", "-", "\n"},
+		New:       wordDiffMark{"new", "+", "\n"},
+		Context:   wordDiffMark{"context", " ", "\n"},
+		Newline:   "~\n",
+		Porcelain: true,
+	},
+}
+
+// wordDiff shows the changed lines of a patch word by word. Without a
+// Regex words are runs of non-whitespace.
+type wordDiff struct {
+	Style *wordDiffStyle
+	Regex *regexp.Regexp
+}
+
+// parseWordDiffMode checks a --word-diff mode, returning nil for none.
+func parseWordDiffMode(mode string) (*wordDiffStyle, error) {
+	if mode == "none" {
+		return nil, nil
+	}
+	style, ok := wordDiffStyles[mode]
+	if !ok {
+		return nil, fmt.Errorf("bad --word-diff argument: %s", mode)
+	}
+	return style, nil
+}
+
+// compileWordRegex compiles a word regex, matched leftmost-longest like the
+// extended regular expressions git uses.
+func compileWordRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %s", expr)
+	}
+	re.Longest()
+	return re, nil
+}
+
+// word is where a word starts and ends in the text it was found in.
+type word struct {
+	Start, End int
+}
+
+// splitWords finds the words of text: each match of re, cut at the first
+// newline, or else each run of non-whitespace. Empty matches are skipped.
+func splitWords(text string, re *regexp.Regexp) []word {
+	words := make([]word, 0, 16)
+	for i := 0; i < len(text); {
+		if re != nil {
+			loc := re.FindStringIndex(text[i:])
+			if loc == nil {
+				break
+			}
+			start, end := i+loc[0], i+loc[1]
+			if nl := strings.IndexByte(text[start:end], '\n'); nl != -1 {
+				end = start + nl
+			}
+			if start == end {
+				i = start + 1
+				continue
+			}
+			words = append(words, word{start, end})
+			i = end
+			continue
+		}
+		for i < len(text) && isDiffSpace(text[i]) {
+			i++
+		}
+		if i == len(text) {
+			break
+		}
+		start := i
+		for i < len(text) && !isDiffSpace(text[i]) {
+			i++
+		}
+		words = append(words, word{start, i})
+	}
+	return words
+}
+
+// writeMarked writes text in a style, marking each of its lines apart and
+// ending them with the style's newline.
+func writeMarked(w io.Writer, text string, mark wordDiffMark, newline string, colors colorPalette) {
+	for text != "" {
+		line, rest, found := strings.Cut(text, "\n")
+		if line != "" {
+			line = mark.Prefix + line + mark.Suffix
+			if colors[mark.Slot] != "" {
+				line = colors.paint(mark.Slot, line)
+			}
+			io.WriteString(w, line)
+		}
+		if !found {
+			return
+		}
+		io.WriteString(w, newline)
+		text = rest
+	}
+}
+
+// writeWordChanges shows the removed and added lines of a run of changes
+// as the words changed between them, the way git's diff_words_show does.
+func writeWordChanges(w io.Writer, minus string, plus string, words *wordDiff, colors colorPalette) {
+	style := words.Style
+	if plus == "" {
+		writeMarked(w, minus, style.Old, style.Newline, colors)
+		return
+	}
+	minusWords, plusWords := splitWords(minus, words.Regex), splitWords(plus, words.Regex)
+	a, b := make([]string, len(minusWords)), make([]string, len(plusWords))
+	for i, word := range minusWords {
+		a[i] = minus[word.Start:word.End]
+	}
+	for i, word := range plusWords {
+		b[i] = plus[word.Start:word.End]
+	}
+
+	// current is how much of plus is written; an empty side of a change is
+	// placed at the end of the word before it
+	current := 0
+	for _, c := range diffChanges(compactedDiff(a, b), 0) {
+		removed, added, firstAdded := c.OldEnd-c.Old, c.Added, c.New
+		minusStart, minusEnd := wordEnd(minusWords, c.Old), wordEnd(minusWords, c.Old)
+		if removed > 0 {
+			minusStart, minusEnd = minusWords[c.Old].Start, minusWords[c.OldEnd-1].End
+		}
+		plusStart, plusEnd := wordEnd(plusWords, firstAdded), wordEnd(plusWords, firstAdded)
+		if added > 0 {
+			plusStart, plusEnd = plusWords[firstAdded].Start, plusWords[firstAdded+added-1].End
+		}
+		if current != plusStart {
+			writeMarked(w, plus[current:plusStart], style.Context, style.Newline, colors)
+		}
+		if minusStart != minusEnd {
+			writeMarked(w, minus[minusStart:minusEnd], style.Old, style.Newline, colors)
+		}
+		if plusStart != plusEnd {
+			writeMarked(w, plus[plusStart:plusEnd], style.New, style.Newline, colors)
+		}
+		current = plusEnd
+	}
+	if current != len(plus) {
+		writeMarked(w, plus[current:], style.Context, style.Newline, colors)
+	}
+}
+
+// wordEnd is where the word before words[i] ends, 0 before the first.
+func wordEnd(words []word, i int) int {
+	if i == 0 {
+		return 0
+	}
+	return words[i-1].End
+}
+
+// writeWordDiffHunks writes the hunks of a patch with the changed lines of
+// each run shown word by word.
+func writeWordDiffHunks(w io.Writer, hunks []diffHunk, oldLines []string, colors colorPalette, words *wordDiff) {
+	for _, h := range hunks {
+		writeHunkHeader(w, h, oldLines, colors)
+		var minus, plus strings.Builder
+		flush := func() {
+			if minus.Len() > 0 || plus.Len() > 0 {
+				writeWordChanges(w, minus.String(), plus.String(), words, colors)
+				minus.Reset()
+				plus.Reset()
+			}
+		}
+		for _, op := range h.Ops {
+			// a missing newline at the end of file is not shown
+			line := strings.TrimSuffix(op.Line, "\n") + "\n"
+			switch op.Kind {
+			case '-':
+				minus.WriteString(line)
+			case '+':
+				plus.WriteString(line)
+			default:
+				flush()
+				if words.Style.Porcelain {
+					line = " " + line
+				}
+				if line = strings.TrimSuffix(line, "\n"); line != "" {
+					line = colors.paint("context", line)
+				}
+				fmt.Fprintln(w, line)
+				if words.Style.Porcelain {
+					io.WriteString(w, words.Style.Newline)
+				}
+			}
+		}
+		flush()
+	}
+}
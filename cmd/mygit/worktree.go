@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type treeFile struct {
+	Path string
+	Mode int
+	Hash string
+}
+
+// flattenTree lists the non-tree entries of a tree recursively, in index
+// order.
+func flattenTree(hash string, prefix string) ([]treeFile, error) {
+	entries, err := readTreeOrEmpty(hash)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]treeFile, 0, len(entries))
+	for _, e := range entries {
+		entryHash := hex.EncodeToString(e.Hash)
+		if e.Mode == modeTree {
+			subFiles, err := flattenTree(entryHash, prefix+e.Name+"/")
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, subFiles...)
+			continue
+		}
+		files = append(files, treeFile{Path: prefix + e.Name, Mode: e.Mode, Hash: entryHash})
+	}
+	return files, nil
+}
+
+// submoduleHead returns the commit checked out in the repository nested at
+// dir, if dir has a .git: either a directory, or a file pointing at one with
+// "gitdir: <path>". head is "" when nothing is checked out there yet.
+func submoduleHead(dir string) (head string, nested bool, err error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", false, nil
+	}
+	nestedGitDir := dotGit
+	if !info.IsDir() {
+		nestedGitDir, err = readGitFile(dotGit)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	// the ref code reads from gitDir, which points at the nested
+	// repository while its HEAD is resolved
+	savedGitDir := gitDir
+	gitDir = nestedGitDir
+	defer func() { gitDir = savedGitDir }()
+	head, _, err = resolveRef("HEAD")
+	if err == errRefNotFound {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", true, err
+	}
+	return head, true, nil
+}
+
+// commitTreeHash returns the tree of a commit, or "" for an unborn branch.
+func commitTreeHash(commitHash string) (string, error) {
+	if commitHash == "" {
+		return "", nil
+	}
+	commit, err := readCommit(commitHash)
+	if err != nil {
+		return "", err
+	}
+	return commit.Tree, nil
+}
+
+func worktreeFileMode(info os.FileInfo) int {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return modeSymlink
+	case info.IsDir():
+		return modeGitlink
+	case info.Mode().Perm()&0o111 != 0:
+		return 100755
+	}
+	return 100644
+}
+
+// hashWorktreeFile computes the blob hash a worktree file would get when added,
+// without writing the object.
+func hashWorktreeFile(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	var content []byte
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read link %s: %s", path, err.Error())
+		}
+		content = []byte(target)
+	} else {
+		big, size, err := openBigFile(path, path)
+		if err != nil {
+			return "", err
+		}
+		if big != nil {
+			defer big.Close()
+			return streamObject(TypeBlob, size, big, false)
+		}
+		content, err = os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %s", path, err.Error())
+		}
+		content, err = applyFilter("clean", path, content)
+		if err != nil {
+			return "", err
+		}
+	}
+	data := append([]byte(fmt.Sprintf("%s %d\u0000", TypeBlob, len(content))), content...)
+	return hex.EncodeToString(calculateObjectBytesHash(data)), nil
+}
+
+// worktreeMatchesIndex reports whether the worktree file has the content and
+// mode recorded in the index entry. Entries marked assume-unchanged or
+// skip-worktree always match, their files are not looked at.
+func worktreeMatchesIndex(index *Index, entry *IndexEntry) (bool, error) {
+	if entry.assumeUnchanged() || entry.skipWorktree() {
+		return true, nil
+	}
+	return worktreeContentMatches(index, entry)
+}
+
+// worktreeContentMatches is worktreeMatchesIndex ignoring the assume-unchanged
+// and skip-worktree bits, for commands that are about to overwrite the file.
+// Only files whose stat data changed, or that are racily clean, are rehashed;
+// when one turns out unchanged its stat data is refreshed so it is not hashed
+// again.
+func worktreeContentMatches(index *Index, entry *IndexEntry) (bool, error) {
+	info, err := os.Lstat(entry.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if entry.Mode == modeGitlink {
+		return info.IsDir(), nil
+	}
+	if worktreeEntryMode(info, entry.Mode) != entry.Mode {
+		return false, nil
+	}
+	// a size of 0 was smudged by writeIndex unless the blob is empty
+	smudged := entry.Size == 0 && entry.Hash != emptyBlobHash
+	if entry.statMatches(info) && !smudged && !index.isRacy(entry) {
+		return true, nil
+	}
+	var hash string
+	if entry.Mode == modeSymlink {
+		// may be a plain file holding the target without core.symlinks
+		target, err := readLinkTarget(entry.Path, info)
+		if err != nil {
+			return false, err
+		}
+		data := []byte(fmt.Sprintf("%s %d\u0000%s", TypeBlob, len(target), target))
+		hash = hex.EncodeToString(calculateObjectBytesHash(data))
+	} else if hash, err = hashWorktreeFile(entry.Path); err != nil {
+		return false, err
+	}
+	if hash != entry.Hash {
+		return false, nil
+	}
+	if !entry.statMatches(info) || smudged {
+		entry.setStatData(info)
+		index.refreshed = true
+	}
+	return true, nil
+}
+
+// errInMemoryWorktree is returned by the worktree writers under --in-memory,
+// where the files they write would outlive the repository. Refs and the index
+// are only changed in memory, so failing on the first file leaves the disk as
+// it was.
+var errInMemoryWorktree = fmt.Errorf("cannot update the worktree of an in-memory repository")
+
+// checkoutFile writes a blob to the worktree and returns the matching index
+// entry.
+func checkoutFile(path string, fileMode int, hash string) (IndexEntry, error) {
+	entry := IndexEntry{Path: path, Mode: fileMode, Hash: hash}
+	if inMemory != nil {
+		return entry, errInMemoryWorktree
+	}
+	if err := os.MkdirAll(filepath.Dir(path), mode); err != nil {
+		return entry, fmt.Errorf("failed to create directory for %s: %s", path, err.Error())
+	}
+	if fileMode == modeGitlink {
+		if err := os.MkdirAll(path, mode); err != nil {
+			return entry, fmt.Errorf("failed to create directory %s: %s", path, err.Error())
+		}
+		return entry, nil
+	}
+
+	// only the header is read before the old file goes away, the content is
+	// streamed so large blobs are never held in memory
+	or, err := openObject(hash)
+	if err != nil {
+		return entry, err
+	}
+	defer or.Close()
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return entry, fmt.Errorf("failed to remove %s: %s", path, err.Error())
+		}
+	} else if err == nil {
+		return entry, fmt.Errorf("cannot check out %s over a directory", path)
+	}
+
+	if fileMode == modeSymlink {
+		target, err := io.ReadAll(or)
+		if err != nil {
+			return entry, err
+		}
+		if !hasSymlinks() {
+			if err := os.WriteFile(path, target, 0o644); err != nil {
+				return entry, fmt.Errorf("failed to write %s: %s", path, err.Error())
+			}
+		} else if err := os.Symlink(string(target), path); err != nil {
+			return entry, fmt.Errorf("failed to create link %s: %s", path, err.Error())
+		}
+	} else {
+		perm := os.FileMode(0o644)
+		if fileMode == 100755 {
+			perm = 0o755
+		}
+		if err := writeBlobFile(path, hash, or, perm); err != nil {
+			return entry, err
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return entry, err
+	}
+	entry.setStatData(info)
+	return entry, nil
+}
+
+// writeBlobFile streams a blob through the path's smudge filter into a new
+// worktree file.
+func writeBlobFile(path string, hash string, or *objectReader, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err.Error())
+	}
+	defer f.Close()
+
+	driver, err := filterDriver(path)
+	if err != nil {
+		return err
+	}
+	passthrough := true
+	if driver != "" {
+		if passthrough, err = runFilter(driver, "smudge", path, or, f); err != nil {
+			return err
+		}
+	}
+	if passthrough && driver != "" {
+		// drop what a failed filter wrote, and start over on the blob if it
+		// consumed some of it
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to write %s: %s", path, err.Error())
+		}
+		if err := f.Truncate(0); err != nil {
+			return fmt.Errorf("failed to write %s: %s", path, err.Error())
+		}
+		if or.read > 0 {
+			if or, err = openObject(hash); err != nil {
+				return err
+			}
+			defer or.Close()
+		}
+	}
+	if passthrough {
+		if _, err := io.Copy(f, or); err != nil {
+			return fmt.Errorf("failed to write %s: %s", path, err.Error())
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// removeWorktreeFile deletes a file and any directories left empty by it.
+func removeWorktreeFile(path string) error {
+	if inMemory != nil {
+		return errInMemoryWorktree
+	}
+	err := os.RemoveAll(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %s", path, err.Error())
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}